@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/joelklabo/buddy/internal/app"
+	"github.com/joelklabo/buddy/internal/config"
+	"github.com/joelklabo/buddy/internal/store"
+	"github.com/joelklabo/buddy/internal/transports/stdio"
+)
+
+// chatDrainSecs lets replies to piped input finish after EOF; an interrupt does not wait.
+const chatDrainSecs = 3600
+
+// runChat runs a preset or config with its transports replaced by the terminal, so the
+// agent, actions, commands, and sessions can be exercised without any network transport.
+func runChat(parent context.Context, args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "Path to config.yaml (defaults: BUDDY_CONFIG env, ./config.yaml, ~/.config/buddy/config.yaml)")
+	statePath := fs.String("state", "", "Optional state DB path (needed while `buddy run` holds the configured one)")
+	verbose := fs.Bool("verbose", false, "Log at the configured level to stderr (default: warnings only)")
+	skipCheck := fs.Bool("skip-check", false, "Skip dependency preflight")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("too many arguments: %v", fs.Args())
+	}
+	interactive := isTerminal(os.Stdin)
+	prompt := ""
+	if interactive {
+		prompt = "> "
+	}
+	// The transports are replaced before validation, so presets whose Nostr keys are not
+	// filled in still chat.
+	cfg, presetName, err := loadAdjustedConfig(*configPath, fs.Arg(0), func(c *config.Config) {
+		c.Transports = []config.TransportConfig{{Type: "stdio", ID: "stdio", Config: map[string]any{"prompt": prompt}}}
+		if c.Runner.DrainTimeoutSecs < chatDrainSecs {
+			c.Runner.DrainTimeoutSecs = chatDrainSecs
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if *statePath != "" {
+		cfg.Storage.Path = *statePath
+	}
+
+	logger := chatLogger(cfg, *verbose)
+	st, err := store.New(cfg.Storage.Path)
+	if err != nil {
+		return fmt.Errorf("open store %s: %w (is `buddy run` using it? pass -state)", cfg.Storage.Path, err)
+	}
+	defer func() {
+		if err := st.Close(); err != nil {
+			logger.Error("failed to close store", slog.String("err", err.Error()))
+		}
+	}()
+	if !*skipCheck {
+		if err := runDepPreflight(cfg, presetName); err != nil {
+			return err
+		}
+	}
+	runner, err := app.Build(cfg, st, logger)
+	if err != nil {
+		return fmt.Errorf("build runner: %w", err)
+	}
+	var term *stdio.Transport
+	for _, tr := range runner.Transports() {
+		if t, ok := tr.(*stdio.Transport); ok {
+			term = t
+		}
+	}
+	if term == nil {
+		return errors.New("chat: stdio transport not built")
+	}
+
+	sigCtx, stop := signal.NotifyContext(parent, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// End of input stops the runner, which drains the lines already read.
+	ctx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+	go func() {
+		select {
+		case <-term.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if interactive {
+		fmt.Fprintf(os.Stderr, "buddy chat (%s agent). /help lists commands; Ctrl-D quits.\n", cfg.Agent.Type)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- runner.Start(ctx) }()
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("runtime error: %w", err)
+		}
+	case <-sigCtx.Done():
+	}
+	return nil
+}
+
+// chatLogger keeps the terminal for the conversation: only warnings reach stderr unless verbose.
+func chatLogger(cfg *config.Config, verbose bool) *slog.Logger {
+	level := slog.LevelWarn
+	if verbose {
+		switch strings.ToLower(cfg.Logging.Level) {
+		case "debug":
+			level = slog.LevelDebug
+		case "warn":
+			level = slog.LevelWarn
+		case "error":
+			level = slog.LevelError
+		default:
+			level = slog.LevelInfo
+		}
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
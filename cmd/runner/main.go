@@ -65,10 +65,19 @@ func main() {
 			fatalf(err.Error())
 		}
 		return
+	case "outbox":
+		if err := runOutbox(args); err != nil {
+			fatalf(err.Error())
+		}
+		return
 	case "run":
 		if err := runContext(context.Background(), args); err != nil {
 			fatalf(err.Error())
 		}
+	case "chat":
+		if err := runChat(context.Background(), args); err != nil {
+			fatalf(err.Error())
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", subcmd)
 		usage()
@@ -211,7 +220,7 @@ func parseSubcommand(args []string) (string, []string) {
 	}
 	first := args[0]
 	switch first {
-	case "presets", "wizard", "init-config", "check", "version", "help", "run":
+	case "presets", "wizard", "init-config", "check", "outbox", "version", "help", "run", "chat":
 		return first, args[1:]
 	}
 	if strings.HasPrefix(first, "-") {
@@ -244,10 +253,12 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: buddy <command> [args]\n")
 	fmt.Fprintf(os.Stderr, "Commands:\n")
 	fmt.Fprintf(os.Stderr, "  run <preset|config>       start the runner\n")
+	fmt.Fprintf(os.Stderr, "  chat <preset|config>      chat with the agent in this terminal\n")
 	fmt.Fprintf(os.Stderr, "  check <preset|config>     verify dependencies for a preset/config\n")
 	fmt.Fprintf(os.Stderr, "  wizard [config-path]      guided setup; supports dry-run\n")
 	fmt.Fprintf(os.Stderr, "  init-config [path]        write example config (default ./config.yaml)\n")
 	fmt.Fprintf(os.Stderr, "  presets [name]            list built-in presets or show one\n")
+	fmt.Fprintf(os.Stderr, "  outbox list|retry|purge   inspect replies waiting for delivery\n")
 	fmt.Fprintf(os.Stderr, "  version                   show version\n")
 	fmt.Fprintf(os.Stderr, "  help [command]            show help\n\n")
 	fmt.Fprintf(os.Stderr, "Env: %s (preferred)\n", envConfigNew)
@@ -292,11 +303,16 @@ func runWizard(args []string) error {
 }
 
 func loadConfigWithPresets(flagConfig string, positional string) (*config.Config, string, error) {
+	return loadAdjustedConfig(flagConfig, positional, nil)
+}
+
+// loadAdjustedConfig is loadConfigWithPresets with adjust applied before validation.
+func loadAdjustedConfig(flagConfig, positional string, adjust func(*config.Config)) (*config.Config, string, error) {
 	// If positional provided, prefer it; otherwise fallback to flag/default path.
 	if positional != "" {
 		// If file exists, load as config.
 		if fileExists(positional) {
-			cfg, err := config.Load(positional)
+			cfg, err := config.LoadWith(positional, adjust)
 			if err != nil {
 				return nil, positional, friendlyConfigErr(positional, err)
 			}
@@ -304,7 +320,7 @@ func loadConfigWithPresets(flagConfig string, positional string) (*config.Config
 		}
 		// Try preset
 		if data, err := presets.Get(positional); err == nil {
-			cfg, err := config.LoadBytes(data, ".")
+			cfg, err := config.LoadBytesWith(data, ".", adjust)
 			if err != nil {
 				return nil, positional, fmt.Errorf("load preset %s: %w", positional, err)
 			}
@@ -313,7 +329,7 @@ func loadConfigWithPresets(flagConfig string, positional string) (*config.Config
 		return nil, positional, fmt.Errorf("path or preset %q not found", positional)
 	}
 
-	cfg, err := config.Load(flagConfig)
+	cfg, err := config.LoadWith(flagConfig, adjust)
 	if err != nil {
 		return nil, flagConfig, friendlyConfigErr(flagConfig, err)
 	}
@@ -339,7 +355,19 @@ func printHelp(args []string) {
 		fmt.Println("  buddy run mock-echo                 # offline smoke test")
 		fmt.Println("  buddy run claude-dm                 # Nostr → Claude/OpenAI HTTP")
 		fmt.Println("  buddy run copilot-shell             # Copilot + shell (trusted operators only)")
+		fmt.Println("  buddy run whatsapp-codex            # WhatsApp (Twilio) → Codex CLI")
 		fmt.Println("  buddy run path/to/config.yaml       # load your own YAML")
+	case "chat":
+		fmt.Println("buddy chat <preset|config> - talk to the configured agent and actions from this terminal")
+		fmt.Println("Transports in the config are replaced by stdin/stdout; commands, sessions, and approvals work as usual.")
+		fmt.Println("Flags:")
+		fmt.Println("  -config <path>          config file path (default search: argv, ./config.yaml, ~/.config/buddy/config.yaml)")
+		fmt.Println("  -state <path>           state DB to use instead of storage.path (e.g. while `buddy run` is running)")
+		fmt.Println("  -verbose                log at the configured level to stderr")
+		fmt.Println("  -skip-check             skip dependency preflight")
+		fmt.Println("Examples:")
+		fmt.Println("  buddy chat mock-echo")
+		fmt.Println("  echo 'summarize README.md' | buddy chat path/to/config.yaml")
 	case "wizard":
 		fmt.Println("buddy wizard [config-path] - guided setup; writes config or dry-runs")
 		fmt.Println("Prompts for relays/keys/allowed pubkeys, agent choice, actions.")
@@ -360,6 +388,19 @@ func printHelp(args []string) {
 		fmt.Println("Flags:")
 		fmt.Println("  -config <path>          config file path (default search: argv, ./config.yaml, ~/.config/buddy/config.yaml)")
 		fmt.Println("  -json                   output JSON report")
+	case "outbox":
+		fmt.Println("buddy outbox <list|retry|purge> [flags] [id...] - manage replies queued for delivery or dead-lettered")
+		fmt.Println("Replies that could not be sent are retried with backoff and dead-lettered after runner.outbox_max_attempts.")
+		fmt.Println("Stop `buddy run` first; it holds the state DB.")
+		fmt.Println("Flags:")
+		fmt.Println("  -config <path>          config file path (default search: argv, ./config.yaml, ~/.config/buddy/config.yaml)")
+		fmt.Println("  -state <path>           state DB to use instead of storage.path")
+		fmt.Println("  -all | -queued | -dead  retry/purge every entry, only queued ones, or only dead letters")
+		fmt.Println("Examples:")
+		fmt.Println("  buddy outbox list")
+		fmt.Println("  buddy outbox retry 42                 # send dead letter 42 again on the next run")
+		fmt.Println("  buddy outbox retry -dead")
+		fmt.Println("  buddy outbox purge -dead")
 	case "version":
 		fmt.Println("buddy version - print version")
 	default:
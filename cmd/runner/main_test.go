@@ -408,3 +408,45 @@ func captureStdout(fn func()) string {
 	_, _ = io.Copy(&buf, r)
 	return buf.String()
 }
+
+// runChatWithInput runs the chat subcommand with input piped to stdin and returns its stdout.
+func runChatWithInput(t *testing.T, input string, args ...string) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	r, w, _ := os.Pipe()
+	_, _ = w.WriteString(input)
+	_ = w.Close()
+	orig := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = orig
+	}()
+
+	var err error
+	out := captureStdout(func() {
+		err = runChat(context.Background(), args)
+	})
+	if err != nil {
+		t.Fatalf("runChat: %v", err)
+	}
+	return out
+}
+
+func TestRunChatAnswersPipedInput(t *testing.T) {
+	out := runChatWithInput(t, "hello from the terminal\n/status\n", "-skip-check", "mock-echo")
+	if !strings.Contains(out, "hello from the terminal") {
+		t.Fatalf("expected echoed reply, got %q", out)
+	}
+	if !strings.Contains(out, "session") {
+		t.Fatalf("expected /status output, got %q", out)
+	}
+}
+
+func TestRunChatWithNostrPreset(t *testing.T) {
+	// claude-dm ships without Nostr keys; chat replaces its transport and must not need them.
+	out := runChatWithInput(t, "/help\n", "-skip-check", "claude-dm")
+	if !strings.Contains(out, "Commands:") {
+		t.Fatalf("expected /help output, got %q", out)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+const outboxUsage = "usage: buddy outbox list | retry <id>...|-all|-dead | purge <id>...|-all|-queued|-dead"
+
+// runOutbox lists, retries or purges replies waiting in the outbox or dead letters.
+func runOutbox(args []string) error {
+	if len(args) == 0 {
+		return errors.New(outboxUsage)
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("outbox", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "Path to config.yaml or preset name")
+	statePath := fs.String("state", "", "State DB path (default: storage.path from the config)")
+	all := fs.Bool("all", false, "Apply to every queued and dead-lettered reply")
+	queued := fs.Bool("queued", false, "Apply to queued replies")
+	dead := fs.Bool("dead", false, "Apply to dead-lettered replies")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	path := *statePath
+	if path == "" {
+		cfg, _, err := loadConfigWithPresets(*configPath, "")
+		if err != nil {
+			return err
+		}
+		path = cfg.Storage.Path
+	}
+	st, err := store.New(path)
+	if err != nil {
+		return fmt.Errorf("open store %s: %w (stop `buddy run` first; it holds the DB)", path, err)
+	}
+	defer func() { _ = st.Close() }()
+	return outboxCommand(os.Stdout, st, action, fs.Args(), *queued || *all, *dead || *all)
+}
+
+// outboxCommand runs action on the given ids, or on every queued and/or dead-lettered entry.
+func outboxCommand(w io.Writer, st *store.Store, action string, ids []string, queued, dead bool) error {
+	queue, err := st.Outbox()
+	if err != nil {
+		return err
+	}
+	letters, err := st.DeadLetters()
+	if err != nil {
+		return err
+	}
+	if action == "list" {
+		printOutbox(w, queue, letters)
+		return nil
+	}
+	var apply func(id string) error
+	switch action {
+	case "retry":
+		apply = st.RetryOutbox
+	case "purge":
+		apply = st.PurgeOutbox
+	default:
+		return fmt.Errorf("unknown outbox command %q; %s", action, outboxUsage)
+	}
+
+	targets := make([]string, 0, len(ids))
+	for _, id := range ids {
+		targets = append(targets, outboxID(id))
+	}
+	if queued {
+		for _, e := range queue {
+			targets = append(targets, e.ID)
+		}
+	}
+	if dead {
+		for _, e := range letters {
+			targets = append(targets, e.ID)
+		}
+	}
+	if len(targets) == 0 && len(ids) == 0 && !queued && !dead {
+		return errors.New(outboxUsage)
+	}
+	for _, id := range targets {
+		if err := apply(id); err != nil {
+			if errors.Is(err, store.ErrOutboxNotFound) {
+				return fmt.Errorf("no queued or dead-lettered reply %s", shortID(id))
+			}
+			return err
+		}
+	}
+	verb := "Requeued"
+	if action == "purge" {
+		verb = "Purged"
+	}
+	fmt.Fprintf(w, "%s %d repl%s.\n", verb, len(targets), plural(len(targets), "y", "ies"))
+	if action == "retry" && len(targets) > 0 {
+		fmt.Fprintln(w, "They are sent when `buddy run` next checks the outbox.")
+	}
+	return nil
+}
+
+func printOutbox(w io.Writer, queue, letters []store.OutboxEntry) {
+	if len(queue) == 0 && len(letters) == 0 {
+		fmt.Fprintln(w, "Outbox and dead letters are empty.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tTRANSPORT\tRECIPIENT\tATTEMPTS\tNEXT\tLAST ERROR\tTEXT")
+	for _, e := range queue {
+		next := "now"
+		if e.NextAttempt.After(time.Now()) {
+			next = e.NextAttempt.Local().Format(time.DateTime)
+		}
+		printEntry(tw, e, "queued", next)
+	}
+	for _, e := range letters {
+		printEntry(tw, e, "dead", "-")
+	}
+	_ = tw.Flush()
+}
+
+func printEntry(w io.Writer, e store.OutboxEntry, state, next string) {
+	var msg struct {
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(e.Payload, &msg)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", shortID(e.ID), state, e.Transport, e.Recipient,
+		e.Attempts, next, preview(e.LastError, 40), preview(msg.Text, 40))
+}
+
+// shortID drops the zero padding of a numeric outbox ID; outboxID restores it.
+func shortID(id string) string {
+	if trimmed := strings.TrimLeft(id, "0"); trimmed != "" {
+		return trimmed
+	}
+	return id
+}
+
+func outboxID(s string) string {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return fmt.Sprintf("%020d", n)
+	}
+	return s
+}
+
+// preview flattens s to one line of at most n runes.
+func preview(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+func TestOutboxCommandListRetryPurge(t *testing.T) {
+	st, err := store.New(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer func() { _ = st.Close() }()
+	for _, text := range []string{"first reply", "second reply"} {
+		if _, err := st.EnqueueOutbox(store.OutboxEntry{Transport: "nostr", Recipient: "npub1alice", Payload: []byte(`{"text":"` + text + `"}`)}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	queue, _ := st.Outbox()
+	if err := st.DeadLetter(queue[0].ID, "relay down"); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	if err := st.FailOutbox(queue[1].ID, "timeout", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := outboxCommand(&out, st, "list", nil, false, false); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, want := range []string{"ID", "queued", "dead", "npub1alice", "relay down", "first reply", "second reply"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("list output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := outboxCommand(&out, st, "retry", []string{"1"}, false, false); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if !strings.Contains(out.String(), "Requeued 1 reply.") {
+		t.Fatalf("unexpected retry output: %s", out.String())
+	}
+	if dead, _ := st.DeadLetters(); len(dead) != 0 {
+		t.Fatalf("dead letter not requeued: %+v", dead)
+	}
+
+	out.Reset()
+	if err := outboxCommand(&out, st, "purge", nil, true, false); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if queue, _ = st.Outbox(); len(queue) != 0 || !strings.Contains(out.String(), "Purged 2 replies.") {
+		t.Fatalf("purge left %+v; output %s", queue, out.String())
+	}
+
+	if err := outboxCommand(&out, st, "purge", []string{"7"}, false, false); err == nil || !strings.Contains(err.Error(), "no queued or dead-lettered reply 7") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if err := outboxCommand(&out, st, "retry", nil, false, false); err == nil {
+		t.Fatalf("retry without ids should fail")
+	}
+}
@@ -37,6 +37,11 @@ func (r *ReadFile) Help() string {
 	return `readfile: {"path": "<file>"} — constrained to configured roots; max_bytes enforced.`
 }
 
+// Schema describes the Invoke arguments as JSON Schema.
+func (r *ReadFile) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"Path of a text file under an allowed root."}},"required":["path"]}`)
+}
+
 func (r *ReadFile) Invoke(ctx context.Context, args json.RawMessage) (json.RawMessage, error) {
 	var payload struct {
 		Path string `json:"path"`
@@ -89,6 +94,11 @@ func (w *WriteFile) Help() string {
 	return `writefile: {"path": "<file>", "content": "<text>"} — constrained to roots/max_bytes; allow_write must be true.`
 }
 
+// Schema describes the Invoke arguments as JSON Schema.
+func (w *WriteFile) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"Destination path under an allowed root."},"content":{"type":"string","description":"Full text content to write."}},"required":["path","content"]}`)
+}
+
 func (w *WriteFile) Invoke(ctx context.Context, args json.RawMessage) (json.RawMessage, error) {
 	if !w.cfg.AllowWrite {
 		return nil, errors.New("write not permitted")
@@ -113,6 +123,22 @@ func (w *WriteFile) Invoke(ctx context.Context, args json.RawMessage) (json.RawM
 	return json.RawMessage(`"ok"`), nil
 }
 
+// OutputFiles returns the file a successful call with args wrote, so it can be sent back
+// as an attachment.
+func (w *WriteFile) OutputFiles(args json.RawMessage) []string {
+	var payload struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &payload); err != nil {
+		return nil
+	}
+	p, err := w.safePath(payload.Path)
+	if err != nil {
+		return nil
+	}
+	return []string{p}
+}
+
 // safePath ensures path is within allowed roots.
 func (r *ReadFile) safePath(p string) (string, error)  { return safePath(r.cfg.Roots, p) }
 func (w *WriteFile) safePath(p string) (string, error) { return safePath(w.cfg.Roots, p) }
@@ -42,6 +42,11 @@ func (a *Action) Help() string {
 	return "/shell <command> — execute a shell command (if enabled); obeys allowlist and timeouts."
 }
 
+// Schema describes the Invoke arguments as JSON Schema.
+func (a *Action) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"command":{"type":"string","description":"Command line run with bash -lc in the configured workdir."}},"required":["command"]}`)
+}
+
 func (a *Action) Invoke(ctx context.Context, args json.RawMessage) (json.RawMessage, error) {
 	var payload struct {
 		Command string `json:"command"`
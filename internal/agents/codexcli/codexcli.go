@@ -21,6 +21,7 @@ type Agent struct {
 // runnerIface allows substitution in tests.
 type runnerIface interface {
 	Run(ctx context.Context, sessionID string, prompt string) (codex.Result, error)
+	RunStream(ctx context.Context, sessionID string, prompt string, onEvent func(codex.Event)) (codex.Result, error)
 	ContextWithTimeout(parent context.Context) (context.Context, context.CancelFunc)
 }
 
@@ -29,10 +30,19 @@ func New(cfg Config) *Agent {
 }
 
 func (a *Agent) Generate(ctx context.Context, req core.AgentRequest) (core.AgentResponse, error) {
+	return a.GenerateStream(ctx, req, nil)
+}
+
+// GenerateStream runs Codex and forwards its reasoning, command, and message items to emit.
+func (a *Agent) GenerateStream(ctx context.Context, req core.AgentRequest, emit func(core.AgentEvent)) (core.AgentResponse, error) {
 	if req.Prompt == "" {
 		return core.AgentResponse{}, fmt.Errorf("prompt is empty")
 	}
-	res, err := a.runner.Run(ctx, "", req.Prompt)
+	var onEvent func(codex.Event)
+	if emit != nil {
+		onEvent = func(e codex.Event) { emit(core.AgentEvent{Kind: e.Kind, Text: e.Text}) }
+	}
+	res, err := a.runner.RunStream(ctx, req.SessionID, req.Prompt, onEvent)
 	if err != nil {
 		return core.AgentResponse{}, err
 	}
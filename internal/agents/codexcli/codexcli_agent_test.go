@@ -10,11 +10,23 @@ import (
 )
 
 type fakeRunner struct {
-	reply string
-	err   error
+	reply     string
+	err       error
+	sessionID string
+	events    []codex.Event
 }
 
 func (f *fakeRunner) Run(ctx context.Context, sessionID string, prompt string) (codex.Result, error) {
+	return f.RunStream(ctx, sessionID, prompt, nil)
+}
+
+func (f *fakeRunner) RunStream(ctx context.Context, sessionID string, prompt string, onEvent func(codex.Event)) (codex.Result, error) {
+	f.sessionID = sessionID
+	if onEvent != nil {
+		for _, e := range f.events {
+			onEvent(e)
+		}
+	}
 	return codex.Result{Reply: f.reply, SessionID: "s1"}, f.err
 }
 
@@ -46,3 +58,25 @@ func TestGenerateRunnerError(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestGenerateResumesSession(t *testing.T) {
+	fr := &fakeRunner{reply: "ok"}
+	ag := &Agent{runner: fr}
+	if _, err := ag.Generate(context.Background(), core.AgentRequest{Prompt: "hi", SessionID: "prev"}); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if fr.sessionID != "prev" {
+		t.Fatalf("expected session to be resumed, got %q", fr.sessionID)
+	}
+}
+
+func TestGenerateStreamForwardsEvents(t *testing.T) {
+	ag := &Agent{runner: &fakeRunner{reply: "ok", events: []codex.Event{{Kind: "command", Text: "go test ./..."}}}}
+	var got []core.AgentEvent
+	if _, err := ag.GenerateStream(context.Background(), core.AgentRequest{Prompt: "hi"}, func(e core.AgentEvent) { got = append(got, e) }); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(got) != 1 || got[0].Kind != core.EventCommand || got[0].Text != "go test ./..." {
+		t.Fatalf("unexpected events %+v", got)
+	}
+}
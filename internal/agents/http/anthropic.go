@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+const anthropicVersion = "2023-06-01"
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+}
+
+// anthropicMessage carries either a plain string or a list of content blocks.
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+}
+
+// anthropicMessages converts text turns plus loop steps into Messages API turns:
+// agent steps become tool_use blocks, action steps become tool_result blocks.
+func anthropicMessages(msgs []message, steps []core.MessageTurn) []anthropicMessage {
+	out := make([]anthropicMessage, 0, len(msgs)+len(steps))
+	for _, m := range msgs {
+		out = append(out, anthropicMessage{Role: m.Role, Content: m.Content})
+	}
+	var results []anthropicBlock
+	flush := func() {
+		if len(results) > 0 {
+			out = append(out, anthropicMessage{Role: "user", Content: results})
+			results = nil
+		}
+	}
+	for _, st := range steps {
+		switch st.Role {
+		case core.RoleAgent:
+			flush()
+			var blocks []anthropicBlock
+			if strings.TrimSpace(st.Text) != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: st.Text})
+			}
+			for _, call := range st.ActionCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: toolArgs(call.Args)})
+			}
+			out = append(out, anthropicMessage{Role: "assistant", Content: blocks})
+		case core.RoleAction:
+			results = append(results, anthropicBlock{Type: "tool_result", ToolUseID: st.CallID, Content: st.Text, IsError: st.IsError})
+		}
+	}
+	flush()
+	return out
+}
+
+// generateAnthropic calls POST /v1/messages.
+func (a *Agent) generateAnthropic(ctx context.Context, msgs []message, steps []core.MessageTurn, actions []core.ActionSpec) (core.AgentResponse, error) {
+	body := anthropicRequest{
+		Model:     a.cfg.Model,
+		MaxTokens: a.cfg.MaxTokens,
+		Messages:  anthropicMessages(msgs, steps),
+	}
+	for _, spec := range actions {
+		body.Tools = append(body.Tools, anthropicTool{
+			Name:        spec.Name,
+			Description: toolDescription(spec),
+			InputSchema: toolSchema(spec),
+		})
+	}
+	headers := map[string]string{
+		"anthropic-version": anthropicVersion,
+	}
+	if a.cfg.APIKey != "" {
+		headers["x-api-key"] = a.cfg.APIKey
+	}
+
+	var out anthropicResponse
+	if err := a.postJSON(ctx, endpoint(a.cfg.APIBase, "/messages"), headers, body, &out); err != nil {
+		return core.AgentResponse{}, err
+	}
+
+	var resp core.AgentResponse
+	var parts []string
+	for _, block := range out.Content {
+		switch block.Type {
+		case "text":
+			if block.Text != "" {
+				parts = append(parts, block.Text)
+			}
+		case "tool_use":
+			resp.ActionCalls = append(resp.ActionCalls, core.ActionCall{
+				ID:   block.ID,
+				Name: block.Name,
+				Args: toolArgs(block.Input),
+			})
+		}
+	}
+	resp.Reply = strings.Join(parts, "\n")
+	if resp.Reply == "" && len(resp.ActionCalls) == 0 {
+		return core.AgentResponse{}, errors.New("http agent: empty response from anthropic")
+	}
+	return resp, nil
+}
@@ -1,29 +1,212 @@
-// Package http provides an HTTP-based agent stub for external LLM APIs.
+// Package http implements an agent backed by LLM HTTP APIs: the Anthropic
+// Messages API and OpenAI-compatible Chat Completions (OpenAI, Ollama, llama.cpp).
 package http
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/joelklabo/buddy/internal/core"
 )
 
+// Supported API dialects.
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderOpenAI    = "openai"
+)
+
 // Config describes a generic HTTP LLM endpoint.
 type Config struct {
-	APIBase string
-	Model   string
-	APIKey  string
+	Provider       string // anthropic|openai; inferred from APIBase/Model when empty
+	APIBase        string
+	Model          string
+	APIKey         string
+	TimeoutSeconds int
+	MaxTokens      int
+	Client         *nethttp.Client // optional override (tests)
 }
 
-// Agent is a stub HTTP agent placeholder.
+// Agent calls a remote or local LLM over HTTP.
 type Agent struct {
-	cfg Config
+	cfg    Config
+	client *nethttp.Client
 }
 
+// New constructs an HTTP agent, filling provider, endpoint, and key defaults.
 func New(cfg Config) *Agent {
-	return &Agent{cfg: cfg}
+	cfg.Provider = strings.ToLower(strings.TrimSpace(cfg.Provider))
+	if cfg.Provider == "" {
+		cfg.Provider = inferProvider(cfg.APIBase, cfg.Model)
+	}
+	if cfg.APIBase == "" {
+		if cfg.Provider == ProviderAnthropic {
+			cfg.APIBase = "https://api.anthropic.com"
+		} else {
+			cfg.APIBase = "https://api.openai.com"
+		}
+	}
+	if cfg.APIKey == "" {
+		if cfg.Provider == ProviderAnthropic {
+			cfg.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		} else {
+			cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+		}
+	}
+	if cfg.TimeoutSeconds == 0 {
+		cfg.TimeoutSeconds = 120
+	}
+	if cfg.MaxTokens == 0 {
+		cfg.MaxTokens = 4096
+	}
+	client := cfg.Client
+	if client == nil {
+		client = nethttp.DefaultClient
+	}
+	return &Agent{cfg: cfg, client: client}
 }
 
 func (a *Agent) Generate(ctx context.Context, req core.AgentRequest) (core.AgentResponse, error) {
-	return core.AgentResponse{}, errors.New("http agent not implemented")
+	if strings.TrimSpace(req.Prompt) == "" {
+		return core.AgentResponse{}, errors.New("prompt is empty")
+	}
+	if a.cfg.Model == "" {
+		return core.AgentResponse{}, errors.New("http agent: model is required")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	msgs := buildMessages(req)
+	if a.cfg.Provider == ProviderAnthropic {
+		return a.generateAnthropic(ctx, msgs, req.Steps, req.Actions)
+	}
+	return a.generateOpenAI(ctx, msgs, req.Steps, req.Actions)
+}
+
+// message is the provider-neutral text turn built from history and prompt;
+// tool-call steps are appended per provider.
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// buildMessages maps history + prompt onto user/assistant turns, merging
+// consecutive turns with the same role so providers that require alternation accept them.
+func buildMessages(req core.AgentRequest) []message {
+	out := make([]message, 0, len(req.History)+1)
+	add := func(role, text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content += "\n\n" + text
+			return
+		}
+		out = append(out, message{Role: role, Content: text})
+	}
+	for _, turn := range req.History {
+		add(mapRole(turn.Role), turn.Text)
+	}
+	add("user", req.Prompt)
+	return out
+}
+
+func mapRole(role string) string {
+	switch strings.ToLower(role) {
+	case "agent", "assistant":
+		return "assistant"
+	default:
+		return "user"
+	}
+}
+
+func inferProvider(base, model string) string {
+	switch {
+	case strings.Contains(strings.ToLower(base), "anthropic"):
+		return ProviderAnthropic
+	case base == "" && strings.HasPrefix(strings.ToLower(model), "claude"):
+		return ProviderAnthropic
+	default:
+		return ProviderOpenAI
+	}
+}
+
+// endpoint joins base and path, tolerating bases that already end in /v1.
+func endpoint(base, path string) string {
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(base, "/v1") {
+		return base + path
+	}
+	return base + "/v1" + path
+}
+
+// postJSON sends body as JSON and decodes a 2xx response into out.
+func (a *Agent) postJSON(ctx context.Context, url string, headers map[string]string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("http agent: encode request: %w", err)
+	}
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.New("http agent: timeout")
+		}
+		return fmt.Errorf("http agent: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return fmt.Errorf("http agent: read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return apiError(resp.Status, data)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("http agent: decode response: %w", err)
+	}
+	return nil
+}
+
+// apiError extracts the provider's error message ({"error":{"message":...}} or {"error":"..."}).
+func apiError(status string, body []byte) error {
+	var env struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &env); err == nil && len(env.Error) > 0 {
+		var obj struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(env.Error, &obj) == nil && obj.Message != "" {
+			return fmt.Errorf("http agent: %s: %s", status, obj.Message)
+		}
+		var s string
+		if json.Unmarshal(env.Error, &s) == nil && s != "" {
+			return fmt.Errorf("http agent: %s: %s", status, s)
+		}
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > 512 {
+		text = text[:512] + "..."
+	}
+	return fmt.Errorf("http agent: %s: %s", status, text)
 }
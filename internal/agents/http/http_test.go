@@ -2,15 +2,201 @@ package http
 
 import (
 	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/joelklabo/buddy/internal/core"
 )
 
-func TestHTTPStub(t *testing.T) {
-	ag := New(Config{APIBase: "https://example.com", Model: "gpt"})
+func TestGenerateAnthropic(t *testing.T) {
+	var got anthropicRequest
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "secret" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("missing anthropic headers: %v", r.Header)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"content":[{"type":"text","text":"hello there"}],"stop_reason":"end_turn"}`))
+	}))
+	defer srv.Close()
+
+	ag := New(Config{Provider: "anthropic", APIBase: srv.URL, Model: "claude-test", APIKey: "secret"})
+	out, err := ag.Generate(context.Background(), core.AgentRequest{
+		Prompt: "and now?",
+		History: []core.MessageTurn{
+			{Role: "user", Text: "hi"},
+			{Role: "agent", Text: "hello"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if out.Reply != "hello there" {
+		t.Fatalf("unexpected reply %q", out.Reply)
+	}
+	if got.Model != "claude-test" || got.MaxTokens == 0 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if len(got.Messages) != 3 || got.Messages[1].Role != "assistant" || got.Messages[2].Content != "and now?" {
+		t.Fatalf("history not mapped: %+v", got.Messages)
+	}
+}
+
+func TestGenerateOpenAICompatible(t *testing.T) {
+	var got openAIRequest
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("expected no auth header for keyless local server, got %q", auth)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	ag := New(Config{APIBase: srv.URL + "/v1/", Model: "llama3"})
+	out, err := ag.Generate(context.Background(), core.AgentRequest{Prompt: "ping"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if out.Reply != "pong" {
+		t.Fatalf("unexpected reply %q", out.Reply)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+		t.Fatalf("unexpected messages %+v", got.Messages)
+	}
+}
+
+func TestGenerateSurfacesAPIError(t *testing.T) {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	}))
+	defer srv.Close()
+
+	ag := New(Config{Provider: "anthropic", APIBase: srv.URL, Model: "claude-test"})
 	_, err := ag.Generate(context.Background(), core.AgentRequest{Prompt: "hi"})
-	if err == nil {
-		t.Fatalf("expected not implemented error")
+	if err == nil || !strings.Contains(err.Error(), "invalid x-api-key") {
+		t.Fatalf("expected api error, got %v", err)
+	}
+}
+
+func TestGenerateRejectsEmptyPrompt(t *testing.T) {
+	ag := New(Config{APIBase: "http://127.0.0.1:0", Model: "m"})
+	if _, err := ag.Generate(context.Background(), core.AgentRequest{}); err == nil {
+		t.Fatalf("expected error on empty prompt")
+	}
+}
+
+func TestBuildMessagesMergesRoles(t *testing.T) {
+	msgs := buildMessages(core.AgentRequest{
+		Prompt:  "third",
+		History: []core.MessageTurn{{Role: "user", Text: "first"}, {Role: "user", Text: "second"}},
+	})
+	if len(msgs) != 1 || msgs[0].Content != "first\n\nsecond\n\nthird" {
+		t.Fatalf("expected merged user turn, got %+v", msgs)
+	}
+}
+
+func TestInferProvider(t *testing.T) {
+	cases := map[string]string{
+		"https://api.anthropic.com": ProviderAnthropic,
+		"http://localhost:11434":    ProviderOpenAI,
+	}
+	for base, want := range cases {
+		if got := New(Config{APIBase: base}).cfg.Provider; got != want {
+			t.Fatalf("%s: got %s want %s", base, got, want)
+		}
+	}
+	if got := New(Config{Model: "claude-3-5-sonnet"}).cfg.Provider; got != ProviderAnthropic {
+		t.Fatalf("expected anthropic from model name, got %s", got)
+	}
+}
+
+func TestAnthropicToolUse(t *testing.T) {
+	var got anthropicRequest
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"content":[{"type":"text","text":"checking"},{"type":"tool_use","id":"toolu_1","name":"shell","input":{"command":"ls"}}],"stop_reason":"tool_use"}`))
+	}))
+	defer srv.Close()
+
+	ag := New(Config{Provider: "anthropic", APIBase: srv.URL, Model: "claude-test"})
+	out, err := ag.Generate(context.Background(), core.AgentRequest{
+		Prompt: "list files",
+		Actions: []core.ActionSpec{
+			{Name: "shell", Description: "run shell", Parameters: json.RawMessage(`{"type":"object","properties":{"command":{"type":"string"}}}`)},
+			{Name: "noschema"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(got.Tools) != 2 || got.Tools[0].Name != "shell" || !strings.Contains(string(got.Tools[0].InputSchema), "command") {
+		t.Fatalf("tools not advertised: %+v", got.Tools)
+	}
+	if string(got.Tools[1].InputSchema) != string(emptySchema) {
+		t.Fatalf("expected default schema, got %s", got.Tools[1].InputSchema)
+	}
+	if len(out.ActionCalls) != 1 {
+		t.Fatalf("expected 1 action call, got %+v", out.ActionCalls)
+	}
+	call := out.ActionCalls[0]
+	if call.ID != "toolu_1" || call.Name != "shell" || string(call.Args) != `{"command":"ls"}` {
+		t.Fatalf("unexpected call %+v (%s)", call, call.Args)
+	}
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	var got openAIRequest
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"readfile","arguments":"{\"path\":\"go.mod\"}"}}]},"finish_reason":"tool_calls"}]}`))
+	}))
+	defer srv.Close()
+
+	ag := New(Config{Provider: "openai", APIBase: srv.URL, Model: "gpt-test"})
+	out, err := ag.Generate(context.Background(), core.AgentRequest{
+		Prompt:  "show go.mod",
+		Actions: []core.ActionSpec{{Name: "readfile"}},
+	})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].Type != "function" || got.Tools[0].Function.Name != "readfile" {
+		t.Fatalf("tools not advertised: %+v", got.Tools)
+	}
+	if len(out.ActionCalls) != 1 || out.ActionCalls[0].ID != "call_1" || string(out.ActionCalls[0].Args) != `{"path":"go.mod"}` {
+		t.Fatalf("unexpected calls %+v", out.ActionCalls)
+	}
+}
+
+func TestStepsMapToToolResults(t *testing.T) {
+	steps := []core.MessageTurn{
+		{Role: core.RoleAgent, Text: "checking", ActionCalls: []core.ActionCall{{ID: "t1", Name: "shell", Args: json.RawMessage(`{"command":"ls"}`)}}},
+		{Role: core.RoleAction, CallID: "t1", Name: "shell", Text: "go.mod"},
+	}
+	msgs := []message{{Role: "user", Content: "list"}}
+
+	am := anthropicMessages(msgs, steps)
+	if len(am) != 3 || am[1].Role != "assistant" || am[2].Role != "user" {
+		t.Fatalf("unexpected anthropic messages %+v", am)
+	}
+	results, ok := am[2].Content.([]anthropicBlock)
+	if !ok || len(results) != 1 || results[0].Type != "tool_result" || results[0].ToolUseID != "t1" {
+		t.Fatalf("unexpected tool_result %+v", am[2].Content)
+	}
+
+	om := openAIMessages(msgs, steps)
+	if len(om) != 3 || len(om[1].ToolCalls) != 1 || om[2].Role != "tool" || om[2].ToolCallID != "t1" {
+		t.Fatalf("unexpected openai messages %+v", om)
 	}
 }
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+type openAIRequest struct {
+	Model     string          `json:"model"`
+	Messages  []openAIMessage `json:"messages"`
+	MaxTokens int             `json:"max_tokens,omitempty"`
+	Tools     []openAITool    `json:"tools,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Role      string           `json:"role"`
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+// openAIMessages converts text turns plus loop steps into chat messages:
+// agent steps carry tool_calls, action steps become role=tool messages.
+func openAIMessages(msgs []message, steps []core.MessageTurn) []openAIMessage {
+	out := make([]openAIMessage, 0, len(msgs)+len(steps))
+	for _, m := range msgs {
+		out = append(out, openAIMessage{Role: m.Role, Content: m.Content})
+	}
+	for _, st := range steps {
+		switch st.Role {
+		case core.RoleAgent:
+			msg := openAIMessage{Role: "assistant", Content: st.Text}
+			for _, call := range st.ActionCalls {
+				tc := openAIToolCall{ID: call.ID, Type: "function"}
+				tc.Function.Name = call.Name
+				tc.Function.Arguments = string(toolArgs(call.Args))
+				msg.ToolCalls = append(msg.ToolCalls, tc)
+			}
+			out = append(out, msg)
+		case core.RoleAction:
+			out = append(out, openAIMessage{Role: "tool", Content: st.Text, ToolCallID: st.CallID})
+		}
+	}
+	return out
+}
+
+// generateOpenAI calls POST /v1/chat/completions (OpenAI, Ollama, llama.cpp, vLLM...).
+func (a *Agent) generateOpenAI(ctx context.Context, msgs []message, steps []core.MessageTurn, actions []core.ActionSpec) (core.AgentResponse, error) {
+	body := openAIRequest{
+		Model:     a.cfg.Model,
+		Messages:  openAIMessages(msgs, steps),
+		MaxTokens: a.cfg.MaxTokens,
+	}
+	for _, spec := range actions {
+		body.Tools = append(body.Tools, openAITool{
+			Type: "function",
+			Function: openAIFunction{
+				Name:        spec.Name,
+				Description: toolDescription(spec),
+				Parameters:  toolSchema(spec),
+			},
+		})
+	}
+	headers := map[string]string{}
+	if a.cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + a.cfg.APIKey
+	}
+
+	var out openAIResponse
+	if err := a.postJSON(ctx, endpoint(a.cfg.APIBase, "/chat/completions"), headers, body, &out); err != nil {
+		return core.AgentResponse{}, err
+	}
+	if len(out.Choices) == 0 {
+		return core.AgentResponse{}, errors.New("http agent: no choices in response")
+	}
+	msg := out.Choices[0].Message
+	resp := core.AgentResponse{Reply: strings.TrimSpace(msg.Content)}
+	for _, tc := range msg.ToolCalls {
+		if tc.Function.Name == "" {
+			continue
+		}
+		resp.ActionCalls = append(resp.ActionCalls, core.ActionCall{
+			ID:   tc.ID,
+			Name: tc.Function.Name,
+			Args: toolArgs(json.RawMessage(tc.Function.Arguments)),
+		})
+	}
+	if resp.Reply == "" && len(resp.ActionCalls) == 0 {
+		return core.AgentResponse{}, errors.New("http agent: empty response")
+	}
+	return resp, nil
+}
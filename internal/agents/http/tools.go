@@ -0,0 +1,34 @@
+package http
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// emptySchema is advertised for actions that do not publish an argument schema.
+var emptySchema = json.RawMessage(`{"type":"object","properties":{}}`)
+
+func toolSchema(spec core.ActionSpec) json.RawMessage {
+	if len(spec.Parameters) == 0 || !json.Valid(spec.Parameters) {
+		return emptySchema
+	}
+	return spec.Parameters
+}
+
+func toolDescription(spec core.ActionSpec) string {
+	if d := strings.TrimSpace(spec.Description); d != "" {
+		return d
+	}
+	return "Invoke the " + spec.Name + " action."
+}
+
+// toolArgs normalizes provider-supplied arguments into a JSON object.
+func toolArgs(raw json.RawMessage) json.RawMessage {
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" || trimmed == "null" || !json.Valid([]byte(trimmed)) {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(trimmed)
+}
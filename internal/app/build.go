@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/joelklabo/buddy/internal/actions/fs"
@@ -14,21 +16,46 @@ import (
 	"github.com/joelklabo/buddy/internal/agents/http"
 	"github.com/joelklabo/buddy/internal/config"
 	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/nostrclient"
 	"github.com/joelklabo/buddy/internal/store"
+	tdiscord "github.com/joelklabo/buddy/internal/transports/discord"
 	imap "github.com/joelklabo/buddy/internal/transports/email/imap"
 	memg "github.com/joelklabo/buddy/internal/transports/email/mailgun"
+	thttp "github.com/joelklabo/buddy/internal/transports/http"
+	tmatrix "github.com/joelklabo/buddy/internal/transports/matrix"
 	tmock "github.com/joelklabo/buddy/internal/transports/mock"
 	tnostr "github.com/joelklabo/buddy/internal/transports/nostr"
+	tslack "github.com/joelklabo/buddy/internal/transports/slack"
+	tstdio "github.com/joelklabo/buddy/internal/transports/stdio"
+	ttg "github.com/joelklabo/buddy/internal/transports/telegram"
 	twa "github.com/joelklabo/buddy/internal/transports/whatsapp"
 )
 
 // Build constructs transports, agent, and actions from config.
 func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runner, error) {
 	transports := make([]core.Transport, 0, len(cfg.Transports))
+	// Chat transports check their own allowlists (user IDs, not Nostr pubkeys), so the
+	// runner's pubkey allowlist must not apply to them.
+	var senderOpts []core.RunnerOption
 	for _, t := range cfg.Transports {
 		switch t.Type {
 		case "nostr":
-			nt, err := tnostr.New(tnostr.Config{Relays: t.Relays, PrivateKey: t.PrivateKey, AllowedPubkeys: t.AllowedPubkeys}, st)
+			nt, err := tnostr.New(tnostr.Config{
+				Relays:          t.Relays,
+				PrivateKey:      t.PrivateKey,
+				Bunker:          t.Bunker,
+				BunkerClientKey: t.BunkerClientKey,
+				AllowedPubkeys:  t.AllowedPubkeys,
+				RequireNIP17:    t.RequireNIP17,
+				DVMKinds:        t.DVMKinds,
+				Profile: nostrclient.Profile{
+					Name:    cfg.Runner.ProfileName,
+					Picture: cfg.Runner.ProfileImage,
+					About:   cfg.Runner.ProfileAbout,
+					NIP05:   cfg.Runner.ProfileNIP05,
+					LUD16:   cfg.Runner.ProfileLUD16,
+				},
+			}, st)
 			if err != nil {
 				return nil, err
 			}
@@ -49,6 +76,9 @@ func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runn
 				if mcfg.ID == "" {
 					mcfg.ID = t.ID
 				}
+				if mcfg.AttachmentsDir == "" {
+					mcfg.AttachmentsDir = attachmentsDir(cfg)
+				}
 				mt, err := memg.New(mcfg)
 				if err != nil {
 					return nil, err
@@ -62,11 +92,20 @@ func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runn
 				if icfg.ID == "" {
 					icfg.ID = t.ID
 				}
-				it, err := imap.New(icfg)
+				if icfg.AttachmentsDir == "" {
+					icfg.AttachmentsDir = attachmentsDir(cfg)
+				}
+				var posStore store.StoreAPI
+				if st != nil {
+					posStore = st
+				}
+				it, err := imap.New(icfg, posStore, logger)
 				if err != nil {
 					return nil, err
 				}
 				transports = append(transports, it)
+				// Senders are email addresses, checked against allow_senders by the transport.
+				senderOpts = append(senderOpts, core.WithTransportSenders(it.ID(), nil))
 			default:
 				return nil, fmt.Errorf("unknown email mode %s", mode)
 			}
@@ -79,11 +118,103 @@ func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runn
 			if wcfg.ID == "" {
 				wcfg.ID = t.ID
 			}
+			if wcfg.MediaDir == "" {
+				wcfg.MediaDir = attachmentsDir(cfg)
+			}
 			wt, err := twa.New(wcfg, logger)
 			if err != nil {
 				return nil, err
 			}
 			transports = append(transports, wt)
+			// Senders are phone numbers, checked against allowed_numbers by the transport.
+			senderOpts = append(senderOpts, core.WithTransportSenders(wt.ID(), nil))
+		case "slack":
+			var scfg tslack.Config
+			if err := decodeMap(t.Config, &scfg); err != nil {
+				return nil, fmt.Errorf("decode slack config: %w", err)
+			}
+			if scfg.ID == "" {
+				scfg.ID = t.ID
+			}
+			slt, err := tslack.New(scfg, logger)
+			if err != nil {
+				return nil, err
+			}
+			transports = append(transports, slt)
+			senderOpts = append(senderOpts, core.WithTransportSenders(slt.ID(), nil))
+		case "telegram":
+			var tcfg ttg.Config
+			if err := decodeMap(t.Config, &tcfg); err != nil {
+				return nil, fmt.Errorf("decode telegram config: %w", err)
+			}
+			if tcfg.ID == "" {
+				tcfg.ID = t.ID
+			}
+			tgt, err := ttg.New(tcfg, logger)
+			if err != nil {
+				return nil, err
+			}
+			transports = append(transports, tgt)
+			senderOpts = append(senderOpts, core.WithTransportSenders(tgt.ID(), nil))
+		case "matrix":
+			var mxcfg tmatrix.Config
+			if err := decodeMap(t.Config, &mxcfg); err != nil {
+				return nil, fmt.Errorf("decode matrix config: %w", err)
+			}
+			if mxcfg.ID == "" {
+				mxcfg.ID = t.ID
+			}
+			var syncStore store.StoreAPI
+			if st != nil {
+				syncStore = st
+			}
+			mxt, err := tmatrix.New(mxcfg, syncStore, logger)
+			if err != nil {
+				return nil, err
+			}
+			transports = append(transports, mxt)
+			senderOpts = append(senderOpts, core.WithTransportSenders(mxt.ID(), nil))
+		case "discord":
+			var dcfg tdiscord.Config
+			if err := decodeMap(t.Config, &dcfg); err != nil {
+				return nil, fmt.Errorf("decode discord config: %w", err)
+			}
+			if dcfg.ID == "" {
+				dcfg.ID = t.ID
+			}
+			dt, err := tdiscord.New(dcfg, logger)
+			if err != nil {
+				return nil, err
+			}
+			transports = append(transports, dt)
+			senderOpts = append(senderOpts, core.WithTransportSenders(dt.ID(), nil))
+		case "http":
+			var hcfg thttp.Config
+			if err := decodeMap(t.Config, &hcfg); err != nil {
+				return nil, fmt.Errorf("decode http config: %w", err)
+			}
+			if hcfg.ID == "" {
+				hcfg.ID = t.ID
+			}
+			ht, err := thttp.New(hcfg, logger)
+			if err != nil {
+				return nil, err
+			}
+			transports = append(transports, ht)
+			// Callers are authenticated by token or signature; allowed_senders is checked by the transport.
+			senderOpts = append(senderOpts, core.WithTransportSenders(ht.ID(), nil))
+		case "stdio":
+			var iocfg tstdio.Config
+			if err := decodeMap(t.Config, &iocfg); err != nil {
+				return nil, fmt.Errorf("decode stdio config: %w", err)
+			}
+			if iocfg.ID == "" {
+				iocfg.ID = t.ID
+			}
+			iot := tstdio.New(iocfg, os.Stdin, os.Stdout)
+			transports = append(transports, iot)
+			// Whoever holds the terminal is the operator.
+			senderOpts = append(senderOpts, core.WithTransportSenders(iot.ID(), nil))
 		default:
 			return nil, fmt.Errorf("unknown transport type %s", t.Type)
 		}
@@ -97,7 +228,14 @@ func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runn
 	case "echo":
 		agent = echo.New()
 	case "http":
-		agent = http.New(http.Config{APIBase: agentCfg.Binary, Model: agentCfg.Profile}) // placeholder reuse fields
+		agent = http.New(http.Config{
+			Provider:       agentCfg.Provider,
+			APIBase:        agentCfg.BaseURL,
+			Model:          agentCfg.Model,
+			APIKey:         agentCfg.APIKey,
+			TimeoutSeconds: agentCfg.TimeoutSeconds,
+			MaxTokens:      agentCfg.MaxTokens,
+		})
 	case "copilotcli":
 		agent = copilotcli.New(copilotcli.Config{
 			Binary:         agentCfg.Binary,
@@ -111,34 +249,63 @@ func Build(cfg *config.Config, st *store.Store, logger *slog.Logger) (*core.Runn
 	}
 
 	actions := make([]core.Action, 0, len(cfg.Actions))
+	var approvalOpts []core.RunnerOption
 	for _, a := range cfg.Actions {
+		var act core.Action
 		switch a.Type {
 		case "shell":
-			actions = append(actions, shell.New(shell.Config{
+			act = shell.New(shell.Config{
 				Workdir:        a.Workdir,
 				Allowed:        a.Allowed,
 				TimeoutSeconds: a.TimeoutSecs,
 				MaxOutput:      a.MaxOutput,
-			}))
+			})
 		case "readfile":
-			actions = append(actions, fs.NewReadFile(fs.Config{Roots: a.Roots, MaxBytes: a.MaxBytes}))
+			act = fs.NewReadFile(fs.Config{Roots: a.Roots, MaxBytes: a.MaxBytes})
 		case "writefile":
-			actions = append(actions, fs.NewWriteFile(fs.Config{Roots: a.Roots, MaxBytes: a.MaxBytes, AllowWrite: a.AllowWrite}))
+			act = fs.NewWriteFile(fs.Config{Roots: a.Roots, MaxBytes: a.MaxBytes, AllowWrite: a.AllowWrite})
 		default:
 			return nil, fmt.Errorf("unknown action type %s", a.Type)
 		}
+		actions = append(actions, act)
+		if a.Approval != "" || len(a.ApprovalPatterns) > 0 {
+			approvalOpts = append(approvalOpts, core.WithApprovalPolicy(act.Name(), core.ApprovalPolicy{Mode: a.Approval, Patterns: a.ApprovalPatterns}))
+		}
 	}
 
-	r := core.NewRunner(transports, agent, actions, logger,
+	opts := []core.RunnerOption{
 		core.WithAllowedSenders(cfg.Runner.AllowedPubkeys),
 		core.WithStore(st),
-		core.WithSessionTimeout(time.Duration(cfg.Runner.SessionTimeoutMins)*time.Minute),
+		core.WithHistoryStore(st),
+		core.WithAuditLogger(st),
+		core.WithOutbox(st, cfg.Runner.OutboxMaxAttempts),
+		core.WithHistoryLimit(cfg.Runner.HistoryTurns, cfg.Runner.HistoryTokens),
+		core.WithSessionTimeout(time.Duration(cfg.Runner.SessionTimeoutMins) * time.Minute),
 		core.WithInitialPrompt(cfg.Runner.InitialPrompt),
 		core.WithMaxReplyChars(cfg.Runner.MaxReplyChars),
-	)
+		core.WithMaxReplyParts(cfg.Runner.MaxReplyParts),
+		core.WithMaxSteps(cfg.Runner.MaxSteps),
+		core.WithLoopBudget(time.Duration(cfg.Runner.LoopBudgetSecs) * time.Second),
+		core.WithWorkers(cfg.Runner.Workers),
+		core.WithDrainTimeout(time.Duration(cfg.Runner.DrainTimeoutSecs) * time.Second),
+		core.WithProgressInterval(time.Duration(cfg.Runner.ProgressSecs) * time.Second),
+		core.WithApprovalTimeout(time.Duration(cfg.Runner.ApprovalTimeoutSecs) * time.Second),
+	}
+	r := core.NewRunner(transports, agent, actions, logger, append(append(opts, senderOpts...), approvalOpts...)...)
 	return r, nil
 }
 
+// attachmentsDir is where email attachments and WhatsApp media go by default: a directory under the first
+// readfile root, so the agent can open them. "" if no readfile action has roots.
+func attachmentsDir(cfg *config.Config) string {
+	for _, a := range cfg.Actions {
+		if a.Type == "readfile" && len(a.Roots) > 0 && a.Roots[0] != "" {
+			return filepath.Join(a.Roots[0], "buddy-attachments")
+		}
+	}
+	return ""
+}
+
 // decodeMap marshals a generic map into a typed struct via JSON.
 func decodeMap(m map[string]any, out any) error {
 	if len(m) == 0 {
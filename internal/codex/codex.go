@@ -28,6 +28,12 @@ type Result struct {
 	RawLines  []string
 }
 
+// Event is an interim item parsed from the Codex JSONL stream while a run is in progress.
+type Event struct {
+	Kind string // reasoning, command, or message
+	Text string
+}
+
 // New creates a Runner with the provided config.
 func New(cfg config.CodexConfig) *Runner {
 	return &Runner{cfg: cfg}
@@ -36,6 +42,12 @@ func New(cfg config.CodexConfig) *Runner {
 // Run executes a prompt. If sessionID is empty, a new Codex session is started;
 // otherwise the session is resumed.
 func (r *Runner) Run(ctx context.Context, sessionID string, prompt string) (Result, error) {
+	return r.RunStream(ctx, sessionID, prompt, nil)
+}
+
+// RunStream is Run, additionally reporting reasoning, command, and message items
+// to onEvent as Codex prints them. onEvent may be nil.
+func (r *Runner) RunStream(ctx context.Context, sessionID string, prompt string, onEvent func(Event)) (Result, error) {
 	if strings.TrimSpace(prompt) == "" {
 		return Result{}, errors.New("prompt cannot be empty")
 	}
@@ -73,15 +85,75 @@ func (r *Runner) Run(ctx context.Context, sessionID string, prompt string) (Resu
 		cmd.Dir = expandPath(r.cfg.WorkingDir)
 	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
+	var stderr bytes.Buffer
+	stdout := &lineWriter{onLine: func(line string) {
+		if onEvent == nil {
+			return
+		}
+		if evt, ok := eventFromLine(line); ok {
+			onEvent(evt)
+		}
+	}}
+	cmd.Stdout = stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
 		return Result{}, fmt.Errorf("codex exec failed: %w; stderr: %s", err, stderr.String())
 	}
 
-	return parseCodexJSONL(stdout.Bytes())
+	return parseCodexJSONL(stdout.buf.Bytes())
+}
+
+// lineWriter buffers all output and reports each complete line as it arrives.
+type lineWriter struct {
+	buf     bytes.Buffer
+	partial []byte
+	onLine  func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// eventFromLine maps one Codex JSONL line to a progress event: reasoning and agent
+// messages when completed, shell commands when they start.
+func eventFromLine(line string) (Event, bool) {
+	var evt struct {
+		Type string `json:"type"`
+		Item *struct {
+			Type    string `json:"type"`
+			Text    string `json:"text"`
+			Command string `json:"command"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal([]byte(line), &evt); err != nil || evt.Item == nil {
+		return Event{}, false
+	}
+	switch evt.Item.Type {
+	case "reasoning":
+		if evt.Item.Text != "" {
+			return Event{Kind: "reasoning", Text: evt.Item.Text}, true
+		}
+	case "command_execution":
+		if evt.Type == "item.started" && evt.Item.Command != "" {
+			return Event{Kind: "command", Text: evt.Item.Command}, true
+		}
+	case "agent_message":
+		if evt.Item.Text != "" {
+			return Event{Kind: "message", Text: evt.Item.Text}, true
+		}
+	}
+	return Event{}, false
 }
 
 // parseCodexJSONL extracts the session id and final agent message from Codex JSONL output.
@@ -134,3 +134,37 @@ func TestRunResumeAddsSessionID(t *testing.T) {
 		t.Fatalf("expected resume args, got %s", argStr)
 	}
 }
+
+func TestRunStreamReportsEvents(t *testing.T) {
+	td := t.TempDir()
+	bin := filepath.Join(td, "codexstream")
+	script := `#!/usr/bin/env bash
+echo '{"type":"thread.started","thread_id":"s1"}'
+echo '{"type":"item.completed","item":{"type":"reasoning","text":"Planning tests"}}'
+echo '{"type":"item.started","item":{"type":"command_execution","command":"go test ./...","status":"in_progress"}}'
+echo '{"type":"item.completed","item":{"type":"command_execution","command":"go test ./...","status":"completed"}}'
+echo '{"type":"item.completed","item":{"type":"agent_message","text":"all green"}}'
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	var events []Event
+	r := New(config.CodexConfig{Binary: bin})
+	res, err := r.RunStream(context.Background(), "", "hello", func(e Event) { events = append(events, e) })
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if res.SessionID != "s1" || res.Reply != "all green" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	want := []Event{{"reasoning", "Planning tests"}, {"command", "go test ./..."}, {"message", "all green"}}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d: got %+v want %+v", i, events[i], want[i])
+		}
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 // Command represents a parsed user instruction carried over transports.
 type Command struct {
-	Name string // run|new|reset|use|status|help|shell
+	Name string // run|new|reset|use|status|help|shell|more|cancel|approve|deny
 	Args string // remaining text after the command keyword
 	Raw  string // original user message
 }
@@ -20,6 +20,10 @@ type Command struct {
 //	"/status"                      -> show active session info
 //	"/help"                        -> usage help
 //	"/shell <command>"             -> run a shell action (if enabled)
+//	"/more" or "more"              -> send the next held-back parts of a long reply
+//	"/cancel" or "cancel"          -> abort the in-flight request
+//	"/approve <code>"              -> allow a pending action call
+//	"/deny [code]"                 -> refuse a pending action call (all when no code)
 //	Anything else                  -> run prompt in the active/new session
 func Parse(msg string) Command {
 	trimmed := strings.TrimSpace(msg)
@@ -46,6 +50,14 @@ func Parse(msg string) Command {
 		return Command{Name: "status", Raw: msg}
 	case strings.HasPrefix(lower, "status"):
 		return Command{Name: "status", Raw: msg}
+	case strings.HasPrefix(lower, "/more"), lower == "more":
+		return Command{Name: "more", Raw: msg}
+	case strings.HasPrefix(lower, "/cancel"), lower == "cancel":
+		return Command{Name: "cancel", Raw: msg}
+	case strings.HasPrefix(lower, "/approve"):
+		return Command{Name: "approve", Args: strings.TrimSpace(trimmed[8:]), Raw: msg}
+	case strings.HasPrefix(lower, "/deny"):
+		return Command{Name: "deny", Args: strings.TrimSpace(trimmed[5:]), Raw: msg}
 	case strings.HasPrefix(lower, "/help"):
 		return Command{Name: "help", Raw: msg}
 	case strings.HasPrefix(lower, "help"):
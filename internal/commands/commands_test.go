@@ -18,6 +18,15 @@ func TestParseCommands(t *testing.T) {
 		{"/help", "help", ""},
 		{"/shell ls -la", "shell", "ls -la"},
 		{"shell ls -la", "shell", "ls -la"},
+		{"/more", "more", ""},
+		{"more", "more", ""},
+		{"more details please", "run", "more details please"},
+		{"/cancel", "cancel", ""},
+		{"cancel", "cancel", ""},
+		{"/approve ab12cd", "approve", "ab12cd"},
+		{"/deny", "deny", ""},
+		{"/deny ab12cd", "deny", "ab12cd"},
+		{"approve the plan", "run", "approve the plan"},
 		{"free text prompt", "run", "free text prompt"},
 	}
 	for _, tc := range cases {
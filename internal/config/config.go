@@ -28,14 +28,29 @@ type Config struct {
 
 // RunnerConfig controls Nostr-facing behaviour.
 type RunnerConfig struct {
-	PrivateKey         string   `yaml:"private_key"`
-	AllowedPubkeys     []string `yaml:"allowed_pubkeys"`
-	AutoReply          bool     `yaml:"auto_reply"`
-	MaxReplyChars      int      `yaml:"max_reply_chars"`
-	SessionTimeoutMins int      `yaml:"session_timeout_minutes"`
-	InitialPrompt      string   `yaml:"initial_prompt"`
-	ProfileName        string   `yaml:"profile_name"`
-	ProfileImage       string   `yaml:"profile_image"`
+	PrivateKey          string   `yaml:"private_key"`
+	Bunker              string   `yaml:"bunker,omitempty"`            // NIP-46 bunker:// URL used instead of private_key
+	BunkerClientKey     string   `yaml:"bunker_client_key,omitempty"` // identifies this runner to the bunker
+	AllowedPubkeys      []string `yaml:"allowed_pubkeys"`
+	AutoReply           bool     `yaml:"auto_reply"`
+	MaxReplyChars       int      `yaml:"max_reply_chars"`
+	MaxReplyParts       int      `yaml:"max_reply_parts,omitempty"` // parts sent per reply before /more
+	SessionTimeoutMins  int      `yaml:"session_timeout_minutes"`
+	InitialPrompt       string   `yaml:"initial_prompt"`
+	ProfileName         string   `yaml:"profile_name"`
+	ProfileImage        string   `yaml:"profile_image"`
+	ProfileAbout        string   `yaml:"profile_about,omitempty"`
+	ProfileNIP05        string   `yaml:"profile_nip05,omitempty"`
+	ProfileLUD16        string   `yaml:"profile_lud16,omitempty"`       // lightning address for zaps
+	MaxSteps            int      `yaml:"max_steps,omitempty"`           // agent/action loop iterations per message
+	LoopBudgetSecs      int      `yaml:"loop_budget_seconds,omitempty"` // total time for the loop
+	HistoryTurns        int      `yaml:"history_turns,omitempty"`       // recent turns passed to agents
+	HistoryTokens       int      `yaml:"history_tokens,omitempty"`      // optional approximate token budget for history
+	Workers             int      `yaml:"workers,omitempty"`             // conversations processed concurrently
+	DrainTimeoutSecs    int      `yaml:"drain_timeout_seconds,omitempty"`
+	ProgressSecs        int      `yaml:"progress_interval_seconds,omitempty"` // min gap between progress updates; -1 disables
+	ApprovalTimeoutSecs int      `yaml:"approval_timeout_seconds,omitempty"`  // how long an approval request waits
+	OutboxMaxAttempts   int      `yaml:"outbox_max_attempts,omitempty"`       // failed deliveries before a reply is dead-lettered
 }
 
 // CodexConfig controls how we invoke the codex CLI.
@@ -48,6 +63,13 @@ type CodexConfig struct {
 	ExtraArgs        []string `yaml:"extra_args"`
 	SkipGitRepoCheck bool     `yaml:"skip_git_repo_check"`
 	TimeoutSeconds   int      `yaml:"timeout_seconds"`
+
+	// HTTP agent fields (used when agent.type=http)
+	Provider  string `yaml:"provider,omitempty"` // anthropic|openai; inferred from base_url when empty
+	BaseURL   string `yaml:"base_url,omitempty"`
+	Model     string `yaml:"model,omitempty"`
+	APIKey    string `yaml:"api_key,omitempty"`
+	MaxTokens int    `yaml:"max_tokens,omitempty"`
 }
 
 // StorageConfig controls persistence.
@@ -76,9 +98,13 @@ type TransportConfig struct {
 	Config map[string]any `yaml:"config"` // generic, transport-specific fields
 
 	// Nostr-specific fields (used when type=nostr)
-	Relays         []string `yaml:"relays"`
-	PrivateKey     string   `yaml:"private_key"`
-	AllowedPubkeys []string `yaml:"allowed_pubkeys"`
+	Relays          []string `yaml:"relays"`
+	PrivateKey      string   `yaml:"private_key"`
+	Bunker          string   `yaml:"bunker,omitempty"`            // NIP-46 bunker:// URL used instead of private_key
+	BunkerClientKey string   `yaml:"bunker_client_key,omitempty"` // identifies this runner to the bunker
+	AllowedPubkeys  []string `yaml:"allowed_pubkeys"`
+	RequireNIP17    bool     `yaml:"require_nip17,omitempty"` // ignore NIP-04 DMs; reply only with gift wraps
+	DVMKinds        []int    `yaml:"dvm_kinds,omitempty"`     // NIP-90 job request kinds (5000-5999) to serve
 }
 
 // AgentConfig holds agent selection and backend config.
@@ -101,24 +127,39 @@ type ActionConfig struct {
 	Capabilities     []string `yaml:"capabilities"`
 	Description      string   `yaml:"description"`
 	UnsafeAllowEmpty bool     `yaml:"unsafe_allow_empty"`
+	Approval         string   `yaml:"approval,omitempty"`          // never|always|patterns: when agent calls need sender approval
+	ApprovalPatterns []string `yaml:"approval_patterns,omitempty"` // regexps over call arguments (patterns mode)
 }
 
 // Load reads and validates configuration from the provided path.
 func Load(path string) (*Config, error) {
+	return LoadWith(path, nil)
+}
+
+// LoadWith is Load with adjust applied to the parsed config before defaults and validation.
+func LoadWith(path string, adjust func(*Config)) (*Config, error) {
 	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
-	return LoadBytes(raw, filepath.Dir(path))
+	return LoadBytesWith(raw, filepath.Dir(path), adjust)
 }
 
 // LoadBytes parses config YAML from bytes and validates, using baseDir for relative paths.
 func LoadBytes(raw []byte, baseDir string) (*Config, error) {
+	return LoadBytesWith(raw, baseDir, nil)
+}
 
+// LoadBytesWith is LoadBytes with adjust applied to the parsed config before defaults and
+// validation, e.g. to replace its transports.
+func LoadBytesWith(raw []byte, baseDir string, adjust func(*Config)) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(raw, &cfg); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	if adjust != nil {
+		adjust(&cfg)
+	}
 
 	cfg.applyDefaults(baseDir)
 	cfg.Storage.Path = expandPath(cfg.Storage.Path)
@@ -128,9 +169,13 @@ func LoadBytes(raw []byte, baseDir string) (*Config, error) {
 	return &cfg, nil
 }
 
-// GetRunnerPubKey derives the runner's public key from its private key.
+// GetRunnerPubKey derives the runner's public key from its private key. A bunker holds the
+// key remotely, so its pubkey is only known after connecting.
 func (c *Config) GetRunnerPubKey() (string, error) {
 	if c.Runner.PrivateKey == "" {
+		if c.Runner.Bunker != "" {
+			return "", errors.New("runner pubkey is held by the bunker")
+		}
 		return "", errors.New("runner.private_key is required")
 	}
 	pub, err := nostr.GetPublicKey(c.Runner.PrivateKey)
@@ -142,8 +187,13 @@ func (c *Config) GetRunnerPubKey() (string, error) {
 
 // Validate ensures the config is usable.
 func (c *Config) Validate() error {
-	if c.Runner.PrivateKey == "" {
-		return errors.New("runner.private_key is required")
+	if c.Runner.PrivateKey == "" && c.Runner.Bunker == "" {
+		return errors.New("runner.private_key or runner.bunker is required")
+	}
+	if c.Runner.Bunker != "" {
+		if err := validateBunkerURL(c.Runner.Bunker); err != nil {
+			return fmt.Errorf("runner.bunker: %w", err)
+		}
 	}
 	if len(c.Runner.AllowedPubkeys) == 0 {
 		return errors.New("runner.allowed_pubkeys must contain at least one key")
@@ -257,12 +307,14 @@ func (c *Config) applyDefaults(baseDir string) {
 	// Defaults for plugin schema (backward compat)
 	if len(c.Transports) == 0 {
 		c.Transports = []TransportConfig{{
-			Type:           "nostr",
-			ID:             "nostr",
-			Relays:         c.Relays,
-			PrivateKey:     c.Runner.PrivateKey,
-			AllowedPubkeys: c.Runner.AllowedPubkeys,
-			Config:         map[string]any{},
+			Type:            "nostr",
+			ID:              "nostr",
+			Relays:          c.Relays,
+			PrivateKey:      c.Runner.PrivateKey,
+			Bunker:          c.Runner.Bunker,
+			BunkerClientKey: c.Runner.BunkerClientKey,
+			AllowedPubkeys:  c.Runner.AllowedPubkeys,
+			Config:          map[string]any{},
 		}}
 	}
 	hasNostr := false
@@ -273,7 +325,7 @@ func (c *Config) applyDefaults(baseDir string) {
 		}
 	}
 	if !hasNostr {
-		if c.Runner.PrivateKey == "" {
+		if c.Runner.PrivateKey == "" && c.Runner.Bunker == "" {
 			c.Runner.PrivateKey = "mock"
 		}
 		if len(c.Runner.AllowedPubkeys) == 0 {
@@ -72,3 +72,141 @@ func TestValidateProjectPath(t *testing.T) {
 		t.Fatalf("expected validation error for empty project path")
 	}
 }
+
+func TestValidateActionsApproval(t *testing.T) {
+	ok := Config{Actions: []ActionConfig{{Type: "shell", Approval: "patterns", ApprovalPatterns: []string{`^rm `}}}}
+	if err := ok.ValidateActions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad := []ActionConfig{
+		{Type: "shell", Approval: "sometimes"},
+		{Type: "shell", Approval: "patterns"},
+		{Type: "shell", ApprovalPatterns: []string{"("}},
+	}
+	for _, a := range bad {
+		cfg := Config{Actions: []ActionConfig{a}}
+		if err := cfg.ValidateActions(); err == nil {
+			t.Fatalf("expected error for %+v", a)
+		}
+	}
+}
+
+func TestValidateTransportsTelegram(t *testing.T) {
+	ok := Config{Transports: []TransportConfig{{Type: "telegram", Config: map[string]any{"bot_token": "123:abc", "allowed_users": []any{42}}}}}
+	if err := ok.ValidateTransports(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad := []map[string]any{
+		{"allowed_users": []any{42}},
+		{"bot_token": "123:abc"},
+	}
+	for _, c := range bad {
+		cfg := Config{Transports: []TransportConfig{{Type: "telegram", Config: c}}}
+		if err := cfg.ValidateTransports(); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestValidateTransportsMatrix(t *testing.T) {
+	valid := map[string]any{"homeserver": "https://hs", "access_token": "tok", "allowed_users": []any{"@joel:hs"}}
+	ok := Config{Transports: []TransportConfig{{Type: "matrix", Config: valid}}}
+	if err := ok.ValidateTransports(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, drop := range []string{"homeserver", "access_token", "allowed_users"} {
+		c := map[string]any{}
+		for k, v := range valid {
+			if k != drop {
+				c[k] = v
+			}
+		}
+		cfg := Config{Transports: []TransportConfig{{Type: "matrix", Config: c}}}
+		if err := cfg.ValidateTransports(); err == nil {
+			t.Fatalf("expected error without %s", drop)
+		}
+	}
+}
+
+func TestValidateTransportsWhatsApp(t *testing.T) {
+	valid := map[string]any{
+		"account_sid":     "AC123",
+		"auth_token":      "tok",
+		"from_number":     "whatsapp:+15550001234",
+		"allowed_numbers": []any{"+15555550100"},
+	}
+	ok := Config{Transports: []TransportConfig{{Type: "whatsapp", Config: valid}}}
+	if err := ok.ValidateTransports(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, drop := range []string{"account_sid", "auth_token", "from_number", "allowed_numbers"} {
+		c := map[string]any{}
+		for k, v := range valid {
+			if k != drop {
+				c[k] = v
+			}
+		}
+		cfg := Config{Transports: []TransportConfig{{Type: "whatsapp", Config: c}}}
+		if err := cfg.ValidateTransports(); err == nil {
+			t.Fatalf("expected error without %s", drop)
+		}
+	}
+	for key, bad := range map[string]string{"from_number": "+15550001234", "public_url": "bot.example.com"} {
+		c := map[string]any{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[key] = bad
+		cfg := Config{Transports: []TransportConfig{{Type: "whatsapp", Config: c}}}
+		if err := cfg.ValidateTransports(); err == nil {
+			t.Fatalf("expected error for %s %q", key, bad)
+		}
+	}
+}
+
+func TestValidateTransportsHTTP(t *testing.T) {
+	for _, c := range []map[string]any{{"bearer_token": "tok"}, {"hmac_secret": "s"}} {
+		cfg := Config{Transports: []TransportConfig{{Type: "http", Config: c}}}
+		if err := cfg.ValidateTransports(); err != nil {
+			t.Fatalf("unexpected error for %v: %v", c, err)
+		}
+	}
+	cfg := Config{Transports: []TransportConfig{{Type: "http", Config: map[string]any{"listen": ":8086"}}}}
+	if err := cfg.ValidateTransports(); err == nil {
+		t.Fatalf("expected error without credentials")
+	}
+}
+
+func TestValidateTransportsNostrBunker(t *testing.T) {
+	signer, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	nostrT := TransportConfig{Type: "nostr", Relays: []string{"wss://relay"}, AllowedPubkeys: []string{"pk"}}
+	for bunker, ok := range map[string]bool{
+		"bunker://" + signer + "?relay=wss://relay.nsec.app&secret=s": true,
+		"bunker://" + signer:                       false,
+		"bunker://npub1xyz?relay=wss://relay":      false,
+		"https://" + signer + "?relay=wss://relay": false,
+	} {
+		nt := nostrT
+		nt.Bunker = bunker
+		cfg := Config{Transports: []TransportConfig{nt}}
+		if err := cfg.ValidateTransports(); (err == nil) != ok {
+			t.Fatalf("bunker %q: ok=%v err=%v", bunker, ok, err)
+		}
+	}
+	cfg := Config{Transports: []TransportConfig{nostrT}}
+	if err := cfg.ValidateTransports(); err == nil {
+		t.Fatalf("expected error without private_key or bunker")
+	}
+}
+
+func TestValidateTransportsNostrDVMKinds(t *testing.T) {
+	nt := TransportConfig{Type: "nostr", Relays: []string{"wss://relay"}, PrivateKey: "k", AllowedPubkeys: []string{"pk"}, DVMKinds: []int{5050}}
+	cfg := Config{Transports: []TransportConfig{nt}}
+	if err := cfg.ValidateTransports(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg.Transports[0].DVMKinds = []int{6050}
+	if err := cfg.ValidateTransports(); err == nil {
+		t.Fatalf("expected error for a result kind")
+	}
+}
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
 )
 
 // ValidateTransports performs type-specific validation beyond core presence checks.
@@ -24,18 +30,81 @@ func (c *Config) ValidateTransports() error {
 			if len(t.Relays) == 0 {
 				return fmt.Errorf("transport %q: relays required", t.ID)
 			}
-			if t.PrivateKey == "" {
-				return fmt.Errorf("transport %q: private_key required", t.ID)
+			if t.PrivateKey == "" && t.Bunker == "" {
+				return fmt.Errorf("transport %q: private_key or bunker required", t.ID)
+			}
+			if t.Bunker != "" {
+				if err := validateBunkerURL(t.Bunker); err != nil {
+					return fmt.Errorf("transport %q: bunker: %w", t.ID, err)
+				}
 			}
 			if len(t.AllowedPubkeys) == 0 {
 				return fmt.Errorf("transport %q: allowed_pubkeys required", t.ID)
 			}
-		case "mock":
+			for _, k := range t.DVMKinds {
+				if k < 5000 || k > 5999 {
+					return fmt.Errorf("transport %q: dvm_kinds: %d is not a NIP-90 job request kind (5000-5999)", t.ID, k)
+				}
+			}
+		case "mock", "stdio":
 			// no extra validation
 		case "email":
 			if _, ok := t.Config["mode"]; !ok {
 				t.Config["mode"] = "mailgun"
 			}
+		case "slack":
+			if token, _ := t.Config["bot_token"].(string); token == "" {
+				return fmt.Errorf("transport %q: bot_token required", t.ID)
+			}
+			if users, _ := t.Config["allowed_users"].([]any); len(users) == 0 {
+				return fmt.Errorf("transport %q: allowed_users required", t.ID)
+			}
+		case "telegram":
+			if token, _ := t.Config["bot_token"].(string); token == "" {
+				return fmt.Errorf("transport %q: bot_token required", t.ID)
+			}
+			if users, _ := t.Config["allowed_users"].([]any); len(users) == 0 {
+				return fmt.Errorf("transport %q: allowed_users required", t.ID)
+			}
+		case "discord":
+			if token, _ := t.Config["bot_token"].(string); token == "" {
+				return fmt.Errorf("transport %q: bot_token required", t.ID)
+			}
+			if users, _ := t.Config["allowed_users"].([]any); len(users) == 0 {
+				return fmt.Errorf("transport %q: allowed_users required", t.ID)
+			}
+		case "matrix":
+			for _, key := range []string{"homeserver", "access_token"} {
+				if v, _ := t.Config[key].(string); v == "" {
+					return fmt.Errorf("transport %q: %s required", t.ID, key)
+				}
+			}
+			if users, _ := t.Config["allowed_users"].([]any); len(users) == 0 {
+				return fmt.Errorf("transport %q: allowed_users required", t.ID)
+			}
+		case "whatsapp":
+			for _, key := range []string{"account_sid", "auth_token", "from_number"} {
+				if v, _ := t.Config[key].(string); v == "" {
+					return fmt.Errorf("transport %q: %s required", t.ID, key)
+				}
+			}
+			if from, _ := t.Config["from_number"].(string); !strings.HasPrefix(from, "whatsapp:") {
+				return fmt.Errorf("transport %q: from_number must look like whatsapp:+15550001234", t.ID)
+			}
+			if numbers, _ := t.Config["allowed_numbers"].([]any); len(numbers) == 0 {
+				return fmt.Errorf("transport %q: allowed_numbers required", t.ID)
+			}
+			if pub, _ := t.Config["public_url"].(string); pub != "" {
+				if u, err := url.Parse(pub); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+					return fmt.Errorf("transport %q: public_url must be an http(s) URL", t.ID)
+				}
+			}
+		case "http":
+			token, _ := t.Config["bearer_token"].(string)
+			secret, _ := t.Config["hmac_secret"].(string)
+			if token == "" && secret == "" {
+				return fmt.Errorf("transport %q: bearer_token or hmac_secret required", t.ID)
+			}
 		default:
 			return fmt.Errorf("transport %q: unknown type %s", t.ID, t.Type)
 		}
@@ -59,6 +128,10 @@ func (c *Config) ValidateActions() error {
 		}
 		seen[name] = struct{}{}
 
+		if err := validateApproval(name, a); err != nil {
+			return err
+		}
+
 		switch a.Type {
 		case "shell":
 			if len(a.Allowed) == 0 && !a.UnsafeAllowEmpty {
@@ -77,3 +150,40 @@ func (c *Config) ValidateActions() error {
 	}
 	return nil
 }
+
+// validateApproval checks an action's approval mode and that its patterns compile.
+func validateApproval(name string, a ActionConfig) error {
+	switch a.Approval {
+	case "", "never", "always":
+	case "patterns":
+		if len(a.ApprovalPatterns) == 0 {
+			return fmt.Errorf("action %q: approval patterns requires approval_patterns", name)
+		}
+	default:
+		return fmt.Errorf("action %q: approval must be never, always, or patterns", name)
+	}
+	for _, p := range a.ApprovalPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("action %q: invalid approval pattern %q: %w", name, p, err)
+		}
+	}
+	return nil
+}
+
+// validateBunkerURL checks the shape of a NIP-46 bunker://<signer-pubkey>?relay=... URL.
+func validateBunkerURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "bunker" {
+		return errors.New("must start with bunker://")
+	}
+	if !nostr.IsValidPublicKey(u.Host) {
+		return fmt.Errorf("%q is not a hex pubkey", u.Host)
+	}
+	if len(u.Query()["relay"]) == 0 {
+		return errors.New("at least one relay= parameter required")
+	}
+	return nil
+}
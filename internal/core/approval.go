@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Approval modes for action calls.
+const (
+	ApprovalNever    = "never"
+	ApprovalAlways   = "always"
+	ApprovalPatterns = "patterns"
+)
+
+// ApprovalPolicy decides when an agent-requested action call must be confirmed by the sender.
+type ApprovalPolicy struct {
+	Mode string // never (default), always, or patterns
+	// Patterns are regular expressions matched against the call's string arguments
+	// (e.g. the shell command or file path); used in patterns mode.
+	Patterns []string
+}
+
+// compiledPolicy is an ApprovalPolicy ready for matching.
+type compiledPolicy struct {
+	always   bool
+	patterns []*regexp.Regexp
+}
+
+// WithApprovalPolicy requires sender approval for calls to the named action.
+// A pattern that fails to compile makes the policy require approval for every call.
+func WithApprovalPolicy(action string, p ApprovalPolicy) RunnerOption {
+	cp := compiledPolicy{}
+	switch p.Mode {
+	case ApprovalAlways:
+		cp.always = true
+	case ApprovalPatterns, "":
+		for _, pat := range p.Patterns {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				cp.always = true
+				continue
+			}
+			cp.patterns = append(cp.patterns, re)
+		}
+	}
+	return func(r *Runner) {
+		if !cp.always && len(cp.patterns) == 0 {
+			delete(r.approvalPolicies, action)
+			return
+		}
+		r.approvalPolicies[action] = cp
+	}
+}
+
+// WithApprovalTimeout sets how long a pending approval waits before the call is refused; d <= 0 keeps the default.
+func WithApprovalTimeout(d time.Duration) RunnerOption {
+	return func(r *Runner) {
+		if d > 0 {
+			r.approvalTimeout = d
+		}
+	}
+}
+
+// needsApproval reports whether call is gated by the action's policy.
+func (r *Runner) needsApproval(call ActionCall) bool {
+	cp, ok := r.approvalPolicies[call.Name]
+	if !ok {
+		return false
+	}
+	if cp.always {
+		return true
+	}
+	text := strings.Join(argStrings(call.Args), "\n")
+	for _, re := range cp.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// argStrings collects every string value in a JSON argument object, falling back to the raw text.
+func argStrings(raw json.RawMessage) []string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return []string{string(raw)}
+	}
+	var out []string
+	var walk func(any)
+	walk = func(x any) {
+		switch t := x.(type) {
+		case string:
+			out = append(out, t)
+		case []any:
+			for _, e := range t {
+				walk(e)
+			}
+		case map[string]any:
+			for _, e := range t {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return out
+}
+
+// requestApproval asks the sender to confirm call and waits for /approve, /deny, the timeout, or ctx.
+// It returns the audit outcome: approved, denied, approval_timeout, or approval_cancelled.
+func (r *Runner) requestApproval(ctx context.Context, msg InboundMessage, call ActionCall, log *slog.Logger) string {
+	code, decision := r.approvals.open(conversationKey(msg))
+	defer r.approvals.close(code)
+
+	args := strings.TrimSpace(string(call.Args))
+	if runes := []rune(args); len(runes) > 300 {
+		args = string(runes[:300]) + "…"
+	}
+	r.sendNotice(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf(
+		"Approval needed: %s %s\nReply /approve %s to run it or /deny %s to refuse (expires in %s).",
+		call.Name, args, code, code, r.approvalTimeout.Round(time.Second)))
+	log.Info("approval requested", slog.String("action", call.Name), slog.String("code", code))
+
+	timer := time.NewTimer(r.approvalTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-decision:
+		if ok {
+			return "approved"
+		}
+		return "denied"
+	case <-timer.C:
+		r.sendNotice(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("Approval %s expired; %s was not run.", code, call.Name))
+		return "approval_timeout"
+	case <-ctx.Done():
+		return "approval_cancelled"
+	}
+}
+
+// approvalTable holds approvals waiting for a sender's decision.
+type approvalTable struct {
+	mu      sync.Mutex
+	pending map[string]*pendingApproval // by code
+}
+
+type pendingApproval struct {
+	owner    string // conversationKey of the requesting sender
+	decision chan bool
+}
+
+func (t *approvalTable) open(owner string) (string, <-chan bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[string]*pendingApproval)
+	}
+	code := newApprovalCode()
+	for t.pending[code] != nil {
+		code = newApprovalCode()
+	}
+	p := &pendingApproval{owner: owner, decision: make(chan bool, 1)}
+	t.pending[code] = p
+	return code, p.decision
+}
+
+func (t *approvalTable) close(code string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pending, code)
+}
+
+// resolve delivers a decision for code if it belongs to owner.
+func (t *approvalTable) resolve(owner, code string, ok bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, found := t.pending[strings.ToLower(code)]
+	if !found || p.owner != owner {
+		return false
+	}
+	delete(t.pending, strings.ToLower(code))
+	p.decision <- ok
+	return true
+}
+
+// denyAll refuses every approval pending for owner and returns how many there were.
+func (t *approvalTable) denyAll(owner string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for code, p := range t.pending {
+		if p.owner == owner {
+			delete(t.pending, code)
+			p.decision <- false
+			n++
+		}
+	}
+	return n
+}
+
+// newApprovalCode returns a short code that is easy to type on a phone.
+func newApprovalCode() string {
+	var b [3]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%06x", time.Now().UnixNano()&0xffffff)
+	}
+	return hex.EncodeToString(b[:])
+}
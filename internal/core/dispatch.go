@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/joelklabo/buddy/internal/commands"
+	"github.com/joelklabo/buddy/internal/metrics"
+)
+
+// dispatcher fans inbound messages out to a fixed pool of workers while keeping
+// messages from the same conversation in arrival order: a message whose
+// conversation is already being handled waits behind it instead of taking a worker.
+// Queues are unbounded so handing off a message never blocks the inbound loop, which
+// must stay free to read /cancel and /approve while every worker is busy.
+type dispatcher struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ready   []InboundMessage            // first messages of idle conversations, waiting for a worker
+	pending map[string][]InboundMessage // queued behind the running message, by conversation
+	closed  bool
+}
+
+func newDispatcher() *dispatcher {
+	d := &dispatcher{pending: make(map[string][]InboundMessage)}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// conversationKey scopes ordering to one sender on one transport, matching how sessions are keyed.
+func conversationKey(msg InboundMessage) string {
+	return msg.Transport + ":" + msg.Sender
+}
+
+// isControl reports whether msg must bypass its conversation queue: /cancel, /status,
+// /approve, and /deny act on the request that is currently holding that queue.
+func isControl(msg InboundMessage) bool {
+	switch commands.Parse(msg.Text).Name {
+	case "cancel", "status", "approve", "deny":
+		return true
+	}
+	return false
+}
+
+// enqueue hands msg to the next free worker, or parks it until the running message of
+// its conversation completes. It never blocks.
+func (d *dispatcher) enqueue(msg InboundMessage) {
+	key := conversationKey(msg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if q, busy := d.pending[key]; busy {
+		d.pending[key] = append(q, msg)
+		return
+	}
+	d.pending[key] = nil
+	d.ready = append(d.ready, msg)
+	d.cond.Signal()
+}
+
+// take waits for a conversation to handle; ok is false once the dispatcher is closed
+// and nothing is left.
+func (d *dispatcher) take() (InboundMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.ready) == 0 && !d.closed {
+		d.cond.Wait()
+	}
+	if len(d.ready) == 0 {
+		return InboundMessage{}, false
+	}
+	msg := d.ready[0]
+	d.ready = d.ready[1:]
+	return msg, true
+}
+
+// close lets workers exit once the remaining messages are handled.
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	d.cond.Broadcast()
+}
+
+// next pops the following message of a conversation, releasing it when none is left.
+func (d *dispatcher) next(key string) (InboundMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := d.pending[key]
+	if len(q) == 0 {
+		delete(d.pending, key)
+		return InboundMessage{}, false
+	}
+	d.pending[key] = q[1:]
+	return q[0], true
+}
+
+// worker handles dispatched conversations, draining each conversation's backlog before taking new work.
+// Messages still queued once ctx is done (shutdown with no drain time left) are dropped unstarted
+// rather than run with a cancelled context and reported as agent failures.
+func (r *Runner) worker(ctx context.Context, d *dispatcher) {
+	for {
+		msg, ok := d.take()
+		if !ok {
+			return
+		}
+		key := conversationKey(msg)
+		for ok := true; ok; msg, ok = d.next(key) {
+			metrics.DecQueued()
+			if ctx.Err() != nil {
+				r.logger.Info("dropping queued message at shutdown",
+					slog.String("transport", msg.Transport), slog.String("sender", msg.Sender))
+				continue
+			}
+			metrics.IncInFlight()
+			r.handleMessage(ctx, msg)
+			metrics.DecInFlight()
+		}
+	}
+}
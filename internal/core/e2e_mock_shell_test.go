@@ -6,6 +6,7 @@ import (
 	"io"
 	"log/slog"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,29 +15,45 @@ import (
 	tmock "github.com/joelklabo/buddy/internal/transports/mock"
 )
 
+// stubAgent asks for resp's actions on its first call and then replies with the last action
+// result; with repeat set it asks for them on every call.
 type stubAgent struct {
-	resp core.AgentResponse
+	resp   core.AgentResponse
+	repeat bool
+
+	mu    sync.Mutex
+	calls int
 }
 
 func (s *stubAgent) Generate(ctx context.Context, req core.AgentRequest) (core.AgentResponse, error) {
-	return s.resp, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls == 1 || s.repeat {
+		return s.resp, nil
+	}
+	last := req.Steps[len(req.Steps)-1]
+	return core.AgentResponse{Reply: "ran: " + last.Text}, nil
 }
 
-func TestRunnerWithMockTransportAndShellAction(t *testing.T) {
+func (s *stubAgent) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// runShellExchange sends one message through a runner with the shell action and returns the reply.
+func runShellExchange(t *testing.T, ag core.Agent, opts ...core.RunnerOption) core.OutboundMessage {
+	t.Helper()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	tr := tmock.New("mock")
-	ag := &stubAgent{resp: core.AgentResponse{
-		Reply: "base",
-		ActionCalls: []core.ActionCall{
-			{Name: "shell", Args: json.RawMessage(`{"command":"echo hi"}`)},
-		},
-	}}
 	sh := shell.New(shell.Config{Allowed: []string{"echo "}, TimeoutSeconds: 5})
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	r := core.NewRunner([]core.Transport{tr}, ag, []core.Action{sh}, logger, core.WithActionTimeout(2*time.Second))
+	opts = append([]core.RunnerOption{core.WithActionTimeout(2 * time.Second)}, opts...)
+	r := core.NewRunner([]core.Transport{tr}, ag, []core.Action{sh}, logger, opts...)
 
 	done := make(chan struct{})
 	go func() {
@@ -49,14 +66,44 @@ func TestRunnerWithMockTransportAndShellAction(t *testing.T) {
 	var out core.OutboundMessage
 	select {
 	case out = <-tr.Outbound:
-	case <-time.After(3 * time.Second):
+	case <-time.After(10 * time.Second): // each step starts a login shell
 		t.Fatal("no outbound message")
 	}
 
 	cancel()
 	<-done
+	return out
+}
 
+func shellCallResponse() core.AgentResponse {
+	return core.AgentResponse{
+		Reply: "base",
+		ActionCalls: []core.ActionCall{
+			{Name: "shell", Args: json.RawMessage(`{"command":"echo hi"}`)},
+		},
+	}
+}
+
+func TestRunnerWithMockTransportAndShellAction(t *testing.T) {
+	ag := &stubAgent{resp: shellCallResponse()}
+	out := runShellExchange(t, ag)
+
+	if !strings.HasPrefix(out.Text, "ran: ") || !strings.Contains(out.Text, "hi") {
+		t.Fatalf("expected the agent's reply to the shell output, got %q", out.Text)
+	}
+	if n := ag.callCount(); n != 2 {
+		t.Fatalf("expected one action round-trip (2 agent calls), got %d", n)
+	}
+}
+
+func TestRunnerStopsAtMaxStepsWhenAgentKeepsCallingActions(t *testing.T) {
+	ag := &stubAgent{resp: shellCallResponse(), repeat: true}
+	out := runShellExchange(t, ag, core.WithMaxSteps(3))
+
+	if n := ag.callCount(); n != 3 {
+		t.Fatalf("expected the loop to stop after 3 agent calls, got %d", n)
+	}
 	if !strings.Contains(out.Text, "hi") {
-		t.Fatalf("expected shell output, got %q", out.Text)
+		t.Fatalf("expected the last shell output in the reply, got %q", out.Text)
 	}
 }
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is an in-flight request that /cancel can abort.
+type job struct {
+	label   string
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// jobTable tracks in-flight requests by transport, sender, and thread.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func jobKey(msg InboundMessage) string {
+	return msg.Transport + ":" + msg.Sender + ":" + msg.ThreadID
+}
+
+// senderPrefix matches every job of the sender on the message's transport.
+func senderPrefix(msg InboundMessage) string {
+	return msg.Transport + ":" + msg.Sender + ":"
+}
+
+// start registers a cancellable job for msg and returns its context and a release func.
+func (t *jobTable) start(parent context.Context, msg InboundMessage, label string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	j := &job{label: label, started: time.Now(), cancel: cancel}
+	key := jobKey(msg)
+	t.mu.Lock()
+	if t.jobs == nil {
+		t.jobs = make(map[string]*job)
+	}
+	t.jobs[key] = j
+	t.mu.Unlock()
+	return ctx, func() {
+		t.mu.Lock()
+		if t.jobs[key] == j {
+			delete(t.jobs, key)
+		}
+		t.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel aborts the job in msg's thread, or every job of the sender when the thread has none.
+func (t *jobTable) cancel(msg InboundMessage) []*job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if j, ok := t.jobs[jobKey(msg)]; ok {
+		delete(t.jobs, jobKey(msg))
+		j.cancel()
+		return []*job{j}
+	}
+	var out []*job
+	for key, j := range t.jobs {
+		if strings.HasPrefix(key, senderPrefix(msg)) {
+			delete(t.jobs, key)
+			j.cancel()
+			out = append(out, j)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].started.Before(out[b].started) })
+	return out
+}
+
+// running lists the sender's jobs on the message's transport, oldest first.
+func (t *jobTable) running(msg InboundMessage) []*job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []*job
+	for key, j := range t.jobs {
+		if strings.HasPrefix(key, senderPrefix(msg)) {
+			out = append(out, j)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].started.Before(out[b].started) })
+	return out
+}
+
+// describe renders a job for /cancel and /status replies.
+func (j *job) describe() string {
+	return fmt.Sprintf("%s (running %s)", j.label, time.Since(j.started).Round(time.Second))
+}
+
+// jobLabel shortens a prompt or command for display.
+func jobLabel(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > 60 {
+		text = string(r[:60]) + "…"
+	}
+	return fmt.Sprintf("%q", text)
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/metrics"
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+const (
+	defaultOutboxAttempts = 10
+	defaultOutboxInterval = 10 * time.Second
+	// outboxLease keeps a reply that is being sent right now away from the retry loop.
+	outboxLease = 2 * time.Minute
+	// maxOutboxBackoff caps the wait between delivery attempts.
+	maxOutboxBackoff = time.Hour
+)
+
+// Outbox persists replies until they are delivered; *store.Store implements it.
+type Outbox interface {
+	EnqueueOutbox(e store.OutboxEntry) (string, error)
+	Outbox() ([]store.OutboxEntry, error)
+	CompleteOutbox(id string) error
+	FailOutbox(id, lastErr string, next time.Time) error
+	DeadLetter(id, lastErr string) error
+}
+
+// WithOutbox writes replies to o before sending them. Replies that cannot be sent are
+// retried with backoff, also after a restart, and dead-lettered after maxAttempts failed
+// attempts (maxAttempts <= 0 keeps the default of 10).
+func WithOutbox(o Outbox, maxAttempts int) RunnerOption {
+	return func(r *Runner) {
+		r.outbox = o
+		if maxAttempts > 0 {
+			r.outboxAttempts = maxAttempts
+		}
+	}
+}
+
+// outboxBackoff is the wait after the nth failed attempt: 30s, doubling up to an hour.
+func outboxBackoff(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
+	if n > 8 {
+		return maxOutboxBackoff
+	}
+	return min(30*time.Second<<(n-1), maxOutboxBackoff)
+}
+
+// queueOutbound stores msg in the outbox, leased so the retry loop leaves it to the caller.
+// It returns "" without an outbox, or if msg could not be stored; it is then sent unqueued.
+func (r *Runner) queueOutbound(msg OutboundMessage, log *slog.Logger) string {
+	if r.outbox == nil {
+		return ""
+	}
+	payload, err := json.Marshal(msg)
+	if err == nil {
+		var id string
+		id, err = r.outbox.EnqueueOutbox(store.OutboxEntry{
+			Transport:   msg.Transport,
+			Recipient:   msg.Recipient,
+			Payload:     payload,
+			NextAttempt: time.Now().Add(outboxLease),
+		})
+		if err == nil {
+			return id
+		}
+	}
+	log.Warn("outbox enqueue failed; sending without it", slog.String("err", err.Error()))
+	return ""
+}
+
+// settleOutbound records the outcome of a delivery attempt of queued message id, which had
+// failed attempts times before. A failure is retried later or, after the last attempt,
+// dead-lettered; one cut short by shutdown is not counted. It reports whether the message
+// is still queued.
+func (r *Runner) settleOutbound(ctx context.Context, id string, attempts int, sendErr error, log *slog.Logger) bool {
+	if sendErr == nil {
+		if err := r.outbox.CompleteOutbox(id); err != nil {
+			log.Warn("outbox complete failed", slog.String("id", id), slog.String("err", err.Error()))
+		}
+		return false
+	}
+	if ctx.Err() != nil {
+		return true
+	}
+	metrics.IncSendError()
+	attempts++
+	if attempts >= r.outboxAttempts {
+		log.Error("giving up on reply; moved to dead letters", slog.String("id", id),
+			slog.Int("attempts", attempts), slog.String("err", sendErr.Error()))
+		metrics.IncDeadLetter()
+		if err := r.outbox.DeadLetter(id, sendErr.Error()); err != nil {
+			log.Warn("outbox dead-letter failed", slog.String("id", id), slog.String("err", err.Error()))
+		}
+		return false
+	}
+	next := r.outboxDelay(attempts)
+	log.Warn("send failed; reply queued for retry", slog.String("id", id),
+		slog.Int("attempts", attempts), slog.Duration("retry_in", next), slog.String("err", sendErr.Error()))
+	if err := r.outbox.FailOutbox(id, sendErr.Error(), time.Now().Add(next)); err != nil {
+		log.Warn("outbox update failed", slog.String("id", id), slog.String("err", err.Error()))
+	}
+	return true
+}
+
+// runOutbox delivers queued replies that are due, once at start (to pick up replies left
+// by a previous run) and then every outboxInterval until ctx is done.
+func (r *Runner) runOutbox(ctx context.Context) {
+	ticker := time.NewTicker(r.outboxInterval)
+	defer ticker.Stop()
+	for {
+		r.flushOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushOutbox sends the due entries oldest first. A recipient's later replies wait while
+// an earlier one is not yet due or fails, so parts arrive in order.
+func (r *Runner) flushOutbox(ctx context.Context) {
+	entries, err := r.outbox.Outbox()
+	if err != nil {
+		r.logger.Warn("outbox read failed", slog.String("err", err.Error()))
+		return
+	}
+	now := time.Now()
+	blocked := make(map[string]bool)
+	pending := 0
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		key := e.Transport + "\x00" + e.Recipient
+		if blocked[key] || e.NextAttempt.After(now) {
+			blocked[key] = true
+			pending++
+			continue
+		}
+		log := r.logger.With(slog.String("transport", e.Transport), slog.String("outbox_id", e.ID))
+		tr, ok := r.transportMap[e.Transport]
+		msg, decodeErr := decodeOutbound(e.Payload)
+		switch {
+		case decodeErr != nil:
+			r.deadLetter(e, "decode: "+decodeErr.Error(), log)
+			continue
+		case !ok:
+			r.deadLetter(e, "unknown transport "+e.Transport, log)
+			continue
+		}
+		sendErr := r.sendWithRetry(ctx, tr, msg, log)
+		if r.settleOutbound(ctx, e.ID, e.Attempts, sendErr, log) {
+			blocked[key] = true
+			pending++
+		} else if sendErr == nil {
+			log.Info("queued reply delivered", slog.Int("attempts", e.Attempts+1))
+		}
+	}
+	metrics.SetOutboxDepth(pending)
+}
+
+// deadLetter gives up on an entry that can never be sent.
+func (r *Runner) deadLetter(e store.OutboxEntry, reason string, log *slog.Logger) {
+	log.Error("reply cannot be sent; moved to dead letters", slog.String("reason", reason))
+	metrics.IncDeadLetter()
+	if err := r.outbox.DeadLetter(e.ID, reason); err != nil {
+		log.Warn("outbox dead-letter failed", slog.String("err", err.Error()))
+	}
+}
+
+// decodeOutbound restores a queued message. JSON turns the attachment list into []any;
+// transports expect []string.
+func decodeOutbound(payload json.RawMessage) (OutboundMessage, error) {
+	var msg OutboundMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return msg, err
+	}
+	if msg.Transport == "" {
+		return msg, errors.New("empty message")
+	}
+	if list, ok := msg.Meta[MetaAttachments].([]any); ok {
+		files := make([]string, 0, len(list))
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				files = append(files, s)
+			}
+		}
+		msg.Meta[MetaAttachments] = files
+	}
+	return msg, nil
+}
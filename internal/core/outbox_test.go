@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+// downTransport fails every send while down is set and records what it delivered.
+type downTransport struct {
+	mu   sync.Mutex
+	down bool
+	sent []OutboundMessage
+}
+
+func (d *downTransport) ID() string { return "mock" }
+func (d *downTransport) Start(ctx context.Context, inbound chan<- InboundMessage) error {
+	return nil
+}
+func (d *downTransport) Send(ctx context.Context, msg OutboundMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return errors.New("relay unreachable")
+	}
+	d.sent = append(d.sent, msg)
+	return nil
+}
+
+func (d *downTransport) setDown(v bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down = v
+}
+
+func (d *downTransport) delivered() []OutboundMessage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]OutboundMessage(nil), d.sent...)
+}
+
+func newOutboxStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.New(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+func newOutboxRunner(st *store.Store, tr Transport, maxAttempts int) *Runner {
+	r := NewRunner([]Transport{tr}, &scriptedAgent{}, nil, slog.Default(), WithOutbox(st, maxAttempts))
+	r.outboxDelay = func(int) time.Duration { return 0 }
+	return r
+}
+
+func TestRunnerQueuesFailedReplyUntilDelivered(t *testing.T) {
+	st := newOutboxStore(t)
+	tr := &downTransport{down: true}
+	r := newOutboxRunner(st, tr, 5)
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "long job"})
+	queued, _ := st.Outbox()
+	if len(queued) != 1 || queued[0].Attempts != 1 || queued[0].LastError != "relay unreachable" {
+		t.Fatalf("failed reply should stay queued: %+v", queued)
+	}
+
+	tr.setDown(false)
+	r.flushOutbox(context.Background())
+	sent := tr.delivered()
+	if len(sent) != 1 || sent[0].Text != "done" || sent[0].Recipient != "alice" {
+		t.Fatalf("queued reply not delivered: %+v", sent)
+	}
+	if queued, _ = st.Outbox(); len(queued) != 0 {
+		t.Fatalf("delivered reply still queued: %+v", queued)
+	}
+}
+
+func TestRunnerDeadLettersAfterMaxAttempts(t *testing.T) {
+	st := newOutboxStore(t)
+	tr := &downTransport{down: true}
+	r := newOutboxRunner(st, tr, 2)
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "hi"})
+	r.flushOutbox(context.Background())
+
+	queued, _ := st.Outbox()
+	dead, _ := st.DeadLetters()
+	if len(queued) != 0 || len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("expected one dead letter after 2 attempts: queued=%+v dead=%+v", queued, dead)
+	}
+
+	// An entry for a transport that is no longer configured is given up on at once.
+	if _, err := st.EnqueueOutbox(store.OutboxEntry{Transport: "gone", Recipient: "bob", Payload: json.RawMessage(`{"transport":"gone","text":"x"}`)}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	r.flushOutbox(context.Background())
+	if dead, _ = st.DeadLetters(); len(dead) != 2 || dead[1].LastError != "unknown transport gone" {
+		t.Fatalf("unknown transport not dead-lettered: %+v", dead)
+	}
+}
+
+func TestRunnerKeepsPartsInOrderBehindFailedOne(t *testing.T) {
+	st := newOutboxStore(t)
+	tr := &downTransport{}
+	r := newOutboxRunner(st, tr, 5)
+	for _, text := range []string{"first", "second"} {
+		payload, _ := json.Marshal(OutboundMessage{Transport: "mock", Recipient: "alice", Text: text})
+		if _, err := st.EnqueueOutbox(store.OutboxEntry{Transport: "mock", Recipient: "alice", Payload: payload}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	queued, _ := st.Outbox()
+	if err := st.FailOutbox(queued[0].ID, "earlier failure", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	r.flushOutbox(context.Background())
+	if sent := tr.delivered(); len(sent) != 0 {
+		t.Fatalf("second part sent before the first: %+v", sent)
+	}
+}
+
+func TestRunnerDeliversOutboxLeftByPreviousRun(t *testing.T) {
+	st := newOutboxStore(t)
+	payload, _ := json.Marshal(OutboundMessage{
+		Transport: "mock",
+		Recipient: "alice",
+		Text:      "result of the long job",
+		Meta:      map[string]any{MetaAttachments: []string{"/tmp/report.md"}},
+	})
+	if _, err := st.EnqueueOutbox(store.OutboxEntry{Transport: "mock", Recipient: "alice", Payload: payload}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	tr := &downTransport{}
+	r := newOutboxRunner(st, tr, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Start(ctx) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.delivered()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	sent := tr.delivered()
+	if len(sent) != 1 || sent[0].Text != "result of the long job" {
+		t.Fatalf("queued reply not delivered at start: %+v", sent)
+	}
+	files, _ := sent[0].Meta[MetaAttachments].([]string)
+	if len(files) != 1 || files[0] != "/tmp/report.md" {
+		t.Fatalf("attachments not restored: %#v", sent[0].Meta)
+	}
+	if queued, _ := st.Outbox(); len(queued) != 0 {
+		t.Fatalf("delivered reply still queued: %+v", queued)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProgressChars bounds a single progress line.
+const maxProgressChars = 200
+
+// progressReporter relays agent events to the sender as throttled status messages,
+// editing one placeholder message when the transport supports it. A nil reporter is a no-op.
+type progressReporter struct {
+	ctx      context.Context
+	tr       Transport
+	base     OutboundMessage
+	interval time.Duration
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	last   time.Time
+	handle string
+}
+
+// newProgress returns a reporter for msg, or nil when progress updates are disabled or
+// the transport can neither edit messages nor accepts progress messages.
+// The first update waits a full interval so quick replies send nothing extra.
+func (r *Runner) newProgress(ctx context.Context, msg InboundMessage, log *slog.Logger) *progressReporter {
+	if r.progressInterval <= 0 {
+		return nil
+	}
+	tr, ok := r.transportMap[msg.Transport]
+	if !ok || !showsProgress(tr) {
+		return nil
+	}
+	return &progressReporter{
+		ctx: ctx,
+		tr:  tr,
+		base: OutboundMessage{
+			Transport: msg.Transport,
+			Recipient: msg.Sender,
+			ThreadID:  msg.ThreadID,
+			Meta:      map[string]any{"progress": true},
+		},
+		interval: r.progressInterval,
+		log:      log,
+		last:     time.Now(),
+	}
+}
+
+func (p *progressReporter) emit(ev AgentEvent) {
+	if p == nil {
+		return
+	}
+	text := progressText(ev)
+	if text == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.last) < p.interval {
+		return
+	}
+	p.last = time.Now()
+
+	out := p.base
+	out.Text = text
+	if et, ok := p.tr.(EditableTransport); ok {
+		if p.handle != "" {
+			err := et.Edit(p.ctx, p.handle, out)
+			if err == nil {
+				return
+			}
+			p.log.Warn("progress edit failed", slog.String("err", err.Error()))
+		}
+		handle, err := et.SendEditable(p.ctx, out)
+		if err != nil {
+			p.log.Warn("progress send failed", slog.String("err", err.Error()))
+			return
+		}
+		p.handle = handle
+		return
+	}
+	if err := p.tr.Send(p.ctx, out); err != nil {
+		p.log.Warn("progress send failed", slog.String("err", err.Error()))
+	}
+}
+
+func showsProgress(tr Transport) bool {
+	if _, ok := tr.(EditableTransport); ok {
+		return true
+	}
+	pt, ok := tr.(ProgressTransport)
+	return ok && pt.AcceptsProgress()
+}
+
+// progressText renders an event as a one-line status, e.g. "running: go test ./...".
+func progressText(ev AgentEvent) string {
+	text := strings.TrimSpace(ev.Text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	if text == "" {
+		return ""
+	}
+	if r := []rune(text); len(r) > maxProgressChars {
+		text = string(r[:maxProgressChars]) + "…"
+	}
+	switch ev.Kind {
+	case EventCommand, EventAction:
+		return "running: " + text
+	case EventReasoning:
+		return "thinking: " + text
+	default:
+		return text
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamAgent emits its events with a pause between them before replying.
+type streamAgent struct {
+	events []AgentEvent
+	pause  time.Duration
+}
+
+func (s *streamAgent) Generate(ctx context.Context, req AgentRequest) (AgentResponse, error) {
+	return AgentResponse{Reply: "done"}, nil
+}
+
+func (s *streamAgent) GenerateStream(ctx context.Context, req AgentRequest, emit func(AgentEvent)) (AgentResponse, error) {
+	for _, e := range s.events {
+		time.Sleep(s.pause)
+		emit(e)
+	}
+	return AgentResponse{Reply: "done"}, nil
+}
+
+// progressSpy is a transportSpy that accepts progress messages.
+type progressSpy struct{ transportSpy }
+
+func (p *progressSpy) AcceptsProgress() bool { return true }
+
+// editableSpy records placeholder sends and edits separately from regular sends.
+type editableSpy struct {
+	mu    sync.Mutex
+	sent  []OutboundMessage
+	edits []OutboundMessage
+}
+
+func (e *editableSpy) ID() string                                               { return "edit" }
+func (e *editableSpy) Start(ctx context.Context, _ chan<- InboundMessage) error { return nil }
+func (e *editableSpy) Send(ctx context.Context, msg OutboundMessage) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sent = append(e.sent, msg)
+	return nil
+}
+func (e *editableSpy) SendEditable(ctx context.Context, msg OutboundMessage) (string, error) {
+	if err := e.Send(ctx, msg); err != nil {
+		return "", err
+	}
+	return "h1", nil
+}
+func (e *editableSpy) Edit(ctx context.Context, handle string, msg OutboundMessage) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.edits = append(e.edits, msg)
+	return nil
+}
+
+func TestRunnerSendsThrottledProgress(t *testing.T) {
+	ag := &streamAgent{pause: 30 * time.Millisecond, events: []AgentEvent{
+		{Kind: EventReasoning, Text: "planning\nmore detail"},
+		{Kind: EventCommand, Text: "go test ./..."},
+	}}
+	r := NewRunner(nil, ag, nil, slog.Default(), WithProgressInterval(20*time.Millisecond))
+	outCh := make(chan OutboundMessage, 4)
+	r.transportMap = map[string]Transport{"mock": &progressSpy{transportSpy{out: outCh}}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "run tests"})
+	close(outCh)
+
+	var texts []string
+	for m := range outCh {
+		texts = append(texts, m.Text)
+	}
+	want := []string{"thinking: planning", "running: go test ./...", "done"}
+	if len(texts) != len(want) {
+		t.Fatalf("expected %v, got %v", want, texts)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Fatalf("message %d: got %q want %q", i, texts[i], want[i])
+		}
+	}
+}
+
+func TestRunnerProgressSkipsQuickEvents(t *testing.T) {
+	ag := &streamAgent{events: []AgentEvent{{Kind: EventCommand, Text: "ls"}}}
+	r := NewRunner(nil, ag, nil, slog.Default(), WithProgressInterval(time.Hour))
+	outCh := make(chan OutboundMessage, 4)
+	r.transportMap = map[string]Transport{"mock": &progressSpy{transportSpy{out: outCh}}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "hi"})
+	if len(outCh) != 1 {
+		t.Fatalf("expected only the final reply, got %d messages", len(outCh))
+	}
+}
+
+func TestRunnerSendsNoProgressToPlainTransports(t *testing.T) {
+	ag := &streamAgent{pause: 30 * time.Millisecond, events: []AgentEvent{
+		{Kind: EventCommand, Text: "go test ./..."},
+	}}
+	r := NewRunner(nil, ag, nil, slog.Default(), WithProgressInterval(20*time.Millisecond))
+	outCh := make(chan OutboundMessage, 4)
+	r.transportMap = map[string]Transport{"mail": &transportSpy{out: outCh}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mail", Sender: "alice", Text: "run tests"})
+	if len(outCh) != 1 || (<-outCh).Text != "done" {
+		t.Fatalf("expected only the final reply on a transport without progress support")
+	}
+}
+
+func TestRunnerEditsProgressPlaceholder(t *testing.T) {
+	ag := &streamAgent{pause: 30 * time.Millisecond, events: []AgentEvent{
+		{Kind: EventCommand, Text: "make build"},
+		{Kind: EventCommand, Text: "make test"},
+	}}
+	tr := &editableSpy{}
+	r := NewRunner([]Transport{tr}, ag, nil, slog.Default(), WithProgressInterval(20*time.Millisecond))
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "edit", Sender: "alice", Text: "build"})
+
+	if len(tr.sent) != 2 || tr.sent[0].Text != "running: make build" || tr.sent[1].Text != "done" {
+		t.Fatalf("expected placeholder then reply, got %+v", tr.sent)
+	}
+	if len(tr.edits) != 1 || tr.edits[0].Text != "running: make test" {
+		t.Fatalf("expected placeholder edit, got %+v", tr.edits)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -25,16 +26,44 @@ type Runner struct {
 
 	reqTimeout    time.Duration
 	actionTimeout time.Duration
+	maxSteps      int
+	loopBudget    time.Duration
 
 	allowedActions map[string]struct{}
 	allowedSenders map[string]struct{}
+	// transportSenders overrides allowedSenders for specific transports.
+	transportSenders map[string]map[string]struct{}
 
 	auditStore AuditLogger
 
+	history       HistoryStore
+	historyTurns  int
+	historyTokens int
+
 	store          store.StoreAPI
 	sessionTimeout time.Duration
 	initialPrompt  string
 	maxReplyChars  int
+	maxReplyParts  int
+
+	moreMu sync.Mutex
+	more   map[string][]string // reply parts held back beyond maxReplyParts, by historyKey
+
+	jobs jobTable // in-flight requests, for /cancel and /status
+
+	approvalPolicies map[string]compiledPolicy // by action name
+	approvalTimeout  time.Duration
+	approvals        approvalTable
+
+	workers      int
+	drainTimeout time.Duration
+
+	progressInterval time.Duration
+
+	outbox         Outbox
+	outboxAttempts int
+	outboxInterval time.Duration
+	outboxDelay    func(attempts int) time.Duration
 }
 
 // AuditLogger records action executions.
@@ -42,6 +71,13 @@ type AuditLogger interface {
 	AppendAudit(action, sender, outcome string, dur time.Duration) error
 }
 
+// HistoryStore persists conversation turns per thread.
+type HistoryStore interface {
+	AppendHistory(threadID string, turn json.RawMessage, maxEntries int) error
+	History(threadID string, maxEntries int) ([]json.RawMessage, error)
+	ClearHistory(threadID string) error
+}
+
 // RunnerOption configures a Runner.
 type RunnerOption func(*Runner)
 
@@ -55,6 +91,24 @@ func WithActionTimeout(d time.Duration) RunnerOption {
 	return func(r *Runner) { r.actionTimeout = d }
 }
 
+// WithMaxSteps caps agent calls per inbound message in the action loop; n <= 0 keeps the default.
+func WithMaxSteps(n int) RunnerOption {
+	return func(r *Runner) {
+		if n > 0 {
+			r.maxSteps = n
+		}
+	}
+}
+
+// WithLoopBudget caps total time spent in the agent/action loop for one message; d <= 0 keeps the default.
+func WithLoopBudget(d time.Duration) RunnerOption {
+	return func(r *Runner) {
+		if d > 0 {
+			r.loopBudget = d
+		}
+	}
+}
+
 // WithAllowedActions sets a whitelist of action names; empty means allow all.
 func WithAllowedActions(names []string) RunnerOption {
 	set := make(map[string]struct{}, len(names))
@@ -73,11 +127,45 @@ func WithAllowedSenders(ids []string) RunnerOption {
 	return func(r *Runner) { r.allowedSenders = set }
 }
 
+// WithTransportSenders sets the allowed sender ids for one transport, overriding
+// WithAllowedSenders for its messages; empty means allow all, for transports that
+// enforce their own allowlist.
+func WithTransportSenders(transportID string, ids []string) RunnerOption {
+	set := make(map[string]struct{}, len(ids))
+	for _, n := range ids {
+		set[strings.ToLower(n)] = struct{}{}
+	}
+	return func(r *Runner) {
+		if r.transportSenders == nil {
+			r.transportSenders = make(map[string]map[string]struct{})
+		}
+		r.transportSenders[transportID] = set
+	}
+}
+
 // WithAuditLogger wires an audit sink.
 func WithAuditLogger(a AuditLogger) RunnerOption {
 	return func(r *Runner) { r.auditStore = a }
 }
 
+// WithHistoryStore enables per-thread conversation history passed to agents.
+func WithHistoryStore(h HistoryStore) RunnerOption {
+	return func(r *Runner) { r.history = h }
+}
+
+// WithHistoryLimit bounds loaded history to the most recent turns and, when tokens > 0,
+// to the newest turns fitting an approximate token budget. Values <= 0 keep the defaults.
+func WithHistoryLimit(turns, tokens int) RunnerOption {
+	return func(r *Runner) {
+		if turns > 0 {
+			r.historyTurns = turns
+		}
+		if tokens > 0 {
+			r.historyTokens = tokens
+		}
+	}
+}
+
 // WithStore provides a store for session/cursor management.
 func WithStore(st store.StoreAPI) RunnerOption {
 	return func(r *Runner) { r.store = st }
@@ -92,11 +180,50 @@ func WithInitialPrompt(p string) RunnerOption {
 	return func(r *Runner) { r.initialPrompt = p }
 }
 
-// WithMaxReplyChars limits reply text length.
+// WithMaxReplyChars limits the size of each outbound message; longer replies are split
+// into numbered parts. Transports implementing MessageLimiter may lower it further.
 func WithMaxReplyChars(n int) RunnerOption {
 	return func(r *Runner) { r.maxReplyChars = n }
 }
 
+// WithMaxReplyParts caps how many parts of a split reply are sent at once; the rest
+// is kept for /more. n <= 0 keeps the default.
+func WithMaxReplyParts(n int) RunnerOption {
+	return func(r *Runner) {
+		if n > 0 {
+			r.maxReplyParts = n
+		}
+	}
+}
+
+// WithWorkers sets how many conversations are processed concurrently; n <= 0 keeps the default.
+func WithWorkers(n int) RunnerOption {
+	return func(r *Runner) {
+		if n > 0 {
+			r.workers = n
+		}
+	}
+}
+
+// WithDrainTimeout lets queued and in-flight messages keep running for up to d after shutdown
+// so their replies are still delivered; by default work is cancelled with the runner's context.
+func WithDrainTimeout(d time.Duration) RunnerOption {
+	return func(r *Runner) { r.drainTimeout = d }
+}
+
+// WithProgressInterval sets the minimum gap between progress updates sent while an agent works;
+// d == 0 keeps the default and d < 0 disables progress updates.
+func WithProgressInterval(d time.Duration) RunnerOption {
+	return func(r *Runner) {
+		switch {
+		case d < 0:
+			r.progressInterval = 0
+		case d > 0:
+			r.progressInterval = d
+		}
+	}
+}
+
 // NewRunner constructs a Runner. If logger is nil, slog.Default is used.
 func NewRunner(transports []Transport, agent Agent, actions []Action, logger *slog.Logger, opts ...RunnerOption) *Runner {
 	if logger == nil {
@@ -112,10 +239,7 @@ func NewRunner(transports []Transport, agent Agent, actions []Action, logger *sl
 	specs := make([]ActionSpec, 0, len(actions))
 	for _, a := range actions {
 		amap[a.Name()] = a
-		specs = append(specs, ActionSpec{
-			Name:         a.Name(),
-			Capabilities: a.Capabilities(),
-		})
+		specs = append(specs, specFor(a))
 	}
 
 	r := &Runner{
@@ -127,6 +251,19 @@ func NewRunner(transports []Transport, agent Agent, actions []Action, logger *sl
 		logger:        logger,
 		reqTimeout:    15 * time.Minute,
 		actionTimeout: 2 * time.Minute,
+		maxSteps:      8,
+		loopBudget:    30 * time.Minute,
+		historyTurns:  20,
+		workers:       4,
+		maxReplyParts: 4,
+		more:          make(map[string][]string),
+
+		progressInterval: 15 * time.Second,
+		approvalPolicies: make(map[string]compiledPolicy),
+		approvalTimeout:  5 * time.Minute,
+		outboxAttempts:   defaultOutboxAttempts,
+		outboxInterval:   defaultOutboxInterval,
+		outboxDelay:      outboxBackoff,
 	}
 	for _, opt := range opts {
 		opt(r)
@@ -134,12 +271,27 @@ func NewRunner(transports []Transport, agent Agent, actions []Action, logger *sl
 	return r
 }
 
+// specFor builds the advertised spec for an action, including its argument schema when available.
+func specFor(a Action) ActionSpec {
+	spec := ActionSpec{
+		Name:         a.Name(),
+		Capabilities: a.Capabilities(),
+		Description:  strings.TrimSpace(a.Help()),
+	}
+	if s, ok := a.(ActionSchema); ok {
+		spec.Parameters = s.Schema()
+	}
+	return spec
+}
+
 // Transports exposes the configured transports (useful for tests).
 func (r *Runner) Transports() []Transport {
 	return r.transports
 }
 
 // Start launches transports and processes inbound messages until ctx is done.
+// Conversations run in parallel on a worker pool; messages from one sender keep their order.
+// On shutdown, queued messages are drained before Start returns; see WithDrainTimeout.
 func (r *Runner) Start(ctx context.Context) error {
 	inbound := make(chan InboundMessage, 128)
 	var wg sync.WaitGroup
@@ -155,16 +307,52 @@ func (r *Runner) Start(ctx context.Context) error {
 		}(t)
 	}
 
-	// Processor loop
+	if r.outbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.runOutbox(ctx)
+		}()
+	}
+
+	// Work outlives ctx so shutdown can drain; it is cancelled once the drain timeout passes.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
 	go func() {
 		<-ctx.Done()
 		close(inbound)
+		if r.drainTimeout <= 0 {
+			cancelWork()
+			return
+		}
+		time.AfterFunc(r.drainTimeout, cancelWork)
 	}()
 
+	d := newDispatcher()
+	var workers sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			r.worker(workCtx, d)
+		}()
+	}
+
 	for msg := range inbound {
 		metrics.IncInbound()
-		r.handleMessage(ctx, msg)
+		if isControl(msg) {
+			workers.Add(1)
+			go func(m InboundMessage) {
+				defer workers.Done()
+				r.handleMessage(workCtx, m)
+			}(msg)
+			continue
+		}
+		metrics.IncQueued()
+		d.enqueue(msg)
 	}
+	d.close()
+	workers.Wait()
 
 	wg.Wait()
 
@@ -189,7 +377,7 @@ func (r *Runner) handleMessage(parent context.Context, msg InboundMessage) {
 		slog.String("thread", msg.ThreadID),
 	)
 
-	if !r.senderAllowed(log, msg.Sender) {
+	if !r.senderAllowed(log, msg.Transport, msg.Sender) {
 		return
 	}
 
@@ -204,93 +392,393 @@ func (r *Runner) handleMessage(parent context.Context, msg InboundMessage) {
 		return
 	}
 
-	reqCtx := parent
-	if r.reqTimeout > 0 {
-		var cancel context.CancelFunc
-		reqCtx, cancel = context.WithTimeout(parent, r.reqTimeout)
-		defer cancel()
-	}
-
+	userText := prompt
 	if sessionID == "" && strings.TrimSpace(r.initialPrompt) != "" {
 		prompt = r.initialPrompt + "\n\n" + prompt
 	}
 
 	req := AgentRequest{
 		Prompt:     prompt,
-		History:    nil,
+		SessionID:  sessionID,
+		History:    r.loadHistory(msg, log),
 		Actions:    r.actionSpecs,
 		SenderMeta: msg.Meta,
 	}
 
-	start := time.Now()
-	resp, err := r.callAgentWithRetry(reqCtx, req, log)
+	ctx, done := r.jobs.start(parent, msg, jobLabel(userText))
+	defer done()
+
+	progress := r.newProgress(parent, msg, log)
+	result, err := r.runAgentLoop(ctx, req, msg, progress, log)
+	if cancelled(ctx, parent) {
+		log.Info("request cancelled")
+		return
+	}
 	if err != nil {
 		log.Error("agent error", slog.String("err", err.Error()))
 		metrics.IncAgentError()
 		return
 	}
-	log.Info("agent reply", slog.Duration("ms", time.Since(start)))
+	finalText := result.Reply
+	r.saveSession(msg.Sender, result.SessionID, log)
+	r.saveHistory(msg, log, MessageTurn{Role: RoleUser, Text: userText}, MessageTurn{Role: RoleAgent, Text: finalText})
+
+	r.sendReply(parent, msg, finalText, result.Files, log)
+}
+
+// cancelled reports whether ctx was cancelled by /cancel rather than by shutdown.
+func cancelled(ctx, parent context.Context) bool {
+	return ctx.Err() != nil && parent.Err() == nil
+}
 
-	// Execute actions if any
-	var actionResults []string
-	for _, call := range resp.ActionCalls {
-		if len(r.allowedActions) > 0 {
-			if _, ok := r.allowedActions[call.Name]; !ok {
-				log.Warn("action not allowed", slog.String("action", call.Name))
-				r.logAudit(call.Name, msg.Sender, "denied", 0)
-				continue
+// sendReply delivers text to the sender of msg, split to the transport's size limit.
+// Parts beyond maxReplyParts are held back and released by /more. files written while
+// answering go with the last part to transports that send attachments.
+func (r *Runner) sendReply(ctx context.Context, msg InboundMessage, text string, files []string, log *slog.Logger) {
+	tr, ok := r.transportMap[msg.Transport]
+	if !ok {
+		log.Error("no transport for outbound", slog.String("transport", msg.Transport))
+		return
+	}
+	parts := splitReply(text, r.replyLimit(tr, msg.ThreadID))
+	r.sendParts(ctx, tr, msg, parts, files, log)
+}
+
+// sendParts sends up to maxReplyParts parts and stores the remainder for /more.
+func (r *Runner) sendParts(ctx context.Context, tr Transport, msg InboundMessage, parts, files []string, log *slog.Logger) {
+	var rest []string
+	if len(parts) > r.maxReplyParts {
+		parts, rest = parts[:r.maxReplyParts], parts[r.maxReplyParts:]
+	}
+	r.setMore(historyKey(msg), rest)
+	if at, ok := tr.(AttachmentTransport); !ok || !at.SendsAttachments() {
+		files = nil
+	}
+
+	outs := make([]OutboundMessage, len(parts))
+	ids := make([]string, len(parts))
+	for i, part := range parts {
+		outs[i] = OutboundMessage{
+			Transport: msg.Transport,
+			Recipient: msg.Sender,
+			Text:      part,
+			ThreadID:  msg.ThreadID,
+		}
+		if i == len(parts)-1 {
+			outs[i].Meta = map[string]any{}
+			if len(rest) == 0 {
+				outs[i].Meta[MetaFinal] = true
+			}
+			if len(files) > 0 {
+				outs[i].Meta[MetaAttachments] = dedupe(files)
 			}
 		}
-		act, ok := r.actions[call.Name]
-		if !ok {
-			log.Warn("unknown action", slog.String("action", call.Name))
+		// The whole reply is queued before the first part goes out.
+		ids[i] = r.queueOutbound(outs[i], log)
+	}
+	for i, out := range outs {
+		err := r.sendWithRetry(ctx, tr, out, log)
+		if ids[i] != "" {
+			// Parts after a failed one stay queued behind it.
+			if r.settleOutbound(ctx, ids[i], 0, err, log) {
+				return
+			}
 			continue
 		}
-		aCtx := reqCtx
-		if r.actionTimeout > 0 {
-			var cancel context.CancelFunc
-			aCtx, cancel = context.WithTimeout(reqCtx, r.actionTimeout)
-			defer cancel()
+		if err != nil {
+			log.Error("send error", slog.String("err", err.Error()))
+			metrics.IncSendError()
+			return
+		}
+	}
+	if len(rest) > 0 {
+		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("%d more part(s) held back. Send /more to continue.", len(rest)))
+	}
+}
+
+// replyLimit is the smaller of max_reply_chars and the transport's own limit (0 = unlimited).
+// Transports that send attachments or take whole replies on threadID get them unsplit.
+func (r *Runner) replyLimit(tr Transport, threadID string) int {
+	if at, ok := tr.(AttachmentTransport); ok && at.SendsAttachments() {
+		return 0
+	}
+	if wr, ok := tr.(WholeReplyTransport); ok && wr.WholeReplies(threadID) {
+		return 0
+	}
+	limit := r.maxReplyChars
+	if ml, ok := tr.(MessageLimiter); ok {
+		if n := ml.MaxMessageChars(); n > 0 && (limit <= 0 || n < limit) {
+			limit = n
 		}
-		aStart := time.Now()
-		out, err := act.Invoke(aCtx, call.Args)
+	}
+	return limit
+}
+
+func (r *Runner) setMore(key string, parts []string) {
+	r.moreMu.Lock()
+	defer r.moreMu.Unlock()
+	if len(parts) == 0 {
+		delete(r.more, key)
+		return
+	}
+	r.more[key] = parts
+}
+
+func (r *Runner) takeMore(key string) []string {
+	r.moreMu.Lock()
+	defer r.moreMu.Unlock()
+	parts := r.more[key]
+	delete(r.more, key)
+	return parts
+}
+
+// runAgentLoop calls the agent, executes requested actions, and feeds their results back
+// as action turns until the agent stops asking for actions or the step/time budget runs out.
+// When the budget is exhausted the last action outputs are appended to the reply verbatim.
+// The returned response carries the final reply text and the latest session id reported by the agent.
+func (r *Runner) runAgentLoop(parent context.Context, req AgentRequest, msg InboundMessage, progress *progressReporter, log *slog.Logger) (AgentResponse, error) {
+	loopCtx := parent
+	if r.loopBudget > 0 {
+		var cancel context.CancelFunc
+		loopCtx, cancel = context.WithTimeout(parent, r.loopBudget)
+		defer cancel()
+	}
+
+	var result AgentResponse
+	for step := 1; ; step++ {
+		resp, err := r.callAgentStep(loopCtx, req, progress, log)
 		if err != nil {
-			log.Error("action error", slog.String("action", call.Name), slog.String("err", err.Error()))
-			r.logAudit(call.Name, msg.Sender, "error", time.Since(aStart))
-			metrics.IncAction(call.Name, "error")
-			continue
+			return result, err
 		}
-		log.Info("action ok", slog.String("action", call.Name), slog.Duration("ms", time.Since(aStart)))
-		r.logAudit(call.Name, msg.Sender, "ok", time.Since(aStart))
-		metrics.IncAction(call.Name, "ok")
-		if len(out) > 0 {
-			actionResults = append(actionResults, fmt.Sprintf("[%s]\n%s", call.Name, string(out)))
+		metrics.IncAgentStep()
+		if resp.SessionID != "" {
+			result.SessionID = resp.SessionID
+			req.SessionID = resp.SessionID
 		}
+		if len(resp.ActionCalls) == 0 {
+			result.Reply = resp.Reply
+			return result, nil
+		}
+
+		for i := range resp.ActionCalls {
+			if resp.ActionCalls[i].ID == "" {
+				resp.ActionCalls[i].ID = fmt.Sprintf("call_%d_%d", step, i+1)
+			}
+		}
+		results := r.runActions(loopCtx, resp.ActionCalls, msg, progress, log)
+		result.Files = append(result.Files, r.outputFiles(resp.ActionCalls, results)...)
+
+		if step >= r.maxSteps || loopCtx.Err() != nil {
+			if r.maxSteps > 1 {
+				log.Warn("agent loop budget exhausted", slog.Int("steps", step))
+			}
+			result.Reply = renderActionResults(resp.Reply, results)
+			return result, nil
+		}
+
+		req.Steps = append(req.Steps, MessageTurn{Role: RoleAgent, Text: resp.Reply, ActionCalls: resp.ActionCalls})
+		req.Steps = append(req.Steps, results...)
 	}
+}
 
-	finalText := resp.Reply
-	if len(actionResults) > 0 {
-		finalText = finalText + "\n\n" + joinStrings(actionResults, "\n\n")
+func (r *Runner) callAgentStep(ctx context.Context, req AgentRequest, progress *progressReporter, log *slog.Logger) (AgentResponse, error) {
+	reqCtx := ctx
+	if r.reqTimeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, r.reqTimeout)
+		defer cancel()
 	}
+	start := time.Now()
+	resp, err := r.callAgentWithRetry(reqCtx, req, progress, log)
+	if err != nil {
+		return resp, err
+	}
+	log.Info("agent reply", slog.Duration("ms", time.Since(start)), slog.Int("action_calls", len(resp.ActionCalls)))
+	return resp, nil
+}
 
-	outMsg := OutboundMessage{
-		Transport: msg.Transport,
-		Recipient: msg.Sender,
-		Text:      finalText,
-		ThreadID:  msg.ThreadID,
+// runActions executes calls in order and returns one action turn per call.
+func (r *Runner) runActions(ctx context.Context, calls []ActionCall, msg InboundMessage, progress *progressReporter, log *slog.Logger) []MessageTurn {
+	results := make([]MessageTurn, 0, len(calls))
+	for _, call := range calls {
+		progress.emit(AgentEvent{Kind: EventAction, Text: call.Name})
+		results = append(results, r.runAction(ctx, call, msg, log))
 	}
+	return results
+}
 
-	tr, ok := r.transportMap[msg.Transport]
+func (r *Runner) runAction(ctx context.Context, call ActionCall, msg InboundMessage, log *slog.Logger) MessageTurn {
+	sender := msg.Sender
+	turn := MessageTurn{Role: RoleAction, CallID: call.ID, Name: call.Name}
+	if len(r.allowedActions) > 0 {
+		if _, ok := r.allowedActions[call.Name]; !ok {
+			log.Warn("action not allowed", slog.String("action", call.Name))
+			r.logAudit(call.Name, sender, "denied", 0)
+			turn.Text, turn.IsError = "action not allowed: "+call.Name, true
+			return turn
+		}
+	}
+	act, ok := r.actions[call.Name]
 	if !ok {
-		log.Error("no transport for outbound", slog.String("transport", msg.Transport))
+		log.Warn("unknown action", slog.String("action", call.Name))
+		turn.Text, turn.IsError = "unknown action: "+call.Name, true
+		return turn
+	}
+	if r.needsApproval(call) {
+		wait := time.Now()
+		outcome := r.requestApproval(ctx, msg, call, log)
+		r.logAudit(call.Name, sender, outcome, time.Since(wait))
+		if outcome != "approved" {
+			log.Warn("action not approved", slog.String("action", call.Name), slog.String("outcome", outcome))
+			turn.Text, turn.IsError = "action not approved by the user ("+outcome+"): "+call.Name, true
+			return turn
+		}
+	}
+	aCtx := ctx
+	if r.actionTimeout > 0 {
+		var cancel context.CancelFunc
+		aCtx, cancel = context.WithTimeout(ctx, r.actionTimeout)
+		defer cancel()
+	}
+	aStart := time.Now()
+	out, err := act.Invoke(aCtx, call.Args)
+	if err != nil {
+		log.Error("action error", slog.String("action", call.Name), slog.String("err", err.Error()))
+		r.logAudit(call.Name, sender, "error", time.Since(aStart))
+		metrics.IncAction(call.Name, "error")
+		turn.Text, turn.IsError = "error: "+err.Error(), true
+		return turn
+	}
+	log.Info("action ok", slog.String("action", call.Name), slog.Duration("ms", time.Since(aStart)))
+	r.logAudit(call.Name, sender, "ok", time.Since(aStart))
+	metrics.IncAction(call.Name, "ok")
+	turn.Text = actionText(out)
+	return turn
+}
+
+// outputFiles lists the files written by the calls that succeeded.
+func (r *Runner) outputFiles(calls []ActionCall, results []MessageTurn) []string {
+	var files []string
+	for i, call := range calls {
+		if i >= len(results) || results[i].IsError {
+			continue
+		}
+		if fo, ok := r.actions[call.Name].(FileOutput); ok {
+			files = append(files, fo.OutputFiles(call.Args)...)
+		}
+	}
+	return files
+}
+
+// actionText unwraps JSON string results so agents and users see plain text.
+func actionText(out json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(out, &s); err == nil {
+		return s
+	}
+	return string(out)
+}
+
+// renderActionResults appends successful action outputs under the agent reply.
+func renderActionResults(reply string, results []MessageTurn) string {
+	var parts []string
+	for _, res := range results {
+		if res.IsError || res.Text == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("[%s]\n%s", res.Name, res.Text))
+	}
+	if len(parts) == 0 {
+		return reply
+	}
+	return reply + "\n\n" + joinStrings(parts, "\n\n")
+}
+
+// saveSession records the agent session as the sender's active one, refreshing its idle timer.
+func (r *Runner) saveSession(sender, sessionID string, log *slog.Logger) {
+	if r.store == nil || sessionID == "" {
+		return
+	}
+	if err := r.store.SaveActive(sender, sessionID); err != nil {
+		log.Warn("save session failed", slog.String("err", err.Error()))
+	}
+}
+
+// historyKey scopes history to a transport thread, falling back to the sender.
+func historyKey(msg InboundMessage) string {
+	thread := msg.ThreadID
+	if thread == "" {
+		thread = msg.Sender
+	}
+	return msg.Transport + ":" + thread
+}
+
+// loadHistory returns the most recent turns for the thread within the turn/token limits.
+func (r *Runner) loadHistory(msg InboundMessage, log *slog.Logger) []MessageTurn {
+	if r.history == nil {
+		return nil
+	}
+	raw, err := r.history.History(historyKey(msg), r.historyTurns)
+	if err != nil {
+		log.Warn("load history failed", slog.String("err", err.Error()))
+		return nil
+	}
+	turns := make([]MessageTurn, 0, len(raw))
+	for _, entry := range raw {
+		var turn MessageTurn
+		if err := json.Unmarshal(entry, &turn); err != nil || turn.Text == "" {
+			continue
+		}
+		turns = append(turns, turn)
+	}
+	return trimToTokens(turns, r.historyTokens)
+}
+
+// trimToTokens keeps the newest turns whose estimated size fits budget (~4 chars per token).
+func trimToTokens(turns []MessageTurn, budget int) []MessageTurn {
+	if budget <= 0 {
+		return turns
+	}
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		used += len(turns[i].Text)/4 + 1
+		if used > budget {
+			return turns[i+1:]
+		}
+	}
+	return turns
+}
+
+func (r *Runner) saveHistory(msg InboundMessage, log *slog.Logger, turns ...MessageTurn) {
+	if r.history == nil {
 		return
 	}
-	if err := r.sendWithRetry(reqCtx, tr, outMsg, log); err != nil {
-		log.Error("send error", slog.String("err", err.Error()))
-		metrics.IncSendError()
+	for _, turn := range turns {
+		data, err := json.Marshal(turn)
+		if err != nil {
+			continue
+		}
+		if err := r.history.AppendHistory(historyKey(msg), data, r.historyTurns); err != nil {
+			log.Warn("save history failed", slog.String("err", err.Error()))
+			return
+		}
 	}
 }
 
+// dedupe drops repeated entries, keeping the first occurrence.
+func dedupe(items []string) []string {
+	seen := make(map[string]struct{}, len(items))
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		if _, ok := seen[it]; ok {
+			continue
+		}
+		seen[it] = struct{}{}
+		out = append(out, it)
+	}
+	return out
+}
+
 func joinStrings(parts []string, sep string) string {
 	if len(parts) == 0 {
 		return ""
@@ -305,12 +793,17 @@ func joinStrings(parts []string, sep string) string {
 	return out
 }
 
-func (r *Runner) callAgentWithRetry(ctx context.Context, req AgentRequest, log *slog.Logger) (AgentResponse, error) {
+func (r *Runner) callAgentWithRetry(ctx context.Context, req AgentRequest, progress *progressReporter, log *slog.Logger) (AgentResponse, error) {
 	var resp AgentResponse
 	var agentErr error
+	streamer, streaming := r.agent.(StreamingAgent)
 	err := retry(ctx, 3, func() error {
 		var err error
-		resp, err = r.agent.Generate(ctx, req)
+		if streaming && progress != nil {
+			resp, err = streamer.GenerateStream(ctx, req, progress.emit)
+		} else {
+			resp, err = r.agent.Generate(ctx, req)
+		}
 		if err != nil {
 			agentErr = err
 			log.Warn("agent retry", slog.String("err", err.Error()))
@@ -346,14 +839,29 @@ func (r *Runner) logAudit(action, sender, outcome string, dur time.Duration) {
 	_ = r.auditStore.AppendAudit(action, sender, outcome, dur)
 }
 
+// sendSimple sends text as the complete answer to a message, such as a command's response.
 func (r *Runner) sendSimple(ctx context.Context, transportID, recipient, threadID, text string) {
-	msg := OutboundMessage{
+	r.sendText(ctx, OutboundMessage{
 		Transport: transportID,
 		Recipient: recipient,
 		ThreadID:  threadID,
 		Text:      text,
-	}
-	tr, ok := r.transportMap[transportID]
+		Meta:      map[string]any{MetaFinal: true},
+	})
+}
+
+// sendNotice sends text while the answer to a message is still to come, e.g. an approval prompt.
+func (r *Runner) sendNotice(ctx context.Context, transportID, recipient, threadID, text string) {
+	r.sendText(ctx, OutboundMessage{
+		Transport: transportID,
+		Recipient: recipient,
+		ThreadID:  threadID,
+		Text:      text,
+	})
+}
+
+func (r *Runner) sendText(ctx context.Context, msg OutboundMessage) {
+	tr, ok := r.transportMap[msg.Transport]
 	if !ok {
 		return
 	}
@@ -361,18 +869,22 @@ func (r *Runner) sendSimple(ctx context.Context, transportID, recipient, threadI
 }
 
 func helpText() string {
-	return "Commands: /help, /status, /new [prompt], /use <session-id>, action commands (see below). Anything else runs as prompt."
+	return "Commands: /help, /status, /new [prompt], /use <session-id>, /cancel, /more, /approve <code>, /deny [code], action commands (see below). Anything else runs as prompt."
 }
 
 func machineGreeting() string {
 	return "Starting fresh session."
 }
 
-func (r *Runner) senderAllowed(log *slog.Logger, sender string) bool {
-	if len(r.allowedSenders) == 0 {
+func (r *Runner) senderAllowed(log *slog.Logger, transportID, sender string) bool {
+	allowed := r.allowedSenders
+	if set, ok := r.transportSenders[transportID]; ok {
+		allowed = set
+	}
+	if len(allowed) == 0 {
 		return true
 	}
-	if _, ok := r.allowedSenders[strings.ToLower(sender)]; ok {
+	if _, ok := allowed[strings.ToLower(sender)]; ok {
 		return true
 	}
 	log.Warn("sender not allowed")
@@ -386,14 +898,61 @@ func (r *Runner) handleCommand(ctx context.Context, msg InboundMessage, log *slo
 		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, r.renderHelp())
 		return true
 	case "status":
+		var lines []string
 		if r.store != nil {
 			if st, ok, _ := r.store.Active(msg.Sender); ok {
-				r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("Active session: %s (updated %s)", st.SessionID, st.UpdatedAt.Format(time.RFC3339)))
+				lines = append(lines, fmt.Sprintf("Active session: %s (updated %s)", st.SessionID, st.UpdatedAt.Format(time.RFC3339)))
 			} else {
-				r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "No active session. Send a prompt to start one or /new to reset.")
+				lines = append(lines, "No active session. Send a prompt to start one or /new to reset.")
 			}
+		}
+		for _, j := range r.jobs.running(msg) {
+			lines = append(lines, "Running: "+j.describe())
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "Nothing running.")
+		}
+		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, strings.Join(lines, "\n"))
+		return true
+	case "approve":
+		code := strings.TrimSpace(cmd.Args)
+		if code == "" {
+			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "Usage: /approve <code>")
+			return true
+		}
+		if !r.approvals.resolve(conversationKey(msg), code, true) {
+			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("No pending approval %s.", code))
+			return true
+		}
+		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("Approved %s.", code))
+		return true
+	case "deny":
+		code := strings.TrimSpace(cmd.Args)
+		n := 0
+		if code == "" {
+			n = r.approvals.denyAll(conversationKey(msg))
+		} else if r.approvals.resolve(conversationKey(msg), code, false) {
+			n = 1
+		}
+		if n == 0 {
+			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "No pending approval to deny.")
+			return true
+		}
+		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("Denied %d pending action(s).", n))
+		return true
+	case "cancel":
+		stopped := r.jobs.cancel(msg)
+		if len(stopped) == 0 {
+			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "Nothing to cancel.")
 			return true
 		}
+		lines := make([]string, 0, len(stopped))
+		for _, j := range stopped {
+			lines = append(lines, "Cancelled: "+j.describe())
+		}
+		log.Info("requests cancelled", slog.Int("count", len(stopped)))
+		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, strings.Join(lines, "\n"))
+		return true
 	case "use":
 		if r.store == nil {
 			return false
@@ -412,20 +971,45 @@ func (r *Runner) handleCommand(ctx context.Context, msg InboundMessage, log *slo
 		if r.store != nil {
 			_ = r.store.ClearActive(msg.Sender)
 		}
+		if r.history != nil {
+			if err := r.history.ClearHistory(historyKey(msg)); err != nil {
+				log.Warn("clear history failed", slog.String("err", err.Error()))
+			}
+		}
+		if cmd.Args != "" {
+			// The prompt that follows /new is answered next.
+			r.sendNotice(ctx, msg.Transport, msg.Sender, msg.ThreadID, machineGreeting())
+			return false
+		}
 		r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, machineGreeting())
-		return cmd.Args == ""
+		return true
+	case "more":
+		parts := r.takeMore(historyKey(msg))
+		if len(parts) == 0 {
+			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "Nothing more to send.")
+			return true
+		}
+		if tr, ok := r.transportMap[msg.Transport]; ok {
+			r.sendParts(ctx, tr, msg, parts, nil, log)
+		}
+		return true
 	case "shell":
 		if strings.TrimSpace(cmd.Args) == "" {
 			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "Usage: /shell <command> (requires shell action enabled)")
 			return true
 		}
 		if act, ok := r.actions["shell"]; ok {
+			jobCtx, done := r.jobs.start(ctx, msg, "/shell "+jobLabel(cmd.Args))
+			defer done()
 			payload := fmt.Sprintf(`{"command":%q}`, cmd.Args)
-			out, err := act.Invoke(ctx, []byte(payload))
+			out, err := act.Invoke(jobCtx, []byte(payload))
+			if cancelled(jobCtx, ctx) {
+				return true
+			}
 			if err != nil {
 				r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, fmt.Sprintf("shell error: %v", err))
 			} else {
-				r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, string(out))
+				r.sendReply(ctx, msg, string(out), nil, log)
 			}
 		} else {
 			r.sendSimple(ctx, msg.Transport, msg.Sender, msg.ThreadID, "shell action not available")
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
 	"testing"
 	"time"
@@ -45,3 +46,23 @@ func TestInitialPromptPrepended(t *testing.T) {
 		t.Fatalf("expected outbound message")
 	}
 }
+
+type schemaAction struct{ mockAction }
+
+func (s *schemaAction) Help() string { return "schema help" }
+func (s *schemaAction) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object"}`)
+}
+
+func TestNewRunnerAdvertisesActionSchema(t *testing.T) {
+	r := NewRunner(nil, &mockAgent{}, []Action{&schemaAction{mockAction{name: "s"}}, &mockAction{name: "plain"}}, nil)
+	if len(r.actionSpecs) != 2 {
+		t.Fatalf("expected 2 specs, got %d", len(r.actionSpecs))
+	}
+	if string(r.actionSpecs[0].Parameters) != `{"type":"object"}` || r.actionSpecs[0].Description != "schema help" {
+		t.Fatalf("schema/description missing: %+v", r.actionSpecs[0])
+	}
+	if r.actionSpecs[1].Parameters != nil {
+		t.Fatalf("expected no schema for plain action")
+	}
+}
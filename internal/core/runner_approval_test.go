@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var approvalCode = regexp.MustCompile(`/approve ([0-9a-f]{6})`)
+
+func shellCall(cmd string) AgentResponse {
+	return AgentResponse{ActionCalls: []ActionCall{{Name: "echo", Args: json.RawMessage(`{"command":"` + cmd + `"}`)}}}
+}
+
+func startApprovalRunner(t *testing.T, ag Agent, audit *auditRecorder, opts ...RunnerOption) (*mockTransport, chan<- InboundMessage, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	tr := &mockTransport{id: "mock"}
+	opts = append(opts, WithAuditLogger(audit))
+	r := NewRunner([]Transport{tr}, ag, []Action{&mockAction{name: "echo", result: `"pong"`}}, slog.Default(), opts...)
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+	return tr, waitForChannel(t, tr.inboundChan), func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestApprovalRequiredRunsAfterApprove(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{shellCall("rm -rf build"), {Reply: "cleaned"}}}
+	audit := &auditRecorder{}
+	tr, in, stop := startApprovalRunner(t, ag, audit, WithApprovalPolicy("echo", ApprovalPolicy{Mode: ApprovalPatterns, Patterns: []string{`^rm `}}))
+	defer stop()
+
+	in <- InboundMessage{Transport: "mock", Sender: "alice", Text: "clean up"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 1 })
+	prompt := tr.sentMessages()[0].Text
+	m := approvalCode.FindStringSubmatch(prompt)
+	if m == nil || !strings.Contains(prompt, "rm -rf build") {
+		t.Fatalf("expected approval prompt with code, got %q", prompt)
+	}
+	if final, _ := tr.sentMessages()[0].Meta[MetaFinal].(bool); final {
+		t.Fatal("approval prompt must not be marked final")
+	}
+
+	// Another sender cannot approve it.
+	in <- InboundMessage{Transport: "mock", Sender: "mallory", Text: "/approve " + m[1]}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 2 })
+
+	in <- InboundMessage{Transport: "mock", Sender: "alice", Text: "/approve " + m[1]}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 4 })
+
+	reqs := ag.requests()
+	if len(reqs) != 2 || reqs[1].Steps[1].IsError || reqs[1].Steps[1].Text != "pong" {
+		t.Fatalf("approved action should have run, got %+v", reqs)
+	}
+	if got := audit.snapshot(); len(got) != 2 || got[0] != "echo:approved" || got[1] != "echo:ok" {
+		t.Fatalf("unexpected audit %v", got)
+	}
+}
+
+func TestApprovalDenyRefusesCall(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{shellCall("deploy"), {Reply: "ok, skipped"}}}
+	audit := &auditRecorder{}
+	tr, in, stop := startApprovalRunner(t, ag, audit, WithApprovalPolicy("echo", ApprovalPolicy{Mode: ApprovalAlways}))
+	defer stop()
+
+	in <- InboundMessage{Transport: "mock", Sender: "alice", Text: "deploy"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 1 })
+	in <- InboundMessage{Transport: "mock", Sender: "alice", Text: "/deny"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 3 })
+
+	reqs := ag.requests()
+	if len(reqs) != 2 || !reqs[1].Steps[1].IsError || !strings.Contains(reqs[1].Steps[1].Text, "denied") {
+		t.Fatalf("denied call should be reported to the agent, got %+v", reqs)
+	}
+	if got := audit.snapshot(); len(got) != 1 || got[0] != "echo:denied" {
+		t.Fatalf("unexpected audit %v", got)
+	}
+}
+
+func TestApprovalTimesOut(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{shellCall("ls"), {Reply: "gave up"}}}
+	audit := &auditRecorder{}
+	tr, in, stop := startApprovalRunner(t, ag, audit,
+		WithApprovalPolicy("echo", ApprovalPolicy{Mode: ApprovalAlways}),
+		WithApprovalTimeout(30*time.Millisecond))
+	defer stop()
+
+	in <- InboundMessage{Transport: "mock", Sender: "alice", Text: "list"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 3 })
+	if got := tr.sentMessages()[1].Text; !strings.Contains(got, "expired") {
+		t.Fatalf("expected expiry notice, got %q", got)
+	}
+	if got := audit.snapshot(); len(got) != 1 || got[0] != "echo:approval_timeout" {
+		t.Fatalf("unexpected audit %v", got)
+	}
+}
+
+func TestApprovalPatternsOnlyGateMatchingCalls(t *testing.T) {
+	r := NewRunner(nil, &mockAgent{}, nil, slog.Default(), WithApprovalPolicy("shell", ApprovalPolicy{Patterns: []string{`git push`}}))
+	if r.needsApproval(ActionCall{Name: "shell", Args: json.RawMessage(`{"command":"git status"}`)}) {
+		t.Fatalf("non-matching call should not need approval")
+	}
+	if !r.needsApproval(ActionCall{Name: "shell", Args: json.RawMessage(`{"command":"git push origin main"}`)}) {
+		t.Fatalf("matching call should need approval")
+	}
+	if r.needsApproval(ActionCall{Name: "readfile", Args: json.RawMessage(`{"path":"git push"}`)}) {
+		t.Fatalf("policy must only apply to its action")
+	}
+}
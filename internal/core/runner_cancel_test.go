@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunnerCancelAbortsInFlightRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr := &mockTransport{id: "mock"}
+	ag := &gateAgent{release: make(chan struct{})}
+	defer close(ag.release)
+	r := NewRunner([]Transport{tr}, ag, nil, slog.Default(), WithWorkers(1))
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+
+	inCh := waitForChannel(t, tr.inboundChan)
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "t1", Text: "block"}
+	waitFor(t, func() bool { return len(r.jobs.running(InboundMessage{Transport: "mock", Sender: "alice"})) == 1 })
+
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "t1", Text: "/status"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 1 })
+	if got := tr.sentMessages()[0].Text; !strings.Contains(got, `Running: "block" (running`) {
+		t.Fatalf("status should show running job, got %q", got)
+	}
+
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "t1", Text: "/cancel"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 2 })
+	if got := tr.sentMessages()[1].Text; !strings.HasPrefix(got, `Cancelled: "block"`) {
+		t.Fatalf("unexpected cancel reply %q", got)
+	}
+
+	// The cancelled request sends nothing and frees the sender's queue.
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "t1", Text: "next"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 3 })
+	if got := tr.sentMessages()[2].Text; got != "next" {
+		t.Fatalf("expected follow-up reply, got %q", got)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunnerCancelWhileWorkersSaturated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr := &mockTransport{id: "mock"}
+	ag := &gateAgent{release: make(chan struct{})}
+	r := NewRunner([]Transport{tr}, ag, nil, slog.Default(), WithWorkers(1))
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+
+	// One worker, several conversations waiting for it: handing them off must not stall intake.
+	inCh := waitForChannel(t, tr.inboundChan)
+	for _, sender := range []string{"alice", "bob", "carol", "dave"} {
+		inCh <- InboundMessage{Transport: "mock", Sender: sender, Text: "block"}
+	}
+	waitFor(t, func() bool { return len(r.jobs.running(InboundMessage{Transport: "mock", Sender: "alice"})) == 1 })
+
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", Text: "/cancel"}
+	waitFor(t, func() bool { return len(tr.sentMessages()) == 1 })
+	if got := tr.sentMessages()[0].Text; !strings.HasPrefix(got, `Cancelled: "block"`) {
+		t.Fatalf("unexpected cancel reply %q", got)
+	}
+
+	close(ag.release)
+	cancel()
+	<-done
+}
+
+func TestRunnerCancelWithNothingRunning(t *testing.T) {
+	r := NewRunner(nil, &mockAgent{reply: "hi"}, nil, slog.Default())
+	outCh := make(chan OutboundMessage, 1)
+	r.transportMap = map[string]Transport{"mock": &transportSpy{out: outCh}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "/cancel"})
+	if got := (<-outCh).Text; got != "Nothing to cancel." {
+		t.Fatalf("unexpected reply %q", got)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -51,6 +51,8 @@ func (m *memoryStore) AppendAudit(action, sender, outcome string, dur time.Durat
 // extra methods to satisfy StoreAPI (no-ops for tests)
 func (m *memoryStore) LastCursor(pubkey string) (time.Time, error)   { return time.Time{}, nil }
 func (m *memoryStore) SaveCursor(pubkey string, ts time.Time) error  { return nil }
+func (m *memoryStore) SyncToken(key string) (string, error)          { return "", nil }
+func (m *memoryStore) SaveSyncToken(key, token string) error         { return nil }
 func (m *memoryStore) AlreadyProcessed(eventID string) (bool, error) { return false, nil }
 func (m *memoryStore) MarkProcessed(eventID string) error            { return nil }
 func (m *memoryStore) RecentMessageSeen(pubkey, message string, window time.Duration) (bool, error) {
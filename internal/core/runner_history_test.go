@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+type memoryHistory struct {
+	mu      sync.Mutex
+	threads map[string][]json.RawMessage
+}
+
+func (m *memoryHistory) AppendHistory(threadID string, turn json.RawMessage, maxEntries int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.threads == nil {
+		m.threads = map[string][]json.RawMessage{}
+	}
+	entries := append(m.threads[threadID], turn)
+	if maxEntries > 0 && len(entries) > maxEntries {
+		entries = entries[len(entries)-maxEntries:]
+	}
+	m.threads[threadID] = entries
+	return nil
+}
+
+func (m *memoryHistory) History(threadID string, maxEntries int) ([]json.RawMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.threads[threadID]
+	if maxEntries > 0 && len(entries) > maxEntries {
+		entries = entries[len(entries)-maxEntries:]
+	}
+	return append([]json.RawMessage(nil), entries...), nil
+}
+
+func (m *memoryHistory) ClearHistory(threadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.threads, threadID)
+	return nil
+}
+
+func TestRunnerPersistsAndLoadsHistory(t *testing.T) {
+	hist := &memoryHistory{}
+	ag := &scriptedAgent{responses: []AgentResponse{{Reply: "first answer"}, {Reply: "second answer"}}}
+	r := NewRunner([]Transport{&mockTransport{id: "mock"}}, ag, nil, slog.Default(), WithHistoryStore(hist))
+
+	msg := InboundMessage{Transport: "mock", Sender: "alice", Text: "one", ThreadID: "t1"}
+	r.handleMessage(context.Background(), msg)
+	msg.Text = "two"
+	r.handleMessage(context.Background(), msg)
+
+	reqs := ag.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 agent calls, got %d", len(reqs))
+	}
+	if len(reqs[0].History) != 0 {
+		t.Fatalf("expected empty history on first call, got %+v", reqs[0].History)
+	}
+	h := reqs[1].History
+	if len(h) != 2 || h[0].Role != RoleUser || h[0].Text != "one" || h[1].Role != RoleAgent || h[1].Text != "first answer" {
+		t.Fatalf("unexpected history %+v", h)
+	}
+
+	newMsg := InboundMessage{Transport: "mock", Sender: "alice", Text: "/new", ThreadID: "t1"}
+	_ = captureSend(r, newMsg)
+	if entries, _ := hist.History("mock:t1", 0); len(entries) != 0 {
+		t.Fatalf("expected /new to clear history, got %d entries", len(entries))
+	}
+}
+
+func TestTrimToTokensKeepsNewest(t *testing.T) {
+	turns := []MessageTurn{
+		{Role: RoleUser, Text: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}, // ~11 tokens
+		{Role: RoleAgent, Text: "bbbb"},
+		{Role: RoleUser, Text: "cccc"},
+	}
+	got := trimToTokens(turns, 5)
+	if len(got) != 2 || got[0].Text != "bbbb" {
+		t.Fatalf("unexpected trim %+v", got)
+	}
+	if len(trimToTokens(turns, 0)) != 3 {
+		t.Fatalf("zero budget should keep all turns")
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// scriptedAgent returns responses in order and records every request.
+type scriptedAgent struct {
+	mu        sync.Mutex
+	responses []AgentResponse
+	reqs      []AgentRequest
+}
+
+func (s *scriptedAgent) Generate(ctx context.Context, req AgentRequest) (AgentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reqs = append(s.reqs, req)
+	if len(s.responses) == 0 {
+		return AgentResponse{Reply: "done"}, nil
+	}
+	resp := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	return resp, nil
+}
+
+func (s *scriptedAgent) requests() []AgentRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AgentRequest(nil), s.reqs...)
+}
+
+func TestAgentLoopFeedsActionResultsBack(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{
+		{Reply: "let me check", ActionCalls: []ActionCall{{ID: "c1", Name: "echo", Args: json.RawMessage(`{}`)}}},
+		{Reply: "the answer is pong"},
+	}}
+	audit := &auditRecorder{}
+	r := NewRunner(nil, ag, []Action{&mockAction{name: "echo", result: `"pong"`}}, slog.Default(), WithAuditLogger(audit))
+
+	out, err := r.runAgentLoop(context.Background(), AgentRequest{Prompt: "ping"}, InboundMessage{Sender: "alice"}, nil, slog.Default())
+	if err != nil {
+		t.Fatalf("loop: %v", err)
+	}
+	if out.Reply != "the answer is pong" {
+		t.Fatalf("unexpected final reply %q", out)
+	}
+	reqs := ag.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 agent calls, got %d", len(reqs))
+	}
+	steps := reqs[1].Steps
+	if len(steps) != 2 || steps[0].Role != RoleAgent || steps[1].Role != RoleAction {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+	if steps[1].CallID != "c1" || steps[1].Text != "pong" || steps[1].IsError {
+		t.Fatalf("unexpected action turn %+v", steps[1])
+	}
+	if got := audit.snapshot(); len(got) != 1 || got[0] != "echo:ok" {
+		t.Fatalf("expected audit entry, got %v", got)
+	}
+}
+
+func TestAgentLoopStopsAtMaxSteps(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{
+		{Reply: "again", ActionCalls: []ActionCall{{Name: "echo"}}},
+	}}
+	r := NewRunner(nil, ag, []Action{&mockAction{name: "echo", result: `"pong"`}}, slog.Default(), WithMaxSteps(3))
+
+	out, err := r.runAgentLoop(context.Background(), AgentRequest{Prompt: "ping"}, InboundMessage{Sender: "alice"}, nil, slog.Default())
+	if err != nil {
+		t.Fatalf("loop: %v", err)
+	}
+	if n := len(ag.requests()); n != 3 {
+		t.Fatalf("expected 3 agent calls, got %d", n)
+	}
+	if !strings.Contains(out.Reply, "[echo]\npong") {
+		t.Fatalf("expected last action output in reply, got %q", out)
+	}
+}
+
+func TestAgentLoopReportsDeniedActions(t *testing.T) {
+	ag := &scriptedAgent{responses: []AgentResponse{
+		{ActionCalls: []ActionCall{{Name: "deny"}}},
+		{Reply: "ok, skipping"},
+	}}
+	r := NewRunner(nil, ag, []Action{&denyAction{name: "deny"}}, slog.Default(), WithAllowedActions([]string{"other"}))
+
+	if _, err := r.runAgentLoop(context.Background(), AgentRequest{Prompt: "x"}, InboundMessage{Sender: "alice"}, nil, slog.Default()); err != nil {
+		t.Fatalf("loop: %v", err)
+	}
+	steps := ag.requests()[1].Steps
+	if len(steps) != 2 || !steps[1].IsError || steps[1].CallID == "" {
+		t.Fatalf("expected error result with generated call id, got %+v", steps)
+	}
+}
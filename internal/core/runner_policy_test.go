@@ -96,3 +96,34 @@ func TestRunnerSenderAllowlist(t *testing.T) {
 		t.Fatalf("expected no outbound for disallowed sender")
 	}
 }
+
+func TestRunnerTransportSendersOverrideAllowlist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr := &mockTransport{id: "chat"}
+	ag := &mockAgent{reply: "hi"}
+
+	r := NewRunner([]Transport{tr}, ag, nil, nil,
+		WithAllowedSenders([]string{"bob"}),
+		WithTransportSenders("chat", nil))
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+
+	inCh := waitForChannel(t, tr.inboundChan)
+	inCh <- InboundMessage{Transport: "chat", Sender: "U123", Text: "run", ThreadID: "t"}
+	deadline := time.Now().Add(time.Second)
+	for len(tr.sentMessages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(tr.sentMessages()) != 1 {
+		t.Fatalf("expected a reply for a sender vetted by the transport")
+	}
+}
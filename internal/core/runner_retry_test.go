@@ -35,7 +35,7 @@ func (f *flakyTransport) Send(ctx context.Context, msg OutboundMessage) error {
 func TestCallAgentWithRetrySucceedsAfterRetry(t *testing.T) {
 	r := &Runner{agent: &flakyAgent{}}
 	log := slog.Default()
-	resp, err := r.callAgentWithRetry(context.Background(), AgentRequest{Prompt: "hi"}, log)
+	resp, err := r.callAgentWithRetry(context.Background(), AgentRequest{Prompt: "hi"}, nil, log)
 	if err != nil {
 		t.Fatalf("callAgentWithRetry err: %v", err)
 	}
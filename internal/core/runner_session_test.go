@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+type sessionAgent struct {
+	calls []AgentRequest
+}
+
+func (s *sessionAgent) Generate(ctx context.Context, req AgentRequest) (AgentResponse, error) {
+	s.calls = append(s.calls, req)
+	return AgentResponse{Reply: "ok", SessionID: "sess-1"}, nil
+}
+
+func TestRunnerSavesAndResumesSession(t *testing.T) {
+	st := &memoryStore{}
+	ag := &sessionAgent{}
+	r := NewRunner(nil, ag, nil, slog.Default(), WithStore(st), WithInitialPrompt("init"))
+	outCh := make(chan OutboundMessage, 2)
+	r.transportMap = map[string]Transport{"mock": &transportSpy{out: outCh}}
+
+	msg := InboundMessage{Transport: "mock", Sender: "alice", Text: "first"}
+	r.handleMessage(context.Background(), msg)
+	if got := st.active["alice"].SessionID; got != "sess-1" {
+		t.Fatalf("expected session saved, got %q", got)
+	}
+
+	msg.Text = "second"
+	r.handleMessage(context.Background(), msg)
+	if len(ag.calls) != 2 {
+		t.Fatalf("expected 2 agent calls, got %d", len(ag.calls))
+	}
+	if ag.calls[0].SessionID != "" || ag.calls[0].Prompt != "init\n\nfirst" {
+		t.Fatalf("unexpected first request %+v", ag.calls[0])
+	}
+	if ag.calls[1].SessionID != "sess-1" || ag.calls[1].Prompt != "second" {
+		t.Fatalf("expected resumed session without preamble, got %+v", ag.calls[1])
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateAgent blocks prompts equal to "block" until release is closed and records call order.
+type gateAgent struct {
+	release chan struct{}
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (g *gateAgent) Generate(ctx context.Context, req AgentRequest) (AgentResponse, error) {
+	if req.Prompt == "block" {
+		select {
+		case <-g.release:
+		case <-ctx.Done():
+			return AgentResponse{}, ctx.Err()
+		}
+	}
+	g.mu.Lock()
+	g.prompts = append(g.prompts, req.Prompt)
+	g.mu.Unlock()
+	return AgentResponse{Reply: req.Prompt}, nil
+}
+
+func (g *gateAgent) calls() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.prompts...)
+}
+
+func TestRunnerWorkersKeepPerSenderOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr := &mockTransport{id: "mock"}
+	ag := &gateAgent{release: make(chan struct{})}
+	r := NewRunner([]Transport{tr}, ag, nil, slog.Default(), WithWorkers(2), WithDrainTimeout(2*time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+
+	inCh := waitForChannel(t, tr.inboundChan)
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", Text: "block"}
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", Text: "after"}
+	inCh <- InboundMessage{Transport: "mock", Sender: "bob", Text: "hi"}
+
+	// bob is served while alice's first message is still running.
+	deadline := time.After(2 * time.Second)
+	for len(tr.sentMessages()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("bob was blocked behind alice")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if got := ag.calls(); len(got) != 1 || got[0] != "hi" {
+		t.Fatalf("expected only bob to be handled, got %v", got)
+	}
+
+	// Shutdown drains alice's queue in order.
+	cancel()
+	close(ag.release)
+	<-done
+
+	got := ag.calls()
+	if len(got) != 3 || got[1] != "block" || got[2] != "after" {
+		t.Fatalf("expected alice's messages in order after bob, got %v", got)
+	}
+	if len(tr.sentMessages()) != 3 {
+		t.Fatalf("expected 3 replies after drain, got %d", len(tr.sentMessages()))
+	}
+}
+
+func TestRunnerDropsQueuedMessagesAtShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tr := &mockTransport{id: "mock"}
+	ag := &gateAgent{release: make(chan struct{})}
+	defer close(ag.release)
+	r := NewRunner([]Transport{tr}, ag, nil, slog.Default(), WithWorkers(1))
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Start(ctx)
+		close(done)
+	}()
+
+	inCh := waitForChannel(t, tr.inboundChan)
+	inCh <- InboundMessage{Transport: "mock", Sender: "alice", Text: "block"}
+	inCh <- InboundMessage{Transport: "mock", Sender: "bob", Text: "queued"}
+	waitFor(t, func() bool { return len(r.jobs.running(InboundMessage{Transport: "mock", Sender: "alice"})) == 1 })
+
+	// Without a drain timeout, shutdown cancels alice's run and bob's message never starts.
+	cancel()
+	<-done
+
+	if got := ag.calls(); len(got) != 0 {
+		t.Fatalf("queued message should not reach the agent after shutdown, got %v", got)
+	}
+	if sent := tr.sentMessages(); len(sent) != 0 {
+		t.Fatalf("expected no replies, got %+v", sent)
+	}
+}
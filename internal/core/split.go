@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// partLabelReserve leaves room for the "(12/34) " prefix on numbered parts.
+const partLabelReserve = 10
+
+// splitReply breaks text into parts of at most limit characters, preferring
+// paragraph boundaries and keeping fenced code blocks intact where possible
+// (a fence that must be split is closed and reopened in the next part).
+// Multiple parts are numbered "(1/3) ...". limit <= 0 disables splitting.
+func splitReply(text string, limit int) []string {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+	budget := limit - partLabelReserve
+	if budget < 1 {
+		budget = limit
+	}
+
+	var parts []string
+	cur := ""
+	for _, block := range replyBlocks(text) {
+		for _, piece := range splitBlock(block, budget) {
+			switch {
+			case cur == "":
+				cur = piece
+			case runeLen(cur)+2+runeLen(piece) <= budget:
+				cur += "\n\n" + piece
+			default:
+				parts = append(parts, cur)
+				cur = piece
+			}
+		}
+	}
+	if cur != "" {
+		parts = append(parts, cur)
+	}
+	if len(parts) == 1 {
+		return parts
+	}
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+// replyBlocks splits text on blank lines outside code fences; each fence stays one block.
+func replyBlocks(text string) []string {
+	var blocks []string
+	var cur []string
+	inFence := false
+	flush := func() {
+		if len(cur) > 0 {
+			blocks = append(blocks, strings.Join(cur, "\n"))
+			cur = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if isFence(line) {
+			if !inFence {
+				flush()
+			}
+			cur = append(cur, line)
+			if inFence {
+				flush()
+			}
+			inFence = !inFence
+			continue
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		cur = append(cur, line)
+	}
+	flush()
+	return blocks
+}
+
+// splitBlock cuts a block that exceeds budget along line boundaries, re-wrapping code fences.
+func splitBlock(block string, budget int) []string {
+	if runeLen(block) <= budget {
+		return []string{block}
+	}
+	lines := strings.Split(block, "\n")
+	if len(lines) >= 2 && isFence(lines[0]) {
+		open := lines[0]
+		inner := lines[1:]
+		if isFence(inner[len(inner)-1]) {
+			inner = inner[:len(inner)-1]
+		}
+		innerBudget := budget - runeLen(open) - len("\n\n```")
+		if innerBudget > 0 {
+			var out []string
+			for _, chunk := range packLines(inner, innerBudget) {
+				out = append(out, open+"\n"+chunk+"\n```")
+			}
+			return out
+		}
+	}
+	return packLines(lines, budget)
+}
+
+// packLines greedily joins lines up to budget, hard-splitting lines that are too long on their own.
+func packLines(lines []string, budget int) []string {
+	var out []string
+	cur := ""
+	started := false
+	for _, line := range lines {
+		for _, seg := range hardSplit(line, budget) {
+			if started && runeLen(cur)+1+runeLen(seg) <= budget {
+				cur += "\n" + seg
+				continue
+			}
+			if started {
+				out = append(out, cur)
+			}
+			cur, started = seg, true
+		}
+	}
+	if started {
+		out = append(out, cur)
+	}
+	return out
+}
+
+func hardSplit(s string, n int) []string {
+	r := []rune(s)
+	if len(r) <= n {
+		return []string{s}
+	}
+	var out []string
+	for len(r) > n {
+		out = append(out, string(r[:n]))
+		r = r[n:]
+	}
+	return append(out, string(r))
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+func runeLen(s string) int { return utf8.RuneCountInString(s) }
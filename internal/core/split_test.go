@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSplitReplyShortTextUnchanged(t *testing.T) {
+	if got := splitReply("hello", 100); len(got) != 1 || got[0] != "hello" {
+		t.Fatalf("unexpected split %v", got)
+	}
+	if got := splitReply(strings.Repeat("x", 500), 0); len(got) != 1 {
+		t.Fatalf("limit 0 should not split, got %d parts", len(got))
+	}
+}
+
+func TestSplitReplyOnParagraphs(t *testing.T) {
+	para := strings.Repeat("a", 30)
+	text := para + "\n\n" + para + "\n\n" + para
+	parts := splitReply(text, 50)
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d: %q", len(parts), parts)
+	}
+	for i, p := range parts {
+		if len([]rune(p)) > 50 {
+			t.Fatalf("part %d exceeds limit: %d", i, len(p))
+		}
+	}
+	if parts[0] != "(1/3) "+para || !strings.HasPrefix(parts[2], "(3/3) ") {
+		t.Fatalf("parts not numbered: %q", parts)
+	}
+}
+
+func TestSplitReplyReopensCodeFence(t *testing.T) {
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, strings.Repeat("x", 10))
+	}
+	text := "intro\n\n```go\n" + strings.Join(lines, "\n") + "\n```"
+	parts := splitReply(text, 80)
+	if len(parts) < 3 {
+		t.Fatalf("expected several parts, got %q", parts)
+	}
+	for i, p := range parts {
+		if len([]rune(p)) > 80 {
+			t.Fatalf("part %d exceeds limit: %q", i, p)
+		}
+		if strings.Count(p, "```")%2 != 0 {
+			t.Fatalf("part %d has unbalanced fence: %q", i, p)
+		}
+	}
+	if !strings.Contains(parts[1], "```go\n") {
+		t.Fatalf("fence not reopened with language: %q", parts[1])
+	}
+}
+
+func TestSplitReplyHardSplitsLongLines(t *testing.T) {
+	parts := splitReply(strings.Repeat("y", 250), 100)
+	total := 0
+	for _, p := range parts {
+		if len(p) > 100 {
+			t.Fatalf("part exceeds limit: %d", len(p))
+		}
+		total += strings.Count(p, "y")
+	}
+	if total != 250 {
+		t.Fatalf("lost content: %d of 250", total)
+	}
+}
+
+// limitedSpy is a transportSpy that declares a small message size.
+type limitedSpy struct{ transportSpy }
+
+func (l *limitedSpy) MaxMessageChars() int { return 40 }
+
+func TestRunnerSplitsRepliesAndHoldsBackForMore(t *testing.T) {
+	reply := strings.Repeat("p", 25)
+	for i := 0; i < 5; i++ {
+		reply += "\n\n" + strings.Repeat("p", 25)
+	}
+	ag := &mockAgent{reply: reply}
+	outCh := make(chan OutboundMessage, 16)
+	r := NewRunner(nil, ag, nil, slog.Default(), WithMaxReplyChars(8000), WithMaxReplyParts(2))
+	r.transportMap = map[string]Transport{"mock": &limitedSpy{transportSpy{out: outCh}}}
+
+	msg := InboundMessage{Transport: "mock", Sender: "alice", Text: "long please"}
+	r.handleMessage(context.Background(), msg)
+	got := drain(outCh)
+	if len(got) != 3 || !strings.HasPrefix(got[0], "(1/6) ") || !strings.HasPrefix(got[1], "(2/6) ") || !strings.Contains(got[2], "/more") {
+		t.Fatalf("unexpected first batch %q", got)
+	}
+	for _, p := range got[:2] {
+		if len(p) > 40 {
+			t.Fatalf("part exceeds transport limit: %q", p)
+		}
+	}
+
+	msg.Text = "/more"
+	r.handleMessage(context.Background(), msg)
+	if got := drain(outCh); len(got) != 3 || !strings.HasPrefix(got[0], "(3/6) ") {
+		t.Fatalf("unexpected second batch %q", got)
+	}
+	r.handleMessage(context.Background(), msg)
+	if got := drain(outCh); len(got) != 2 || !strings.HasPrefix(got[1], "(6/6) ") {
+		t.Fatalf("unexpected last batch %q", got)
+	}
+	r.handleMessage(context.Background(), msg)
+	if got := drain(outCh); len(got) != 1 || got[0] != "Nothing more to send." {
+		t.Fatalf("expected nothing more, got %q", got)
+	}
+	if len(ag.calls) != 1 {
+		t.Fatalf("/more must not reach the agent, got %d calls", len(ag.calls))
+	}
+}
+
+// wholeSpy is a limitedSpy that takes whole replies on the thread "job".
+type wholeSpy struct{ limitedSpy }
+
+func (w *wholeSpy) WholeReplies(threadID string) bool { return threadID == "job" }
+
+func TestRunnerSendsWholeRepliesAndMarksTheFinalPart(t *testing.T) {
+	reply := strings.Repeat("w", 25) + "\n\n" + strings.Repeat("w", 25) + "\n\n" + strings.Repeat("w", 25)
+	outCh := make(chan OutboundMessage, 16)
+	r := NewRunner(nil, &mockAgent{reply: reply}, nil, slog.Default())
+	r.transportMap = map[string]Transport{"mock": &wholeSpy{limitedSpy{transportSpy{out: outCh}}}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "job", Text: "go"})
+	whole := <-outCh
+	if whole.Text != reply || whole.Meta[MetaFinal] != true || len(outCh) != 0 {
+		t.Fatalf("expected the whole reply as one final message, got %+v", whole)
+	}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", ThreadID: "chat", Text: "go"})
+	var parts []OutboundMessage
+	for len(outCh) > 0 {
+		parts = append(parts, <-outCh)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("expected three parts, got %+v", parts)
+	}
+	for i, p := range parts {
+		if final, _ := p.Meta[MetaFinal].(bool); final != (i == len(parts)-1) {
+			t.Fatalf("only the last part should be final: %+v", parts)
+		}
+	}
+}
+
+func drain(ch chan OutboundMessage) []string {
+	var out []string
+	for {
+		select {
+		case m := <-ch:
+			out = append(out, m.Text)
+		default:
+			return out
+		}
+	}
+}
+
+// attachSpy is a transportSpy that sends attachments.
+type attachSpy struct{ limitedSpy }
+
+func (a *attachSpy) SendsAttachments() bool { return true }
+
+// writeAction reports the "path" argument of each call as a written file.
+type writeAction struct{ mockAction }
+
+func (w *writeAction) OutputFiles(args json.RawMessage) []string {
+	var p struct{ Path string }
+	_ = json.Unmarshal(args, &p)
+	return []string{p.Path}
+}
+
+func TestRunnerSendsWholeReplyWithWrittenFiles(t *testing.T) {
+	reply := strings.Repeat("p", 25) + "\n\n" + strings.Repeat("q", 25)
+	ag := &scriptedAgent{responses: []AgentResponse{
+		{ActionCalls: []ActionCall{
+			{Name: "write", Args: json.RawMessage(`{"path":"/tmp/a.txt"}`)},
+			{Name: "write", Args: json.RawMessage(`{"path":"/tmp/a.txt"}`)},
+		}},
+		{Reply: reply},
+	}}
+	outCh := make(chan OutboundMessage, 4)
+	r := NewRunner(nil, ag, []Action{&writeAction{mockAction{name: "write", result: `"ok"`}}}, slog.Default(), WithMaxSteps(2))
+	r.transportMap = map[string]Transport{"mock": &attachSpy{limitedSpy{transportSpy{out: outCh}}}}
+
+	r.handleMessage(context.Background(), InboundMessage{Transport: "mock", Sender: "alice", Text: "write it"})
+	if len(outCh) != 1 {
+		t.Fatalf("expected one unsplit reply, got %d messages", len(outCh))
+	}
+	out := <-outCh
+	if out.Text != reply {
+		t.Fatalf("reply changed: %q", out.Text)
+	}
+	files, _ := out.Meta[MetaAttachments].([]string)
+	if len(files) != 1 || files[0] != "/tmp/a.txt" {
+		t.Fatalf("attachments = %v", out.Meta)
+	}
+}
@@ -21,6 +21,66 @@ type Agent interface {
 	Generate(ctx context.Context, req AgentRequest) (AgentResponse, error)
 }
 
+// StreamingAgent is optionally implemented by agents that report progress
+// (reasoning, commands, interim messages) before their final reply.
+type StreamingAgent interface {
+	Agent
+	GenerateStream(ctx context.Context, req AgentRequest, emit func(AgentEvent)) (AgentResponse, error)
+}
+
+// MessageLimiter is optionally implemented by transports that reject messages
+// above a size; the runner splits replies to fit.
+type MessageLimiter interface {
+	// MaxMessageChars returns the largest message the transport accepts, in characters; 0 means no limit.
+	MaxMessageChars() int
+}
+
+// AttachmentTransport is optionally implemented by transports that can send files with a
+// message. The runner hands them replies unsplit, leaving long ones for the transport to
+// attach, and lists files written by actions in OutboundMessage.Meta[MetaAttachments].
+type AttachmentTransport interface {
+	Transport
+	// SendsAttachments reports whether this instance is configured to send attachments.
+	SendsAttachments() bool
+}
+
+// MetaAttachments is the Meta key holding file paths ([]string): files received with an
+// inbound message, or files to send with an outbound one.
+const MetaAttachments = "attachments"
+
+// MetaFinal marks the outbound message that completes the runner's answer to an inbound
+// message (bool): the reply or its last part, or a command's response. Progress updates,
+// approval prompts and earlier reply parts come without it.
+const MetaFinal = "final"
+
+// WholeReplyTransport is optionally implemented by transports that take a reply of any
+// length as one message; the runner neither splits nor holds back replies on the threads
+// for which WholeReplies returns true.
+type WholeReplyTransport interface {
+	Transport
+	WholeReplies(threadID string) bool
+}
+
+// EditableTransport is optionally implemented by transports that can update a
+// message after sending it; the runner uses it to keep a single progress placeholder.
+type EditableTransport interface {
+	Transport
+	// SendEditable delivers msg and returns a handle identifying it for Edit.
+	SendEditable(ctx context.Context, msg OutboundMessage) (string, error)
+	// Edit replaces the text of a message previously sent with SendEditable.
+	Edit(ctx context.Context, handle string, msg OutboundMessage) error
+}
+
+// ProgressTransport is optionally implemented by transports that show progress updates
+// sent as ordinary messages with Meta["progress"] set (a status line, job feedback).
+// Other transports only get progress through EditableTransport, so a long run does not
+// turn into one email or paid message per update.
+type ProgressTransport interface {
+	Transport
+	// AcceptsProgress reports whether progress messages should be sent to this instance.
+	AcceptsProgress() bool
+}
+
 // Action exposes a callable capability (shell, fs, git, etc.).
 type Action interface {
 	Name() string
@@ -30,6 +90,19 @@ type Action interface {
 	Invoke(ctx context.Context, args json.RawMessage) (json.RawMessage, error)
 }
 
+// FileOutput is optionally implemented by actions that write files, so the runner can
+// offer them to transports that send attachments.
+type FileOutput interface {
+	// OutputFiles returns the paths written by a successful call with args.
+	OutputFiles(args json.RawMessage) []string
+}
+
+// ActionSchema is optionally implemented by actions that describe their Invoke
+// arguments as a JSON Schema object, letting agents expose them as native tools.
+type ActionSchema interface {
+	Schema() json.RawMessage
+}
+
 // InboundMessage represents a message entering the runner.
 type InboundMessage struct {
 	Transport string         `json:"transport"`
@@ -50,8 +123,13 @@ type OutboundMessage struct {
 
 // AgentRequest supplies the agent with prompt/context and available actions.
 type AgentRequest struct {
-	Prompt     string         `json:"prompt"`
-	History    []MessageTurn  `json:"history,omitempty"`
+	Prompt string `json:"prompt"`
+	// SessionID is the sender's active agent session to resume; empty starts a new one.
+	SessionID string        `json:"session_id,omitempty"`
+	History   []MessageTurn `json:"history,omitempty"`
+	// Steps holds agent and action turns produced after Prompt within the
+	// current multi-step loop (tool calls and their results), oldest first.
+	Steps      []MessageTurn  `json:"steps,omitempty"`
 	Actions    []ActionSpec   `json:"actions,omitempty"`
 	SenderMeta map[string]any `json:"sender_meta,omitempty"`
 }
@@ -61,23 +139,55 @@ type AgentResponse struct {
 	Reply       string       `json:"reply"`
 	SessionID   string       `json:"session_id,omitempty"`
 	ActionCalls []ActionCall `json:"action_calls,omitempty"`
+	// Files are the paths written by actions during the agent loop; set by the runner.
+	Files []string `json:"-"`
 }
 
 // MessageTurn represents one exchange in history.
 type MessageTurn struct {
-	Role string `json:"role"` // user or agent
+	Role string `json:"role"` // user, agent, or action
 	Text string `json:"text"`
+
+	// ActionCalls are the calls requested by an agent turn.
+	ActionCalls []ActionCall `json:"action_calls,omitempty"`
+	// CallID, Name, and IsError describe an action turn (the result of one call).
+	CallID  string `json:"call_id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	IsError bool   `json:"is_error,omitempty"`
 }
 
+// Message turn roles.
+const (
+	RoleUser   = "user"
+	RoleAgent  = "agent"
+	RoleAction = "action"
+)
+
+// AgentEvent is an interim progress update emitted while an agent works.
+type AgentEvent struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+// Agent event kinds.
+const (
+	EventReasoning = "reasoning"
+	EventCommand   = "command"
+	EventMessage   = "message"
+	EventAction    = "action"
+)
+
 // ActionSpec advertises an available action to the agent.
 type ActionSpec struct {
-	Name         string   `json:"name"`
-	Capabilities []string `json:"capabilities,omitempty"`
-	Description  string   `json:"description,omitempty"`
+	Name         string          `json:"name"`
+	Capabilities []string        `json:"capabilities,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	Parameters   json.RawMessage `json:"parameters,omitempty"` // JSON Schema for args
 }
 
 // ActionCall is an agent-requested invocation of an action.
 type ActionCall struct {
+	ID   string          `json:"id,omitempty"` // provider tool-call id, if any
 	Name string          `json:"name"`
 	Args json.RawMessage `json:"args"`
 }
@@ -7,6 +7,8 @@ import (
 	"net"
 	"net/http"
 	"time"
+
+	"github.com/joelklabo/buddy/internal/metrics"
 )
 
 // Start launches a simple /health endpoint. If addr is empty, it is a no-op.
@@ -24,10 +26,7 @@ func Start(ctx context.Context, addr, version string, logger *slog.Logger) (stri
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]string{
-			"status":  "ok",
-			"version": version,
-		})
+		_ = json.NewEncoder(w).Encode(report(version, metrics.Relays()))
 	})
 
 	srv := &http.Server{
@@ -51,3 +50,26 @@ func Start(ctx context.Context, addr, version string, logger *slog.Logger) (stri
 	logger.Info("health server listening", slog.String("addr", actual))
 	return actual, nil
 }
+
+// report is the /health body. With Nostr relays in use, status is "degraded" while none of
+// them is up.
+func report(version string, relays []metrics.RelayStatus) map[string]any {
+	status := "ok"
+	if len(relays) > 0 {
+		status = "degraded"
+		for _, r := range relays {
+			if r.Up {
+				status = "ok"
+				break
+			}
+		}
+	}
+	body := map[string]any{
+		"status":  status,
+		"version": version,
+	}
+	if len(relays) > 0 {
+		body["relays"] = relays
+	}
+	return body
+}
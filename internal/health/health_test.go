@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"log/slog"
+
+	"github.com/joelklabo/buddy/internal/metrics"
 )
 
 func TestHealthServer(t *testing.T) {
@@ -56,3 +58,17 @@ func TestHealthServerStopsOnCancel(t *testing.T) {
 	}
 	t.Fatalf("health endpoint still responding after cancel")
 }
+
+func TestReportDegradedWhenNoRelayUp(t *testing.T) {
+	if got := report("v", nil); got["status"] != "ok" || got["relays"] != nil {
+		t.Fatalf("unexpected report without relays %+v", got)
+	}
+	down := []metrics.RelayStatus{{URL: "wss://a"}, {URL: "wss://b", LastError: "timeout"}}
+	if got := report("v", down); got["status"] != "degraded" {
+		t.Fatalf("expected degraded, got %+v", got)
+	}
+	down[1].Up = true
+	if got := report("v", down); got["status"] != "ok" {
+		t.Fatalf("expected ok with one relay up, got %+v", got)
+	}
+}
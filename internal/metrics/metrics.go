@@ -4,6 +4,8 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,12 +17,38 @@ var (
 	agentErrors = prometheus.NewCounter(prometheus.CounterOpts{Name: "runner_agent_errors_total", Help: "Agent errors"})
 	actionCalls = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "runner_action_calls_total", Help: "Action invocations"}, []string{"action", "status"})
 	sendErrors  = prometheus.NewCounter(prometheus.CounterOpts{Name: "runner_send_errors_total", Help: "Transport send errors"})
+	agentSteps  = prometheus.NewCounter(prometheus.CounterOpts{Name: "runner_agent_steps_total", Help: "Agent calls made by the action loop"})
+	queueDepth  = prometheus.NewGauge(prometheus.GaugeOpts{Name: "runner_queue_depth", Help: "Inbound messages waiting for a worker"})
+	inFlight    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "runner_inflight", Help: "Inbound messages being processed"})
+
+	relayUp      = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "runner_relay_up", Help: "Nostr relay connection state (1 up, 0 down)"}, []string{"relay"})
+	relayPublish = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "runner_relay_publish_total", Help: "Events published per Nostr relay"}, []string{"relay", "status"})
+
+	deliveryStatus = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "runner_delivery_status_total", Help: "Delivery status callbacks per transport"}, []string{"transport", "status"})
+
+	outboxDepth = prometheus.NewGauge(prometheus.GaugeOpts{Name: "runner_outbox_depth", Help: "Outbound messages queued for delivery"})
+	deadLetters = prometheus.NewCounter(prometheus.CounterOpts{Name: "runner_dead_letters_total", Help: "Outbound messages given up on after their last attempt"})
 )
 
 func init() {
-	prometheus.MustRegister(inboundMsgs, agentErrors, actionCalls, sendErrors)
+	prometheus.MustRegister(inboundMsgs, agentErrors, actionCalls, sendErrors, agentSteps, queueDepth, inFlight, relayUp, relayPublish, deliveryStatus, outboxDepth, deadLetters)
+}
+
+// RelayStatus is a relay's state as reported on /health.
+type RelayStatus struct {
+	URL           string    `json:"url"`
+	Up            bool      `json:"up"`
+	Since         time.Time `json:"since"` // when Up last changed
+	LastError     string    `json:"last_error,omitempty"`
+	Published     int       `json:"published"`
+	PublishFailed int       `json:"publish_failed"`
 }
 
+var (
+	relayMu     sync.Mutex
+	relayStates = map[string]*RelayStatus{}
+)
+
 // Start runs a Prometheus handler on the given listen addr.
 func Start(ctx context.Context, listen string, log *slog.Logger) error {
 	if listen == "" {
@@ -52,3 +80,83 @@ func IncAgentError() { agentErrors.Inc() }
 func IncAction(action string, status string) { actionCalls.WithLabelValues(action, status).Inc() }
 
 func IncSendError() { sendErrors.Inc() }
+
+func IncAgentStep() { agentSteps.Inc() }
+
+// IncDeliveryStatus counts a delivery report (e.g. "delivered", "failed") from a transport.
+func IncDeliveryStatus(transport, status string) {
+	deliveryStatus.WithLabelValues(transport, status).Inc()
+}
+
+// SetOutboxDepth reports how many outbound messages are queued for delivery.
+func SetOutboxDepth(n int) { outboxDepth.Set(float64(n)) }
+
+func IncDeadLetter() { deadLetters.Inc() }
+
+func IncQueued() { queueDepth.Inc() }
+
+func DecQueued() { queueDepth.Dec() }
+
+func IncInFlight() { inFlight.Inc() }
+
+func DecInFlight() { inFlight.Dec() }
+
+// SetRelayUp records a relay's connection state; errMsg explains a down relay.
+func SetRelayUp(relay string, up bool, errMsg string) {
+	v := 0.0
+	if up {
+		v = 1
+	}
+	relayUp.WithLabelValues(relay).Set(v)
+
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	st := relayState(relay)
+	if st.Up != up || st.Since.IsZero() {
+		st.Up, st.Since = up, time.Now()
+	}
+	if errMsg != "" {
+		st.LastError = errMsg
+	}
+}
+
+// IncRelayPublish counts an event a relay accepted (ok) or rejected with errMsg.
+func IncRelayPublish(relay string, ok bool, errMsg string) {
+	status := "ok"
+	if !ok {
+		status = "error"
+	}
+	relayPublish.WithLabelValues(relay, status).Inc()
+
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	st := relayState(relay)
+	if ok {
+		st.Published++
+	} else {
+		st.PublishFailed++
+		st.LastError = errMsg
+	}
+}
+
+// Relays returns the state of every relay seen so far, sorted by URL.
+func Relays() []RelayStatus {
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	out := make([]RelayStatus, 0, len(relayStates))
+	for _, st := range relayStates {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+// relayState returns the entry for relay, creating it; callers hold relayMu.
+func relayState(relay string) *RelayStatus {
+	st, ok := relayStates[relay]
+	if !ok {
+		st = &RelayStatus{URL: relay}
+		relayStates[relay] = st
+	}
+	return st
+}
@@ -5,34 +5,69 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joelklabo/buddy/internal/store"
 
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// DM encryption schemes.
+const (
+	SchemeNIP04 = "nip04" // kind 4, deprecated; leaks sender, recipient, and timing
+	SchemeNIP17 = "nip17" // kind 1059 gift wrap > kind 13 seal > kind 14 rumor, NIP-44 encrypted
+)
+
+// giftWrapSkew covers the randomized created_at of gift wraps (NIP-59 allows up to two days
+// in the past), so the subscription reaches back further than the cursor.
+const giftWrapSkew = 48 * time.Hour
+
+// maxUnwraps is the number of workers decrypting gift wraps, and maxQueuedWraps how many
+// wraps may wait for them; further wraps are dropped. The sender is only known after
+// unwrapping, so wraps from anyone reach this point; the limits bound the work they cause.
+const (
+	maxUnwraps     = 4
+	maxQueuedWraps = 64
 )
 
 // IncomingMessage is a decrypted DM sent to the runner.
 type IncomingMessage struct {
-	Event        *nostr.Event
+	Event        *nostr.Event // the kind 4 event, or the unwrapped kind 14 rumor for NIP-17
 	SenderPubKey string
 	Plaintext    string
+	Scheme       string       // SchemeNIP04 or SchemeNIP17; empty for job requests
+	Job          *nostr.Event // the NIP-90 job request, when the message is one
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// RequireNIP17 ignores NIP-04 DMs and always replies with NIP-17 gift wraps.
+func RequireNIP17() Option {
+	return func(c *Client) { c.requireNIP17 = true }
 }
 
 // Client wraps Nostr connectivity and send/receive helpers.
 type Client struct {
 	pool    Pool
-	privKey string
+	signer  Signer
 	pubKey  string
 	relays  []string
 	store   store.StoreAPI
 	allowed map[string]struct{}
 
-	secretMu sync.Mutex
-	secrets  map[string][]byte
+	requireNIP17 bool
+	dvmKinds     []int // NIP-90 job request kinds served
+	schemeMu     sync.Mutex
+	schemes      map[string]string // sender -> scheme of their latest DM, used for replies
+
+	outboxMu sync.Mutex
+	outbox   map[string][]string // sender -> read relays from their NIP-65 list
 
 	seen *seenIDs
 
@@ -43,6 +78,8 @@ type Client struct {
 	senderMu    sync.Mutex
 	senderLocks map[string]*sync.Mutex
 	msgWindow   time.Duration
+
+	droppedWraps atomic.Int64 // gift wraps dropped because the unwrap queue was full
 }
 
 type lastSeen struct {
@@ -51,102 +88,254 @@ type lastSeen struct {
 }
 
 // New constructs a client pointing at the provided relays.
-func New(privKey string, pubKey string, relays []string, allowedPubkeys []string, st store.StoreAPI) *Client {
-	return NewWithPool(privKey, pubKey, relays, allowedPubkeys, st, nostr.NewSimplePool(context.Background()))
+func New(privKey string, pubKey string, relays []string, allowedPubkeys []string, st store.StoreAPI, opts ...Option) *Client {
+	return NewWithPool(privKey, pubKey, relays, allowedPubkeys, st, nostr.NewSimplePool(context.Background()), opts...)
 }
 
 // NewWithPool allows injecting a custom pool (for tests).
-func NewWithPool(privKey string, pubKey string, relays []string, allowedPubkeys []string, st store.StoreAPI, pool Pool) *Client {
+func NewWithPool(privKey string, pubKey string, relays []string, allowedPubkeys []string, st store.StoreAPI, pool Pool, opts ...Option) *Client {
+	return NewWithSigner(newKeySigner(privKey, pubKey), relays, allowedPubkeys, st, pool, opts...)
+}
+
+// NewWithSigner constructs a client whose identity is held by signer, such as a BunkerSigner.
+func NewWithSigner(signer Signer, relays []string, allowedPubkeys []string, st store.StoreAPI, pool Pool, opts ...Option) *Client {
 	allowed := make(map[string]struct{}, len(allowedPubkeys))
 	for _, pk := range allowedPubkeys {
 		allowed[strings.ToLower(pk)] = struct{}{}
 	}
-	return &Client{
+	c := &Client{
 		pool:        pool,
-		privKey:     privKey,
-		pubKey:      strings.ToLower(pubKey),
+		signer:      signer,
+		pubKey:      strings.ToLower(signer.PublicKey()),
 		relays:      relays,
 		store:       st,
 		allowed:     allowed,
-		secrets:     make(map[string][]byte),
+		schemes:     make(map[string]string),
+		outbox:      make(map[string][]string),
 		seen:        newSeenIDs(),
 		lastMsg:     make(map[string]lastSeen),
 		windowDur:   8 * time.Second,
 		senderLocks: make(map[string]*sync.Mutex),
 		msgWindow:   30 * time.Second,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// Listen subscribes to encrypted DMs addressed to this runner and invokes handler for each new message.
+// Listen subscribes to encrypted DMs addressed to this runner (NIP-04 and NIP-17) and
+// invokes handler for each new message. Each relay is subscribed separately and
+// resubscribed with backoff, so one failing relay does not interrupt the others.
 func (c *Client) Listen(ctx context.Context, handler func(context.Context, IncomingMessage)) error {
 	if c.pool == nil {
 		return errors.New("nil pool")
 	}
 
+	floor := c.buildFilter().Since.Time()
+	wraps := make(chan *nostr.Event, maxQueuedWraps)
+	for i := 0; i < maxUnwraps; i++ {
+		go c.unwrapWorker(ctx, wraps, floor, handler)
+	}
+	events := make(chan nostr.RelayEvent)
+	for _, url := range c.relays {
+		go c.listenRelay(ctx, url, events)
+	}
+	go c.probeRelays(ctx)
+	go c.discoverOutbox(ctx)
+
 	for {
-		filter := c.buildFilter()
-		events := c.pool.SubscribeMany(ctx, c.relays, filter)
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case ie, ok := <-events:
-				if !ok {
-					time.Sleep(2 * time.Second)
-					goto resubscribe
-				}
-				evt := ie.Event
-				if evt == nil {
-					continue
-				}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ie := <-events:
+			evt := ie.Event
+			if evt == nil {
+				continue
+			}
 
-				if c.seen.Seen(evt.ID) {
-					continue
-				}
-				already, err := c.store.AlreadyProcessed(evt.ID)
-				if err != nil {
-					continue
-				}
-				if already {
+			if c.seen.Seen(evt.ID) {
+				continue
+			}
+			already, err := c.store.AlreadyProcessed(evt.ID)
+			if err != nil {
+				continue
+			}
+			if already {
+				continue
+			}
+
+			switch evt.Kind {
+			case nostr.KindEncryptedDirectMessage:
+				if c.requireNIP17 {
 					continue
 				}
-
 				sender := strings.ToLower(evt.PubKey)
 				if _, ok := c.allowed[sender]; !ok {
 					continue
 				}
 
-				secret, err := c.sharedSecret(sender)
-				if err != nil {
-					continue
-				}
-
 				lock := c.senderLock(sender)
-				go func(e *nostr.Event, s string, sec []byte) {
+				go func(e *nostr.Event, s string) {
 					lock.Lock()
 					defer lock.Unlock()
 
-					dec, err := nip04.Decrypt(e.Content, sec)
+					dec, err := c.signer.DecryptNIP04(ctx, e.Content, s)
 					if err != nil {
 						return
 					}
+					c.deliver(ctx, handler, IncomingMessage{Event: e, SenderPubKey: s, Plaintext: dec, Scheme: SchemeNIP04})
+				}(evt, sender)
+			case nostr.KindGiftWrap:
+				// Unwrapping takes NIP-44 decryptions (round trips with a bunker), so the
+				// workers do it off the read loop; when they fall behind, wraps are dropped.
+				select {
+				case wraps <- evt:
+				default:
+					c.dropWrap(evt.ID)
+				}
+			default:
+				if !c.isJobKind(evt.Kind) {
+					continue
+				}
+				sender := strings.ToLower(evt.PubKey)
+				if _, ok := c.allowed[sender]; !ok {
+					continue
+				}
 
-					if seen, err := c.store.RecentMessageSeen(s, dec, c.msgWindow); err == nil && seen {
+				lock := c.senderLock(sender)
+				go func(e *nostr.Event, s string) {
+					lock.Lock()
+					defer lock.Unlock()
+					input, ok := c.acceptJob(ctx, e)
+					if !ok {
 						return
 					}
+					c.deliver(ctx, handler, IncomingMessage{Event: e, SenderPubKey: s, Plaintext: input, Job: e})
+				}(evt, sender)
+			}
+		}
+	}
+}
 
-					if c.isReplay(s, dec, e.CreatedAt.Time()) {
-						return
-					}
+// unwrapWorker handles queued gift wraps until ctx is done.
+func (c *Client) unwrapWorker(ctx context.Context, wraps <-chan *nostr.Event, floor time.Time, handler func(context.Context, IncomingMessage)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case wrap := <-wraps:
+			c.receiveGiftWrap(ctx, wrap, floor, handler)
+		}
+	}
+}
 
-					_ = c.store.SaveCursor(s, e.CreatedAt.Time())
+// dropWrap counts a gift wrap the workers had no room for, logging the first and every 100th.
+func (c *Client) dropWrap(id string) {
+	if n := c.droppedWraps.Add(1); n == 1 || n%100 == 0 {
+		slog.Warn("dropping gift wrap: unwrap queue is full", "event", id, "dropped", n)
+	}
+}
 
-					handler(ctx, IncomingMessage{Event: e, SenderPubKey: s, Plaintext: dec})
-				}(evt, sender, secret)
-			}
+// receiveGiftWrap unwraps a NIP-17 DM and delivers it if it comes from an allowed sender.
+func (c *Client) receiveGiftWrap(ctx context.Context, wrap *nostr.Event, floor time.Time, handler func(context.Context, IncomingMessage)) {
+	rumor, err := c.unwrap(ctx, wrap)
+	if err != nil {
+		return
+	}
+	sender := strings.ToLower(rumor.PubKey)
+	if _, ok := c.allowed[sender]; !ok {
+		return
+	}
+	// The wrap's timestamp is randomized; the rumor carries the real one.
+	if rumor.Kind != nostr.KindDirectMessage || rumor.CreatedAt.Time().Before(floor) {
+		return
+	}
+
+	lock := c.senderLock(sender)
+	lock.Lock()
+	defer lock.Unlock()
+	c.deliver(ctx, handler, IncomingMessage{Event: &rumor, SenderPubKey: sender, Plaintext: rumor.Content, Scheme: SchemeNIP17})
+}
+
+// deliver applies the content dedupe, advances the sender's cursor, and hands msg to handler.
+// Callers hold the sender's lock.
+func (c *Client) deliver(ctx context.Context, handler func(context.Context, IncomingMessage), msg IncomingMessage) {
+	if seen, err := c.store.RecentMessageSeen(msg.SenderPubKey, msg.Plaintext, c.msgWindow); err == nil && seen {
+		return
+	}
+
+	at := msg.Event.CreatedAt.Time()
+	if c.isReplay(msg.SenderPubKey, msg.Plaintext, at) {
+		return
+	}
+
+	_ = c.store.SaveCursor(msg.SenderPubKey, at)
+	if msg.Scheme != "" {
+		c.schemeMu.Lock()
+		c.schemes[msg.SenderPubKey] = msg.Scheme
+		c.schemeMu.Unlock()
+	}
+
+	handler(ctx, msg)
+}
+
+// subscribe opens the kind 4, gift wrap, and job request subscriptions on one relay and
+// merges their events. eose is closed once the relay has answered either subscription.
+func (c *Client) subscribe(ctx context.Context, url string, dmFilter nostr.Filter) (events chan nostr.RelayEvent, eose chan struct{}) {
+	filters := []nostr.Filter{c.giftWrapFilter(*dmFilter.Since)}
+	if !c.requireNIP17 {
+		filters = append(filters, dmFilter)
+	}
+	if len(c.dvmKinds) > 0 {
+		filters = append(filters, c.jobFilter(*dmFilter.Since))
+	}
+	out := make(chan nostr.RelayEvent)
+	eose = make(chan struct{})
+	var eoseOnce sync.Once
+	var wg sync.WaitGroup
+	for _, f := range filters {
+		var in chan nostr.RelayEvent
+		if p, ok := c.pool.(eosePool); ok {
+			done := make(chan struct{})
+			in = p.SubscribeManyNotifyEOSE(ctx, []string{url}, f, done)
+			go func() {
+				select {
+				case <-done:
+					eoseOnce.Do(func() { close(eose) })
+				case <-ctx.Done():
+				}
+			}()
+		} else {
+			in = c.pool.SubscribeMany(ctx, []string{url}, f)
 		}
-	resubscribe:
-		continue
+		wg.Add(1)
+		go func(in chan nostr.RelayEvent) {
+			defer wg.Done()
+			for ie := range in {
+				select {
+				case out <- ie:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, eose
+}
+
+// giftWrapFilter matches NIP-17 gift wraps to this runner. Wraps are signed by throwaway
+// keys, so senders are checked after unwrapping.
+func (c *Client) giftWrapFilter(since nostr.Timestamp) nostr.Filter {
+	wrapSince := since - nostr.Timestamp(giftWrapSkew/time.Second)
+	return nostr.Filter{
+		Kinds: []int{nostr.KindGiftWrap},
+		Since: &wrapSince,
+		Tags:  nostr.TagMap{"p": []string{c.pubKey}},
 	}
 }
 
@@ -160,14 +349,15 @@ func (c *Client) buildFilter() nostr.Filter {
 	}
 }
 
-// SendReply DM's a message back to the sender.
+// SendReply DM's a message back to the sender, in the scheme of their latest DM
+// (NIP-17 when required). Besides the configured relays, it goes to the read relays from
+// the sender's NIP-65 relay list.
 func (c *Client) SendReply(ctx context.Context, toPubKey string, message string) error {
-	secret, err := c.sharedSecret(toPubKey)
-	if err != nil {
-		return err
+	if c.replyScheme(toPubKey) == SchemeNIP17 {
+		return c.sendGiftWrap(ctx, toPubKey, message)
 	}
 
-	enc, err := nip04.Encrypt(message, secret)
+	enc, err := c.signer.EncryptNIP04(ctx, message, toPubKey)
 	if err != nil {
 		return fmt.Errorf("encrypt DM: %w", err)
 	}
@@ -179,29 +369,76 @@ func (c *Client) SendReply(ctx context.Context, toPubKey string, message string)
 		Tags:      nostr.Tags{nostr.Tag{"p", toPubKey}},
 		Content:   enc,
 	}
-	if err := ev.Sign(c.privKey); err != nil {
+	if err := c.signer.Sign(ctx, &ev); err != nil {
 		return fmt.Errorf("sign DM: %w", err)
 	}
+	return c.publish(ctx, ev, c.outboxRelays(toPubKey)...)
+}
 
-	results := c.pool.PublishMany(ctx, c.relays, ev)
-	var firstErr error
-	for res := range results {
-		if res.Error != nil && firstErr == nil {
-			firstErr = res.Error
-		}
+// sendGiftWrap sends message as a NIP-17 kind 14 rumor, sealed and gift wrapped for toPubKey.
+func (c *Client) sendGiftWrap(ctx context.Context, toPubKey string, message string) error {
+	rumor := nostr.Event{
+		PubKey:    c.pubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", toPubKey}},
+		Content:   message,
 	}
-	return firstErr
+	rumor.ID = rumor.GetID()
+	wrap, err := nip59.GiftWrap(rumor, toPubKey,
+		func(plaintext string) (string, error) { return c.signer.EncryptNIP44(ctx, plaintext, toPubKey) },
+		func(e *nostr.Event) error { return c.signer.Sign(ctx, e) },
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("gift wrap DM: %w", err)
+	}
+	return c.publish(ctx, wrap, c.outboxRelays(toPubKey)...)
 }
 
-// PublishProfile broadcasts the runner's metadata (name, picture) to configured relays.
-func (c *Client) PublishProfile(ctx context.Context, name, picture string) error {
-	meta := make(map[string]string, 2)
-	if strings.TrimSpace(name) != "" {
-		meta["name"] = name
+// unwrap opens a gift wrap and its seal; the rumor's author is the seal's signer.
+func (c *Client) unwrap(ctx context.Context, wrap *nostr.Event) (nostr.Event, error) {
+	return nip59.GiftUnwrap(*wrap, func(otherPub, ciphertext string) (string, error) {
+		return c.signer.DecryptNIP44(ctx, ciphertext, otherPub)
+	})
+}
+
+func (c *Client) replyScheme(peerPub string) string {
+	if c.requireNIP17 {
+		return SchemeNIP17
+	}
+	c.schemeMu.Lock()
+	defer c.schemeMu.Unlock()
+	if s, ok := c.schemes[strings.ToLower(peerPub)]; ok {
+		return s
 	}
-	if strings.TrimSpace(picture) != "" {
-		meta["picture"] = picture
+	return SchemeNIP04
+}
+
+// Profile is the runner's kind 0 metadata. Empty fields are omitted.
+type Profile struct {
+	Name    string
+	Picture string
+	About   string
+	NIP05   string
+	LUD16   string
+}
+
+// metadata returns the set fields keyed by their kind 0 names.
+func (p Profile) metadata() map[string]string {
+	meta := make(map[string]string, 5)
+	for k, v := range map[string]string{"name": p.Name, "picture": p.Picture, "about": p.About, "nip05": p.NIP05, "lud16": p.LUD16} {
+		if strings.TrimSpace(v) != "" {
+			meta[k] = v
+		}
 	}
+	return meta
+}
+
+// PublishProfile broadcasts the runner's metadata (name, picture, about, nip05, lud16) to
+// configured relays.
+func (c *Client) PublishProfile(ctx context.Context, p Profile) error {
+	meta := p.metadata()
 	if len(meta) == 0 {
 		return nil
 	}
@@ -217,38 +454,10 @@ func (c *Client) PublishProfile(ctx context.Context, name, picture string) error
 		Kind:      nostr.KindProfileMetadata,
 		Content:   string(content),
 	}
-	if err := ev.Sign(c.privKey); err != nil {
+	if err := c.signer.Sign(ctx, &ev); err != nil {
 		return fmt.Errorf("sign profile: %w", err)
 	}
-
-	results := c.pool.PublishMany(ctx, c.relays, ev)
-	var firstErr error
-	for res := range results {
-		if res.Error != nil && firstErr == nil {
-			firstErr = res.Error
-		}
-	}
-	return firstErr
-}
-
-func (c *Client) sharedSecret(peerPub string) ([]byte, error) {
-	peerPub = strings.ToLower(peerPub)
-	c.secretMu.Lock()
-	if key, ok := c.secrets[peerPub]; ok {
-		c.secretMu.Unlock()
-		return key, nil
-	}
-	c.secretMu.Unlock()
-
-	key, err := nip04.ComputeSharedSecret(peerPub, c.privKey)
-	if err != nil {
-		return nil, fmt.Errorf("compute shared secret: %w", err)
-	}
-
-	c.secretMu.Lock()
-	c.secrets[peerPub] = key
-	c.secretMu.Unlock()
-	return key, nil
+	return c.publish(ctx, ev)
 }
 
 func (c *Client) allowedList() []string {
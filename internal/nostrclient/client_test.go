@@ -3,6 +3,8 @@ package nostrclient
 import (
 	"context"
 	"errors"
+	"fmt"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -11,6 +13,8 @@ import (
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip59"
 )
 
 func newStore(t *testing.T) *store.Store {
@@ -76,12 +80,15 @@ func TestLastCursorMax(t *testing.T) {
 func TestSharedSecretCaches(t *testing.T) {
 	priv := nostr.GeneratePrivateKey()
 	pub, _ := nostr.GetPublicKey(priv)
-	c := New(priv, pub, nil, nil, newStore(t))
-	sec1, err := c.sharedSecret(pub)
+	s, err := NewKeySigner(priv)
+	if err != nil || s.PublicKey() != pub {
+		t.Fatalf("key signer: %v", err)
+	}
+	sec1, err := s.sharedSecret(pub)
 	if err != nil {
 		t.Fatalf("shared secret: %v", err)
 	}
-	sec2, _ := c.sharedSecret(pub)
+	sec2, _ := s.sharedSecret(pub)
 	if string(sec1) != string(sec2) {
 		t.Fatalf("expected cached secret")
 	}
@@ -106,6 +113,8 @@ func (s *stubStore) Active(pubkey string) (store.SessionState, bool, error) {
 }
 func (s *stubStore) LastCursor(pubkey string) (time.Time, error)  { return time.Time{}, nil }
 func (s *stubStore) SaveCursor(pubkey string, ts time.Time) error { return nil }
+func (s *stubStore) SyncToken(key string) (string, error)         { return "", nil }
+func (s *stubStore) SaveSyncToken(key, token string) error        { return nil }
 func (s *stubStore) AlreadyProcessed(eventID string) (bool, error) {
 	if s.processed == nil {
 		s.processed = map[string]bool{}
@@ -210,7 +219,7 @@ func TestPublishProfileSkipsEmpty(t *testing.T) {
 	priv := nostr.GeneratePrivateKey()
 	pub, _ := nostr.GetPublicKey(priv)
 	c := NewWithPool(priv, pub, nil, nil, newStore(t), errPool{})
-	if err := c.PublishProfile(context.Background(), "", ""); err != nil {
+	if err := c.PublishProfile(context.Background(), Profile{}); err != nil {
 		t.Fatalf("expected nil with empty meta, got %v", err)
 	}
 }
@@ -219,7 +228,7 @@ func TestPublishProfilePropagatesError(t *testing.T) {
 	priv := nostr.GeneratePrivateKey()
 	pub, _ := nostr.GetPublicKey(priv)
 	c := NewWithPool(priv, pub, []string{"wss://relay"}, []string{pub}, newStore(t), errPool{})
-	if err := c.PublishProfile(context.Background(), "runner", "pic"); err == nil {
+	if err := c.PublishProfile(context.Background(), Profile{Name: "runner", Picture: "pic"}); err == nil {
 		t.Fatalf("expected publish error")
 	}
 }
@@ -254,7 +263,263 @@ func TestPublishProfileSuccess(t *testing.T) {
 	priv := nostr.GeneratePrivateKey()
 	pub, _ := nostr.GetPublicKey(priv)
 	c := NewWithPool(priv, pub, []string{"wss://relay"}, []string{pub}, newStore(t), okPool{})
-	if err := c.PublishProfile(context.Background(), "runner", "pic"); err != nil {
+	if err := c.PublishProfile(context.Background(), Profile{Name: "runner", Picture: "pic"}); err != nil {
 		t.Fatalf("publish profile: %v", err)
 	}
 }
+
+// capturePool records published events and serves subscriptions from ch.
+type capturePool struct {
+	ch     chan nostr.RelayEvent
+	mu     sync.Mutex
+	events []nostr.Event
+}
+
+func (p *capturePool) SubscribeMany(ctx context.Context, relays []string, filter nostr.Filter, _ ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	if p.ch != nil {
+		return p.ch
+	}
+	ch := make(chan nostr.RelayEvent)
+	close(ch)
+	return ch
+}
+
+func (p *capturePool) PublishMany(ctx context.Context, relays []string, ev nostr.Event) chan nostr.PublishResult {
+	p.mu.Lock()
+	p.events = append(p.events, ev)
+	p.mu.Unlock()
+	ch := make(chan nostr.PublishResult)
+	close(ch)
+	return ch
+}
+
+func (p *capturePool) last(t *testing.T) nostr.Event {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.events) == 0 {
+		t.Fatalf("nothing published")
+	}
+	return p.events[len(p.events)-1]
+}
+
+func TestListenUnwrapsGiftWrapAndRepliesInKind(t *testing.T) {
+	runnerPriv := nostr.GeneratePrivateKey()
+	runnerPub, _ := nostr.GetPublicKey(runnerPriv)
+	userPriv := nostr.GeneratePrivateKey()
+	userPub, _ := nostr.GetPublicKey(userPriv)
+
+	userPool := &capturePool{}
+	user := NewWithPool(userPriv, userPub, []string{"wss://relay"}, []string{runnerPub}, &stubStore{}, userPool)
+	if err := user.sendGiftWrap(context.Background(), runnerPub, "hello over nip17"); err != nil {
+		t.Fatalf("wrap: %v", err)
+	}
+	wrap := userPool.last(t)
+	if wrap.Kind != nostr.KindGiftWrap || wrap.PubKey == userPub {
+		t.Fatalf("expected gift wrap from a throwaway key, got kind %d", wrap.Kind)
+	}
+
+	// A rumor older than the cursor (e.g. before the first start) is not answered.
+	old := nostr.Event{PubKey: userPub, CreatedAt: nostr.Now() - 3600, Kind: nostr.KindDirectMessage, Tags: nostr.Tags{{"p", runnerPub}}, Content: "stale"}
+	old.ID = old.GetID()
+	key, _ := nip44.GenerateConversationKey(runnerPub, userPriv)
+	staleWrap, err := nip59.GiftWrap(old, runnerPub,
+		func(s string) (string, error) { return nip44.Encrypt(s, key) },
+		func(e *nostr.Event) error { return e.Sign(userPriv) }, nil)
+	if err != nil {
+		t.Fatalf("wrap stale: %v", err)
+	}
+
+	pool := &capturePool{ch: make(chan nostr.RelayEvent, 1)}
+	runner := NewWithPool(runnerPriv, runnerPub, []string{"wss://relay"}, []string{userPub}, &stubStore{}, pool)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan IncomingMessage, 2)
+	go func() { _ = runner.Listen(ctx, func(_ context.Context, m IncomingMessage) { got <- m }) }()
+
+	pool.ch <- nostr.RelayEvent{Event: &staleWrap}
+	pool.ch <- nostr.RelayEvent{Event: &wrap}
+	select {
+	case msg := <-got:
+		if msg.Plaintext != "hello over nip17" || msg.SenderPubKey != userPub || msg.Scheme != SchemeNIP17 {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err := runner.SendReply(context.Background(), userPub, "hi back"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	reply := pool.last(t)
+	if reply.Kind != nostr.KindGiftWrap {
+		t.Fatalf("expected reply in NIP-17, got kind %d", reply.Kind)
+	}
+	rumor, err := user.unwrap(context.Background(), &reply)
+	if err != nil || rumor.Content != "hi back" || rumor.PubKey != runnerPub {
+		t.Fatalf("unexpected reply rumor %+v err=%v", rumor, err)
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("stale rumor delivered: %+v", msg)
+	default:
+	}
+}
+
+func TestRequireNIP17IgnoresNIP04(t *testing.T) {
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	pool := &capturePool{ch: make(chan nostr.RelayEvent, 1)}
+	c := NewWithPool(priv, pub, []string{"wss://relay"}, []string{pub}, &stubStore{}, pool, RequireNIP17())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan IncomingMessage, 1)
+	go func() { _ = c.Listen(ctx, func(_ context.Context, m IncomingMessage) { got <- m }) }()
+
+	ev := &nostr.Event{PubKey: pub, CreatedAt: nostr.Now(), Kind: nostr.KindEncryptedDirectMessage, Tags: nostr.Tags{nostr.Tag{"p", pub}}}
+	secret, _ := nip04.ComputeSharedSecret(pub, priv)
+	ev.Content, _ = nip04.Encrypt("legacy", secret)
+	_ = ev.Sign(priv)
+	pool.ch <- nostr.RelayEvent{Event: ev}
+	select {
+	case msg := <-got:
+		t.Fatalf("NIP-04 DM delivered despite require_nip17: %+v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := c.SendReply(context.Background(), pub, "hi"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if k := pool.last(t).Kind; k != nostr.KindGiftWrap {
+		t.Fatalf("expected gift wrap reply, got kind %d", k)
+	}
+}
+
+// blockingSigner holds every NIP-44 decryption until release is closed, recording how
+// many run at once.
+type blockingSigner struct {
+	*KeySigner
+	release chan struct{}
+	mu      sync.Mutex
+	active  int
+	peak    int
+}
+
+func (s *blockingSigner) DecryptNIP44(ctx context.Context, ciphertext, peerPub string) (string, error) {
+	s.mu.Lock()
+	s.active++
+	s.peak = max(s.peak, s.active)
+	s.mu.Unlock()
+	<-s.release
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	return "", errors.New("not for us")
+}
+
+func TestListenUnwrapsOffTheReadLoop(t *testing.T) {
+	ks, err := NewKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("key signer: %v", err)
+	}
+	userPriv := nostr.GeneratePrivateKey()
+	userPub, _ := nostr.GetPublicKey(userPriv)
+	signer := &blockingSigner{KeySigner: ks, release: make(chan struct{})}
+	pool := &capturePool{ch: make(chan nostr.RelayEvent, 16)}
+	c := NewWithSigner(signer, []string{"wss://relay"}, []string{userPub}, &stubStore{}, pool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan IncomingMessage, 1)
+	go func() { _ = c.Listen(ctx, func(_ context.Context, m IncomingMessage) { got <- m }) }()
+
+	// Gift wraps from anyone are decrypted before the sender is known; slow ones must not
+	// hold up other messages, and only maxUnwraps run at once.
+	for i := 0; i < maxUnwraps+3; i++ {
+		pool.ch <- nostr.RelayEvent{Event: &nostr.Event{ID: fmt.Sprintf("wrap%d", i), PubKey: userPub, Kind: nostr.KindGiftWrap, CreatedAt: nostr.Now(), Content: "junk"}}
+	}
+	secret, _ := nip04.ComputeSharedSecret(ks.PublicKey(), userPriv)
+	enc, _ := nip04.Encrypt("still heard", secret)
+	dm := &nostr.Event{PubKey: userPub, CreatedAt: nostr.Now(), Kind: nostr.KindEncryptedDirectMessage, Tags: nostr.Tags{{"p", ks.PublicKey()}}, Content: enc}
+	if err := dm.Sign(userPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	pool.ch <- nostr.RelayEvent{Event: dm}
+
+	select {
+	case msg := <-got:
+		if msg.Plaintext != "still heard" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("NIP-04 DM blocked behind gift wrap decryption")
+	}
+	close(signer.release)
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+	if signer.peak > maxUnwraps {
+		t.Fatalf("%d unwraps ran at once, cap is %d", signer.peak, maxUnwraps)
+	}
+}
+
+func TestListenBoundsQueuedGiftWraps(t *testing.T) {
+	ks, err := NewKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("key signer: %v", err)
+	}
+	signer := &blockingSigner{KeySigner: ks, release: make(chan struct{})}
+	defer close(signer.release)
+	pool := &capturePool{ch: make(chan nostr.RelayEvent, 16)}
+	c := NewWithSigner(signer, []string{"wss://relay"}, nil, &stubStore{}, pool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Listen(ctx, func(context.Context, IncomingMessage) {}) }()
+
+	wrap := func(i int) {
+		pool.ch <- nostr.RelayEvent{Event: &nostr.Event{ID: fmt.Sprintf("wrap%d", i), Kind: nostr.KindGiftWrap, CreatedAt: nostr.Now(), Content: "junk"}}
+	}
+	for i := 0; i < maxUnwraps; i++ {
+		wrap(i)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		signer.mu.Lock()
+		active := signer.active
+		signer.mu.Unlock()
+		if active == maxUnwraps {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d workers busy", active, maxUnwraps)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// With every worker stuck, a flood fills the queue and the rest is dropped rather
+	// than piling up goroutines.
+	before := runtime.NumGoroutine()
+	const extra = 20
+	for i := maxUnwraps; i < maxUnwraps+maxQueuedWraps+extra; i++ {
+		wrap(i)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for c.droppedWraps.Load() < extra {
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped %d wraps, want %d", c.droppedWraps.Load(), extra)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if grown := runtime.NumGoroutine() - before; grown > 2 {
+		t.Fatalf("goroutines grew by %d while flooded", grown)
+	}
+	if n := c.droppedWraps.Load(); n != extra {
+		t.Fatalf("dropped %d wraps, want %d", n, extra)
+	}
+	signer.mu.Lock()
+	defer signer.mu.Unlock()
+	if signer.peak > maxUnwraps {
+		t.Fatalf("%d unwraps ran at once, cap is %d", signer.peak, maxUnwraps)
+	}
+}
@@ -0,0 +1,179 @@
+package nostrclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-90 job requests are kinds 5000-5999, each answered by a result of the request kind
+// plus 1000 and by kind 7000 feedback (status updates). NIP-89 announces a handler with 31990.
+const (
+	kindJobFeedback = 7000
+	kindHandlerInfo = 31990
+)
+
+// handlerID is the d tag of the runner's NIP-89 announcement, so re-announcing replaces it.
+const handlerID = "buddy"
+
+// Job feedback statuses.
+const (
+	JobProcessing = "processing"
+	JobError      = "error"
+)
+
+// WithDVM accepts NIP-90 job requests of kinds (5000-5999) from allowed pubkeys next to DMs.
+func WithDVM(kinds ...int) Option {
+	return func(c *Client) { c.dvmKinds = append(c.dvmKinds, kinds...) }
+}
+
+// jobFilter matches job requests of the served kinds from allowed senders.
+func (c *Client) jobFilter(since nostr.Timestamp) nostr.Filter {
+	return nostr.Filter{
+		Kinds:   c.dvmKinds,
+		Authors: c.allowedList(),
+		Since:   &since,
+	}
+}
+
+func (c *Client) isJobKind(kind int) bool {
+	for _, k := range c.dvmKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptJob checks a job request from an allowed sender and extracts its prompt. Requests
+// addressed to another service provider are skipped silently; unusable ones get error feedback.
+func (c *Client) acceptJob(ctx context.Context, job *nostr.Event) (string, bool) {
+	targeted, mine := false, false
+	for t := range job.Tags.FindAll("p") {
+		targeted = true
+		mine = mine || strings.EqualFold(t[1], c.pubKey)
+	}
+	if targeted && !mine {
+		return "", false
+	}
+	if job.Tags.GetFirst([]string{"encrypted"}) != nil {
+		_ = c.SendJobFeedback(ctx, job, JobError, "encrypted job requests are not supported")
+		return "", false
+	}
+	input := jobInput(job)
+	if input == "" {
+		_ = c.SendJobFeedback(ctx, job, JobError, "no input")
+		return "", false
+	}
+	return input, true
+}
+
+// jobInput turns a request's i and param tags into a prompt: text inputs verbatim, other
+// inputs as "<type>: <data>", and params as a trailing line.
+func jobInput(job *nostr.Event) string {
+	var parts, params []string
+	for _, tag := range job.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "i":
+			typ := "text"
+			if len(tag) >= 3 && tag[2] != "" {
+				typ = tag[2]
+			}
+			if strings.TrimSpace(tag[1]) == "" {
+				continue
+			}
+			if typ == "text" {
+				parts = append(parts, tag[1])
+			} else {
+				parts = append(parts, typ+": "+tag[1])
+			}
+		case "param":
+			if len(tag) >= 3 {
+				params = append(params, tag[1]+"="+strings.Join(tag[2:], ","))
+			}
+		}
+	}
+	if len(params) > 0 && len(parts) > 0 {
+		parts = append(parts, "params: "+strings.Join(params, " "))
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// SendJobResult publishes content as the NIP-90 result of job.
+func (c *Client) SendJobResult(ctx context.Context, job *nostr.Event, content string) error {
+	request, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job request: %w", err)
+	}
+	tags := nostr.Tags{
+		{"request", string(request)},
+		{"e", job.ID},
+		{"p", job.PubKey},
+	}
+	for in := range job.Tags.FindAll("i") {
+		tags = append(tags, in)
+	}
+	ev := nostr.Event{
+		PubKey:    c.pubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      job.Kind + 1000,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := c.signer.Sign(ctx, &ev); err != nil {
+		return fmt.Errorf("sign job result: %w", err)
+	}
+	return c.publish(ctx, ev, c.outboxRelays(job.PubKey)...)
+}
+
+// SendJobFeedback publishes a kind 7000 status update for job; info is shown to the customer.
+func (c *Client) SendJobFeedback(ctx context.Context, job *nostr.Event, status, info string) error {
+	statusTag := nostr.Tag{"status", status}
+	if info != "" {
+		statusTag = append(statusTag, info)
+	}
+	ev := nostr.Event{
+		PubKey:    c.pubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      kindJobFeedback,
+		Tags:      nostr.Tags{statusTag, {"e", job.ID}, {"p", job.PubKey}},
+	}
+	if err := c.signer.Sign(ctx, &ev); err != nil {
+		return fmt.Errorf("sign job feedback: %w", err)
+	}
+	return c.publish(ctx, ev, c.outboxRelays(job.PubKey)...)
+}
+
+// AnnounceDVM publishes a NIP-89 handler announcement for the served job kinds, described
+// by p. It is a no-op unless WithDVM was given.
+func (c *Client) AnnounceDVM(ctx context.Context, p Profile) error {
+	if len(c.dvmKinds) == 0 {
+		return nil
+	}
+	content, err := json.Marshal(p.metadata())
+	if err != nil {
+		return fmt.Errorf("marshal handler info: %w", err)
+	}
+	tags := nostr.Tags{{"d", handlerID}}
+	for _, k := range c.dvmKinds {
+		tags = append(tags, nostr.Tag{"k", strconv.Itoa(k)})
+	}
+	ev := nostr.Event{
+		PubKey:    c.pubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      kindHandlerInfo,
+		Tags:      tags,
+		Content:   string(content),
+	}
+	if err := c.signer.Sign(ctx, &ev); err != nil {
+		return fmt.Errorf("sign handler info: %w", err)
+	}
+	return c.publish(ctx, ev)
+}
@@ -0,0 +1,113 @@
+package nostrclient
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestListenAcceptsJobRequests(t *testing.T) {
+	runnerPriv := nostr.GeneratePrivateKey()
+	runnerPub, _ := nostr.GetPublicKey(runnerPriv)
+	userPriv := nostr.GeneratePrivateKey()
+	userPub, _ := nostr.GetPublicKey(userPriv)
+	otherPub, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	pool := &capturePool{ch: make(chan nostr.RelayEvent, 3)}
+	c := NewWithPool(runnerPriv, runnerPub, []string{"wss://relay"}, []string{userPub}, &stubStore{}, pool, WithDVM(5050))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan IncomingMessage, 3)
+	go func() { _ = c.Listen(ctx, func(_ context.Context, m IncomingMessage) { got <- m }) }()
+
+	job := func(tags nostr.Tags) *nostr.Event {
+		ev := &nostr.Event{PubKey: userPub, CreatedAt: nostr.Now(), Kind: 5050, Tags: tags}
+		_ = ev.Sign(userPriv)
+		return ev
+	}
+	elsewhere := job(nostr.Tags{{"i", "not for us", "text"}, {"p", otherPub}})
+	encrypted := job(nostr.Tags{{"p", runnerPub}, {"encrypted"}})
+	req := job(nostr.Tags{{"i", "summarize this", "text"}, {"i", "https://example.com", "url"}, {"param", "lang", "en"}, {"p", runnerPub}})
+	for _, ev := range []*nostr.Event{elsewhere, encrypted, req} {
+		pool.ch <- nostr.RelayEvent{Event: ev}
+	}
+
+	select {
+	case msg := <-got:
+		if msg.Job == nil || msg.Job.ID != req.ID || msg.Scheme != "" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		if want := "summarize this\n\nurl: https://example.com\n\nparams: lang=en"; msg.Plaintext != want {
+			t.Fatalf("input %q, want %q", msg.Plaintext, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout")
+	}
+	// Requests from one sender are handled in any order, so wait for the feedback.
+	var feedback nostr.Event
+	for deadline := time.Now().Add(2 * time.Second); feedback.ID == "" && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pool.mu.Lock()
+		for _, ev := range pool.events {
+			if ev.Kind == kindJobFeedback {
+				feedback = ev
+			}
+		}
+		pool.mu.Unlock()
+	}
+	if feedback.Kind != kindJobFeedback || feedback.Tags.FindWithValue("e", encrypted.ID) == nil || feedback.Tags.Find("status")[1] != JobError {
+		t.Fatalf("expected error feedback for the encrypted request, got %+v", feedback)
+	}
+
+	if err := c.SendJobResult(context.Background(), req, "done"); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	res := pool.last(t)
+	if res.Kind != 6050 || res.Content != "done" || res.Tags.FindWithValue("e", req.ID) == nil || res.Tags.FindWithValue("p", userPub) == nil {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	var embedded nostr.Event
+	if err := json.Unmarshal([]byte(res.Tags.Find("request")[1]), &embedded); err != nil || embedded.ID != req.ID {
+		t.Fatalf("request tag should embed the job: %v", err)
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("unexpected extra message %+v", msg)
+	default:
+	}
+}
+
+func TestAnnounceDVMAndProfile(t *testing.T) {
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	pool := &capturePool{}
+	p := Profile{Name: "buddy", About: "coding agent", NIP05: "buddy@example.com", LUD16: "buddy@getalby.com"}
+
+	if err := NewWithPool(priv, pub, []string{"wss://relay"}, nil, &stubStore{}, pool).AnnounceDVM(context.Background(), p); err != nil || len(pool.events) != 0 {
+		t.Fatalf("expected no announcement without DVM kinds: %v", err)
+	}
+	c := NewWithPool(priv, pub, []string{"wss://relay"}, nil, &stubStore{}, pool, WithDVM(5050, 5100))
+	if err := c.AnnounceDVM(context.Background(), p); err != nil {
+		t.Fatalf("announce: %v", err)
+	}
+	ann := pool.last(t)
+	if ann.Kind != kindHandlerInfo || ann.Tags.GetD() != handlerID || ann.Tags.FindWithValue("k", "5100") == nil {
+		t.Fatalf("unexpected announcement %+v", ann)
+	}
+
+	if err := c.PublishProfile(context.Background(), p); err != nil {
+		t.Fatalf("profile: %v", err)
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(pool.last(t).Content), &meta); err != nil {
+		t.Fatalf("profile content: %v", err)
+	}
+	if meta["about"] != "coding agent" || meta["nip05"] != "buddy@example.com" || meta["lud16"] != "buddy@getalby.com" {
+		t.Fatalf("unexpected profile %v", meta)
+	}
+	if _, ok := meta["picture"]; ok {
+		t.Fatalf("empty fields should be omitted: %v", meta)
+	}
+}
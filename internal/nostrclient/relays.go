@@ -0,0 +1,244 @@
+package nostrclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/metrics"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay resubscription backoff. A subscription that stayed open for relayStableAfter resets it.
+const (
+	minRelayBackoff  = time.Second
+	maxRelayBackoff  = 2 * time.Minute
+	relayStableAfter = time.Minute
+)
+
+// relayProbeInterval is how often connection state is read from pools that expose relays.
+const relayProbeInterval = 30 * time.Second
+
+// Outbox discovery: allowed senders' NIP-65 relay lists are re-read every outboxRefresh, and
+// replies go to at most maxOutboxRelays of each sender's read relays.
+const (
+	outboxRefresh   = time.Hour
+	outboxTimeout   = 10 * time.Second
+	maxOutboxRelays = 4
+)
+
+// eosePool reports when stored events have been sent (SimplePool does), which is the
+// first sign that a relay is up.
+type eosePool interface {
+	SubscribeManyNotifyEOSE(ctx context.Context, urls []string, filter nostr.Filter, eoseChan chan struct{}, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent
+}
+
+// fetchPool runs queries that end at EOSE; outbox discovery needs it.
+type fetchPool interface {
+	FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent
+}
+
+// relayPool exposes the pool's relay connections for probing.
+type relayPool interface {
+	EnsureRelay(url string) (*nostr.Relay, error)
+}
+
+// listenRelay keeps the DM subscriptions open on one relay, forwarding events to out and
+// resubscribing with exponential backoff whenever the relay drops them.
+func (c *Client) listenRelay(ctx context.Context, url string, out chan<- nostr.RelayEvent) {
+	backoff := minRelayBackoff
+	for {
+		subCtx, cancel := context.WithCancel(ctx)
+		started := time.Now()
+		events, eose := c.subscribe(subCtx, url, c.buildFilter())
+		c.forward(subCtx, url, events, eose, out)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		metrics.SetRelayUp(url, false, "subscription closed")
+		if time.Since(started) >= relayStableAfter {
+			backoff = minRelayBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxRelayBackoff)
+	}
+}
+
+// forward passes events on until the subscription closes. The relay counts as up once it
+// answers with EOSE or an event.
+func (c *Client) forward(ctx context.Context, url string, events chan nostr.RelayEvent, eose chan struct{}, out chan<- nostr.RelayEvent) {
+	up := false
+	markUp := func() {
+		if !up {
+			up = true
+			metrics.SetRelayUp(url, true, "")
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-eose:
+			markUp()
+			eose = nil
+		case ie, ok := <-events:
+			if !ok {
+				return
+			}
+			markUp()
+			select {
+			case out <- ie:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// probeRelays refreshes relay state from the pool's connections, which catches drops the
+// pool reconnects on its own without closing the subscription.
+func (c *Client) probeRelays(ctx context.Context) {
+	p, ok := c.pool.(relayPool)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(relayProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, url := range c.relays {
+			relay, err := p.EnsureRelay(url)
+			switch {
+			case err != nil:
+				metrics.SetRelayUp(url, false, err.Error())
+			case relay.IsConnected():
+				metrics.SetRelayUp(url, true, "")
+			}
+		}
+	}
+}
+
+// publish sends ev to the configured relays plus extra. It succeeds if at least one relay
+// accepted the event; otherwise it returns every relay's error.
+func (c *Client) publish(ctx context.Context, ev nostr.Event, extra ...string) error {
+	relays := c.relays
+	if len(extra) > 0 {
+		relays = mergeRelays(c.relays, extra)
+	}
+	results := c.pool.PublishMany(ctx, relays, ev)
+	accepted := false
+	var errs []error
+	for res := range results {
+		if res.Error != nil {
+			metrics.IncRelayPublish(res.RelayURL, false, res.Error.Error())
+			errs = append(errs, res.Error)
+			continue
+		}
+		// A relay that accepted an event is reachable, including outbox relays not subscribed to.
+		metrics.IncRelayPublish(res.RelayURL, true, "")
+		metrics.SetRelayUp(res.RelayURL, true, "")
+		accepted = true
+	}
+	if accepted {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// discoverOutbox keeps each allowed sender's read relays up to date.
+func (c *Client) discoverOutbox(ctx context.Context) {
+	p, ok := c.pool.(fetchPool)
+	if !ok || len(c.allowed) == 0 || len(c.relays) == 0 {
+		return
+	}
+	for {
+		c.refreshOutbox(ctx, p)
+		select {
+		case <-time.After(outboxRefresh):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshOutbox reads the latest kind 10002 relay list of each allowed sender.
+func (c *Client) refreshOutbox(ctx context.Context, p fetchPool) {
+	ctx, cancel := context.WithTimeout(ctx, outboxTimeout)
+	defer cancel()
+	latest := make(map[string]*nostr.Event)
+	filter := nostr.Filter{Kinds: []int{nostr.KindRelayListMetadata}, Authors: c.allowedList()}
+	for ie := range p.FetchMany(ctx, c.relays, filter) {
+		ev := ie.Event
+		if ev == nil || ev.Kind != nostr.KindRelayListMetadata {
+			continue
+		}
+		author := strings.ToLower(ev.PubKey)
+		if _, ok := c.allowed[author]; !ok {
+			continue
+		}
+		if cur, ok := latest[author]; !ok || ev.CreatedAt > cur.CreatedAt {
+			latest[author] = ev
+		}
+	}
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	for author, ev := range latest {
+		c.outbox[author] = readRelays(ev)
+	}
+}
+
+// outboxRelays returns peerPub's read relays, if known.
+func (c *Client) outboxRelays(peerPub string) []string {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	return c.outbox[strings.ToLower(peerPub)]
+}
+
+// readRelays extracts the relays a NIP-65 list marks for reading (unmarked ones are both).
+func readRelays(ev *nostr.Event) []string {
+	var out []string
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		if len(tag) >= 3 && tag[2] == "write" {
+			continue
+		}
+		if !strings.HasPrefix(tag[1], "wss://") && !strings.HasPrefix(tag[1], "ws://") {
+			continue
+		}
+		out = mergeRelays(out, []string{nostr.NormalizeURL(tag[1])})
+		if len(out) == maxOutboxRelays {
+			break
+		}
+	}
+	return out
+}
+
+// mergeRelays appends the relays of extra not already in base.
+func mergeRelays(base, extra []string) []string {
+	out := append([]string(nil), base...)
+	seen := make(map[string]struct{}, len(base)+len(extra))
+	for _, r := range base {
+		seen[nostr.NormalizeURL(r)] = struct{}{}
+	}
+	for _, r := range extra {
+		if _, ok := seen[nostr.NormalizeURL(r)]; ok {
+			continue
+		}
+		seen[nostr.NormalizeURL(r)] = struct{}{}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+package nostrclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/metrics"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayPoolStub fails every relay in bad and records per-relay subscriptions and publishes.
+type relayPoolStub struct {
+	bad map[string]bool
+
+	mu        sync.Mutex
+	subs      map[string]int
+	published []string
+	lists     []nostr.RelayEvent
+}
+
+func (p *relayPoolStub) SubscribeMany(ctx context.Context, relays []string, filter nostr.Filter, _ ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.SubscribeManyNotifyEOSE(ctx, relays, filter, make(chan struct{}))
+}
+
+func (p *relayPoolStub) SubscribeManyNotifyEOSE(ctx context.Context, relays []string, filter nostr.Filter, eose chan struct{}, _ ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subs == nil {
+		p.subs = map[string]int{}
+	}
+	ch := make(chan nostr.RelayEvent)
+	for _, r := range relays {
+		p.subs[r]++
+		if p.bad[r] {
+			close(ch)
+			return ch
+		}
+	}
+	close(eose)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func (p *relayPoolStub) PublishMany(ctx context.Context, relays []string, ev nostr.Event) chan nostr.PublishResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan nostr.PublishResult, len(relays))
+	for _, r := range relays {
+		p.published = append(p.published, r)
+		if p.bad[r] {
+			ch <- nostr.PublishResult{RelayURL: r, Error: errors.New("connection refused")}
+		} else {
+			ch <- nostr.PublishResult{RelayURL: r}
+		}
+	}
+	close(ch)
+	return ch
+}
+
+func (p *relayPoolStub) FetchMany(ctx context.Context, relays []string, filter nostr.Filter, _ ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent, len(p.lists))
+	for _, ie := range p.lists {
+		ch <- ie
+	}
+	close(ch)
+	return ch
+}
+
+func (p *relayPoolStub) subscriptions(relay string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.subs[relay]
+}
+
+func relayStatus(t *testing.T, url string) metrics.RelayStatus {
+	t.Helper()
+	for _, r := range metrics.Relays() {
+		if r.URL == url {
+			return r
+		}
+	}
+	t.Fatalf("relay %s not reported", url)
+	return metrics.RelayStatus{}
+}
+
+func TestListenResubscribesOnlyFailedRelay(t *testing.T) {
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	good, bad := "wss://listen-good.example", "wss://listen-bad.example"
+	pool := &relayPoolStub{bad: map[string]bool{bad: true}}
+	c := NewWithPool(priv, pub, []string{good, bad}, []string{pub}, &stubStore{}, pool, RequireNIP17())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Listen(ctx, func(context.Context, IncomingMessage) {}) }()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for pool.subscriptions(bad) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := pool.subscriptions(bad); n < 2 {
+		t.Fatalf("expected the failed relay to be retried, got %d subscriptions", n)
+	}
+	if n := pool.subscriptions(good); n != 1 {
+		t.Fatalf("healthy relay resubscribed %d times", n)
+	}
+	if st := relayStatus(t, good); !st.Up {
+		t.Fatalf("expected %s up after EOSE: %+v", good, st)
+	}
+	if st := relayStatus(t, bad); st.Up || st.LastError == "" {
+		t.Fatalf("expected %s down: %+v", bad, st)
+	}
+}
+
+func TestPublishSucceedsIfAnyRelayAccepts(t *testing.T) {
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	good, bad := "wss://publish-good.example", "wss://publish-bad.example"
+	pool := &relayPoolStub{bad: map[string]bool{bad: true}}
+
+	// Relay state is process-wide, so compare counts against their values before publishing.
+	var okBefore, failedBefore int
+	for _, r := range metrics.Relays() {
+		switch r.URL {
+		case good:
+			okBefore = r.Published
+		case bad:
+			failedBefore = r.PublishFailed
+		}
+	}
+	c := NewWithPool(priv, pub, []string{bad, good}, []string{pub}, &stubStore{}, pool)
+	if err := c.SendReply(context.Background(), pub, "hi"); err != nil {
+		t.Fatalf("expected success when one relay accepts: %v", err)
+	}
+	if st := relayStatus(t, good); st.Published != okBefore+1 || !st.Up {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if st := relayStatus(t, bad); st.PublishFailed != failedBefore+1 || st.LastError != "connection refused" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	c = NewWithPool(priv, pub, []string{bad}, []string{pub}, &stubStore{}, pool)
+	if err := c.SendReply(context.Background(), pub, "hi"); err == nil {
+		t.Fatalf("expected error when no relay accepts")
+	}
+}
+
+func TestSendReplyUsesSenderReadRelays(t *testing.T) {
+	priv := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(priv)
+	userPriv := nostr.GeneratePrivateKey()
+	userPub, _ := nostr.GetPublicKey(userPriv)
+
+	older := nostr.Event{PubKey: userPub, CreatedAt: nostr.Now() - 60, Kind: nostr.KindRelayListMetadata,
+		Tags: nostr.Tags{{"r", "wss://old-inbox.example"}}}
+	list := nostr.Event{PubKey: userPub, CreatedAt: nostr.Now(), Kind: nostr.KindRelayListMetadata, Tags: nostr.Tags{
+		{"r", "wss://inbox.example", "read"},
+		{"r", "wss://both.example/"},
+		{"r", "wss://outbox.example", "write"},
+		{"r", "https://not-a-relay.example"},
+	}}
+	pool := &relayPoolStub{lists: []nostr.RelayEvent{{Event: &list}, {Event: &older}}}
+	c := NewWithPool(priv, pub, []string{"wss://home.example"}, []string{userPub}, &stubStore{}, pool)
+	c.refreshOutbox(context.Background(), pool)
+
+	if err := c.SendReply(context.Background(), userPub, "hi"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	want := []string{"wss://home.example", "wss://inbox.example", "wss://both.example"}
+	if len(pool.published) != len(want) {
+		t.Fatalf("published to %v, want %v", pool.published, want)
+	}
+	for i := range want {
+		if pool.published[i] != want[i] {
+			t.Fatalf("published to %v, want %v", pool.published, want)
+		}
+	}
+}
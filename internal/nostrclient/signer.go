@@ -0,0 +1,227 @@
+package nostrclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// Signer holds the runner's identity. The client never sees a private key; it signs events
+// and encrypts DMs through this interface.
+type Signer interface {
+	PublicKey() string
+	Sign(ctx context.Context, ev *nostr.Event) error
+	EncryptNIP04(ctx context.Context, plaintext, peerPub string) (string, error)
+	DecryptNIP04(ctx context.Context, ciphertext, peerPub string) (string, error)
+	EncryptNIP44(ctx context.Context, plaintext, peerPub string) (string, error)
+	DecryptNIP44(ctx context.Context, ciphertext, peerPub string) (string, error)
+}
+
+// KeySigner signs with a private key held in memory.
+type KeySigner struct {
+	privKey string
+	pubKey  string
+
+	mu       sync.Mutex
+	secrets  map[string][]byte   // NIP-04 shared secrets by peer
+	convKeys map[string][32]byte // NIP-44 conversation keys by peer
+}
+
+// NewKeySigner derives the public key from a hex private key.
+func NewKeySigner(privKey string) (*KeySigner, error) {
+	pub, err := nostr.GetPublicKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("derive pubkey: %w", err)
+	}
+	return newKeySigner(privKey, pub), nil
+}
+
+func newKeySigner(privKey, pubKey string) *KeySigner {
+	return &KeySigner{
+		privKey:  privKey,
+		pubKey:   strings.ToLower(pubKey),
+		secrets:  make(map[string][]byte),
+		convKeys: make(map[string][32]byte),
+	}
+}
+
+func (s *KeySigner) PublicKey() string { return s.pubKey }
+
+func (s *KeySigner) Sign(_ context.Context, ev *nostr.Event) error { return ev.Sign(s.privKey) }
+
+func (s *KeySigner) EncryptNIP04(_ context.Context, plaintext, peerPub string) (string, error) {
+	secret, err := s.sharedSecret(peerPub)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plaintext, secret)
+}
+
+func (s *KeySigner) DecryptNIP04(_ context.Context, ciphertext, peerPub string) (string, error) {
+	secret, err := s.sharedSecret(peerPub)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, secret)
+}
+
+func (s *KeySigner) EncryptNIP44(_ context.Context, plaintext, peerPub string) (string, error) {
+	key, err := s.conversationKey(peerPub, true)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(plaintext, key)
+}
+
+// DecryptNIP44 reads the conversation key cache but does not fill it: gift wraps come from
+// single-use keys.
+func (s *KeySigner) DecryptNIP44(_ context.Context, ciphertext, peerPub string) (string, error) {
+	key, err := s.conversationKey(peerPub, false)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(ciphertext, key)
+}
+
+func (s *KeySigner) sharedSecret(peerPub string) ([]byte, error) {
+	peerPub = strings.ToLower(peerPub)
+	s.mu.Lock()
+	if key, ok := s.secrets[peerPub]; ok {
+		s.mu.Unlock()
+		return key, nil
+	}
+	s.mu.Unlock()
+
+	key, err := nip04.ComputeSharedSecret(peerPub, s.privKey)
+	if err != nil {
+		return nil, fmt.Errorf("compute shared secret: %w", err)
+	}
+
+	s.mu.Lock()
+	s.secrets[peerPub] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+func (s *KeySigner) conversationKey(peerPub string, cache bool) ([32]byte, error) {
+	peerPub = strings.ToLower(peerPub)
+	s.mu.Lock()
+	key, ok := s.convKeys[peerPub]
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := nip44.GenerateConversationKey(peerPub, s.privKey)
+	if err != nil {
+		return key, fmt.Errorf("compute conversation key: %w", err)
+	}
+	if cache {
+		s.mu.Lock()
+		s.convKeys[peerPub] = key
+		s.mu.Unlock()
+	}
+	return key, nil
+}
+
+// bunkerTimeout bounds each round trip to the remote signer.
+const bunkerTimeout = 30 * time.Second
+
+// BunkerSigner delegates signing and encryption to a NIP-46 remote signer, so the runner's
+// private key lives in a separate process.
+type BunkerSigner struct {
+	client *nip46.BunkerClient
+	pubKey string
+}
+
+// ConnectBunker connects to the remote signer in a bunker:// URL and fetches the runner's
+// public key. clientKey identifies this runner to the bunker; keep it stable so the bunker's
+// approval survives restarts (an empty key uses a throwaway one). ctx bounds the handshake
+// only. onAuth, if set, receives any URL the bunker asks the operator to open.
+func ConnectBunker(ctx context.Context, bunkerURL, clientKey string, onAuth func(url string)) (*BunkerSigner, error) {
+	target, relays, secret, err := ParseBunkerURL(bunkerURL)
+	if err != nil {
+		return nil, err
+	}
+	if clientKey == "" {
+		clientKey = nostr.GeneratePrivateKey()
+	}
+	if onAuth == nil {
+		onAuth = func(string) {}
+	}
+	// The client's relay subscription lives as long as the process, not the handshake.
+	bc := nip46.NewBunker(context.Background(), clientKey, target, relays, nil, onAuth)
+
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	if _, err := bc.RPC(ctx, "connect", []string{target, secret}); err != nil {
+		return nil, fmt.Errorf("connect to bunker: %w", err)
+	}
+	pub, err := bc.GetPublicKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bunker get_public_key: %w", err)
+	}
+	if !nostr.IsValidPublicKey(pub) {
+		return nil, fmt.Errorf("bunker returned invalid pubkey %q", pub)
+	}
+	return &BunkerSigner{client: bc, pubKey: strings.ToLower(pub)}, nil
+}
+
+// ParseBunkerURL splits bunker://<signer-pubkey>?relay=wss://...&secret=... into its parts.
+func ParseBunkerURL(raw string) (target string, relays []string, secret string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("invalid bunker url: %w", err)
+	}
+	if u.Scheme != "bunker" {
+		return "", nil, "", errors.New("bunker url must start with bunker://")
+	}
+	if !nostr.IsValidPublicKey(u.Host) {
+		return "", nil, "", fmt.Errorf("bunker url: %q is not a hex pubkey", u.Host)
+	}
+	q := u.Query()
+	if len(q["relay"]) == 0 {
+		return "", nil, "", errors.New("bunker url: at least one relay= parameter required")
+	}
+	return u.Host, q["relay"], q.Get("secret"), nil
+}
+
+func (s *BunkerSigner) PublicKey() string { return s.pubKey }
+
+func (s *BunkerSigner) Sign(ctx context.Context, ev *nostr.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.client.SignEvent(ctx, ev)
+}
+
+func (s *BunkerSigner) EncryptNIP04(ctx context.Context, plaintext, peerPub string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.client.NIP04Encrypt(ctx, peerPub, plaintext)
+}
+
+func (s *BunkerSigner) DecryptNIP04(ctx context.Context, ciphertext, peerPub string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.client.NIP04Decrypt(ctx, peerPub, ciphertext)
+}
+
+func (s *BunkerSigner) EncryptNIP44(ctx context.Context, plaintext, peerPub string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.client.NIP44Encrypt(ctx, peerPub, plaintext)
+}
+
+func (s *BunkerSigner) DecryptNIP44(ctx context.Context, ciphertext, peerPub string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.client.NIP44Decrypt(ctx, peerPub, ciphertext)
+}
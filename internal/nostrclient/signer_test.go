@@ -0,0 +1,81 @@
+package nostrclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// countingSigner stands in for a remote signer: the client only reaches the key through it.
+type countingSigner struct {
+	*KeySigner
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *countingSigner) count(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[op]++
+}
+
+func (s *countingSigner) Sign(ctx context.Context, ev *nostr.Event) error {
+	s.count("sign")
+	return s.KeySigner.Sign(ctx, ev)
+}
+
+func (s *countingSigner) EncryptNIP04(ctx context.Context, plaintext, peerPub string) (string, error) {
+	s.count("nip04_encrypt")
+	return s.KeySigner.EncryptNIP04(ctx, plaintext, peerPub)
+}
+
+func (s *countingSigner) EncryptNIP44(ctx context.Context, plaintext, peerPub string) (string, error) {
+	s.count("nip44_encrypt")
+	return s.KeySigner.EncryptNIP44(ctx, plaintext, peerPub)
+}
+
+func TestClientSignsThroughSigner(t *testing.T) {
+	ks, err := NewKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("key signer: %v", err)
+	}
+	peer, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	signer := &countingSigner{KeySigner: ks, calls: map[string]int{}}
+	pool := &capturePool{}
+	c := NewWithSigner(signer, []string{"wss://relay"}, []string{peer}, &stubStore{}, pool)
+
+	if err := c.SendReply(context.Background(), peer, "nip04"); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if ev := pool.last(t); ev.PubKey != ks.PublicKey() {
+		t.Fatalf("reply not from signer's key: %s", ev.PubKey)
+	}
+	if err := c.sendGiftWrap(context.Background(), peer, "nip17"); err != nil {
+		t.Fatalf("gift wrap: %v", err)
+	}
+	want := map[string]int{"sign": 2, "nip04_encrypt": 1, "nip44_encrypt": 1}
+	for op, n := range want {
+		if signer.calls[op] != n {
+			t.Fatalf("expected %d %s calls, got %v", n, op, signer.calls)
+		}
+	}
+}
+
+func TestParseBunkerURL(t *testing.T) {
+	pub, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	target, relays, secret, err := ParseBunkerURL("bunker://" + pub + "?relay=wss://relay.one&relay=wss://relay.two&secret=s3")
+	if err != nil || target != pub || len(relays) != 2 || secret != "s3" {
+		t.Fatalf("unexpected parse %s %v %s err=%v", target, relays, secret, err)
+	}
+	for _, bad := range []string{
+		"nostrconnect://" + pub + "?relay=wss://relay.one",
+		"bunker://npub1xyz?relay=wss://relay.one",
+		"bunker://" + pub,
+	} {
+		if _, _, _, err := ParseBunkerURL(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
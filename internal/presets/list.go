@@ -9,10 +9,11 @@ import (
 // List returns preset names and descriptions.
 func List() map[string]string {
 	return map[string]string{
-		"claude-dm":     "DMs to Claude/OpenAI HTTP agent (no shell by default)",
-		"copilot-shell": "DMs to Copilot CLI with shell action (trusted)",
-		"local-llm":     "DMs to local HTTP LLM endpoint",
-		"mock-echo":     "Offline mock transport + echo agent",
+		"claude-dm":      "DMs to Claude/OpenAI HTTP agent (no shell by default)",
+		"copilot-shell":  "DMs to Copilot CLI with shell action (trusted)",
+		"local-llm":      "DMs to local HTTP LLM endpoint",
+		"mock-echo":      "Offline mock transport + echo agent",
+		"whatsapp-codex": "WhatsApp (Twilio) messages to Codex CLI",
 	}
 }
 
@@ -30,6 +31,8 @@ func Get(name string) ([]byte, error) {
 		return LocalLLM, nil
 	case "mock-echo":
 		return MockEcho, nil
+	case "whatsapp-codex":
+		return WhatsAppCodex, nil
 	default:
 		return nil, fmt.Errorf("unknown preset %s", name)
 	}
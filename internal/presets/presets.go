@@ -18,6 +18,9 @@ var LocalLLM []byte
 //go:embed data/mock-echo.yaml
 var MockEcho []byte
 
+//go:embed data/whatsapp-codex.yaml
+var WhatsAppCodex []byte
+
 // PresetDeps returns declared prerequisites for built-in presets.
 func PresetDeps() map[string][]config.Dep {
 	return map[string][]config.Dep{
@@ -31,6 +34,10 @@ func PresetDeps() map[string][]config.Dep {
 			{Name: "https://api.anthropic.com", Type: "url", Optional: true, Hint: "Claude endpoint reachability"},
 			{Name: ".", Type: "dirwrite", Optional: true, Hint: "Workspace must be writable"},
 		},
+		"whatsapp-codex": {
+			{Name: "codex", Type: "binary", Hint: "Install Codex CLI: https://github.com/openai/codex"},
+			{Name: "https://api.twilio.com", Type: "url", Optional: true, Hint: "Twilio API reachability"},
+		},
 		"local-llm": {
 			{Name: "curl", Type: "binary", Optional: true, Hint: "Useful for hitting local endpoints"},
 			{Name: "127.0.0.1:11434", Type: "port", Optional: true, Hint: "Example local LLM port (ollama)"},
@@ -11,6 +11,9 @@ type StoreAPI interface {
 	LastCursor(pubkey string) (time.Time, error)
 	SaveCursor(pubkey string, ts time.Time) error
 
+	SyncToken(key string) (string, error)
+	SaveSyncToken(key, token string) error
+
 	AlreadyProcessed(eventID string) (bool, error)
 	MarkProcessed(eventID string) error
 
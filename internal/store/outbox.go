@@ -0,0 +1,179 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketOutbox      = []byte("outbox")
+	bucketDeadLetters = []byte("dead_letters")
+)
+
+// ErrOutboxNotFound is returned when an outbox or dead-letter entry does not exist.
+var ErrOutboxNotFound = errors.New("outbox entry not found")
+
+// OutboxEntry is an outbound message waiting for delivery, or given up on.
+type OutboxEntry struct {
+	ID          string          `json:"id"`
+	Transport   string          `json:"transport"`
+	Recipient   string          `json:"recipient"`
+	Payload     json.RawMessage `json:"payload"` // the encoded outbound message
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"`
+}
+
+// EnqueueOutbox stores e in the outbox and returns its ID. IDs increase, so entries list
+// in the order they were queued.
+func (s *Store) EnqueueOutbox(e OutboxEntry) (string, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketOutbox)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		e.ID = fmt.Sprintf("%020d", seq)
+		e.Created, e.Updated = now, now
+		return putEntry(b, e)
+	})
+	if err != nil {
+		return "", err
+	}
+	return e.ID, nil
+}
+
+// Outbox returns the queued entries, oldest first.
+func (s *Store) Outbox() ([]OutboxEntry, error) {
+	return s.entries(bucketOutbox)
+}
+
+// DeadLetters returns the entries that ran out of attempts, oldest first.
+func (s *Store) DeadLetters() ([]OutboxEntry, error) {
+	return s.entries(bucketDeadLetters)
+}
+
+// CompleteOutbox removes a delivered entry.
+func (s *Store) CompleteOutbox(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketOutbox).Delete([]byte(id))
+	})
+}
+
+// FailOutbox records a failed attempt and schedules the next one. Entries purged in the
+// meantime stay gone.
+func (s *Store) FailOutbox(id, lastErr string, next time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketOutbox)
+		e, ok, err := getEntry(b, id)
+		if err != nil || !ok {
+			return err
+		}
+		e.Attempts++
+		e.LastError = lastErr
+		e.NextAttempt = next.UTC()
+		e.Updated = time.Now().UTC()
+		return putEntry(b, e)
+	})
+}
+
+// DeadLetter records a final failed attempt and moves the entry to the dead-letter bucket.
+func (s *Store) DeadLetter(id, lastErr string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		out := tx.Bucket(bucketOutbox)
+		e, ok, err := getEntry(out, id)
+		if err != nil || !ok {
+			return err
+		}
+		e.Attempts++
+		e.LastError = lastErr
+		e.NextAttempt = time.Time{}
+		e.Updated = time.Now().UTC()
+		if err := putEntry(tx.Bucket(bucketDeadLetters), e); err != nil {
+			return err
+		}
+		return out.Delete([]byte(id))
+	})
+}
+
+// RetryOutbox makes an entry due now. A dead letter is moved back to the outbox with its
+// attempts reset.
+func (s *Store) RetryOutbox(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		out, dead := tx.Bucket(bucketOutbox), tx.Bucket(bucketDeadLetters)
+		e, ok, err := getEntry(out, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if e, ok, err = getEntry(dead, id); err != nil {
+				return err
+			}
+			if !ok {
+				return ErrOutboxNotFound
+			}
+			e.Attempts = 0
+			if err := dead.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		e.NextAttempt = time.Now().UTC()
+		e.Updated = e.NextAttempt
+		return putEntry(out, e)
+	})
+}
+
+// PurgeOutbox deletes an entry from the outbox or the dead letters.
+func (s *Store) PurgeOutbox(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketOutbox, bucketDeadLetters} {
+			b := tx.Bucket(name)
+			if b.Get([]byte(id)) != nil {
+				return b.Delete([]byte(id))
+			}
+		}
+		return ErrOutboxNotFound
+	})
+}
+
+func (s *Store) entries(bucket []byte) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(_, v []byte) error {
+			var e OutboxEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func getEntry(b *bolt.Bucket, id string) (OutboxEntry, bool, error) {
+	var e OutboxEntry
+	v := b.Get([]byte(id))
+	if v == nil {
+		return e, false, nil
+	}
+	if err := json.Unmarshal(v, &e); err != nil {
+		return e, false, err
+	}
+	return e, true, nil
+}
+
+func putEntry(b *bolt.Bucket, e OutboxEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(e.ID), data)
+}
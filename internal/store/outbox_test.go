@@ -0,0 +1,98 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboxLifecycle(t *testing.T) {
+	st, cleanup := newTempStore(t)
+	defer cleanup()
+
+	var ids []string
+	for _, text := range []string{"one", "two"} {
+		id, err := st.EnqueueOutbox(OutboxEntry{Transport: "nostr", Recipient: "alice", Payload: json.RawMessage(`{"text":"` + text + `"}`)})
+		if err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	queued, err := st.Outbox()
+	if err != nil || len(queued) != 2 || queued[0].ID != ids[0] || queued[1].ID != ids[1] {
+		t.Fatalf("expected two entries in order, got %+v (%v)", queued, err)
+	}
+
+	next := time.Now().Add(time.Minute)
+	if err := st.FailOutbox(ids[0], "relay down", next); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	queued, _ = st.Outbox()
+	if queued[0].Attempts != 1 || queued[0].LastError != "relay down" || !queued[0].NextAttempt.Equal(next.UTC()) {
+		t.Fatalf("failure not recorded: %+v", queued[0])
+	}
+
+	if err := st.DeadLetter(ids[0], "still down"); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	if err := st.CompleteOutbox(ids[1]); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	queued, _ = st.Outbox()
+	dead, _ := st.DeadLetters()
+	if len(queued) != 0 || len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastError != "still down" {
+		t.Fatalf("unexpected state: queued=%+v dead=%+v", queued, dead)
+	}
+	if string(dead[0].Payload) != `{"text":"one"}` {
+		t.Fatalf("payload not kept: %s", dead[0].Payload)
+	}
+
+	if err := st.RetryOutbox(ids[0]); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	queued, _ = st.Outbox()
+	dead, _ = st.DeadLetters()
+	if len(queued) != 1 || len(dead) != 0 || queued[0].Attempts != 0 || queued[0].NextAttempt.After(time.Now()) {
+		t.Fatalf("retry should requeue as due: queued=%+v dead=%+v", queued, dead)
+	}
+
+	if err := st.PurgeOutbox(ids[0]); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if err := st.PurgeOutbox(ids[0]); !errors.Is(err, ErrOutboxNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := st.RetryOutbox("missing"); !errors.Is(err, ErrOutboxNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	// Failing a purged entry must not bring it back.
+	if err := st.FailOutbox(ids[0], "late", next); err != nil {
+		t.Fatalf("fail purged: %v", err)
+	}
+	if queued, _ = st.Outbox(); len(queued) != 0 {
+		t.Fatalf("purged entry came back: %+v", queued)
+	}
+}
+
+func TestOutboxSurvivesReopen(t *testing.T) {
+	path := t.TempDir() + "/state.db"
+	st, err := New(path)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, err := st.EnqueueOutbox(OutboxEntry{Transport: "email", Recipient: "bob@example.com"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_ = st.Close()
+
+	st, err = New(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { _ = st.Close() }()
+	queued, err := st.Outbox()
+	if err != nil || len(queued) != 1 || queued[0].Recipient != "bob@example.com" {
+		t.Fatalf("entry lost across reopen: %+v (%v)", queued, err)
+	}
+}
@@ -16,10 +16,12 @@ import (
 var (
 	bucketActive    = []byte("active_sessions")
 	bucketCursor    = []byte("cursors")
+	bucketSync      = []byte("sync_tokens")
 	bucketProcessed = []byte("processed")
 	bucketMessages  = []byte("messages")
 	bucketHistory   = []byte("history")
 	bucketAudit     = []byte("audit")
+	bucketCrypto    = []byte("crypto_state")
 
 	auditMaxEntries = 200
 )
@@ -52,6 +54,9 @@ func New(path string) (*Store, error) {
 		if _, err := tx.CreateBucketIfNotExists(bucketCursor); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(bucketSync); err != nil {
+			return err
+		}
 		if _, err := tx.CreateBucketIfNotExists(bucketProcessed); err != nil {
 			return err
 		}
@@ -64,6 +69,15 @@ func New(path string) (*Store, error) {
 		if _, err := tx.CreateBucketIfNotExists(bucketAudit); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists(bucketCrypto); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(bucketOutbox); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(bucketDeadLetters); err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {
@@ -151,6 +165,42 @@ func (s *Store) SaveCursor(pubkey string, t time.Time) error {
 	})
 }
 
+// SyncToken returns the opaque sync position saved for key (e.g. a Matrix next_batch), or "".
+func (s *Store) SyncToken(key string) (string, error) {
+	var token string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		token = string(tx.Bucket(bucketSync).Get([]byte(key)))
+		return nil
+	})
+	return token, err
+}
+
+// SaveSyncToken persists the sync position for key.
+func (s *Store) SaveSyncToken(key, token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSync).Put([]byte(key), []byte(token))
+	})
+}
+
+// CryptoState returns the end-to-end encryption state saved for key (e.g. a Matrix device's keys), or nil.
+func (s *Store) CryptoState(key string) ([]byte, error) {
+	var state []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketCrypto).Get([]byte(key)); v != nil {
+			state = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return state, err
+}
+
+// SaveCryptoState persists the encryption state for key.
+func (s *Store) SaveCryptoState(key string, state []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCrypto).Put([]byte(key), state)
+	})
+}
+
 // AlreadyProcessed checks if we've handled an event ID; if not, it marks it processed.
 func (s *Store) AlreadyProcessed(id string) (bool, error) {
 	if id == "" {
@@ -254,6 +304,16 @@ func (s *Store) History(threadID string, maxEntries int) ([]json.RawMessage, err
 	return entries, err
 }
 
+// ClearHistory drops all stored turns for a thread.
+func (s *Store) ClearHistory(threadID string) error {
+	if threadID == "" {
+		return errors.New("thread id required")
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketHistory).Delete([]byte(threadID))
+	})
+}
+
 // AppendAudit records an action execution entry.
 func (s *Store) AppendAudit(action, sender, outcome string, dur time.Duration) error {
 	maxEntries := auditMaxEntries
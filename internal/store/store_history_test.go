@@ -25,6 +25,25 @@ func TestHistoryAppendAndTrim(t *testing.T) {
 	}
 }
 
+func TestClearHistory(t *testing.T) {
+	st, cleanup := newTempStore(t)
+	defer cleanup()
+
+	if err := st.AppendHistory("thread", json.RawMessage(`{"role":"user"}`), 10); err != nil {
+		t.Fatalf("append err: %v", err)
+	}
+	if err := st.ClearHistory("thread"); err != nil {
+		t.Fatalf("clear err: %v", err)
+	}
+	entries, err := st.History("thread", 10)
+	if err != nil {
+		t.Fatalf("history err: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected empty history, got %d", len(entries))
+	}
+}
+
 func TestAuditAppend(t *testing.T) {
 	st, cleanup := newTempStore(t)
 	defer cleanup()
@@ -101,6 +120,41 @@ func TestCursorSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestSyncTokenSaveAndLoad(t *testing.T) {
+	st, cleanup := newTempStore(t)
+	defer cleanup()
+
+	if got, err := st.SyncToken("matrix"); err != nil || got != "" {
+		t.Fatalf("expected empty token, got %q err %v", got, err)
+	}
+	if err := st.SaveSyncToken("matrix", "s72594_4483_1934"); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	got, err := st.SyncToken("matrix")
+	if err != nil {
+		t.Fatalf("sync token: %v", err)
+	}
+	if got != "s72594_4483_1934" {
+		t.Fatalf("token mismatch %q", got)
+	}
+}
+
+func TestCryptoStateSaveAndLoad(t *testing.T) {
+	st, cleanup := newTempStore(t)
+	defer cleanup()
+
+	if got, err := st.CryptoState("matrix:bot"); err != nil || got != nil {
+		t.Fatalf("expected no state, got %q err %v", got, err)
+	}
+	if err := st.SaveCryptoState("matrix:bot", []byte(`{"device_id":"DEV"}`)); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+	got, err := st.CryptoState("matrix:bot")
+	if err != nil || string(got) != `{"device_id":"DEV"}` {
+		t.Fatalf("state mismatch %q err %v", got, err)
+	}
+}
+
 func TestActiveLifecycle(t *testing.T) {
 	st, cleanup := newTempStore(t)
 	defer cleanup()
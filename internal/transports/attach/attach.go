@@ -0,0 +1,108 @@
+// Package attach holds the attachment handling shared by transports that send files:
+// attaching long replies behind a preview, collecting the files written while answering,
+// and noting received files in the inbound text.
+package attach
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// PreviewChars is how much of a long reply stays in the body when the rest is attached.
+const PreviewChars = 1000
+
+// File is an attachment received with, or sent with, a message.
+type File struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Options decide what Outgoing attaches.
+type Options struct {
+	AttachAfter int    // replies longer than this many characters are attached; 0 never attaches
+	ReplyName   string // file name of an attached reply, e.g. "reply.md"
+	ReplyType   string // content type of an attached reply
+	MaxBytes    int64  // files larger than this are named instead of attached; 0 means no limit
+}
+
+// Outgoing decides what a reply carries: text longer than opt.AttachAfter is sent as
+// opt.ReplyName behind a short preview, and the files in paths are attached up to
+// opt.MaxBytes each. Files that cannot be sent are listed in the body instead.
+func Outgoing(text string, paths []string, opt Options) (string, []File) {
+	var files []File
+	if opt.AttachAfter > 0 && utf8.RuneCountInString(text) > opt.AttachAfter {
+		files = append(files, File{Filename: opt.ReplyName, ContentType: opt.ReplyType, Data: []byte(text)})
+		text = truncateRunes(text, PreviewChars) + "\n\n[Full reply attached as " + opt.ReplyName + "]"
+	}
+	var skipped []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			skipped = append(skipped, filepath.Base(p)+" (unreadable)")
+			continue
+		}
+		if opt.MaxBytes > 0 && info.Size() > opt.MaxBytes {
+			skipped = append(skipped, filepath.Base(p)+" (too large)")
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			skipped = append(skipped, filepath.Base(p)+" (unreadable)")
+			continue
+		}
+		files = append(files, File{Filename: filepath.Base(p), ContentType: contentType(p), Data: data})
+	}
+	if len(skipped) > 0 {
+		text += "\n\nNot attached: " + strings.Join(skipped, ", ")
+	}
+	return text, files
+}
+
+// Note appends the saved paths, and the attachments that were not kept, to an inbound
+// message's text so the agent knows what it was sent. missing says why, e.g. "too large
+// to keep", and reads "Attachments <missing>: a, b".
+func Note(text string, paths []string, missing string, skipped []string) string {
+	if len(paths) == 0 && len(skipped) == 0 {
+		return text
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(text, "\n"))
+	if len(paths) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("Attached files:")
+		for _, p := range paths {
+			b.WriteString("\n- " + p)
+		}
+	}
+	if len(skipped) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("Attachments " + missing + ": " + strings.Join(skipped, ", "))
+	}
+	return b.String()
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func truncateRunes(s string, n int) string {
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
@@ -0,0 +1,34 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutgoingAttachesLongReplyUnderGivenName(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.csv")
+	_ = os.WriteFile(report, []byte("a,b\n"), 0o600)
+
+	long := strings.Repeat("é", PreviewChars+10)
+	text, files := Outgoing(long, []string{report}, Options{AttachAfter: PreviewChars, ReplyName: "reply.txt", ReplyType: "text/plain"})
+	if len(files) != 2 || files[0].Filename != "reply.txt" || files[0].ContentType != "text/plain" || files[1].Filename != "report.csv" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	if !strings.HasPrefix(text, strings.Repeat("é", PreviewChars)+"\n\n[Full reply attached as reply.txt]") {
+		t.Fatalf("unexpected preview %q", text)
+	}
+}
+
+func TestNote(t *testing.T) {
+	got := Note("look\n", []string{"/tmp/a.png"}, "that could not be downloaded", []string{"audio/ogg"})
+	want := "look\n\nAttached files:\n- /tmp/a.png\n\nAttachments that could not be downloaded: audio/ogg"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := Note("plain\n", nil, "too large to keep", nil); got != "plain\n" {
+		t.Fatalf("text without attachments changed: %q", got)
+	}
+}
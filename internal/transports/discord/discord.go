@@ -0,0 +1,274 @@
+// Package discord implements a Discord bot transport: inbound messages arrive over the
+// Gateway websocket, replies go out through the REST API in a thread per conversation.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// maxContentChars is Discord's limit for a message's content.
+const maxContentChars = 2000
+
+// Config for the Discord transport.
+type Config struct {
+	ID              string   `json:"id"`
+	BotToken        string   `json:"bot_token"`
+	AllowedUsers    []string `json:"allowed_users"`    // user IDs (snowflakes)
+	AllowedChannels []string `json:"allowed_channels"` // guild channel IDs where mentions are answered
+	GatewayURL      string   `json:"gateway_url"`      // optional; discovered via /gateway/bot when empty
+	APIBase         string   `json:"api_base"`         // optional REST base override for tests
+}
+
+// Transport implements core.Transport (and core.EditableTransport) for Discord.
+type Transport struct {
+	cfg      Config
+	log      *slog.Logger
+	client   *http.Client
+	users    map[string]struct{}
+	channels map[string]struct{}
+
+	mu       sync.Mutex
+	botID    string
+	parents  map[string]channelInfo // channel ID -> cached lookup (threads resolve to their parent)
+	pending  map[string]pendingThread
+	session  string // gateway session for resume
+	resume   string // resume_gateway_url
+	sequence *int64
+}
+
+type channelInfo struct {
+	allowed bool
+	checked time.Time
+}
+
+// pendingThread is a guild mention whose reply thread has not been created yet.
+// Discord gives a thread started from a message the message's ID, so the ThreadID
+// is known before the thread exists.
+type pendingThread struct {
+	channel string
+	name    string
+	at      time.Time
+}
+
+// pendingTTL bounds how long a mention waits for its first reply; entries for mentions
+// that were never answered are dropped after it.
+const pendingTTL = 24 * time.Hour
+
+// addPending records a mention whose reply thread is started by the first reply.
+func (t *Transport) addPending(id string, p pendingThread) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, old := range t.pending {
+		if now.Sub(old.at) > pendingTTL {
+			delete(t.pending, k)
+		}
+	}
+	p.at = now
+	t.pending[id] = p
+}
+
+func New(cfg Config, logger *slog.Logger) (*Transport, error) {
+	if cfg.ID == "" {
+		cfg.ID = "discord"
+	}
+	if cfg.BotToken == "" {
+		return nil, errors.New("discord: bot_token required")
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		return nil, errors.New("discord: allowed_users required")
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = "https://discord.com/api/v10"
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Transport{
+		cfg:      cfg,
+		log:      logger.With("transport", "discord"),
+		client:   http.DefaultClient,
+		users:    toSet(cfg.AllowedUsers),
+		channels: toSet(cfg.AllowedChannels),
+		parents:  make(map[string]channelInfo),
+		pending:  make(map[string]pendingThread),
+	}, nil
+}
+
+func (t *Transport) ID() string { return t.cfg.ID }
+
+// MaxMessageChars reports Discord's content limit so the runner splits replies to fit.
+func (t *Transport) MaxMessageChars() int { return maxContentChars }
+
+// Send posts msg to the channel or thread in ThreadID, creating the reply thread on first use.
+// Text over the content limit is sent as several messages.
+func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
+	for _, part := range chunk(msg.Text, maxContentChars) {
+		if _, err := t.SendEditable(ctx, core.OutboundMessage{Transport: msg.Transport, Recipient: msg.Recipient, ThreadID: msg.ThreadID, Text: part, Meta: msg.Meta}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendEditable posts msg and returns a "channel:message" handle for Edit.
+func (t *Transport) SendEditable(ctx context.Context, msg core.OutboundMessage) (string, error) {
+	channel := msg.ThreadID
+	if channel == "" {
+		return "", errors.New("discord: thread id (channel) required")
+	}
+	if err := t.ensureThread(ctx, channel); err != nil {
+		return "", err
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	body := map[string]any{"content": truncate(msg.Text, maxContentChars), "allowed_mentions": map[string]any{"parse": []string{}}}
+	if err := t.rest(ctx, http.MethodPost, "/channels/"+channel+"/messages", body, &out); err != nil {
+		return "", err
+	}
+	return channel + ":" + out.ID, nil
+}
+
+// Edit replaces the content of a message sent with SendEditable.
+func (t *Transport) Edit(ctx context.Context, handle string, msg core.OutboundMessage) error {
+	channel, id, _ := strings.Cut(handle, ":")
+	if channel == "" || id == "" {
+		return fmt.Errorf("discord: invalid message handle %q", handle)
+	}
+	return t.rest(ctx, http.MethodPatch, "/channels/"+channel+"/messages/"+id, map[string]any{"content": truncate(msg.Text, maxContentChars)}, nil)
+}
+
+// ensureThread starts the reply thread for a pending guild mention.
+func (t *Transport) ensureThread(ctx context.Context, threadID string) error {
+	t.mu.Lock()
+	p, ok := t.pending[threadID]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	err := t.rest(ctx, http.MethodPost, "/channels/"+p.channel+"/messages/"+threadID+"/threads",
+		map[string]any{"name": p.name, "auto_archive_duration": 1440}, nil)
+	var apiErr *apiError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == codeThreadExists) {
+		return err
+	}
+	t.mu.Lock()
+	delete(t.pending, threadID)
+	t.parents[threadID] = channelInfo{allowed: true, checked: time.Now()}
+	t.mu.Unlock()
+	return nil
+}
+
+// codeThreadExists is Discord's error code for "a thread has already been created for this message".
+const codeThreadExists = 160004
+
+// apiError is a non-2xx REST response.
+type apiError struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("discord api failed: %d %s (code %d)", e.Status, e.Message, e.Code)
+}
+
+// rest performs an authenticated REST call, decoding a JSON response into out.
+func (t *Transport) rest(ctx context.Context, method, path string, body any, out any) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(t.cfg.APIBase, "/")+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+t.cfg.BotToken)
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/joelklabo/buddy, 1)")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return &apiError{Status: resp.StatusCode, Code: e.Code, Message: e.Message}
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// chunk splits text into pieces of at most limit runes, preferring line breaks.
+func chunk(text string, limit int) []string {
+	var out []string
+	for {
+		r := []rune(text)
+		if len(r) <= limit {
+			return append(out, text)
+		}
+		cut := limit
+		if i := strings.LastIndex(string(r[:limit]), "\n"); i > 0 {
+			cut = len([]rune(string(r[:limit])[:i]))
+		}
+		out = append(out, string(r[:cut]))
+		text = strings.TrimPrefix(string(r[cut:]), "\n")
+	}
+}
+
+func truncate(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit])
+	}
+	return s
+}
+
+func toSet(list []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		out[v] = struct{}{}
+	}
+	return out
+}
+
+func init() {
+	transport.MustRegister("discord", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("discord: invalid config type %T", cfg)
+		}
+		return New(c, nil)
+	})
+}
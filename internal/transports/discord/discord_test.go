@@ -0,0 +1,324 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// fakeDiscord serves the REST endpoints the transport uses and a scripted gateway.
+type fakeDiscord struct {
+	url string
+
+	mu         sync.Mutex
+	rest       []restCall
+	handshakes []frame // identify/resume frame of each gateway connection
+	channels   map[string]map[string]any
+}
+
+type restCall struct {
+	Method string
+	Path   string
+	Body   map[string]any
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/gateway" {
+		f.serveGateway(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bot tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.rest = append(f.rest, restCall{Method: r.Method, Path: path, Body: body})
+	n := len(f.rest)
+	ch, known := f.channels[strings.TrimPrefix(path, "/channels/")]
+	f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/channels/"):
+		if !known {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":10003,"message":"Unknown Channel"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ch)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/threads"):
+		_, _ = w.Write([]byte(`{"id":"thread"}`))
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/messages"):
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": "sent" + string(rune('0'+n))})
+	default:
+		_, _ = w.Write([]byte(`{}`))
+	}
+}
+
+func (f *fakeDiscord) serveGateway(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+	ctx := r.Context()
+	_ = wsjson.Write(ctx, conn, map[string]any{"op": opHello, "d": map[string]any{"heartbeat_interval": 60000}})
+	var hs frame
+	if err := wsjson.Read(ctx, conn, &hs); err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.handshakes = append(f.handshakes, hs)
+	first := len(f.handshakes) == 1
+	f.mu.Unlock()
+
+	if first {
+		dispatch := func(seq int, typ string, d any) {
+			_ = wsjson.Write(ctx, conn, map[string]any{"op": opDispatch, "s": seq, "t": typ, "d": d})
+		}
+		dispatch(1, "READY", map[string]any{"session_id": "sess", "resume_gateway_url": f.url, "user": map[string]any{"id": "B"}})
+		msgs := []map[string]any{
+			discordMessage("m1", "D1", "", "U1", false, "hello", nil),
+			discordMessage("m2", "C1", "G1", "U1", false, "<@B> run tests", []string{"B"}),
+			discordMessage("m3", "T1", "G1", "U1", false, "<@!B> and lint", []string{"B"}),
+			discordMessage("m4", "C9", "G1", "U1", false, "<@B> wrong channel", []string{"B"}),
+			discordMessage("m5", "C1", "G1", "U1", false, "no mention", nil),
+			discordMessage("m6", "D1", "", "B", true, "own echo", nil),
+			discordMessage("m7", "D2", "", "U9", false, "stranger", nil),
+		}
+		for i, m := range msgs {
+			dispatch(2+i, "MESSAGE_CREATE", m)
+		}
+		// Ask the client to reconnect; it should resume the session.
+		_ = wsjson.Write(ctx, conn, map[string]any{"op": opReconnect})
+	}
+	for {
+		var f frame
+		if err := wsjson.Read(ctx, conn, &f); err != nil {
+			return
+		}
+	}
+}
+
+func discordMessage(id, channel, guild, author string, bot bool, content string, mentions []string) map[string]any {
+	m := map[string]any{
+		"id": id, "channel_id": channel, "content": content,
+		"author": map[string]any{"id": author, "bot": bot},
+	}
+	if guild != "" {
+		m["guild_id"] = guild
+	}
+	var ms []map[string]any
+	for _, u := range mentions {
+		ms = append(ms, map[string]any{"id": u})
+	}
+	m["mentions"] = ms
+	return m
+}
+
+func newFake(t *testing.T) (*fakeDiscord, *httptest.Server) {
+	t.Helper()
+	fake := &fakeDiscord{channels: map[string]map[string]any{
+		"T1": {"id": "T1", "type": 11, "parent_id": "C1"},
+		"C9": {"id": "C9", "type": 0},
+	}}
+	srv := httptest.NewServer(fake)
+	fake.url = "ws" + strings.TrimPrefix(srv.URL, "http") + "/gateway"
+	return fake, srv
+}
+
+func newTestTransport(t *testing.T, fake *fakeDiscord, srv *httptest.Server) *Transport {
+	t.Helper()
+	tr, err := New(Config{
+		BotToken:        "tok",
+		AllowedUsers:    []string{"U1"},
+		AllowedChannels: []string{"C1"},
+		GatewayURL:      fake.url,
+		APIBase:         srv.URL + "/api",
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return tr
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{}, nil); err == nil {
+		t.Fatalf("expected error without token")
+	}
+	if _, err := New(Config{BotToken: "tok"}, nil); err == nil {
+		t.Fatalf("expected error without allowed users")
+	}
+}
+
+func TestGatewayDeliversDMsAndMentions(t *testing.T) {
+	fake, srv := newFake(t)
+	defer srv.Close()
+	tr := newTestTransport(t, fake, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inbound := make(chan core.InboundMessage, 8)
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, inbound) }()
+
+	want := []core.InboundMessage{
+		{Sender: "U1", Text: "hello", ThreadID: "D1"},
+		{Sender: "U1", Text: "run tests", ThreadID: "m2"},
+		{Sender: "U1", Text: "and lint", ThreadID: "T1"},
+	}
+	for _, w := range want {
+		select {
+		case msg := <-inbound:
+			if msg.Transport != "discord" || msg.Sender != w.Sender || msg.Text != w.Text || msg.ThreadID != w.ThreadID {
+				t.Fatalf("got %+v, want %+v", msg, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", w.Text)
+		}
+	}
+
+	// The reconnect request is answered with a resume of the same session.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		n := len(fake.handshakes)
+		var last frame
+		if n > 0 {
+			last = fake.handshakes[n-1]
+		}
+		fake.mu.Unlock()
+		if n == 2 {
+			var d struct {
+				SessionID string `json:"session_id"`
+				Seq       int64  `json:"seq"`
+			}
+			_ = json.Unmarshal(last.D, &d)
+			if last.Op != opResume || d.SessionID != "sess" || d.Seq != 8 {
+				t.Fatalf("expected resume of sess at seq 8, got op %d %s", last.Op, last.D)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client did not reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected context error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("start did not return after cancel")
+	}
+	select {
+	case msg := <-inbound:
+		t.Fatalf("unexpected message %+v", msg)
+	default:
+	}
+}
+
+func TestSendStartsThreadAndSplits(t *testing.T) {
+	fake, srv := newFake(t)
+	defer srv.Close()
+	tr := newTestTransport(t, fake, srv)
+	tr.botID = "B"
+
+	msg, ok := tr.toInbound(context.Background(), message{
+		ID: "m2", ChannelID: "C1", GuildID: "G1", Content: "<@B> run tests",
+		Author: struct {
+			ID  string `json:"id"`
+			Bot bool   `json:"bot"`
+		}{ID: "U1"},
+		Mentions: []struct {
+			ID string `json:"id"`
+		}{{ID: "B"}},
+	})
+	if !ok || msg.ThreadID != "m2" {
+		t.Fatalf("unexpected inbound %+v", msg)
+	}
+
+	long := strings.Repeat("a", 1500) + "\n" + strings.Repeat("b", 1000)
+	if err := tr.Send(context.Background(), core.OutboundMessage{ThreadID: msg.ThreadID, Text: long}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	handle, err := tr.SendEditable(context.Background(), core.OutboundMessage{ThreadID: msg.ThreadID, Text: "working"})
+	if err != nil {
+		t.Fatalf("send editable: %v", err)
+	}
+	if err := tr.Edit(context.Background(), handle, core.OutboundMessage{Text: "done"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+
+	fake.mu.Lock()
+	calls := append([]restCall(nil), fake.rest...)
+	fake.mu.Unlock()
+	if len(calls) != 5 {
+		t.Fatalf("expected thread + 3 messages + edit, got %+v", calls)
+	}
+	if calls[0].Path != "/channels/C1/messages/m2/threads" || calls[0].Body["name"] != "run tests" {
+		t.Fatalf("expected thread creation first, got %+v", calls[0])
+	}
+	first, _ := calls[1].Body["content"].(string)
+	second, _ := calls[2].Body["content"].(string)
+	if calls[1].Path != "/channels/m2/messages" || first != strings.Repeat("a", 1500) || second != strings.Repeat("b", 1000) {
+		t.Fatalf("expected reply split at the line break into the thread, got %q / %q", calls[1].Path, calls[2].Path)
+	}
+	if calls[4].Method != http.MethodPatch || calls[4].Path != "/channels/"+strings.Replace(handle, ":", "/messages/", 1) {
+		t.Fatalf("unexpected edit %+v (handle %s)", calls[4], handle)
+	}
+}
+
+func TestPendingThreadsExpire(t *testing.T) {
+	fake, srv := newFake(t)
+	defer srv.Close()
+	tr := newTestTransport(t, fake, srv)
+	tr.botID = "B"
+	tr.pending["old"] = pendingThread{channel: "C1", name: "never answered", at: time.Now().Add(-pendingTTL - time.Minute)}
+
+	if _, ok := tr.toInbound(context.Background(), message{
+		ID: "m3", ChannelID: "C1", GuildID: "G1", Content: "<@B> status",
+		Author: struct {
+			ID  string `json:"id"`
+			Bot bool   `json:"bot"`
+		}{ID: "U1"},
+		Mentions: []struct {
+			ID string `json:"id"`
+		}{{ID: "B"}},
+	}); !ok {
+		t.Fatal("mention rejected")
+	}
+	if _, ok := tr.pending["old"]; ok {
+		t.Fatal("expired pending thread kept")
+	}
+	if _, ok := tr.pending["m3"]; !ok {
+		t.Fatal("new mention not pending")
+	}
+}
+
+func TestChunk(t *testing.T) {
+	parts := chunk(strings.Repeat("x", 4500), 2000)
+	if len(parts) != 3 || len([]rune(parts[0])) != 2000 || len([]rune(parts[2])) != 500 {
+		t.Fatalf("unexpected chunks %d", len(parts))
+	}
+	if got := chunk("short", 2000); len(got) != 1 || got[0] != "short" {
+		t.Fatalf("unexpected %v", got)
+	}
+}
@@ -0,0 +1,367 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatAck   = 11
+)
+
+// intents requests guild and direct message events. Message content of mentions and DMs
+// is delivered without the privileged MESSAGE_CONTENT intent.
+const intents = 1<<9 | 1<<12
+
+// channelCacheTTL bounds how long a thread's parent lookup is trusted.
+const channelCacheTTL = 10 * time.Minute
+
+// errFatal marks gateway close codes that reconnecting cannot fix (bad token, bad intents).
+var errFatal = errors.New("discord: fatal gateway error")
+
+type frame struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d,omitempty"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+type message struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	Content   string `json:"content"`
+	Author    struct {
+		ID  string `json:"id"`
+		Bot bool   `json:"bot"`
+	} `json:"author"`
+	Mentions []struct {
+		ID string `json:"id"`
+	} `json:"mentions"`
+}
+
+// Start holds a Gateway connection until ctx is done, resuming the session after drops.
+func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	backoff := time.Second
+	for {
+		err := t.connect(ctx, inbound)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errFatal) {
+			return err
+		}
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		t.log.Warn("gateway disconnected", "err", err, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// connect runs one gateway connection. A nil error means Discord asked for a reconnect.
+func (t *Transport) connect(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	gateway, resuming, err := t.gatewayURL(ctx)
+	if err != nil {
+		return err
+	}
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, _, err := websocket.Dial(connCtx, gateway+"?v=10&encoding=json", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+	conn.SetReadLimit(8 << 20)
+
+	var hello frame
+	if err := wsjson.Read(connCtx, conn, &hello); err != nil {
+		return err
+	}
+	if hello.Op != opHello {
+		return fmt.Errorf("discord: expected hello, got op %d", hello.Op)
+	}
+	var hb struct {
+		Interval int `json:"heartbeat_interval"`
+	}
+	if err := json.Unmarshal(hello.D, &hb); err != nil || hb.Interval <= 0 {
+		return fmt.Errorf("discord: bad hello payload")
+	}
+
+	if err := t.identify(connCtx, conn, resuming); err != nil {
+		return err
+	}
+	var acked atomic.Bool
+	acked.Store(true)
+	go t.heartbeat(connCtx, conn, time.Duration(hb.Interval)*time.Millisecond, &acked)
+
+	for {
+		var f frame
+		if err := wsjson.Read(connCtx, conn, &f); err != nil {
+			return closeError(err)
+		}
+		if f.S != nil {
+			t.mu.Lock()
+			seq := *f.S
+			t.sequence = &seq
+			t.mu.Unlock()
+		}
+		switch f.Op {
+		case opDispatch:
+			t.handleDispatch(connCtx, f, inbound)
+		case opHeartbeat:
+			if err := t.sendHeartbeat(connCtx, conn); err != nil {
+				return err
+			}
+		case opHeartbeatAck:
+			acked.Store(true)
+		case opReconnect:
+			_ = conn.Close(websocket.StatusCode(4000), "reconnect")
+			return nil
+		case opInvalidSession:
+			var resumable bool
+			_ = json.Unmarshal(f.D, &resumable)
+			if !resumable {
+				t.mu.Lock()
+				t.session, t.resume, t.sequence = "", "", nil
+				t.mu.Unlock()
+			}
+			_ = conn.Close(websocket.StatusCode(4000), "invalid session")
+			return nil
+		}
+	}
+}
+
+// gatewayURL picks the resume URL for an existing session, else the configured or discovered URL.
+func (t *Transport) gatewayURL(ctx context.Context) (string, bool, error) {
+	t.mu.Lock()
+	session, resume := t.session, t.resume
+	t.mu.Unlock()
+	if session != "" && resume != "" {
+		return strings.TrimRight(resume, "/"), true, nil
+	}
+	if t.cfg.GatewayURL != "" {
+		return strings.TrimRight(t.cfg.GatewayURL, "/"), session != "", nil
+	}
+	var out struct {
+		URL string `json:"url"`
+	}
+	if err := t.rest(ctx, http.MethodGet, "/gateway/bot", nil, &out); err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(out.URL, "/"), session != "", nil
+}
+
+func (t *Transport) identify(ctx context.Context, conn *websocket.Conn, resuming bool) error {
+	t.mu.Lock()
+	session, seq := t.session, t.sequence
+	t.mu.Unlock()
+	if resuming && session != "" {
+		return wsjson.Write(ctx, conn, map[string]any{"op": opResume, "d": map[string]any{
+			"token": t.cfg.BotToken, "session_id": session, "seq": seq,
+		}})
+	}
+	return wsjson.Write(ctx, conn, map[string]any{"op": opIdentify, "d": map[string]any{
+		"token":      t.cfg.BotToken,
+		"intents":    intents,
+		"properties": map[string]string{"os": "linux", "browser": "buddy", "device": "buddy"},
+	}})
+}
+
+// heartbeat beats every interval; a beat that was never acknowledged means the
+// connection is dead, so it is closed to force a resume.
+func (t *Transport) heartbeat(ctx context.Context, conn *websocket.Conn, interval time.Duration, acked *atomic.Bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !acked.Swap(false) {
+				t.log.Warn("heartbeat not acknowledged; reconnecting")
+				_ = conn.Close(websocket.StatusCode(4000), "zombie connection")
+				return
+			}
+			if err := t.sendHeartbeat(ctx, conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (t *Transport) sendHeartbeat(ctx context.Context, conn *websocket.Conn) error {
+	t.mu.Lock()
+	seq := t.sequence
+	t.mu.Unlock()
+	return wsjson.Write(ctx, conn, map[string]any{"op": opHeartbeat, "d": seq})
+}
+
+func (t *Transport) handleDispatch(ctx context.Context, f frame, inbound chan<- core.InboundMessage) {
+	switch f.T {
+	case "READY":
+		var ready struct {
+			SessionID string `json:"session_id"`
+			ResumeURL string `json:"resume_gateway_url"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(f.D, &ready); err != nil {
+			t.log.Warn("decode READY failed", "err", err)
+			return
+		}
+		t.mu.Lock()
+		t.session, t.resume, t.botID = ready.SessionID, ready.ResumeURL, ready.User.ID
+		t.mu.Unlock()
+		t.log.Info("gateway ready", "bot", ready.User.ID)
+	case "MESSAGE_CREATE":
+		var m message
+		if err := json.Unmarshal(f.D, &m); err != nil {
+			t.log.Warn("decode MESSAGE_CREATE failed", "err", err)
+			return
+		}
+		msg, ok := t.toInbound(ctx, m)
+		if !ok {
+			return
+		}
+		select {
+		case inbound <- msg:
+		case <-ctx.Done():
+		}
+	}
+}
+
+var mentionToken = regexp.MustCompile(`<@!?(\w+)>`)
+
+// toInbound accepts DMs and bot mentions in allowlisted channels (or threads under them)
+// from allowlisted users. A mention in a channel is answered in a new thread started from it.
+func (t *Transport) toInbound(ctx context.Context, m message) (core.InboundMessage, bool) {
+	t.mu.Lock()
+	botID := t.botID
+	t.mu.Unlock()
+	if m.Author.Bot || m.Author.ID == botID {
+		return core.InboundMessage{}, false
+	}
+	if _, ok := t.users[m.Author.ID]; !ok {
+		t.log.Warn("rejecting sender not in allowlist", "user", m.Author.ID)
+		return core.InboundMessage{}, false
+	}
+	text := strings.TrimSpace(mentionToken.ReplaceAllStringFunc(m.Content, func(tok string) string {
+		if mentionToken.FindStringSubmatch(tok)[1] == botID {
+			return ""
+		}
+		return tok
+	}))
+	if text == "" {
+		return core.InboundMessage{}, false
+	}
+
+	threadID := m.ChannelID
+	if m.GuildID != "" {
+		mentioned := false
+		for _, u := range m.Mentions {
+			mentioned = mentioned || u.ID == botID
+		}
+		if !mentioned {
+			return core.InboundMessage{}, false
+		}
+		switch {
+		case t.isAllowedChannel(m.ChannelID):
+			threadID = m.ID
+			t.addPending(m.ID, pendingThread{channel: m.ChannelID, name: threadName(text)})
+		case t.isAllowedThread(ctx, m.ChannelID):
+		default:
+			t.log.Warn("rejecting mention in channel not in allowlist", "channel", m.ChannelID)
+			return core.InboundMessage{}, false
+		}
+	}
+
+	return core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    m.Author.ID,
+		Text:      text,
+		ThreadID:  threadID,
+		Meta: map[string]any{
+			"channel_id": m.ChannelID,
+			"guild_id":   m.GuildID,
+			"message_id": m.ID,
+		},
+	}, true
+}
+
+func (t *Transport) isAllowedChannel(id string) bool {
+	_, ok := t.channels[id]
+	return ok
+}
+
+// isAllowedThread reports whether channel is a thread under an allowlisted channel.
+func (t *Transport) isAllowedThread(ctx context.Context, channel string) bool {
+	t.mu.Lock()
+	c, ok := t.parents[channel]
+	t.mu.Unlock()
+	if ok && time.Since(c.checked) < channelCacheTTL {
+		return c.allowed
+	}
+	var info struct {
+		Type     int    `json:"type"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := t.rest(ctx, http.MethodGet, "/channels/"+channel, nil, &info); err != nil {
+		t.log.Warn("channel lookup failed", "channel", channel, "err", err)
+		return false
+	}
+	isThread := info.Type == 10 || info.Type == 11 || info.Type == 12
+	allowed := isThread && t.isAllowedChannel(info.ParentID)
+	t.mu.Lock()
+	t.parents[channel] = channelInfo{allowed: allowed, checked: time.Now()}
+	t.mu.Unlock()
+	return allowed
+}
+
+func threadName(text string) string {
+	name := strings.Join(strings.Fields(text), " ")
+	if r := []rune(name); len(r) > 80 {
+		name = string(r[:80])
+	}
+	return name
+}
+
+// closeError maps fatal gateway close codes to errFatal.
+func closeError(err error) error {
+	switch code := websocket.CloseStatus(err); code {
+	case 4004, 4010, 4011, 4012, 4013, 4014:
+		return fmt.Errorf("%w: close code %d", errFatal, code)
+	}
+	return err
+}
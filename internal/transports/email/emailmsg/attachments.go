@@ -0,0 +1,112 @@
+package emailmsg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joelklabo/buddy/internal/transports/attach"
+)
+
+// Defaults for attachment handling shared by the email transports.
+const (
+	DefaultMaxAttachmentBytes = 10 << 20
+	DefaultAttachAfterChars   = 8000
+	// maxNameLen bounds file and directory names derived from filenames and Message-IDs.
+	maxNameLen = 64
+)
+
+// Attachment is a file received with, or sent with, an email.
+type Attachment = attach.File
+
+// SaveAttachments writes atts into a per-thread directory under dir and returns their
+// paths. Filenames are reduced to a safe base name and never overwrite an existing file.
+func SaveAttachments(dir, threadID string, atts []Attachment) ([]string, error) {
+	if len(atts) == 0 {
+		return nil, nil
+	}
+	if dir == "" {
+		return nil, errors.New("no attachments directory configured")
+	}
+	threadDir := filepath.Join(dir, safeName(threadID, "thread"))
+	if err := os.MkdirAll(threadDir, 0o700); err != nil {
+		return nil, fmt.Errorf("attachments dir: %w", err)
+	}
+	var paths []string
+	for _, a := range atts {
+		p, err := createUnique(threadDir, safeName(a.Filename, "attachment"), a.Data)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// createUnique writes data to dir/name, adding "-N" before the extension if name is taken.
+func createUnique(dir, name string, data []byte) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = stem + "-" + strconv.Itoa(i) + ext
+		}
+		p := filepath.Join(dir, candidate)
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+		return p, f.Close()
+	}
+	return "", fmt.Errorf("no free name for %s", name)
+}
+
+// safeName reduces s to a single path element of letters, digits, '.', '-' and '_'.
+func safeName(s, fallback string) string {
+	s = filepath.Base(strings.ReplaceAll(s, `\`, "/"))
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	out := strings.Trim(b.String(), "._")
+	if len(out) > maxNameLen {
+		out = out[:maxNameLen]
+	}
+	if out == "" {
+		return fallback
+	}
+	return out
+}
+
+// AttachmentNote appends the saved paths, and the names of attachments that were too
+// large to keep, to an inbound message's text so the agent knows what it was sent.
+func AttachmentNote(text string, paths, skipped []string) string {
+	return attach.Note(text, paths, "too large to keep", skipped)
+}
+
+// Outgoing decides what a reply carries: text longer than attachAfter is sent as reply.md
+// behind a short preview, and the files in paths are attached up to maxBytes each.
+func Outgoing(text string, paths []string, attachAfter int, maxBytes int64) (string, []Attachment) {
+	return attach.Outgoing(text, paths, attach.Options{
+		AttachAfter: attachAfter,
+		ReplyName:   "reply.md",
+		ReplyType:   "text/markdown",
+		MaxBytes:    maxBytes,
+	})
+}
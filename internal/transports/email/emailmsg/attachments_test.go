@@ -0,0 +1,102 @@
+package emailmsg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestSaveAttachmentsPerThreadWithSafeUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := SaveAttachments(dir, "abc/../123@mail.example.com", []Attachment{
+		{Filename: "../../etc/passwd", Data: []byte("one")},
+		{Filename: "passwd", Data: []byte("two")},
+		{Filename: "", Data: []byte("three")},
+	})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	threadDir := filepath.Join(dir, "123_mail.example.com")
+	want := []string{
+		filepath.Join(threadDir, "passwd"),
+		filepath.Join(threadDir, "passwd-1"),
+		filepath.Join(threadDir, "attachment"),
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+	if b, _ := os.ReadFile(paths[1]); string(b) != "two" {
+		t.Fatalf("second file = %q", b)
+	}
+}
+
+func TestOutgoingAttachesLongRepliesAndFiles(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "notes.txt")
+	big := filepath.Join(dir, "big.bin")
+	_ = os.WriteFile(small, []byte("notes"), 0o600)
+	_ = os.WriteFile(big, make([]byte, 100), 0o600)
+
+	long := strings.Repeat("x", 50)
+	text, atts := Outgoing(long, []string{small, big, filepath.Join(dir, "missing")}, 10, 50)
+	if len(atts) != 2 || atts[0].Filename != "reply.md" || string(atts[0].Data) != long {
+		t.Fatalf("expected reply.md first, got %+v", atts)
+	}
+	if atts[1].Filename != "notes.txt" || !strings.HasPrefix(atts[1].ContentType, "text/plain") {
+		t.Fatalf("unexpected file attachment %+v", atts[1])
+	}
+	if !strings.Contains(text, "big.bin (too large)") || !strings.Contains(text, "missing (unreadable)") {
+		t.Fatalf("skipped files not noted: %q", text)
+	}
+
+	if text, atts := Outgoing("short", nil, 10, 50); text != "short" || len(atts) != 0 {
+		t.Fatalf("short reply changed: %q %v", text, atts)
+	}
+}
+
+func TestReplyBuildWithAttachments(t *testing.T) {
+	raw, _, err := Reply{
+		From:        "buddy@example.org",
+		To:          "alice@example.com",
+		Text:        "see attached",
+		Attachments: []Attachment{{Filename: "out.log", ContentType: "text/plain", Data: []byte("log line")}},
+	}.Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	r, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var inline []string
+	var files []string
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			typ, _, _ := h.ContentType()
+			inline = append(inline, typ)
+		case *mail.AttachmentHeader:
+			name, _ := h.Filename()
+			b, _ := io.ReadAll(p.Body)
+			files = append(files, name+"="+string(b))
+		}
+	}
+	if strings.Join(inline, ",") != "text/plain,text/html" {
+		t.Fatalf("inline parts = %v", inline)
+	}
+	if len(files) != 1 || files[0] != "out.log=log line" {
+		t.Fatalf("attachments = %v", files)
+	}
+}
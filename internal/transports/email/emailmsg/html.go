@@ -0,0 +1,107 @@
+package emailmsg
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.+)$`)
+	mdBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdBold    = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdItalic  = regexp.MustCompile(`\*([^*\s][^*]*?)\*`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+)
+
+// RenderHTML converts the Markdown agents produce into a small HTML document: fenced code,
+// headings, lists, paragraphs, bold, italic, inline code and http(s) links. Everything else
+// is escaped text.
+func RenderHTML(markdown string) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><body>\n")
+	var para []string
+	list := ""
+	flushPara := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + strings.Join(para, "<br>\n") + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		flushPara()
+		if list != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushPara()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, html.EscapeString(lines[i]))
+			}
+			b.WriteString("<pre><code>" + strings.Join(code, "\n") + "</code></pre>\n")
+		case trimmed == "":
+			flushPara()
+			closeList()
+		case mdHeading.MatchString(trimmed):
+			flushPara()
+			closeList()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + inline(m[2]) + "</" + tag + ">\n")
+		case mdBullet.MatchString(line):
+			openList("ul")
+			b.WriteString("<li>" + inline(mdBullet.FindStringSubmatch(line)[1]) + "</li>\n")
+		case mdOrdered.MatchString(line):
+			openList("ol")
+			b.WriteString("<li>" + inline(mdOrdered.FindStringSubmatch(line)[1]) + "</li>\n")
+		default:
+			closeList()
+			para = append(para, inline(line))
+		}
+	}
+	flushPara()
+	closeList()
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+// inline escapes one line and applies emphasis and links outside `code` spans.
+func inline(line string) string {
+	// Odd segments between backticks are inline code.
+	segs := strings.Split(line, "`")
+	if len(segs)%2 == 0 {
+		// Unbalanced backtick: treat the last one literally.
+		segs[len(segs)-2] += "`" + segs[len(segs)-1]
+		segs = segs[:len(segs)-1]
+	}
+	for j, s := range segs {
+		s = html.EscapeString(s)
+		if j%2 == 1 {
+			segs[j] = "<code>" + s + "</code>"
+			continue
+		}
+		s = mdLink.ReplaceAllString(s, `<a href="$2">$1</a>`)
+		s = mdBold.ReplaceAllString(s, "<strong>$1$2</strong>")
+		s = mdItalic.ReplaceAllString(s, "<em>$1</em>")
+		segs[j] = s
+	}
+	return strings.Join(segs, "")
+}
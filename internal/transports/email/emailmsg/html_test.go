@@ -0,0 +1,32 @@
+package emailmsg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderHTML(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"paragraphs", "first\nline\n\nsecond", "<p>first<br>\nline</p>\n<p>second</p>"},
+		{"heading", "## Result", "<h2>Result</h2>"},
+		{"list", "- one\n- **two**", "<ul>\n<li>one</li>\n<li><strong>two</strong></li>\n</ul>"},
+		{"ordered", "1. a\n2. b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>"},
+		{"fence escapes", "```\n<b>x</b> **y**\n```", "<pre><code>&lt;b&gt;x&lt;/b&gt; **y**</code></pre>"},
+		{"inline code keeps stars", "run `a*b*c` *now*", "<p>run <code>a*b*c</code> <em>now</em></p>"},
+		{"link", "see [docs](https://example.com/a?b=1&c=2)", `<p>see <a href="https://example.com/a?b=1&amp;c=2">docs</a></p>`},
+		{"no javascript links", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"escapes html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := RenderHTML(tc.in)
+			if !strings.Contains(got, tc.want) {
+				t.Fatalf("RenderHTML(%q) =\n%s\nwant it to contain\n%s", tc.in, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package emailmsg builds the RFC 5322 replies the email transports send and tracks the
+// thread state (subject, Message-ID, References) each reply needs.
+package emailmsg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Thread is what a reply needs to know about the message it answers. Message IDs are
+// kept without angle brackets.
+type Thread struct {
+	Subject    string
+	MessageID  string
+	References []string
+}
+
+// ThreadFromMeta reads the subject, message_id and references an email transport put in
+// InboundMessage.Meta.
+func ThreadFromMeta(meta map[string]any) Thread {
+	var th Thread
+	th.Subject, _ = meta["subject"].(string)
+	if id, _ := meta["message_id"].(string); id != "" {
+		th.MessageID = trimID(id)
+	}
+	switch refs := meta["references"].(type) {
+	case []string:
+		th.References = refs
+	case string:
+		th.References = ParseMsgIDs(refs)
+	}
+	return th
+}
+
+// ThreadID names the conversation a message belongs to: the root of its References chain,
+// else the message it replies to, else the message itself.
+func ThreadID(messageID, inReplyTo string, references []string) string {
+	if len(references) > 0 {
+		return references[0]
+	}
+	if ids := ParseMsgIDs(inReplyTo); len(ids) > 0 {
+		return ids[0]
+	}
+	return trimID(messageID)
+}
+
+// ParseMsgIDs splits a References or In-Reply-To value ("<a@x> <b@y>") into bare IDs.
+func ParseMsgIDs(s string) []string {
+	var ids []string
+	for _, f := range strings.Fields(s) {
+		if id := trimID(f); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func trimID(id string) string { return strings.Trim(strings.TrimSpace(id), "<>") }
+
+// ReplySubject prefixes subject with "Re: " unless it already has one.
+func ReplySubject(subject string) string {
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		return "Re: your message"
+	}
+	if len(subject) >= 3 && strings.EqualFold(subject[:3], "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// Reply is an outbound message answering Thread.
+type Reply struct {
+	From   string
+	To     string
+	Thread Thread
+	// Text is the agent's Markdown; it is sent as text/plain with a rendered text/html
+	// alternative.
+	Text string
+	Date time.Time
+	// Attachments, if any, make the message multipart/mixed.
+	Attachments []Attachment
+}
+
+// Build renders the reply as a multipart/alternative RFC 5322 message, wrapped in
+// multipart/mixed when it has attachments, and returns it with its generated Message-ID.
+func (r Reply) Build() ([]byte, string, error) {
+	from, err := mail.ParseAddress(r.From)
+	if err != nil {
+		return nil, "", fmt.Errorf("from address: %w", err)
+	}
+	to, err := mail.ParseAddress(r.To)
+	if err != nil {
+		return nil, "", fmt.Errorf("to address: %w", err)
+	}
+	date := r.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var h mail.Header
+	h.SetAddressList("From", []*mail.Address{from})
+	h.SetAddressList("To", []*mail.Address{to})
+	h.SetDate(date)
+	h.SetSubject(ReplySubject(r.Thread.Subject))
+	// The sender's domain keeps the ID unique and in line with the envelope for spam filters.
+	if err := h.GenerateMessageIDWithHostname(domainOf(from.Address)); err != nil {
+		return nil, "", fmt.Errorf("generate message-id: %w", err)
+	}
+	id, _ := h.MessageID()
+	if r.Thread.MessageID != "" {
+		h.SetMsgIDList("In-Reply-To", []string{r.Thread.MessageID})
+		h.SetMsgIDList("References", append(append([]string(nil), r.Thread.References...), r.Thread.MessageID))
+	}
+	h.Set("MIME-Version", "1.0")
+
+	var buf bytes.Buffer
+	if len(r.Attachments) == 0 {
+		w, err := mail.CreateInlineWriter(&buf, h)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := r.writeBody(w); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), id, nil
+	}
+
+	mw, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, "", err
+	}
+	w, err := mw.CreateInline()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := r.writeBody(w); err != nil {
+		return nil, "", err
+	}
+	for _, a := range r.Attachments {
+		var ah mail.AttachmentHeader
+		ah.SetContentType(a.ContentType, nil)
+		ah.SetFilename(a.Filename)
+		aw, err := mw.CreateAttachment(ah)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := aw.Write(a.Data); err != nil {
+			return nil, "", err
+		}
+		if err := aw.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), id, nil
+}
+
+// writeBody writes the text/plain and text/html alternatives and closes w.
+func (r Reply) writeBody(w *mail.InlineWriter) error {
+	for _, part := range []struct{ typ, body string }{
+		{"text/plain", r.Text},
+		{"text/html", RenderHTML(r.Text)},
+	} {
+		var ph mail.InlineHeader
+		ph.SetContentType(part.typ, map[string]string{"charset": "utf-8"})
+		pw, err := w.CreatePart(ph)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write([]byte(part.body)); err != nil {
+			return err
+		}
+		if err := pw.Close(); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func domainOf(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 && i < len(addr)-1 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
+
+// threadTTL bounds how long a conversation's latest message is remembered for replies.
+const threadTTL = 7 * 24 * time.Hour
+
+// Threads remembers the latest inbound message of each conversation, keyed by ThreadID,
+// so replies can continue it.
+type Threads struct {
+	mu      sync.Mutex
+	threads map[string]threadEntry
+}
+
+type threadEntry struct {
+	Thread
+	seen time.Time
+}
+
+// Remember records th as the message to answer in threadID, dropping expired threads.
+func (t *Threads) Remember(threadID string, th Thread) {
+	if threadID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.threads == nil {
+		t.threads = make(map[string]threadEntry)
+	}
+	now := time.Now()
+	for id, e := range t.threads {
+		if now.Sub(e.seen) > threadTTL {
+			delete(t.threads, id)
+		}
+	}
+	t.threads[threadID] = threadEntry{Thread: th, seen: now}
+}
+
+// Lookup returns the message to answer in threadID, if known.
+func (t *Threads) Lookup(threadID string) (Thread, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.threads[threadID]
+	return e.Thread, ok
+}
@@ -0,0 +1,126 @@
+package emailmsg
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestReplyBuildThreadsAndRendersHTML(t *testing.T) {
+	th := ThreadFromMeta(map[string]any{
+		"subject":    "Deploy plan",
+		"message_id": "<m2@example.com>",
+		"references": []string{"m1@example.com"},
+	})
+	raw, id, err := Reply{
+		From:   "buddy@example.org",
+		To:     "alice@example.com",
+		Thread: th,
+		Text:   "**Done**, see `make deploy`.",
+		Date:   time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC),
+	}.Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.HasSuffix(id, "@example.org") {
+		t.Fatalf("message-id should use the sender domain, got %q", id)
+	}
+
+	r, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("parse reply: %v", err)
+	}
+	h := r.Header
+	if subj, _ := h.Subject(); subj != "Re: Deploy plan" {
+		t.Fatalf("subject = %q", subj)
+	}
+	if got, _ := h.MsgIDList("In-Reply-To"); len(got) != 1 || got[0] != "m2@example.com" {
+		t.Fatalf("in-reply-to = %v", got)
+	}
+	if got, _ := h.MsgIDList("References"); strings.Join(got, " ") != "m1@example.com m2@example.com" {
+		t.Fatalf("references = %v", got)
+	}
+	if got, _ := h.MessageID(); got != id {
+		t.Fatalf("message-id header %q, returned %q", got, id)
+	}
+	if from, _ := h.AddressList("From"); len(from) != 1 || from[0].Address != "buddy@example.org" {
+		t.Fatalf("from = %v", from)
+	}
+	if h.Get("Date") == "" {
+		t.Fatal("missing Date header")
+	}
+
+	parts := map[string]string{}
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		typ, _, _ := p.Header.(*mail.InlineHeader).ContentType()
+		b, _ := io.ReadAll(p.Body)
+		parts[typ] = string(b)
+	}
+	if parts["text/plain"] != "**Done**, see `make deploy`." {
+		t.Fatalf("text part = %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], "<strong>Done</strong>, see <code>make deploy</code>.") {
+		t.Fatalf("html part = %q", parts["text/html"])
+	}
+}
+
+func TestReplyWithoutThreadOmitsThreadHeaders(t *testing.T) {
+	raw, _, err := Reply{From: "buddy@example.org", To: "alice@example.com", Text: "hi"}.Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	s := string(raw)
+	if strings.Contains(s, "In-Reply-To") || strings.Contains(s, "References") {
+		t.Fatalf("unexpected thread headers:\n%s", s)
+	}
+}
+
+func TestReplySubject(t *testing.T) {
+	for in, want := range map[string]string{
+		"build":      "Re: build",
+		"Re: build":  "Re: build",
+		"RE:build":   "RE:build",
+		"  ":         "Re: your message",
+		"Regression": "Re: Regression",
+	} {
+		if got := ReplySubject(in); got != want {
+			t.Errorf("ReplySubject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestThreadID(t *testing.T) {
+	if got := ThreadID("<c@x>", "<b@x>", []string{"a@x", "b@x"}); got != "a@x" {
+		t.Fatalf("expected references root, got %q", got)
+	}
+	if got := ThreadID("<c@x>", "<b@x>", nil); got != "b@x" {
+		t.Fatalf("expected in-reply-to, got %q", got)
+	}
+	if got := ThreadID("<c@x>", "", nil); got != "c@x" {
+		t.Fatalf("expected own id, got %q", got)
+	}
+}
+
+func TestThreadsRemembersLatest(t *testing.T) {
+	var ts Threads
+	ts.Remember("a@x", Thread{Subject: "one", MessageID: "a@x"})
+	ts.Remember("a@x", Thread{Subject: "one", MessageID: "b@x", References: []string{"a@x"}})
+	th, ok := ts.Lookup("a@x")
+	if !ok || th.MessageID != "b@x" {
+		t.Fatalf("lookup = %+v, %v", th, ok)
+	}
+	if _, ok := ts.Lookup("other"); ok {
+		t.Fatal("unexpected thread")
+	}
+}
@@ -1,5 +1,11 @@
 package imap
 
+import (
+	"strings"
+
+	"github.com/joelklabo/buddy/internal/transports/email/emailmsg"
+)
+
 // Config for IMAP/SMTP transport.
 type Config struct {
 	ID       string `yaml:"id" json:"id"`
@@ -8,14 +14,30 @@ type Config struct {
 	Username string `yaml:"username" json:"username"`
 	Password string `yaml:"password" json:"password"`
 	Folder   string `yaml:"folder" json:"folder"`
-	Idle     bool   `yaml:"idle" json:"idle"`
+	// Idle waits for new mail with IMAP IDLE when the server supports it instead of polling.
+	Idle bool `yaml:"idle" json:"idle"`
+	// MoveTo, if set, is the folder processed messages are moved to; otherwise they are
+	// flagged \Seen and left in Folder.
+	MoveTo string `yaml:"move_to" json:"move_to"`
 
 	SMTPHost string `yaml:"smtp_host" json:"smtp_host"`
 	SMTPPort int    `yaml:"smtp_port" json:"smtp_port"`
-	SMTPTLS  bool   `yaml:"smtp_tls" json:"smtp_tls"`
+	// SMTPTLS connects with implicit TLS (port 465); otherwise the connection is upgraded
+	// with STARTTLS when the server offers it (port 587).
+	SMTPTLS bool `yaml:"smtp_tls" json:"smtp_tls"`
+	// From is the reply sender address; defaults to Username.
+	From string `yaml:"from" json:"from"`
 
 	AllowSenders []string `yaml:"allow_senders" json:"allow_senders"`
 	MaxBytes     int      `yaml:"max_bytes" json:"max_bytes"`
+
+	// AttachmentsDir receives inbound attachments, one subdirectory per thread. Without
+	// it attachments are ignored; the app defaults it under the first readfile root.
+	AttachmentsDir string `yaml:"attachments_dir" json:"attachments_dir"`
+	// MaxAttachmentBytes caps each attachment received or sent.
+	MaxAttachmentBytes int64 `yaml:"max_attachment_bytes" json:"max_attachment_bytes"`
+	// AttachAfterChars sends longer replies as a reply.md attachment behind a preview.
+	AttachAfterChars int `yaml:"attach_after_chars" json:"attach_after_chars"`
 }
 
 func (c *Config) Defaults() {
@@ -27,6 +49,9 @@ func (c *Config) Defaults() {
 	}
 	if c.SMTPPort == 0 {
 		c.SMTPPort = 587
+		if c.SMTPTLS {
+			c.SMTPPort = 465
+		}
 	}
 	if c.ID == "" {
 		c.ID = "email-imap"
@@ -34,15 +59,27 @@ func (c *Config) Defaults() {
 	if c.SMTPHost == "" {
 		c.SMTPHost = c.Host
 	}
+	if c.From == "" {
+		c.From = c.Username
+	}
 	if c.MaxBytes == 0 {
 		c.MaxBytes = 262144 // 256 KiB
 	}
+	if c.MaxAttachmentBytes == 0 {
+		c.MaxAttachmentBytes = emailmsg.DefaultMaxAttachmentBytes
+	}
+	if c.AttachAfterChars == 0 {
+		c.AttachAfterChars = emailmsg.DefaultAttachAfterChars
+	}
 }
 
 func (c *Config) Validate() error {
 	if c.Host == "" || c.Username == "" || c.Password == "" {
 		return Err("host, username, password are required")
 	}
+	if !strings.Contains(c.From, "@") {
+		return Err("from must be an email address (set it when username is not one)")
+	}
 	if len(c.AllowSenders) == 0 {
 		c.AllowSenders = []string{c.Username}
 	}
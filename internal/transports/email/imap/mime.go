@@ -0,0 +1,109 @@
+package imap
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset" // decode non-UTF-8 text parts
+	"github.com/joelklabo/buddy/internal/transports/email/emailmsg"
+)
+
+// parsedMessage is what the transport needs from a raw message.
+type parsedMessage struct {
+	Header message.Header
+	// Text is the first inline text/plain part, "" if there is none.
+	Text        string
+	Attachments []emailmsg.Attachment
+	// Skipped names attachments dropped for exceeding the size limit.
+	Skipped []string
+}
+
+// parseMessage reads a raw RFC 5322 message and returns its header, first inline
+// text/plain part and attachments of at most maxAttachment bytes, with the transfer
+// encoding (quoted-printable, base64) and charset decoded.
+func parseMessage(r io.Reader, maxAttachment int64) (parsedMessage, error) {
+	e, err := message.Read(r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return parsedMessage{}, err
+	}
+	out := parsedMessage{Header: e.Header}
+	found := false
+	err = e.Walk(func(_ []int, part *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) {
+			return err
+		}
+		mediaType, params, _ := part.Header.ContentType()
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil
+		}
+		if mediaType == "" {
+			mediaType = "text/plain" // RFC 2045 default
+		}
+		disp, dispParams, _ := part.Header.ContentDisposition()
+		filename := dispParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if disp == "attachment" || filename != "" {
+			b, err := io.ReadAll(io.LimitReader(part.Body, maxAttachment+1))
+			if err != nil {
+				return err
+			}
+			if int64(len(b)) > maxAttachment {
+				out.Skipped = append(out.Skipped, filename)
+				return nil
+			}
+			out.Attachments = append(out.Attachments, emailmsg.Attachment{Filename: filename, ContentType: mediaType, Data: b})
+			return nil
+		}
+		if found || mediaType != "text/plain" {
+			return nil
+		}
+		b, err := io.ReadAll(part.Body)
+		if err != nil {
+			return err
+		}
+		out.Text, found = string(b), true
+		return nil
+	})
+	if err != nil {
+		return parsedMessage{}, err
+	}
+	return out, nil
+}
+
+// Reply headers that introduce the quoted original, e.g. Gmail's "On <date>, <name> wrote:"
+// and Outlook's separators.
+var (
+	replyHeader  = regexp.MustCompile(`^On .+ wrote:\s*$`)
+	replyOpening = regexp.MustCompile(`^On .+`)
+	replyClosing = regexp.MustCompile(`wrote:\s*$`)
+	outlookLine  = regexp.MustCompile(`^(-{2,}\s*Original Message\s*-{2,}|_{10,})\s*$`)
+)
+
+// stripReply drops quoted lines, everything from a reply header on, and the signature
+// (after a "-- " line), leaving what the sender actually wrote.
+func stripReply(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var out []string
+	for i, line := range lines {
+		if line == "-- " || line == "--" {
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if replyHeader.MatchString(trimmed) || outlookLine.MatchString(trimmed) {
+			break
+		}
+		// Clients wrap long "On ... wrote:" headers onto a second line.
+		if replyOpening.MatchString(trimmed) && i+1 < len(lines) && replyClosing.MatchString(strings.TrimSpace(lines[i+1])) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,107 @@
+package imap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMessageText(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "plain without content type",
+			raw:  "Subject: hi\n\nhello there",
+			want: "hello there",
+		},
+		{
+			name: "base64",
+			raw:  "Content-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: base64\n\naGVsbG8gd29ybGQ=",
+			want: "hello world",
+		},
+		{
+			name: "latin-1 quoted-printable",
+			raw:  "Content-Type: text/plain; charset=iso-8859-1\nContent-Transfer-Encoding: quoted-printable\n\ncaf=E9 soft=\n break",
+			want: "café soft break",
+		},
+		{
+			name: "skips html and attachments",
+			raw: "Content-Type: multipart/mixed; boundary=m\n\n" +
+				"--m\nContent-Type: multipart/alternative; boundary=a\n\n" +
+				"--a\nContent-Type: text/html\n\n<b>hi</b>\n" +
+				"--a\nContent-Type: text/plain\n\nhi\n" +
+				"--a--\n" +
+				"--m\nContent-Type: text/plain\nContent-Disposition: attachment; filename=log.txt\n\nlog line\n" +
+				"--m--\n",
+			want: "hi",
+		},
+		{
+			name: "html only",
+			raw:  "Content-Type: text/html\n\n<p>hi</p>",
+			want: "",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, err := parseMessage(strings.NewReader(strings.ReplaceAll(tc.raw, "\n", "\r\n")), 1024)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := strings.TrimSpace(strings.ReplaceAll(parsed.Text, "\r\n", "\n")); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseMessageAttachments(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=m\n\n" +
+		"--m\nContent-Type: text/plain\n\nsee logs\n" +
+		"--m\nContent-Type: text/plain\nContent-Disposition: attachment; filename=app.log\n\nboom\n" +
+		"--m\nContent-Type: image/png; name=shot.png\nContent-Transfer-Encoding: base64\n\niVBORw0K\n" +
+		"--m\nContent-Type: application/zip\nContent-Disposition: attachment; filename=big.zip\n\n" + strings.Repeat("z", 64) + "\n" +
+		"--m--\n"
+	parsed, err := parseMessage(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n")), 32)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if strings.TrimSpace(parsed.Text) != "see logs" {
+		t.Fatalf("text = %q", parsed.Text)
+	}
+	if len(parsed.Attachments) != 2 {
+		t.Fatalf("attachments = %+v", parsed.Attachments)
+	}
+	if a := parsed.Attachments[0]; a.Filename != "app.log" || strings.TrimSpace(string(a.Data)) != "boom" {
+		t.Fatalf("first attachment = %+v", a)
+	}
+	if a := parsed.Attachments[1]; a.Filename != "shot.png" || a.ContentType != "image/png" || string(a.Data[:4]) != "\x89PNG" {
+		t.Fatalf("second attachment = %+v", a)
+	}
+	if len(parsed.Skipped) != 1 || parsed.Skipped[0] != "big.zip" {
+		t.Fatalf("skipped = %v", parsed.Skipped)
+	}
+}
+
+func TestStripReply(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"gmail header", "Sure, go ahead.\n\nOn Tue, Mar 5, 2024 at 9:12 AM Bob <bob@example.com> wrote:\n> can I deploy?", "Sure, go ahead."},
+		{"wrapped header", "Yes.\nOn Tue, Mar 5, 2024 at 9:12 AM Bob Example\n<bob@example.com> wrote:\n> hi", "Yes."},
+		{"outlook", "Done.\n\n-----Original Message-----\nFrom: Bob", "Done."},
+		{"signature", "Run the tests.\n\n-- \nAlice\nSent from my phone", "Run the tests."},
+		{"inline quotes", "> what about logs?\nattach them\n> and metrics?\nskip those", "attach them\nskip those"},
+		{"untouched", "first\n\nsecond", "first\n\nsecond"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stripReply(tc.in); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,122 +1,477 @@
 package imap
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
+	"log/slog"
+	"net"
 	"net/smtp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	imapclient "github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message"
 	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/store"
+	"github.com/joelklabo/buddy/internal/transports/email/emailmsg"
 )
 
-// Transport implements a polling IMAP receive + SMTP send.
+// Without IDLE the folder is searched every pollInterval on the same connection.
+const pollInterval = 30 * time.Second
+
+// Reconnect backoff. A session that stayed up for sessionStableAfter resets it.
+const (
+	minBackoff         = 5 * time.Second
+	maxBackoff         = 5 * time.Minute
+	sessionStableAfter = time.Minute
+)
+
+// fetchBatch caps how many messages are fetched (and held in memory) at once.
+const fetchBatch = 20
+
+// Transport implements IMAP receive (IDLE or polling) + SMTP send.
 type Transport struct {
-	cfg Config
+	cfg   Config
+	store store.StoreAPI
+	log   *slog.Logger
+	dial  func() (*imapclient.Client, error)
+
+	// Position in Folder: messages up to lastUID have been handled. Only the Start
+	// goroutine touches these.
+	validity uint32
+	lastUID  uint32
+
+	threads emailmsg.Threads
 }
 
-func New(cfg Config) (*Transport, error) {
+// New builds the transport. st persists the folder position across restarts; it may be nil.
+func New(cfg Config, st store.StoreAPI, logger *slog.Logger) (*Transport, error) {
 	cfg.Defaults()
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
-	return &Transport{cfg: cfg}, nil
+	if logger == nil {
+		logger = slog.Default()
+	}
+	t := &Transport{
+		cfg:   cfg,
+		store: st,
+		log:   logger.With("transport", cfg.ID),
+	}
+	t.dial = func() (*imapclient.Client, error) {
+		return imapclient.DialTLS(fmt.Sprintf("%s:%d", t.cfg.Host, t.cfg.Port), nil)
+	}
+	return t, nil
 }
 
 func (t *Transport) ID() string { return t.cfg.ID }
 
+// Start keeps a session open on Folder until ctx is done, reconnecting with backoff.
+// Only unseen messages past the saved UID are delivered; without a saved position that
+// means every unseen message in the folder.
 func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	t.loadState()
+	backoff := minBackoff
 	for {
-		if err := t.pollOnce(ctx, inbound); err != nil {
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-			// backoff on errors
-			select {
-			case <-time.After(5 * time.Second):
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+		started := time.Now()
+		err := t.session(ctx, inbound)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(started) >= sessionStableAfter {
+			backoff = minBackoff
 		}
+		t.log.Warn("imap session ended", "err", err, "retry_in", backoff)
 		select {
-		case <-time.After(30 * time.Second):
+		case <-time.After(backoff):
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+		backoff = min(backoff*2, maxBackoff)
 	}
 }
 
-func (t *Transport) pollOnce(ctx context.Context, inbound chan<- core.InboundMessage) error {
-	c, err := imapclient.DialTLS(fmt.Sprintf("%s:%d", t.cfg.Host, t.cfg.Port), nil)
+// session logs in, selects Folder and delivers new messages until the connection fails.
+func (t *Transport) session(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	c, err := t.dial()
 	if err != nil {
 		return err
 	}
 	defer c.Logout()
+	// Logout does not unblock a pending command when the server stops answering.
+	stop := context.AfterFunc(ctx, func() { _ = c.Terminate() })
+	defer stop()
+
+	// Unilateral EXISTS responses wake the IDLE wait. The reader blocks on Updates, so
+	// it is drained for the whole session.
+	updates := make(chan imapclient.Update, 16)
+	wake := make(chan struct{}, 1)
+	c.Updates = updates
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				if _, ok := u.(*imapclient.MailboxUpdate); ok {
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}
+			case <-c.LoggedOut():
+				return
+			}
+		}
+	}()
 
 	if err := c.Login(t.cfg.Username, t.cfg.Password); err != nil {
 		return err
 	}
-
-	if _, err := c.Select(t.cfg.Folder, false); err != nil {
+	mbox, err := c.Select(t.cfg.Folder, false)
+	if err != nil {
 		return err
 	}
+	if mbox.UidValidity != t.validity {
+		if t.validity != 0 {
+			t.log.Info("uidvalidity changed, rescanning folder", "folder", t.cfg.Folder)
+		}
+		t.validity, t.lastUID = mbox.UidValidity, 0
+		t.saveState()
+	}
 
-	seqset := new(imap.SeqSet)
-	seqset.AddRange(1, 0)
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
-	go func() {
-		done <- c.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchBody}, messages)
-	}()
+	useIdle := false
+	if t.cfg.Idle {
+		if useIdle, err = c.Support("IDLE"); err != nil {
+			return err
+		}
+		if !useIdle {
+			t.log.Info("server lacks IDLE, polling", "interval", pollInterval)
+		}
+	}
 
-	for msg := range messages {
-		if msg.Envelope == nil {
-			continue
-		}
-		from := ""
-		if len(msg.Envelope.From) > 0 {
-			from = msg.Envelope.From[0].Address()
-		}
-		var body strings.Builder
-		section := &imap.BodySectionName{}
-		if r := msg.GetBody(section); r != nil {
-			m, _ := message.Read(r)
-			if m != nil {
-				b, _ := io.ReadAll(m.Body)
-				body.Write(b)
+	for {
+		if err := t.fetchNew(ctx, c, inbound); err != nil {
+			return err
+		}
+		if useIdle {
+			err = t.idle(ctx, c, wake)
+		} else {
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				err = ctx.Err()
 			}
 		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-		if !t.allowed(from) {
-			continue
+// idle waits in IDLE until the server reports a mailbox change.
+func (t *Transport) idle(ctx context.Context, c *imapclient.Client, wake <-chan struct{}) error {
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- c.Idle(stop, nil) }()
+	select {
+	case <-wake:
+		close(stop)
+		return <-done
+	case <-ctx.Done():
+		close(stop)
+		<-done
+		return ctx.Err()
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("idle ended unexpectedly")
 		}
-		if body.Len() > t.cfg.MaxBytes {
-			continue
+		return err
+	}
+}
+
+// fetchNew delivers unseen messages with a UID above lastUID, oldest first.
+func (t *Transport) fetchNew(ctx context.Context, c *imapclient.Client, inbound chan<- core.InboundMessage) error {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	if t.lastUID > 0 {
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(t.lastUID+1, 0)
+	}
+	found, err := c.UidSearch(criteria)
+	if err != nil {
+		return err
+	}
+	// "n:*" always matches the highest UID, even one below n.
+	uids := found[:0]
+	for _, uid := range found {
+		if uid > t.lastUID {
+			uids = append(uids, uid)
 		}
+	}
+	slices.Sort(uids)
 
-		inbound <- core.InboundMessage{
-			Transport: t.ID(),
-			Sender:    from,
-			Text:      body.String(),
-			ThreadID:  msg.Envelope.InReplyTo,
-			Meta: map[string]any{
-				"subject":    msg.Envelope.Subject,
-				"message_id": msg.Envelope.MessageId,
-			},
+	for len(uids) > 0 {
+		n := min(len(uids), fetchBatch)
+		msgs, err := t.fetch(c, uids[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := t.handle(ctx, c, msg, inbound); err != nil {
+				return err
+			}
+		}
+		uids = uids[n:]
+	}
+	return nil
+}
+
+func (t *Transport) fetch(c *imapclient.Client, uids []uint32) ([]*imap.Message, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	// PEEK leaves \Seen alone until the message has actually been handed to the runner.
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, section.FetchItem()}
+
+	messages := make(chan *imap.Message, fetchBatch)
+	done := make(chan error, 1)
+	go func() { done <- c.UidFetch(seqset, items, messages) }()
+	var out []*imap.Message
+	for msg := range messages {
+		out = append(out, msg)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	slices.SortFunc(out, func(a, b *imap.Message) int { return cmp.Compare(a.Uid, b.Uid) })
+	return out, nil
+}
+
+// handle delivers one message and marks it processed. Messages that are not delivered
+// (unknown sender, no text, too large) are left untouched in the folder.
+func (t *Transport) handle(ctx context.Context, c *imapclient.Client, msg *imap.Message, inbound chan<- core.InboundMessage) error {
+	in, ok := t.toInbound(msg)
+	if ok {
+		t.threads.Remember(in.ThreadID, emailmsg.ThreadFromMeta(in.Meta))
+		select {
+		case inbound <- in:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	return <-done
+	// Past this point the message is never delivered again, even if flagging it fails.
+	t.advance(msg.Uid)
+	if !ok {
+		return nil
+	}
+	return t.markProcessed(c, msg.Uid)
+}
+
+// toInbound converts a fetched message, reporting false if it should not be delivered.
+func (t *Transport) toInbound(msg *imap.Message) (core.InboundMessage, bool) {
+	if msg.Envelope == nil {
+		return core.InboundMessage{}, false
+	}
+	from := ""
+	if len(msg.Envelope.From) > 0 {
+		from = msg.Envelope.From[0].Address()
+	}
+	if !t.allowed(from) {
+		t.log.Debug("sender not allowed", "uid", msg.Uid)
+		return core.InboundMessage{}, false
+	}
+	r := msg.GetBody(&imap.BodySectionName{Peek: true})
+	if r == nil {
+		t.log.Warn("message body missing", "uid", msg.Uid)
+		return core.InboundMessage{}, false
+	}
+	parsed, err := parseMessage(r, t.cfg.MaxAttachmentBytes)
+	if err != nil {
+		t.log.Warn("parse message failed", "uid", msg.Uid, "err", err)
+		return core.InboundMessage{}, false
+	}
+	text := stripReply(parsed.Text)
+	if len(text) > t.cfg.MaxBytes {
+		t.log.Warn("message too large", "uid", msg.Uid, "bytes", len(text), "max_bytes", t.cfg.MaxBytes)
+		return core.InboundMessage{}, false
+	}
+	refs := emailmsg.ParseMsgIDs(parsed.Header.Get("References"))
+	threadID := emailmsg.ThreadID(msg.Envelope.MessageId, msg.Envelope.InReplyTo, refs)
+	if len(parsed.Skipped) > 0 {
+		t.log.Warn("attachments too large", "uid", msg.Uid, "files", parsed.Skipped, "max_attachment_bytes", t.cfg.MaxAttachmentBytes)
+	}
+	files := t.saveAttachments(msg.Uid, threadID, parsed.Attachments)
+	if text == "" && len(files) == 0 && len(parsed.Skipped) == 0 {
+		t.log.Info("message has no text/plain content", "uid", msg.Uid)
+		return core.InboundMessage{}, false
+	}
+	meta := map[string]any{
+		"subject":     msg.Envelope.Subject,
+		"message_id":  msg.Envelope.MessageId,
+		"in_reply_to": msg.Envelope.InReplyTo,
+		"references":  refs,
+		"uid":         msg.Uid,
+	}
+	if len(files) > 0 {
+		meta[core.MetaAttachments] = files
+	}
+	return core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    from,
+		Text:      emailmsg.AttachmentNote(text, files, parsed.Skipped),
+		ThreadID:  threadID,
+		Meta:      meta,
+	}, true
+}
+
+// saveAttachments stores atts under AttachmentsDir and returns their paths.
+func (t *Transport) saveAttachments(uid uint32, threadID string, atts []emailmsg.Attachment) []string {
+	if len(atts) == 0 {
+		return nil
+	}
+	if t.cfg.AttachmentsDir == "" {
+		t.log.Info("ignoring attachments: no attachments_dir", "uid", uid, "count", len(atts))
+		return nil
+	}
+	files, err := emailmsg.SaveAttachments(t.cfg.AttachmentsDir, threadID, atts)
+	if err != nil {
+		t.log.Warn("save attachments failed", "uid", uid, "err", err)
+	}
+	return files
+}
+
+// markProcessed moves the message to MoveTo, or flags it \Seen.
+func (t *Transport) markProcessed(c *imapclient.Client, uid uint32) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	if t.cfg.MoveTo != "" {
+		return c.UidMove(seqset, t.cfg.MoveTo)
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	return c.UidStore(seqset, item, []interface{}{imap.SeenFlag}, nil)
+}
+
+func (t *Transport) advance(uid uint32) {
+	if uid <= t.lastUID {
+		return
+	}
+	t.lastUID = uid
+	t.saveState()
+}
+
+// stateKey names the folder position in the store; it is saved as "<uidvalidity>:<uid>".
+func (t *Transport) stateKey() string { return "imap:" + t.cfg.ID + ":" + t.cfg.Folder }
+
+func (t *Transport) loadState() {
+	if t.store == nil {
+		return
+	}
+	tok, err := t.store.SyncToken(t.stateKey())
+	if err != nil {
+		t.log.Warn("load imap position failed", "err", err)
+		return
+	}
+	if tok == "" {
+		return
+	}
+	if _, err := fmt.Sscanf(tok, "%d:%d", &t.validity, &t.lastUID); err != nil {
+		t.log.Warn("ignoring malformed imap position", "token", tok)
+		t.validity, t.lastUID = 0, 0
+	}
 }
 
+func (t *Transport) saveState() {
+	if t.store == nil {
+		return
+	}
+	tok := fmt.Sprintf("%d:%d", t.validity, t.lastUID)
+	if err := t.store.SaveSyncToken(t.stateKey(), tok); err != nil {
+		t.log.Warn("save imap position failed", "err", err)
+	}
+}
+
+// SendsAttachments reports that replies are sent whole, with long text and written files
+// as attachments.
+func (t *Transport) SendsAttachments() bool { return true }
+
+// Send mails msg as a reply in its thread: "Re: <subject>", In-Reply-To and References
+// from the latest inbound message, a text/plain + HTML body and any attachments.
 func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
-	auth := smtp.PlainAuth("", t.cfg.Username, t.cfg.Password, t.cfg.SMTPHost)
-	to := []string{msg.Recipient}
-	data := fmt.Sprintf("Subject: buddy reply\r\nIn-Reply-To: %s\r\n\r\n%s", msg.ThreadID, msg.Text)
-	return smtp.SendMail(fmt.Sprintf("%s:%d", t.cfg.SMTPHost, t.cfg.SMTPPort), auth, t.cfg.Username, to, []byte(data))
+	th, ok := t.threads.Lookup(msg.ThreadID)
+	if !ok {
+		// Unknown after a restart; the thread root still lets clients group the reply.
+		th = emailmsg.Thread{MessageID: msg.ThreadID}
+	}
+	files, _ := msg.Meta[core.MetaAttachments].([]string)
+	text, atts := emailmsg.Outgoing(msg.Text, files, t.cfg.AttachAfterChars, t.cfg.MaxAttachmentBytes)
+	raw, _, err := emailmsg.Reply{From: t.cfg.From, To: msg.Recipient, Thread: th, Text: text, Attachments: atts}.Build()
+	if err != nil {
+		return err
+	}
+	return t.sendMail(ctx, msg.Recipient, raw)
+}
+
+// smtpTimeout bounds one SMTP delivery.
+const smtpTimeout = 30 * time.Second
+
+// sendMail delivers raw over SMTP with implicit TLS (SMTPTLS) or STARTTLS when offered.
+// net/smtp refuses to send the password over an unencrypted connection to a remote host.
+func (t *Transport) sendMail(ctx context.Context, to string, raw []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(t.cfg.SMTPHost, strconv.Itoa(t.cfg.SMTPPort))
+	tlsCfg := &tls.Config{ServerName: t.cfg.SMTPHost}
+	var conn net.Conn
+	var err error
+	if t.cfg.SMTPTLS {
+		conn, err = (&tls.Dialer{Config: tlsCfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, t.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer c.Close()
+	if !t.cfg.SMTPTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsCfg); err != nil {
+				return fmt.Errorf("smtp starttls: %w", err)
+			}
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		if err := c.Auth(smtp.PlainAuth("", t.cfg.Username, t.cfg.Password, t.cfg.SMTPHost)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(t.cfg.From); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return c.Quit()
 }
 
 func (t *Transport) allowed(sender string) bool {
@@ -0,0 +1,433 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+// testServer is an in-process IMAP server over the go-imap memory backend (user
+// "username"/"password", INBOX holding one seen message with UID 6) that can push EXISTS
+// updates to idling clients. The memory backend is not safe for concurrent use, so
+// mailbox access from the server and the test goes through mu.
+type testServer struct {
+	*memory.Backend
+	mu      *sync.Mutex
+	updates chan backend.Update
+	addr    string
+}
+
+func (s *testServer) Updates() <-chan backend.Update { return s.updates }
+
+func (s *testServer) Login(info *imap.ConnInfo, username, password string) (backend.User, error) {
+	u, err := s.Backend.Login(info, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return lockedUser{User: u, mu: s.mu}, nil
+}
+
+type lockedUser struct {
+	backend.User
+	mu *sync.Mutex
+}
+
+func (u lockedUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return lockedMailbox{Mailbox: mbox, mu: u.mu}, nil
+}
+
+type lockedMailbox struct {
+	backend.Mailbox
+	mu *sync.Mutex
+}
+
+func (m lockedMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.Status(items)
+}
+
+func (m lockedMailbox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.ListMessages(uid, seqset, items, ch)
+}
+
+func (m lockedMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.SearchMessages(uid, criteria)
+}
+
+func (m lockedMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.CreateMessage(flags, date, body)
+}
+
+func (m lockedMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.UpdateMessagesFlags(uid, seqset, op, flags)
+}
+
+func (m lockedMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.CopyMessages(uid, seqset, dest)
+}
+
+func (m lockedMailbox) Expunge() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.Expunge()
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{Backend: memory.New(), mu: new(sync.Mutex), updates: make(chan backend.Update)}
+	srv := server.New(ts)
+	srv.AllowInsecureAuth = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	ts.addr = ln.Addr().String()
+	return ts
+}
+
+func (s *testServer) inbox(t *testing.T) backend.Mailbox {
+	t.Helper()
+	u, err := s.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	mbox, err := u.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatalf("inbox: %v", err)
+	}
+	return mbox
+}
+
+// deliver appends a raw message to INBOX and, if notify is set, tells idling clients.
+func (s *testServer) deliver(t *testing.T, raw string, notify bool) {
+	t.Helper()
+	mbox := s.inbox(t)
+	if err := mbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if !notify {
+		return
+	}
+	status, err := mbox.Status([]imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	s.updates <- &backend.MailboxUpdate{Update: backend.NewUpdate("username", "INBOX"), MailboxStatus: status}
+}
+
+func (s *testServer) seen(t *testing.T, uid uint32) bool {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, _ := s.Backend.Login(nil, "username", "password")
+	mbox, _ := u.GetMailbox("INBOX")
+	for _, m := range mbox.(*memory.Mailbox).Messages {
+		if m.Uid == uid {
+			return slices.Contains(m.Flags, imap.SeenFlag)
+		}
+	}
+	t.Fatalf("no message with uid %d", uid)
+	return false
+}
+
+func rawMessage(from, subject, body string) string {
+	return strings.ReplaceAll("From: "+from+"\n"+
+		"To: buddy@example.com\n"+
+		"Subject: "+subject+"\n"+
+		"Message-ID: <"+subject+"@example.com>\n"+
+		body, "\n", "\r\n")
+}
+
+func newTestTransport(t *testing.T, ts *testServer, st store.StoreAPI) *Transport {
+	t.Helper()
+	tr, err := New(Config{
+		Host:         "127.0.0.1",
+		Username:     "username",
+		Password:     "password",
+		From:         "buddy@example.com",
+		Idle:         true,
+		AllowSenders: []string{"alice@example.com"},
+	}, st, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	tr.dial = func() (*imapclient.Client, error) { return imapclient.Dial(ts.addr) }
+	return tr
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.New(t.TempDir() + "/state.db")
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+func start(t *testing.T, tr *Transport) (<-chan core.InboundMessage, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	inbound := make(chan core.InboundMessage, 4)
+	done := make(chan struct{})
+	go func() {
+		_ = tr.Start(ctx, inbound)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Start did not return after cancel")
+		}
+	}
+	t.Cleanup(stop)
+	return inbound, stop
+}
+
+func receive(t *testing.T, inbound <-chan core.InboundMessage) core.InboundMessage {
+	t.Helper()
+	select {
+	case msg := <-inbound:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for inbound message")
+	}
+	return core.InboundMessage{}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartDeliversNewMailAndWakesOnIdle(t *testing.T) {
+	ts := newTestServer(t)
+	ts.deliver(t, rawMessage("bob@example.com", "spam", "Content-Type: text/plain\n\nbuy now"), false)
+	ts.deliver(t, rawMessage("alice@example.com", "build",
+		"MIME-Version: 1.0\n"+
+			"Content-Type: multipart/alternative; boundary=b1\n"+
+			"\n"+
+			"--b1\n"+
+			"Content-Type: text/plain; charset=utf-8\n"+
+			"Content-Transfer-Encoding: quoted-printable\n"+
+			"\n"+
+			"Can you check the build? It costs =E2=82=AC5 a run.\n"+
+			"\n"+
+			"On Mon, 1 Jan 2024 at 10:00, Buddy <buddy@example.com> wrote:\n"+
+			"> Earlier reply\n"+
+			"--b1\n"+
+			"Content-Type: text/html\n"+
+			"\n"+
+			"<p>Can you check the build?</p>\n"+
+			"--b1--\n"), false)
+	st := newTestStore(t)
+	tr := newTestTransport(t, ts, st)
+	inbound, _ := start(t, tr)
+
+	msg := receive(t, inbound)
+	if msg.Sender != "alice@example.com" || msg.Text != "Can you check the build? It costs €5 a run." {
+		t.Fatalf("unexpected message from %q: %q", msg.Sender, msg.Text)
+	}
+	if msg.Meta["subject"] != "build" || msg.Meta["uid"] != uint32(8) {
+		t.Fatalf("unexpected meta %v", msg.Meta)
+	}
+	waitFor(t, "uid 8 flagged seen", func() bool { return ts.seen(t, 8) })
+	if ts.seen(t, 7) {
+		t.Fatal("message from a sender not allowed should stay unseen")
+	}
+
+	ts.deliver(t, rawMessage("alice@example.com", "again", "Content-Type: text/plain\n\nsecond one\n-- \nAlice"), true)
+	msg = receive(t, inbound)
+	if msg.Text != "second one" {
+		t.Fatalf("expected idle to pick up the second message, got %q", msg.Text)
+	}
+	waitFor(t, "position saved", func() bool {
+		tok, _ := st.SyncToken(tr.stateKey())
+		return tok == "1:9"
+	})
+}
+
+func TestStartResumesFromSavedUID(t *testing.T) {
+	ts := newTestServer(t)
+	ts.deliver(t, rawMessage("alice@example.com", "old", "Content-Type: text/plain\n\nalready handled"), false)
+	ts.deliver(t, rawMessage("alice@example.com", "new", "Content-Type: text/plain\n\nnot yet"), false)
+	st := newTestStore(t)
+	if err := st.SaveSyncToken("imap:email-imap:INBOX", "1:7"); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	inbound, stop := start(t, newTestTransport(t, ts, st))
+
+	if msg := receive(t, inbound); msg.Text != "not yet" {
+		t.Fatalf("expected only the message past the saved uid, got %q", msg.Text)
+	}
+	stop()
+	if ts.seen(t, 7) {
+		t.Fatal("message at or below the saved uid should not be touched")
+	}
+}
+
+func TestStartRescansWhenUIDValidityChanges(t *testing.T) {
+	ts := newTestServer(t)
+	ts.deliver(t, rawMessage("alice@example.com", "hello", "Content-Type: text/plain\n\nhello"), false)
+	st := newTestStore(t)
+	// A position from a previous incarnation of the folder.
+	if err := st.SaveSyncToken("imap:email-imap:INBOX", "42:100"); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	inbound, _ := start(t, newTestTransport(t, ts, st))
+
+	if msg := receive(t, inbound); msg.Text != "hello" {
+		t.Fatalf("unexpected message %q", msg.Text)
+	}
+}
+
+// fakeSMTP accepts one plaintext SMTP session with AUTH PLAIN and records the message.
+func fakeSMTP(t *testing.T) (addr string, got <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		_ = tc.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO":
+				_ = tc.PrintfLine("250-fake\r\n250 AUTH PLAIN")
+			case "AUTH":
+				_ = tc.PrintfLine("235 ok")
+			case "MAIL", "RCPT":
+				_ = tc.PrintfLine("250 ok")
+			case "DATA":
+				_ = tc.PrintfLine("354 go ahead")
+				data, _ := tc.ReadDotBytes()
+				out <- string(data)
+				_ = tc.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tc.PrintfLine("221 bye")
+				return
+			default:
+				_ = tc.PrintfLine("502 unsupported")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestSendRepliesInThread(t *testing.T) {
+	ts := newTestServer(t)
+	ts.deliver(t, rawMessage("alice@example.com", "build",
+		"In-Reply-To: <root@example.com>\nReferences: <root@example.com>\nContent-Type: text/plain\n\nship it?"), false)
+	tr := newTestTransport(t, ts, nil)
+	addr, got := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+	tr.cfg.SMTPHost = host
+	tr.cfg.SMTPPort, _ = strconv.Atoi(port)
+	inbound, _ := start(t, tr)
+
+	in := receive(t, inbound)
+	if in.ThreadID != "root@example.com" {
+		t.Fatalf("expected thread root, got %q", in.ThreadID)
+	}
+	err := tr.Send(context.Background(), core.OutboundMessage{Recipient: in.Sender, ThreadID: in.ThreadID, Text: "**yes**"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var data string
+	select {
+	case data = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message reached the SMTP server")
+	}
+	for _, want := range []string{
+		"Subject: Re: build",
+		"In-Reply-To: <build@example.com>",
+		"References: <root@example.com> <build@example.com>",
+		"From: <buddy@example.com>",
+		"Content-Type: multipart/alternative",
+		"<strong>yes</strong>",
+	} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("sent message missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestStartSavesAttachmentsPerThread(t *testing.T) {
+	ts := newTestServer(t)
+	ts.deliver(t, rawMessage("alice@example.com", "crash",
+		"Content-Type: multipart/mixed; boundary=m\n\n"+
+			"--m\nContent-Type: text/plain\n\nwhy does this fail?\n"+
+			"--m\nContent-Type: text/plain\nContent-Disposition: attachment; filename=app.log\n\npanic: boom\n"+
+			"--m--\n"), false)
+	tr := newTestTransport(t, ts, nil)
+	tr.cfg.AttachmentsDir = t.TempDir()
+	inbound, _ := start(t, tr)
+
+	in := receive(t, inbound)
+	files, _ := in.Meta[core.MetaAttachments].([]string)
+	want := filepath.Join(tr.cfg.AttachmentsDir, "crash_example.com", "app.log")
+	if len(files) != 1 || files[0] != want {
+		t.Fatalf("attachments = %v, want [%s]", files, want)
+	}
+	if b, err := os.ReadFile(want); err != nil || strings.TrimSpace(string(b)) != "panic: boom" {
+		t.Fatalf("saved file = %q, %v", b, err)
+	}
+	if !strings.Contains(in.Text, "why does this fail?") || !strings.Contains(in.Text, "- "+want) {
+		t.Fatalf("text should list the saved file: %q", in.Text)
+	}
+}
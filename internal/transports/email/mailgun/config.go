@@ -1,6 +1,10 @@
 package mailgun
 
-import "time"
+import (
+	"time"
+
+	"github.com/joelklabo/buddy/internal/transports/email/emailmsg"
+)
 
 // Config holds Mailgun transport settings.
 type Config struct {
@@ -12,9 +16,18 @@ type Config struct {
 	RoutePrefix  string        `yaml:"route_prefix" json:"route_prefix"`
 	Listen       string        `yaml:"listen" json:"listen"`
 	Path         string        `yaml:"path" json:"path"`
+	From         string        `yaml:"from" json:"from"` // reply sender; defaults to buddy@<domain>
 	AllowSenders []string      `yaml:"allow_senders" json:"allow_senders"`
 	MaxBytes     int           `yaml:"max_bytes" json:"max_bytes"`
 	Timeout      time.Duration `yaml:"timeout" json:"timeout"`
+
+	// AttachmentsDir receives inbound attachments, one subdirectory per thread. Without
+	// it attachments are ignored; the app defaults it under the first readfile root.
+	AttachmentsDir string `yaml:"attachments_dir" json:"attachments_dir"`
+	// MaxAttachmentBytes caps each attachment received or sent.
+	MaxAttachmentBytes int64 `yaml:"max_attachment_bytes" json:"max_attachment_bytes"`
+	// AttachAfterChars sends longer replies as a reply.md attachment behind a preview.
+	AttachAfterChars int `yaml:"attach_after_chars" json:"attach_after_chars"`
 }
 
 // Defaults fills missing optional fields.
@@ -37,6 +50,15 @@ func (c *Config) Defaults() {
 	if c.Timeout == 0 {
 		c.Timeout = 10 * time.Second
 	}
+	if c.MaxAttachmentBytes == 0 {
+		c.MaxAttachmentBytes = emailmsg.DefaultMaxAttachmentBytes
+	}
+	if c.AttachAfterChars == 0 {
+		c.AttachAfterChars = emailmsg.DefaultAttachAfterChars
+	}
+	if c.From == "" && c.Domain != "" {
+		c.From = "buddy@" + c.Domain
+	}
 }
 
 func (c *Config) Validate() error {
@@ -1,23 +1,29 @@
 package mailgun
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/transports/email/emailmsg"
 	"sync/atomic"
 )
 
 // Transport implements core.Transport backed by Mailgun inbound webhooks for inbound
 // and Mailgun send API for outbound.
 type Transport struct {
-	cfg    Config
-	client *Client
-	lastOK atomic.Value
+	cfg     Config
+	client  *Client
+	lastOK  atomic.Value
+	threads emailmsg.Threads
 }
 
 // New builds a Transport from Config (call Defaults/Validate upstream).
@@ -75,7 +81,8 @@ func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessag
 // Handler returns an http.Handler that processes Mailgun webhooks.
 func (t *Transport) Handler(inbound chan<- core.InboundMessage) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
+		// Messages with attachments arrive as multipart/form-data.
+		if err := r.ParseMultipartForm(formMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
@@ -100,21 +107,39 @@ func (t *Transport) Handler(inbound chan<- core.InboundMessage) http.Handler {
 			return
 		}
 
-		thread := r.FormValue("In-Reply-To")
-		if thread == "" {
-			thread = r.FormValue("Message-Id")
+		refs := emailmsg.ParseMsgIDs(r.FormValue("References"))
+		threadID := emailmsg.ThreadID(r.FormValue("Message-Id"), r.FormValue("In-Reply-To"), refs)
+		atts, skipped, err := t.attachments(r)
+		if err != nil {
+			http.Error(w, "bad attachment", http.StatusBadRequest)
+			return
 		}
-
-		inbound <- core.InboundMessage{
+		var files []string
+		if len(atts) > 0 && t.cfg.AttachmentsDir != "" {
+			if files, err = emailmsg.SaveAttachments(t.cfg.AttachmentsDir, threadID, atts); err != nil {
+				// Mailgun retries the webhook on a server error.
+				http.Error(w, "saving attachments failed", http.StatusInternalServerError)
+				return
+			}
+		}
+		meta := map[string]any{
+			"subject":     r.FormValue("subject"),
+			"message_id":  r.FormValue("Message-Id"),
+			"in_reply_to": r.FormValue("In-Reply-To"),
+			"references":  refs,
+		}
+		if len(files) > 0 {
+			meta[core.MetaAttachments] = files
+		}
+		in := core.InboundMessage{
 			Transport: t.ID(),
 			Sender:    from,
-			Text:      text,
-			ThreadID:  thread,
-			Meta: map[string]any{
-				"subject":    r.FormValue("subject"),
-				"message_id": r.FormValue("Message-Id"),
-			},
+			Text:      emailmsg.AttachmentNote(text, files, skipped),
+			ThreadID:  threadID,
+			Meta:      meta,
 		}
+		t.threads.Remember(in.ThreadID, emailmsg.ThreadFromMeta(in.Meta))
+		inbound <- in
 
 		t.lastOK.Store(time.Now())
 
@@ -122,16 +147,60 @@ func (t *Transport) Handler(inbound chan<- core.InboundMessage) http.Handler {
 	})
 }
 
-// Send uses Mailgun Messages API.
+// formMemory is how much of a multipart webhook is held in memory; larger attachments are
+// spooled to temporary files by net/http.
+const formMemory = 8 << 20
+
+// attachments reads the attachment-1..attachment-N files of a Mailgun webhook, returning
+// those within MaxAttachmentBytes and the names of the rest.
+func (t *Transport) attachments(r *http.Request) ([]emailmsg.Attachment, []string, error) {
+	if r.MultipartForm == nil {
+		return nil, nil, nil
+	}
+	var atts []emailmsg.Attachment
+	var skipped []string
+	for i := 1; ; i++ {
+		fhs := r.MultipartForm.File["attachment-"+strconv.Itoa(i)]
+		if len(fhs) == 0 {
+			break
+		}
+		fh := fhs[0]
+		if fh.Size > t.cfg.MaxAttachmentBytes {
+			skipped = append(skipped, fh.Filename)
+			continue
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		atts = append(atts, emailmsg.Attachment{Filename: fh.Filename, ContentType: fh.Header.Get("Content-Type"), Data: data})
+	}
+	return atts, skipped, nil
+}
+
+// SendsAttachments reports that replies are sent whole, with long text and written files
+// as attachments.
+func (t *Transport) SendsAttachments() bool { return true }
+
+// Send mails msg as a reply in its thread through the Mailgun MIME API, so the headers
+// (Message-ID, In-Reply-To, References) are exactly the ones built here.
 func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
-	return t.client.Send(ctx, SendRequest{
-		From:       "buddy@" + t.cfg.Domain,
-		To:         msg.Recipient,
-		Subject:    "buddy reply",
-		Text:       msg.Text,
-		InReplyTo:  msg.ThreadID,
-		References: msg.ThreadID,
-	})
+	th, ok := t.threads.Lookup(msg.ThreadID)
+	if !ok {
+		th = emailmsg.Thread{MessageID: msg.ThreadID}
+	}
+	files, _ := msg.Meta[core.MetaAttachments].([]string)
+	text, atts := emailmsg.Outgoing(msg.Text, files, t.cfg.AttachAfterChars, t.cfg.MaxAttachmentBytes)
+	raw, _, err := emailmsg.Reply{From: t.cfg.From, To: msg.Recipient, Thread: th, Text: text, Attachments: atts}.Build()
+	if err != nil {
+		return err
+	}
+	return t.client.SendMIME(ctx, msg.Recipient, raw)
 }
 
 func (t *Transport) allowed(sender string) bool {
@@ -171,38 +240,33 @@ func NewClient(baseURL, domain, apiKey string, timeout time.Duration) *Client {
 	return &Client{baseURL: baseURL, domain: domain, apiKey: apiKey, timeout: timeout}
 }
 
-type SendRequest struct {
-	From       string
-	To         string
-	Subject    string
-	Text       string
-	InReplyTo  string
-	References string
-}
-
-func (c *Client) Send(ctx context.Context, req SendRequest) error {
-	// Minimal implementation uses Mailgun messages endpoint.
-	form := url.Values{}
-	form.Set("from", req.From)
-	form.Set("to", req.To)
-	form.Set("subject", req.Subject)
-	form.Set("text", req.Text)
-	if req.InReplyTo != "" {
-		form.Set("h:In-Reply-To", req.InReplyTo)
+// SendMIME posts a complete RFC 5322 message to the messages.mime endpoint.
+func (c *Client) SendMIME(ctx context.Context, to string, raw []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("to", to); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return err
 	}
-	if req.References != "" {
-		form.Set("h:References", req.References)
+	if _, err := fw.Write(raw); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	endpoint := c.baseURL + "/" + c.domain + "/messages"
-	reqHTTP, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	endpoint := c.baseURL + "/" + c.domain + "/messages.mime"
+	reqHTTP, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
 	if err != nil {
 		return err
 	}
-	reqHTTP.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	reqHTTP.Header.Set("Content-Type", mw.FormDataContentType())
 	reqHTTP.SetBasicAuth("api", c.apiKey)
 
 	resp, err := http.DefaultClient.Do(reqHTTP)
@@ -1,12 +1,19 @@
 package mailgun
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -102,6 +109,171 @@ func TestHandlerRejectsBadSignature(t *testing.T) {
 	}
 }
 
+func TestSendPostsThreadedMIMEReply(t *testing.T) {
+	var path, to, raw string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		to = r.FormValue("to")
+		if f, _, err := r.FormFile("message"); err == nil {
+			b, _ := io.ReadAll(f)
+			raw = string(b)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	cfg := Config{
+		Domain:       "mg.example.com",
+		APIKey:       "test-api",
+		SigningKey:   "test-key",
+		BaseURL:      api.URL,
+		AllowSenders: []string{"alice@example.com"},
+	}
+	cfg.Defaults()
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+
+	inbound := make(chan core.InboundMessage, 1)
+	form := url.Values{}
+	form.Set("timestamp", "1700000000")
+	form.Set("token", "abcdef")
+	form.Set("signature", hmacHex("1700000000", "abcdef", cfg.SigningKey))
+	form.Set("sender", "alice@example.com")
+	form.Set("stripped-text", "status?")
+	form.Set("Message-Id", "<m2@example.com>")
+	form.Set("In-Reply-To", "<m1@example.com>")
+	form.Set("References", "<m1@example.com>")
+	form.Set("subject", "Re: nightly")
+	req := httptest.NewRequest(http.MethodPost, cfg.Path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tr.Handler(inbound).ServeHTTP(httptest.NewRecorder(), req)
+	in := <-inbound
+	if in.ThreadID != "m1@example.com" {
+		t.Fatalf("expected thread root, got %q", in.ThreadID)
+	}
+
+	if err := tr.Send(context.Background(), core.OutboundMessage{Recipient: in.Sender, ThreadID: in.ThreadID, Text: "all green"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if path != "/mg.example.com/messages.mime" || to != "alice@example.com" {
+		t.Fatalf("unexpected request to %s for %q", path, to)
+	}
+	for _, want := range []string{
+		"Subject: Re: nightly",
+		"From: <buddy@mg.example.com>",
+		"In-Reply-To: <m2@example.com>",
+		"References: <m1@example.com> <m2@example.com>",
+		"Message-Id: <",
+		"Content-Type: multipart/alternative",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Fatalf("mime message missing %q:\n%s", want, raw)
+		}
+	}
+}
+
+func TestHandlerSavesMultipartAttachments(t *testing.T) {
+	cfg := Config{
+		Domain:             "mg.example.com",
+		APIKey:             "test-api",
+		SigningKey:         "test-key",
+		AllowSenders:       []string{"alice@example.com"},
+		AttachmentsDir:     t.TempDir(),
+		MaxAttachmentBytes: 16,
+	}
+	cfg.Defaults()
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range map[string]string{
+		"timestamp":        "1700000000",
+		"token":            "abcdef",
+		"signature":        hmacHex("1700000000", "abcdef", cfg.SigningKey),
+		"sender":           "alice@example.com",
+		"stripped-text":    "see log",
+		"Message-Id":       "<m3@example.com>",
+		"attachment-count": "2",
+	} {
+		_ = mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("attachment-1", "app.log")
+	_, _ = fw.Write([]byte("panic: boom"))
+	fw, _ = mw.CreateFormFile("attachment-2", "core.dump")
+	_, _ = fw.Write(bytes.Repeat([]byte("x"), 64))
+	_ = mw.Close()
+
+	inbound := make(chan core.InboundMessage, 1)
+	req := httptest.NewRequest(http.MethodPost, cfg.Path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	tr.Handler(inbound).ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+
+	in := <-inbound
+	want := filepath.Join(cfg.AttachmentsDir, "m3_example.com", "app.log")
+	files, _ := in.Meta[core.MetaAttachments].([]string)
+	if len(files) != 1 || files[0] != want {
+		t.Fatalf("attachments = %v, want [%s]", files, want)
+	}
+	if b, err := os.ReadFile(want); err != nil || string(b) != "panic: boom" {
+		t.Fatalf("saved file = %q, %v", b, err)
+	}
+	if !strings.Contains(in.Text, "- "+want) || !strings.Contains(in.Text, "too large to keep: core.dump") {
+		t.Fatalf("unexpected text: %q", in.Text)
+	}
+}
+
+func TestSendAttachesWrittenFiles(t *testing.T) {
+	var raw string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, _, err := r.FormFile("message"); err == nil {
+			b, _ := io.ReadAll(f)
+			raw = string(b)
+		}
+	}))
+	t.Cleanup(api.Close)
+	cfg := Config{
+		Domain:       "mg.example.com",
+		APIKey:       "test-api",
+		SigningKey:   "test-key",
+		BaseURL:      api.URL,
+		AllowSenders: []string{"alice@example.com"},
+	}
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+	report := filepath.Join(t.TempDir(), "report.csv")
+	_ = os.WriteFile(report, []byte("a,b\n1,2\n"), 0o600)
+
+	err = tr.Send(context.Background(), core.OutboundMessage{
+		Recipient: "alice@example.com",
+		Text:      "report attached",
+		Meta:      map[string]any{core.MetaAttachments: []string{report}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for _, want := range []string{
+		"Content-Type: multipart/mixed",
+		"Content-Disposition: attachment; filename=report.csv",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Fatalf("mime message missing %q:\n%s", want, raw)
+		}
+	}
+}
+
 func hmacHex(ts, token, key string) string {
 	return hexEncode(ts, token, key)
 }
@@ -0,0 +1,245 @@
+// Package http implements a generic REST transport for scripts and CI: callers POST a
+// message, then wait on the same request for the reply or poll the returned job.
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// Job states.
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusExpired = "expired" // no reply within wait_timeout_seconds
+)
+
+// Config for the HTTP transport. At least one of BearerToken and HMACSecret is required;
+// when both are set either form of authentication is accepted.
+type Config struct {
+	ID              string   `json:"id"`
+	Listen          string   `json:"listen"`               // ":8086"
+	Path            string   `json:"path"`                 // "/messages"
+	BearerToken     string   `json:"bearer_token"`         // Authorization: Bearer <token>
+	HMACSecret      string   `json:"hmac_secret"`          // X-Buddy-Signature over timestamp, method, path, body
+	AllowedSenders  []string `json:"allowed_senders"`      // optional; empty allows any authenticated caller
+	WaitTimeoutSecs int      `json:"wait_timeout_seconds"` // how long a job may wait for its reply (300)
+	JobTTLMins      int      `json:"job_ttl_minutes"`      // how long jobs stay pollable (60)
+}
+
+// Transport implements core.Transport for the REST API.
+type Transport struct {
+	cfg Config
+	log *slog.Logger
+
+	addrMu sync.RWMutex
+	addr   string
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	pending map[string]*job // conversation -> job waiting for its reply
+	last    map[string]*job // conversation -> job that was answered (or expired) last
+}
+
+// job is one POSTed message and the replies sent to it.
+type job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Sender    string    `json:"sender"`
+	ThreadID  string    `json:"thread_id"`
+	Replies   []string  `json:"replies"`
+	Progress  string    `json:"progress,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	done chan struct{}
+}
+
+func New(cfg Config, logger *slog.Logger) (*Transport, error) {
+	if cfg.ID == "" {
+		cfg.ID = "http"
+	}
+	if cfg.BearerToken == "" && cfg.HMACSecret == "" {
+		return nil, errors.New("http: bearer_token or hmac_secret required")
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8086"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/messages"
+	}
+	if cfg.WaitTimeoutSecs <= 0 {
+		cfg.WaitTimeoutSecs = 300
+	}
+	if cfg.JobTTLMins <= 0 {
+		cfg.JobTTLMins = 60
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Transport{
+		cfg:     cfg,
+		log:     logger.With("transport", "http"),
+		jobs:    make(map[string]*job),
+		pending: make(map[string]*job),
+		last:    make(map[string]*job),
+	}, nil
+}
+
+func (t *Transport) ID() string { return t.cfg.ID }
+
+// AcceptsProgress reports that progress updates are kept as the waiting job's progress line.
+func (t *Transport) AcceptsProgress() bool { return true }
+
+// WholeReplies reports that replies are stored whole on the job, unsplit.
+func (t *Transport) WholeReplies(string) bool { return true }
+
+// Send records msg on the job waiting in its conversation. The runner's final message
+// completes the job; approval prompts and other notices before it are recorded while the
+// job stays pending. Messages that follow are appended to the job that was answered last.
+// Progress updates only refresh the waiting job's progress line.
+func (t *Transport) Send(_ context.Context, msg core.OutboundMessage) error {
+	key := conversationKey(msg.Recipient, msg.ThreadID)
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	j, waiting := t.pending[key]
+	if progress, _ := msg.Meta["progress"].(bool); progress {
+		if waiting {
+			j.Progress, j.UpdatedAt = msg.Text, now
+		}
+		return nil
+	}
+	if !waiting {
+		if j = t.last[key]; j == nil {
+			t.log.Warn("dropping reply with no job", "recipient", msg.Recipient, "thread", msg.ThreadID)
+			return nil
+		}
+	}
+	j.Replies = append(j.Replies, msg.Text)
+	j.UpdatedAt = now
+	if final, _ := msg.Meta[core.MetaFinal].(bool); waiting && final {
+		delete(t.pending, key)
+		close(j.done)
+		t.last[key] = j
+		j.Status, j.Progress = StatusDone, ""
+	}
+	return nil
+}
+
+// errThreadBusy rejects a job for a conversation that is still waiting on a reply:
+// replies carry no job ID, so only one job per thread can be outstanding.
+var errThreadBusy = errors.New("thread has a pending job")
+
+// newJob registers a pending job for sender in thread; an empty thread becomes the job ID,
+// so each job is its own conversation.
+func (t *Transport) newJob(sender, thread string) (*job, error) {
+	id := newJobID()
+	if thread == "" {
+		thread = id
+	}
+	now := time.Now()
+	key := conversationKey(sender, thread)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	if _, busy := t.pending[key]; busy {
+		return nil, errThreadBusy
+	}
+	j := &job{
+		ID:        id,
+		Status:    StatusPending,
+		Sender:    sender,
+		ThreadID:  thread,
+		Replies:   []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+		done:      make(chan struct{}),
+	}
+	t.jobs[id] = j
+	t.pending[key] = j
+	return j, nil
+}
+
+// dropJob forgets a job whose message never reached the runner.
+func (t *Transport) dropJob(j *job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.jobs, j.ID)
+	key := conversationKey(j.Sender, j.ThreadID)
+	if t.pending[key] == j {
+		delete(t.pending, key)
+	}
+}
+
+// snapshot returns a copy of job id that is safe to encode outside the lock.
+func (t *Transport) snapshot(id string) (job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	j, ok := t.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	out := *j
+	out.Replies = append([]string{}, j.Replies...)
+	return out, true
+}
+
+// expire fails jobs that got no reply within the wait timeout (the runner sends nothing
+// when the agent errors) and forgets jobs untouched for longer than the TTL.
+// A reply that arrives after expiry is still recorded. Callers hold t.mu.
+func (t *Transport) expire(now time.Time) {
+	wait := time.Duration(t.cfg.WaitTimeoutSecs) * time.Second
+	ttl := time.Duration(t.cfg.JobTTLMins) * time.Minute
+	for key, j := range t.pending {
+		if now.Sub(j.CreatedAt) > wait {
+			j.Status, j.UpdatedAt = StatusExpired, now
+			close(j.done)
+			delete(t.pending, key)
+			t.last[key] = j
+		}
+	}
+	for id, j := range t.jobs {
+		if j.Status == StatusPending || now.Sub(j.UpdatedAt) <= ttl {
+			continue
+		}
+		delete(t.jobs, id)
+		key := conversationKey(j.Sender, j.ThreadID)
+		if t.last[key] == j {
+			delete(t.last, key)
+		}
+	}
+}
+
+func conversationKey(sender, thread string) string {
+	return sender + "\x00" + thread
+}
+
+func newJobID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func init() {
+	transport.MustRegister("http", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("http: invalid config type %T", cfg)
+		}
+		return New(c, nil)
+	})
+}
@@ -0,0 +1,176 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+func newTestServer(t *testing.T, cfg Config) (*Transport, *httptest.Server, chan core.InboundMessage) {
+	t.Helper()
+	tr, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	inbound := make(chan core.InboundMessage, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(tr.Handler(ctx, inbound))
+	t.Cleanup(func() {
+		cancel()
+		srv.Close()
+	})
+	return tr, srv, inbound
+}
+
+func do(t *testing.T, req *nethttp.Request) (int, job) {
+	t.Helper()
+	resp, err := nethttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var j job
+	_ = json.NewDecoder(resp.Body).Decode(&j)
+	return resp.StatusCode, j
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{}, nil); err == nil {
+		t.Fatalf("expected error without credentials")
+	}
+	tr, err := New(Config{HMACSecret: "s"}, nil)
+	if err != nil || tr.ID() != "http" || tr.cfg.Path != "/messages" {
+		t.Fatalf("unexpected defaults %+v err=%v", tr, err)
+	}
+}
+
+func TestPostWaitReturnsReply(t *testing.T) {
+	tr, srv, inbound := newTestServer(t, Config{BearerToken: "tok"})
+
+	go func() {
+		msg := <-inbound
+		ctx := context.Background()
+		_ = tr.Send(ctx, core.OutboundMessage{Recipient: msg.Sender, ThreadID: msg.ThreadID, Text: "running: go test", Meta: map[string]any{"progress": true}})
+		_ = tr.Send(ctx, core.OutboundMessage{Recipient: msg.Sender, ThreadID: msg.ThreadID, Text: "echo: " + msg.Text + " " + msg.Meta["build"].(string), Meta: map[string]any{core.MetaFinal: true}})
+	}()
+
+	body := `{"sender":"ci","text":"triage","meta":{"build":"42"}}`
+	req, _ := nethttp.NewRequest(nethttp.MethodPost, srv.URL+"/messages?wait=true", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer tok")
+	status, j := do(t, req)
+	if status != nethttp.StatusOK || j.Status != StatusDone || j.Sender != "ci" || j.ThreadID != j.ID {
+		t.Fatalf("unexpected response %d %+v", status, j)
+	}
+	if len(j.Replies) != 1 || j.Replies[0] != "echo: triage 42" || j.Progress != "" {
+		t.Fatalf("unexpected replies %+v", j)
+	}
+
+	req, _ = nethttp.NewRequest(nethttp.MethodPost, srv.URL+"/messages", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer wrong")
+	if status, _ := do(t, req); status != nethttp.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad token, got %d", status)
+	}
+}
+
+func TestPostThenPollWithHMAC(t *testing.T) {
+	tr, srv, inbound := newTestServer(t, Config{HMACSecret: "secret", AllowedSenders: []string{"ci"}})
+
+	signed := func(method, path, body string) *nethttp.Request {
+		req, _ := nethttp.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Buddy-Timestamp", ts)
+		req.Header.Set("X-Buddy-Signature", sign("secret", ts, method, path, []byte(body)))
+		return req
+	}
+
+	status, j := do(t, signed(nethttp.MethodPost, "/messages", `{"sender":"ci","text":"first","thread_id":"build-7"}`))
+	if status != nethttp.StatusAccepted || j.Status != StatusPending || j.ID == "" {
+		t.Fatalf("unexpected response %d %+v", status, j)
+	}
+	msg := <-inbound
+	if msg.Transport != "http" || msg.ThreadID != "build-7" || msg.Meta["job_id"] != j.ID {
+		t.Fatalf("unexpected inbound %+v", msg)
+	}
+	if status, _ := do(t, signed(nethttp.MethodGet, "/messages/"+j.ID, "")); status != nethttp.StatusAccepted {
+		t.Fatalf("expected pending job, got %d", status)
+	}
+
+	// Replies carry no job ID, so a thread takes one job at a time.
+	if status, _ := do(t, signed(nethttp.MethodPost, "/messages", `{"sender":"ci","text":"second","thread_id":"build-7"}`)); status != nethttp.StatusConflict {
+		t.Fatalf("expected 409 while the thread is busy, got %d", status)
+	}
+	// An approval prompt is recorded but leaves the job pending; the final reply completes it.
+	_ = tr.Send(context.Background(), core.OutboundMessage{Recipient: "ci", ThreadID: "build-7", Text: "Approval needed: shell"})
+	status, got := do(t, signed(nethttp.MethodGet, "/messages/"+j.ID, ""))
+	if status != nethttp.StatusAccepted || got.Status != StatusPending || len(got.Replies) != 1 {
+		t.Fatalf("prompt should not complete the job: %d %+v", status, got)
+	}
+	_ = tr.Send(context.Background(), core.OutboundMessage{Recipient: "ci", ThreadID: "build-7", Text: "done", Meta: map[string]any{core.MetaFinal: true}})
+	status, got = do(t, signed(nethttp.MethodGet, "/messages/"+j.ID, ""))
+	if status != nethttp.StatusOK || got.Status != StatusDone || len(got.Replies) != 2 || got.Replies[1] != "done" {
+		t.Fatalf("unexpected first job %d %+v", status, got)
+	}
+
+	// A job that never gets a reply expires after the wait timeout.
+	status, j2 := do(t, signed(nethttp.MethodPost, "/messages", `{"sender":"ci","text":"second","thread_id":"build-7"}`))
+	if status != nethttp.StatusAccepted {
+		t.Fatalf("expected second job to be accepted, got %d", status)
+	}
+	<-inbound
+	tr.mu.Lock()
+	tr.expire(time.Now().Add(time.Duration(tr.cfg.WaitTimeoutSecs+1) * time.Second))
+	tr.mu.Unlock()
+	if status, got := do(t, signed(nethttp.MethodGet, "/messages/"+j2.ID, "")); status != nethttp.StatusOK || got.Status != StatusExpired {
+		t.Fatalf("expected expired job, got %d %+v", status, got)
+	}
+
+	if status, _ := do(t, signed(nethttp.MethodGet, "/messages/missing", "")); status != nethttp.StatusNotFound {
+		t.Fatalf("expected 404, got %d", status)
+	}
+	if status, _ := do(t, signed(nethttp.MethodPost, "/messages", `{"sender":"mallory","text":"hi"}`)); status != nethttp.StatusForbidden {
+		t.Fatalf("expected 403 for sender outside allowlist, got %d", status)
+	}
+	req := signed(nethttp.MethodPost, "/messages", `{"sender":"ci","text":"hi"}`)
+	req.Header.Set("X-Buddy-Timestamp", "1")
+	if status, _ := do(t, req); status != nethttp.StatusUnauthorized {
+		t.Fatalf("expected 401 for stale signature, got %d", status)
+	}
+}
+
+// longAgent answers with a reply far over the runner's split limit.
+type longAgent struct{}
+
+func (longAgent) Generate(ctx context.Context, req core.AgentRequest) (core.AgentResponse, error) {
+	return core.AgentResponse{Reply: strings.Repeat("line of output\n", 50) + "end"}, nil
+}
+
+func TestWaitingCallerGetsWholeReply(t *testing.T) {
+	tr, err := New(Config{BearerToken: "tok", Listen: "127.0.0.1:0"}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	r := core.NewRunner([]core.Transport{tr}, longAgent{}, nil, nil, core.WithMaxReplyChars(100), core.WithMaxReplyParts(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Start(ctx) }()
+	for deadline := time.Now().Add(2 * time.Second); tr.Addr() == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req, _ := nethttp.NewRequest(nethttp.MethodPost, "http://"+tr.Addr()+"/messages?wait=true", bytes.NewBufferString(`{"sender":"ci","text":"build log"}`))
+	req.Header.Set("Authorization", "Bearer tok")
+	status, j := do(t, req)
+	if status != nethttp.StatusOK || j.Status != StatusDone || len(j.Replies) != 1 || !strings.HasSuffix(j.Replies[0], "end") {
+		t.Fatalf("expected the whole reply in one piece, got %d %+v", status, j)
+	}
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// maxClockSkew bounds the age of a signed request to limit replays.
+const maxClockSkew = 5 * time.Minute
+
+// maxBodyBytes caps a POSTed message.
+const maxBodyBytes = 1 << 20
+
+// request is the body of POST /messages.
+type request struct {
+	Sender   string         `json:"sender"`
+	Text     string         `json:"text"`
+	ThreadID string         `json:"thread_id"`
+	Meta     map[string]any `json:"meta"`
+}
+
+// Start serves the REST API until ctx is done.
+func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	srv := &nethttp.Server{
+		Handler:           t.Handler(ctx, inbound),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ln, err := net.Listen("tcp", t.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	t.addrMu.Lock()
+	t.addr = ln.Addr().String()
+	t.addrMu.Unlock()
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Waiting requests hold their connection until the reply; don't block shutdown on them.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Addr returns the listen address (for tests).
+func (t *Transport) Addr() string {
+	t.addrMu.RLock()
+	defer t.addrMu.RUnlock()
+	return t.addr
+}
+
+// Handler serves POST {path} to submit a message and GET {path}/{id} to poll its job.
+func (t *Transport) Handler(ctx context.Context, inbound chan<- core.InboundMessage) nethttp.Handler {
+	mux := nethttp.NewServeMux()
+	base := strings.TrimRight(t.cfg.Path, "/")
+	mux.HandleFunc("POST "+base, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		t.handlePost(ctx, w, r, inbound)
+	})
+	mux.HandleFunc("GET "+base+"/{id}", t.handleGet)
+	return mux
+}
+
+func (t *Transport) handlePost(ctx context.Context, w nethttp.ResponseWriter, r *nethttp.Request, inbound chan<- core.InboundMessage) {
+	body, err := io.ReadAll(nethttp.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, nethttp.StatusBadRequest, "request body too large or unreadable")
+		return
+	}
+	if !t.authorized(r, body, time.Now()) {
+		writeError(w, nethttp.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, nethttp.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		writeError(w, nethttp.StatusBadRequest, "text required")
+		return
+	}
+	if req.Sender == "" {
+		req.Sender = t.ID()
+	}
+	if len(t.cfg.AllowedSenders) > 0 && !contains(t.cfg.AllowedSenders, req.Sender) {
+		t.log.Warn("rejecting sender not in allowlist", "sender", req.Sender)
+		writeError(w, nethttp.StatusForbidden, "sender not allowed")
+		return
+	}
+
+	j, err := t.newJob(req.Sender, req.ThreadID)
+	if err != nil {
+		writeError(w, nethttp.StatusConflict, err.Error())
+		return
+	}
+	meta := make(map[string]any, len(req.Meta)+1)
+	for k, v := range req.Meta {
+		meta[k] = v
+	}
+	meta["job_id"] = j.ID
+	msg := core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    req.Sender,
+		Text:      req.Text,
+		ThreadID:  j.ThreadID,
+		Meta:      meta,
+	}
+	select {
+	case inbound <- msg:
+	case <-r.Context().Done():
+		t.dropJob(j)
+		return
+	case <-ctx.Done():
+		t.dropJob(j)
+		writeError(w, nethttp.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		timer := time.NewTimer(time.Duration(t.cfg.WaitTimeoutSecs)*time.Second + time.Second)
+		defer timer.Stop()
+		select {
+		case <-j.done:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+		}
+	}
+	t.writeJob(w, j.ID)
+}
+
+func (t *Transport) handleGet(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if !t.authorized(r, nil, time.Now()) {
+		writeError(w, nethttp.StatusUnauthorized, "unauthorized")
+		return
+	}
+	t.writeJob(w, r.PathValue("id"))
+}
+
+// writeJob answers 200 once a job has finished (done or expired) and 202 while it is pending.
+func (t *Transport) writeJob(w nethttp.ResponseWriter, id string) {
+	j, ok := t.snapshot(id)
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "job not found")
+		return
+	}
+	status := nethttp.StatusOK
+	if j.Status == StatusPending {
+		status = nethttp.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+func writeError(w nethttp.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// authorized accepts a matching bearer token or a valid HMAC signature.
+func (t *Transport) authorized(r *nethttp.Request, body []byte, now time.Time) bool {
+	if t.cfg.BearerToken != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(t.cfg.BearerToken)) == 1 {
+			return true
+		}
+	}
+	if t.cfg.HMACSecret != "" {
+		return verifySignature(t.cfg.HMACSecret, r, body, now)
+	}
+	return false
+}
+
+// verifySignature checks X-Buddy-Signature:
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + method + "." + path + "." + body)),
+// with the unix timestamp from X-Buddy-Timestamp.
+func verifySignature(secret string, r *nethttp.Request, body []byte, now time.Time) bool {
+	ts := r.Header.Get("X-Buddy-Timestamp")
+	sig := r.Header.Get("X-Buddy-Signature")
+	if ts == "" || sig == "" {
+		return false
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(sign(secret, ts, r.Method, r.URL.Path, body)))
+}
+
+func sign(secret, ts, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + method + "." + path + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,905 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/transports/matrix/olm"
+)
+
+// Algorithms used in end-to-end encrypted rooms.
+const (
+	algOlm    = "m.olm.v1.curve25519-aes-sha2"
+	algMegolm = "m.megolm.v1.aes-sha2"
+)
+
+const (
+	// More one-time keys are uploaded when the server holds fewer than minOneTimeKeys.
+	minOneTimeKeys  = 25
+	oneTimeKeyBatch = 50
+
+	// The bot's room key is replaced after this many messages or this long (the
+	// m.room.encryption defaults), or when a device it was shared with goes away.
+	rotateMessages = 100
+	rotatePeriod   = 7 * 24 * time.Hour
+
+	// maxSessionsPerDevice bounds the Olm sessions kept for one peer device.
+	maxSessionsPerDevice = 8
+
+	// Encrypted messages whose room key has not arrived wait up to pendingTTL, at most maxPending of them.
+	pendingTTL = 10 * time.Minute
+	maxPending = 100
+)
+
+// errNoRoomKey marks an encrypted event whose Megolm session the bot has not received (yet).
+var errNoRoomKey = errors.New("matrix: room key not received")
+
+// cryptoStore is implemented by stores that can keep end-to-end encryption state (store.Store does).
+type cryptoStore interface {
+	CryptoState(key string) ([]byte, error)
+	SaveCryptoState(key string, state []byte) error
+}
+
+// cryptoState is the persisted encryption state of the bot's device.
+type cryptoState struct {
+	UserID   string                    `json:"user_id"`
+	DeviceID string                    `json:"device_id"`
+	Account  *olm.Account              `json:"account"`
+	Uploaded bool                      `json:"uploaded"` // device keys published
+	Sessions map[string][]*olm.Session `json:"sessions"` // peer Curve25519 key -> sessions, most recently used last
+	Inbound  map[string]*inboundGroup  `json:"inbound"`  // "room|session_id"
+	Outbound map[string]*outboundGroup `json:"outbound"` // room ID
+}
+
+// inboundGroup is a Megolm session another device shared with the bot.
+type inboundGroup struct {
+	Session   *olm.InboundGroupSession `json:"session"`
+	Sender    string                   `json:"sender"`     // user whose device shared it
+	SenderKey string                   `json:"sender_key"` // Curve25519 key of that device
+}
+
+// outboundGroup is the Megolm session the bot encrypts a room's messages with.
+type outboundGroup struct {
+	Session    *olm.OutboundGroupSession `json:"session"`
+	Created    time.Time                 `json:"created"`
+	SharedWith map[string]bool           `json:"shared_with"` // "user|device"
+}
+
+// device is a peer device whose keys carry a valid self-signature.
+type device struct {
+	UserID, DeviceID, Curve25519, Ed25519 string
+}
+
+func (d device) key() string { return d.UserID + "|" + d.DeviceID }
+
+// pendingEvent is an encrypted event waiting for its room key.
+type pendingEvent struct {
+	room string
+	ev   roomEvent
+	at   time.Time
+}
+
+// olmMachine holds the bot device's keys and sessions and does the Olm and Megolm work
+// for encrypted rooms. Devices are trusted on their self-signature; cross-signing is not checked.
+type olmMachine struct {
+	t        *Transport
+	store    cryptoStore
+	key      string
+	userID   string
+	deviceID string
+
+	shareMu sync.Mutex // serializes room key sharing for sends
+
+	mu      sync.Mutex
+	state   cryptoState
+	devices map[string]map[string]device // user -> device ID -> keys
+	tracked map[string]bool              // users whose device list is current
+	seen    map[string]string            // "room|session|index" -> event ID, to catch replays
+	pending []pendingEvent
+}
+
+// setupEncryption loads (or creates and uploads) the bot device's encryption keys. When
+// that is impossible a configured room with encryption turned on is an error.
+func (t *Transport) setupEncryption(ctx context.Context) error {
+	t.mu.Lock()
+	userID, deviceID := t.userID, t.deviceID
+	t.mu.Unlock()
+	cs, ok := t.store.(cryptoStore)
+	switch {
+	case !ok:
+		t.noCrypto = "the transport has no state store to keep encryption keys in"
+	case deviceID == "":
+		t.noCrypto = "the access token has no device ID"
+	default:
+		m, err := newOlmMachine(ctx, t, cs, userID, deviceID)
+		if err != nil {
+			return err
+		}
+		t.crypto.Store(m)
+		return nil
+	}
+	for room := range t.rooms {
+		encrypted, err := t.roomEncrypted(ctx, room)
+		if err != nil {
+			t.log.Warn("cannot check room encryption", "room", room, "err", err)
+			continue
+		}
+		if encrypted {
+			return fmt.Errorf("matrix: room %s is end-to-end encrypted but encryption is unavailable: %s", room, t.noCrypto)
+		}
+	}
+	return nil
+}
+
+func newOlmMachine(ctx context.Context, t *Transport, cs cryptoStore, userID, deviceID string) (*olmMachine, error) {
+	m := &olmMachine{
+		t:        t,
+		store:    cs,
+		key:      "matrix:" + t.cfg.ID,
+		userID:   userID,
+		deviceID: deviceID,
+		devices:  make(map[string]map[string]device),
+		tracked:  make(map[string]bool),
+		seen:     make(map[string]string),
+	}
+	raw, err := cs.CryptoState(m.key)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: load encryption state: %w", err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &m.state); err != nil {
+			return nil, fmt.Errorf("matrix: load encryption state: %w", err)
+		}
+	}
+	if m.state.Account == nil || m.state.UserID != userID || m.state.DeviceID != deviceID {
+		if m.state.Account != nil {
+			t.log.Warn("access token belongs to another device; creating new encryption keys", "device", deviceID)
+		}
+		acct, err := olm.NewAccount()
+		if err != nil {
+			return nil, err
+		}
+		m.state = cryptoState{UserID: userID, DeviceID: deviceID, Account: acct}
+	}
+	if m.state.Sessions == nil {
+		m.state.Sessions = make(map[string][]*olm.Session)
+	}
+	if m.state.Inbound == nil {
+		m.state.Inbound = make(map[string]*inboundGroup)
+	}
+	if m.state.Outbound == nil {
+		m.state.Outbound = make(map[string]*outboundGroup)
+	}
+	have := -1
+	if !m.state.Uploaded {
+		have = 0
+	}
+	if err := m.shareKeys(ctx, have); err != nil {
+		return nil, fmt.Errorf("matrix: upload encryption keys: %w", err)
+	}
+	return m, nil
+}
+
+func (m *olmMachine) curve25519() string { return m.state.Account.Curve25519() }
+func (m *olmMachine) ed25519() string    { return m.state.Account.Ed25519() }
+
+// saveLocked persists the state; failures are logged since the sessions still work in memory.
+func (m *olmMachine) saveLocked() {
+	raw, err := json.Marshal(m.state)
+	if err == nil {
+		err = m.store.SaveCryptoState(m.key, raw)
+	}
+	if err != nil {
+		m.t.log.Warn("save encryption state failed", "err", err)
+	}
+}
+
+// shareKeys uploads the device keys once and tops up one-time keys when the server holds
+// fewer than minOneTimeKeys. have is the server's count, or -1 to only upload pending keys.
+func (m *olmMachine) shareKeys(ctx context.Context, have int) error {
+	m.mu.Lock()
+	if have >= 0 && have < minOneTimeKeys {
+		if err := m.state.Account.GenerateOneTimeKeys(oneTimeKeyBatch - have); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	body, err := m.keysToUpload()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	if err := m.t.do(ctx, http.MethodPost, "/_matrix/client/v3/keys/upload", nil, body, nil); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Uploaded = true
+	m.state.Account.MarkKeysAsPublished()
+	m.saveLocked()
+	return nil
+}
+
+// keysToUpload builds the /keys/upload body: the device keys until they are published,
+// and the one-time keys not uploaded yet.
+func (m *olmMachine) keysToUpload() (map[string]any, error) {
+	body := map[string]any{}
+	if !m.state.Uploaded {
+		dk, err := m.signed(map[string]any{
+			"user_id":    m.userID,
+			"device_id":  m.deviceID,
+			"algorithms": []string{algOlm, algMegolm},
+			"keys": map[string]string{
+				"curve25519:" + m.deviceID: m.curve25519(),
+				"ed25519:" + m.deviceID:    m.ed25519(),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		body["device_keys"] = dk
+	}
+	otks := map[string]any{}
+	for id, key := range m.state.Account.UnpublishedOneTimeKeys() {
+		otk, err := m.signed(map[string]any{"key": key})
+		if err != nil {
+			return nil, err
+		}
+		otks["signed_curve25519:"+id] = otk
+	}
+	if len(otks) > 0 {
+		body["one_time_keys"] = otks
+	}
+	return body, nil
+}
+
+// signed adds the device's signature over obj's canonical JSON.
+func (m *olmMachine) signed(obj map[string]any) (map[string]any, error) {
+	canon, err := canonicalJSON(obj)
+	if err != nil {
+		return nil, err
+	}
+	obj["signatures"] = map[string]any{
+		m.userID: map[string]string{"ed25519:" + m.deviceID: m.state.Account.Sign(canon)},
+	}
+	return obj, nil
+}
+
+// canonicalJSON encodes v the way Matrix signs JSON: sorted keys, no insignificant
+// whitespace, and without the signatures and unsigned members.
+func canonicalJSON(v any) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	delete(obj, "signatures")
+	delete(obj, "unsigned")
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// verifySigned checks the signature a device made over a signed JSON object.
+func verifySigned(raw json.RawMessage, userID, deviceID, ed25519Key string) error {
+	var obj struct {
+		Signatures map[string]map[string]string `json:"signatures"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return err
+	}
+	sig, err := olm.Decode(obj.Signatures[userID]["ed25519:"+deviceID])
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("missing or malformed signature")
+	}
+	pub, err := olm.Decode(ed25519Key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("malformed signing key")
+	}
+	canon, err := canonicalJSON(raw)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, canon, sig) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// handleSync processes the encryption parts of a sync batch: device list changes, keys
+// sent to the bot's device, and the server's one-time key count.
+func (m *olmMachine) handleSync(ctx context.Context, res syncResponse) {
+	m.mu.Lock()
+	for _, u := range res.DeviceLists.Changed {
+		m.tracked[u] = false
+	}
+	for _, u := range res.DeviceLists.Left {
+		delete(m.tracked, u)
+		delete(m.devices, u)
+	}
+	m.mu.Unlock()
+
+	for _, ev := range res.ToDevice.Events {
+		if err := m.handleToDevice(ctx, ev); err != nil {
+			m.t.log.Warn("rejecting to-device event", "type", ev.Type, "sender", ev.Sender, "err", err)
+		}
+	}
+	if res.OneTimeKeyCounts != nil {
+		if have := res.OneTimeKeyCounts["signed_curve25519"]; have < minOneTimeKeys {
+			if err := m.shareKeys(ctx, have); err != nil {
+				m.t.log.Warn("upload one-time keys failed", "err", err)
+			}
+		}
+	}
+}
+
+// handleToDevice decrypts an Olm message from an allowed user's device and stores the room key it carries.
+func (m *olmMachine) handleToDevice(ctx context.Context, ev roomEvent) error {
+	if ev.Type != "m.room.encrypted" {
+		return nil
+	}
+	if _, ok := m.t.allowed[ev.Sender]; !ok {
+		return nil
+	}
+	var c struct {
+		Algorithm  string `json:"algorithm"`
+		SenderKey  string `json:"sender_key"`
+		Ciphertext map[string]struct {
+			Type int    `json:"type"`
+			Body string `json:"body"`
+		} `json:"ciphertext"`
+	}
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		return err
+	}
+	if c.Algorithm != algOlm {
+		return fmt.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+	ours, ok := c.Ciphertext[m.curve25519()]
+	if !ok {
+		return errors.New("not encrypted for this device")
+	}
+	plaintext, err := m.decryptOlm(c.SenderKey, ours.Type, ours.Body)
+	if err != nil {
+		return err
+	}
+	var p struct {
+		Type          string          `json:"type"`
+		Content       json.RawMessage `json:"content"`
+		Sender        string          `json:"sender"`
+		Recipient     string          `json:"recipient"`
+		RecipientKeys struct {
+			Ed25519 string `json:"ed25519"`
+		} `json:"recipient_keys"`
+		Keys struct {
+			Ed25519 string `json:"ed25519"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return err
+	}
+	if p.Sender != ev.Sender || p.Recipient != m.userID || p.RecipientKeys.Ed25519 != m.ed25519() {
+		return errors.New("olm payload names other devices than the event")
+	}
+	dev, err := m.deviceByKey(ctx, ev.Sender, c.SenderKey)
+	if err != nil {
+		return err
+	}
+	if dev.Ed25519 != p.Keys.Ed25519 {
+		return fmt.Errorf("device %s signing key does not match the payload", dev.DeviceID)
+	}
+	if p.Type != "m.room_key" {
+		return nil
+	}
+	return m.addRoomKey(ev.Sender, c.SenderKey, p.Content)
+}
+
+// decryptOlm tries the sessions with senderKey, newest first, and starts a new inbound
+// session for a pre-key message none of them matches.
+func (m *olmMachine) decryptOlm(senderKey string, msgType int, body string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := m.state.Sessions[senderKey]
+	for i := len(sessions) - 1; i >= 0; i-- {
+		s := sessions[i]
+		if msgType == olm.MsgTypePreKey && !s.MatchesInbound(senderKey, body) {
+			continue
+		}
+		plaintext, err := s.Decrypt(msgType, body)
+		if err != nil {
+			continue
+		}
+		m.state.Sessions[senderKey] = append(append(sessions[:i:i], sessions[i+1:]...), s)
+		m.saveLocked()
+		return plaintext, nil
+	}
+	if msgType != olm.MsgTypePreKey {
+		return nil, errors.New("no olm session decrypts the message")
+	}
+	s, plaintext, err := m.state.Account.NewInboundSession(senderKey, body)
+	if err != nil {
+		return nil, err
+	}
+	m.addSessionLocked(senderKey, s)
+	m.saveLocked()
+	return plaintext, nil
+}
+
+func (m *olmMachine) addSessionLocked(curveKey string, s *olm.Session) {
+	sessions := append(m.state.Sessions[curveKey], s)
+	if len(sessions) > maxSessionsPerDevice {
+		sessions = append([]*olm.Session(nil), sessions[len(sessions)-maxSessionsPerDevice:]...)
+	}
+	m.state.Sessions[curveKey] = sessions
+}
+
+func (m *olmMachine) latestSessionLocked(curveKey string) *olm.Session {
+	sessions := m.state.Sessions[curveKey]
+	if len(sessions) == 0 {
+		return nil
+	}
+	return sessions[len(sessions)-1]
+}
+
+// addRoomKey stores a Megolm session shared by sender's device.
+func (m *olmMachine) addRoomKey(sender, senderKey string, content json.RawMessage) error {
+	var k struct {
+		Algorithm  string `json:"algorithm"`
+		RoomID     string `json:"room_id"`
+		SessionID  string `json:"session_id"`
+		SessionKey string `json:"session_key"`
+	}
+	if err := json.Unmarshal(content, &k); err != nil {
+		return err
+	}
+	if k.Algorithm != algMegolm {
+		return fmt.Errorf("unsupported room key algorithm %q", k.Algorithm)
+	}
+	s, err := olm.NewInboundGroupSession(k.SessionKey)
+	if err != nil {
+		return err
+	}
+	if s.ID() != k.SessionID {
+		return errors.New("room key does not match its session id")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := k.RoomID + "|" + k.SessionID
+	if old, ok := m.state.Inbound[id]; ok && (old.Sender != sender || old.Session.FirstKnownIndex() <= s.FirstKnownIndex()) {
+		return nil
+	}
+	m.state.Inbound[id] = &inboundGroup{Session: s, Sender: sender, SenderKey: senderKey}
+	m.saveLocked()
+	return nil
+}
+
+// decryptRoomEvent turns an m.room.encrypted timeline event into the event it carries.
+// Relations stay in the clear so they are copied into the decrypted content.
+func (m *olmMachine) decryptRoomEvent(room string, ev roomEvent) (roomEvent, error) {
+	var c struct {
+		Algorithm  string          `json:"algorithm"`
+		SessionID  string          `json:"session_id"`
+		Ciphertext string          `json:"ciphertext"`
+		RelatesTo  json.RawMessage `json:"m.relates_to"`
+	}
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		return roomEvent{}, err
+	}
+	if c.Algorithm != algMegolm {
+		return roomEvent{}, fmt.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+	m.mu.Lock()
+	g := m.state.Inbound[room+"|"+c.SessionID]
+	if g == nil {
+		m.mu.Unlock()
+		return roomEvent{}, errNoRoomKey
+	}
+	if g.Sender != ev.Sender {
+		m.mu.Unlock()
+		return roomEvent{}, fmt.Errorf("room key was shared by %s, not the sender", g.Sender)
+	}
+	plaintext, index, err := g.Session.Decrypt(c.Ciphertext)
+	if err == nil {
+		seen := fmt.Sprintf("%s|%s|%d", room, c.SessionID, index)
+		if prev, ok := m.seen[seen]; ok && prev != ev.EventID {
+			err = fmt.Errorf("message index %d was already used by %s", index, prev)
+		} else {
+			if len(m.seen) >= 4096 {
+				m.seen = make(map[string]string)
+			}
+			m.seen[seen] = ev.EventID
+		}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return roomEvent{}, err
+	}
+
+	var p struct {
+		Type    string                     `json:"type"`
+		Content map[string]json.RawMessage `json:"content"`
+		RoomID  string                     `json:"room_id"`
+	}
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return roomEvent{}, err
+	}
+	if p.RoomID != room {
+		return roomEvent{}, fmt.Errorf("encrypted for room %s", p.RoomID)
+	}
+	if p.Content == nil {
+		p.Content = make(map[string]json.RawMessage)
+	}
+	if _, ok := p.Content["m.relates_to"]; !ok && c.RelatesTo != nil {
+		p.Content["m.relates_to"] = c.RelatesTo
+	}
+	content, err := json.Marshal(p.Content)
+	if err != nil {
+		return roomEvent{}, err
+	}
+	return roomEvent{Type: p.Type, Sender: ev.Sender, EventID: ev.EventID, Content: content}, nil
+}
+
+// deferEvent keeps an encrypted event until its room key arrives; the key often comes
+// a sync batch after the message.
+func (m *olmMachine) deferEvent(room string, ev roomEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) >= maxPending {
+		m.t.log.Warn("dropping encrypted message still waiting for its room key", "room", m.pending[0].room, "event", m.pending[0].ev.EventID)
+		m.pending = m.pending[1:]
+	}
+	m.pending = append(m.pending, pendingEvent{room: room, ev: ev, at: time.Now()})
+}
+
+// readyPending returns deferred events whose room key has arrived and drops ones that waited too long.
+func (m *olmMachine) readyPending() []pendingEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ready, keep []pendingEvent
+	for _, p := range m.pending {
+		var c struct {
+			SessionID string `json:"session_id"`
+		}
+		_ = json.Unmarshal(p.ev.Content, &c)
+		switch {
+		case m.state.Inbound[p.room+"|"+c.SessionID] != nil:
+			ready = append(ready, p)
+		case time.Since(p.at) > pendingTTL:
+			m.t.log.Warn("no room key arrived for encrypted message", "room", p.room, "event", p.ev.EventID, "sender", p.ev.Sender)
+		default:
+			keep = append(keep, p)
+		}
+	}
+	m.pending = keep
+	return ready
+}
+
+// devicesOf returns the verified devices of users, asking the homeserver about users
+// whose device list is not current.
+func (m *olmMachine) devicesOf(ctx context.Context, users []string) (map[string]map[string]device, error) {
+	m.mu.Lock()
+	query := map[string][]string{}
+	for _, u := range users {
+		if !m.tracked[u] {
+			query[u] = []string{}
+		}
+	}
+	m.mu.Unlock()
+	if len(query) > 0 {
+		var res struct {
+			DeviceKeys map[string]map[string]json.RawMessage `json:"device_keys"`
+		}
+		if err := m.t.do(ctx, http.MethodPost, "/_matrix/client/v3/keys/query", nil, map[string]any{"device_keys": query}, &res); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		for u := range query {
+			known := m.devices[u]
+			fresh := make(map[string]device)
+			for id, raw := range res.DeviceKeys[u] {
+				d, err := parseDevice(u, id, raw)
+				if err != nil {
+					m.t.log.Warn("ignoring device keys", "user", u, "device", id, "err", err)
+					continue
+				}
+				if old, ok := known[id]; ok && old.Ed25519 != d.Ed25519 {
+					m.t.log.Warn("device signing key changed; keeping the old one", "user", u, "device", id)
+					d = old
+				}
+				fresh[id] = d
+			}
+			m.devices[u] = fresh
+			m.tracked[u] = true
+		}
+		m.mu.Unlock()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]map[string]device, len(users))
+	for _, u := range users {
+		out[u] = m.devices[u]
+	}
+	return out, nil
+}
+
+func parseDevice(userID, deviceID string, raw json.RawMessage) (device, error) {
+	var dk struct {
+		UserID   string            `json:"user_id"`
+		DeviceID string            `json:"device_id"`
+		Keys     map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &dk); err != nil {
+		return device{}, err
+	}
+	if dk.UserID != userID || dk.DeviceID != deviceID {
+		return device{}, errors.New("keys name another device")
+	}
+	d := device{
+		UserID:     userID,
+		DeviceID:   deviceID,
+		Curve25519: dk.Keys["curve25519:"+deviceID],
+		Ed25519:    dk.Keys["ed25519:"+deviceID],
+	}
+	if d.Curve25519 == "" || d.Ed25519 == "" {
+		return device{}, errors.New("missing identity keys")
+	}
+	if err := verifySigned(raw, userID, deviceID, d.Ed25519); err != nil {
+		return device{}, err
+	}
+	return d, nil
+}
+
+// deviceByKey finds userID's device with the given Curve25519 key, refreshing the
+// device list once when it is not known yet.
+func (m *olmMachine) deviceByKey(ctx context.Context, userID, curveKey string) (device, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		devs, err := m.devicesOf(ctx, []string{userID})
+		if err != nil {
+			return device{}, err
+		}
+		for _, d := range devs[userID] {
+			if d.Curve25519 == curveKey {
+				return d, nil
+			}
+		}
+		m.mu.Lock()
+		m.tracked[userID] = false
+		m.mu.Unlock()
+	}
+	return device{}, fmt.Errorf("no device of %s has key %s", userID, curveKey)
+}
+
+// encrypt turns event content for room into m.room.encrypted content, first sharing the
+// room key with member devices that do not have it yet.
+func (m *olmMachine) encrypt(ctx context.Context, room, evType string, content map[string]any) (map[string]any, error) {
+	m.shareMu.Lock()
+	defer m.shareMu.Unlock()
+
+	members, err := m.t.joinedMembers(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(members))
+	for u := range members {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	devs, err := m.devicesOf(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	var recipients []device
+	for _, u := range users {
+		for _, d := range devs[u] {
+			if u == m.userID && d.DeviceID == m.deviceID {
+				continue
+			}
+			recipients = append(recipients, d)
+		}
+	}
+
+	m.mu.Lock()
+	g := m.state.Outbound[room]
+	if g == nil || g.stale(recipients) {
+		s, err := olm.NewOutboundGroupSession()
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		g = &outboundGroup{Session: s, Created: time.Now(), SharedWith: make(map[string]bool)}
+		m.state.Outbound[room] = g
+	}
+	var missing []device
+	for _, d := range recipients {
+		if !g.SharedWith[d.key()] {
+			missing = append(missing, d)
+		}
+	}
+	m.mu.Unlock()
+	if len(missing) > 0 {
+		if err := m.shareRoomKey(ctx, room, g, missing); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := json.Marshal(map[string]any{"type": evType, "content": content, "room_id": room})
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	ciphertext, err := g.Session.Encrypt(payload)
+	m.saveLocked()
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]any{
+		"algorithm":  algMegolm,
+		"sender_key": m.curve25519(),
+		"ciphertext": ciphertext,
+		"session_id": g.Session.ID(),
+		"device_id":  m.deviceID,
+	}
+	if rel, ok := content["m.relates_to"]; ok {
+		out["m.relates_to"] = rel
+	}
+	return out, nil
+}
+
+// stale reports whether the room key must be replaced: it is old or used up, or a device
+// it was shared with is no longer in the room.
+func (g *outboundGroup) stale(recipients []device) bool {
+	if g.Session.MessageIndex() >= rotateMessages || time.Since(g.Created) > rotatePeriod {
+		return true
+	}
+	current := make(map[string]bool, len(recipients))
+	for _, d := range recipients {
+		current[d.key()] = true
+	}
+	for k := range g.SharedWith {
+		if !current[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// shareRoomKey sends the room's Megolm session key to devices over Olm.
+func (m *olmMachine) shareRoomKey(ctx context.Context, room string, g *outboundGroup, devices []device) error {
+	if err := m.startSessions(ctx, devices); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	roomKey := map[string]any{
+		"algorithm":   algMegolm,
+		"room_id":     room,
+		"session_id":  g.Session.ID(),
+		"session_key": g.Session.SessionKey(),
+	}
+	messages := map[string]map[string]any{}
+	var sent []string
+	for _, d := range devices {
+		s := m.latestSessionLocked(d.Curve25519)
+		if s == nil {
+			m.t.log.Warn("no olm session with device; it cannot read the bot's messages", "user", d.UserID, "device", d.DeviceID)
+			continue
+		}
+		payload, err := json.Marshal(map[string]any{
+			"type":           "m.room_key",
+			"content":        roomKey,
+			"sender":         m.userID,
+			"sender_device":  m.deviceID,
+			"keys":           map[string]string{"ed25519": m.ed25519()},
+			"recipient":      d.UserID,
+			"recipient_keys": map[string]string{"ed25519": d.Ed25519},
+		})
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		msgType, body, err := s.Encrypt(payload)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		if messages[d.UserID] == nil {
+			messages[d.UserID] = map[string]any{}
+		}
+		messages[d.UserID][d.DeviceID] = map[string]any{
+			"algorithm":  algOlm,
+			"sender_key": m.curve25519(),
+			"ciphertext": map[string]any{d.Curve25519: map[string]any{"type": msgType, "body": body}},
+		}
+		sent = append(sent, d.key())
+	}
+	m.saveLocked()
+	m.mu.Unlock()
+	if len(sent) == 0 {
+		return nil
+	}
+
+	path := "/_matrix/client/v3/sendToDevice/m.room.encrypted/" + url.PathEscape(m.t.nextTxnID())
+	if err := m.t.do(ctx, http.MethodPut, path, nil, map[string]any{"messages": messages}, nil); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	for _, k := range sent {
+		g.SharedWith[k] = true
+	}
+	m.saveLocked()
+	m.mu.Unlock()
+	return nil
+}
+
+// startSessions claims one-time keys for devices without an Olm session and starts one with each.
+func (m *olmMachine) startSessions(ctx context.Context, devices []device) error {
+	m.mu.Lock()
+	claim := map[string]map[string]string{}
+	for _, d := range devices {
+		if m.latestSessionLocked(d.Curve25519) != nil {
+			continue
+		}
+		if claim[d.UserID] == nil {
+			claim[d.UserID] = map[string]string{}
+		}
+		claim[d.UserID][d.DeviceID] = "signed_curve25519"
+	}
+	m.mu.Unlock()
+	if len(claim) == 0 {
+		return nil
+	}
+	var res struct {
+		OneTimeKeys map[string]map[string]map[string]json.RawMessage `json:"one_time_keys"`
+	}
+	if err := m.t.do(ctx, http.MethodPost, "/_matrix/client/v3/keys/claim", nil, map[string]any{"one_time_keys": claim}, &res); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range devices {
+		if _, claimed := claim[d.UserID][d.DeviceID]; !claimed {
+			continue
+		}
+		for _, raw := range res.OneTimeKeys[d.UserID][d.DeviceID] {
+			var k struct {
+				Key string `json:"key"`
+			}
+			if err := json.Unmarshal(raw, &k); err != nil || k.Key == "" {
+				continue
+			}
+			if err := verifySigned(raw, d.UserID, d.DeviceID, d.Ed25519); err != nil {
+				m.t.log.Warn("ignoring one-time key", "user", d.UserID, "device", d.DeviceID, "err", err)
+				continue
+			}
+			s, err := m.state.Account.NewOutboundSession(d.Curve25519, k.Key)
+			if err != nil {
+				m.t.log.Warn("cannot start olm session", "user", d.UserID, "device", d.DeviceID, "err", err)
+				continue
+			}
+			m.addSessionLocked(d.Curve25519, s)
+		}
+	}
+	m.saveLocked()
+	return nil
+}
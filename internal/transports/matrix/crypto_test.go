@@ -0,0 +1,438 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/transports/matrix/olm"
+)
+
+// serveKeys handles the key and to-device endpoints for encrypted rooms; f.mu is held.
+func (f *fakeHomeserver) serveKeys(w http.ResponseWriter, r *http.Request, path string) bool {
+	var body map[string]json.RawMessage
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	switch {
+	case path == "/_matrix/client/v3/keys/upload":
+		f.keyUploads = append(f.keyUploads, body)
+		if raw, ok := body["device_keys"]; ok {
+			f.addDevice("@bot:hs", f.deviceID, raw)
+		}
+		var otks map[string]json.RawMessage
+		_ = json.Unmarshal(body["one_time_keys"], &otks)
+		f.addOneTimeKeys("@bot:hs", f.deviceID, otks)
+		_, _ = w.Write([]byte(`{"one_time_key_counts":{}}`))
+	case path == "/_matrix/client/v3/keys/query":
+		var query map[string][]string
+		_ = json.Unmarshal(body["device_keys"], &query)
+		out := map[string]any{}
+		for user := range query {
+			out[user] = f.deviceKeys[user]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"device_keys": out})
+	case path == "/_matrix/client/v3/keys/claim":
+		var claim map[string]map[string]string
+		_ = json.Unmarshal(body["one_time_keys"], &claim)
+		out := map[string]map[string]map[string]json.RawMessage{}
+		for user, devices := range claim {
+			out[user] = map[string]map[string]json.RawMessage{}
+			for dev := range devices {
+				for id, key := range f.oneTimeKeys[user+"|"+dev] {
+					out[user][dev] = map[string]json.RawMessage{id: key}
+					delete(f.oneTimeKeys[user+"|"+dev], id)
+					break
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"one_time_keys": out})
+	case strings.HasPrefix(path, "/_matrix/client/v3/sendToDevice/m.room.encrypted/"):
+		var messages map[string]map[string]json.RawMessage
+		_ = json.Unmarshal(body["messages"], &messages)
+		f.toDevice = append(f.toDevice, messages)
+		_, _ = w.Write([]byte(`{}`))
+	default:
+		return false
+	}
+	return true
+}
+
+func (f *fakeHomeserver) addDevice(user, dev string, raw json.RawMessage) {
+	if f.deviceKeys == nil {
+		f.deviceKeys = map[string]map[string]json.RawMessage{}
+	}
+	if f.deviceKeys[user] == nil {
+		f.deviceKeys[user] = map[string]json.RawMessage{}
+	}
+	f.deviceKeys[user][dev] = raw
+}
+
+func (f *fakeHomeserver) addOneTimeKeys(user, dev string, keys map[string]json.RawMessage) {
+	if f.oneTimeKeys == nil {
+		f.oneTimeKeys = map[string]map[string]json.RawMessage{}
+	}
+	if f.oneTimeKeys[user+"|"+dev] == nil {
+		f.oneTimeKeys[user+"|"+dev] = map[string]json.RawMessage{}
+	}
+	for id, k := range keys {
+		f.oneTimeKeys[user+"|"+dev][id] = k
+	}
+}
+
+// peer is another client's device, playing the part of e.g. Element.
+type peer struct {
+	user, device string
+	acct         *olm.Account
+}
+
+func newPeer(t *testing.T, f *fakeHomeserver, user, device string) *peer {
+	t.Helper()
+	acct, err := olm.NewAccount()
+	if err != nil {
+		t.Fatalf("account: %v", err)
+	}
+	p := &peer{user: user, device: device, acct: acct}
+	keys := p.sign(t, map[string]any{
+		"user_id":    user,
+		"device_id":  device,
+		"algorithms": []string{algOlm, algMegolm},
+		"keys": map[string]string{
+			"curve25519:" + device: acct.Curve25519(),
+			"ed25519:" + device:    acct.Ed25519(),
+		},
+	})
+	_ = acct.GenerateOneTimeKeys(1)
+	otks := map[string]json.RawMessage{}
+	for id, k := range acct.UnpublishedOneTimeKeys() {
+		otks["signed_curve25519:"+id] = p.sign(t, map[string]any{"key": k})
+	}
+	acct.MarkKeysAsPublished()
+	f.mu.Lock()
+	f.addDevice(user, device, keys)
+	f.addOneTimeKeys(user, device, otks)
+	f.mu.Unlock()
+	return p
+}
+
+func (p *peer) sign(t *testing.T, obj map[string]any) json.RawMessage {
+	t.Helper()
+	canon, err := canonicalJSON(obj)
+	if err != nil {
+		t.Fatalf("canonical json: %v", err)
+	}
+	obj["signatures"] = map[string]any{p.user: map[string]string{"ed25519:" + p.device: p.acct.Sign(canon)}}
+	raw, _ := json.Marshal(obj)
+	return raw
+}
+
+// toDeviceEvent encrypts an Olm payload from the peer to the bot's device.
+func (p *peer) toDeviceEvent(t *testing.T, s *olm.Session, botCurve, botEd string, typ string, content any) map[string]any {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]any{
+		"type":           typ,
+		"content":        content,
+		"sender":         p.user,
+		"sender_device":  p.device,
+		"keys":           map[string]string{"ed25519": p.acct.Ed25519()},
+		"recipient":      "@bot:hs",
+		"recipient_keys": map[string]string{"ed25519": botEd},
+	})
+	msgType, body, err := s.Encrypt(payload)
+	if err != nil {
+		t.Fatalf("olm encrypt: %v", err)
+	}
+	return map[string]any{"type": "m.room.encrypted", "sender": p.user, "content": map[string]any{
+		"algorithm":  algOlm,
+		"sender_key": p.acct.Curve25519(),
+		"ciphertext": map[string]any{botCurve: map[string]any{"type": msgType, "body": body}},
+	}}
+}
+
+func roomKeyContent(room string, s *olm.OutboundGroupSession) map[string]any {
+	return map[string]any{"algorithm": algMegolm, "room_id": room, "session_id": s.ID(), "session_key": s.SessionKey()}
+}
+
+func (p *peer) encryptedEvent(t *testing.T, id, room string, s *olm.OutboundGroupSession, body string) map[string]any {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]any{
+		"type":    "m.room.message",
+		"content": map[string]any{"msgtype": "m.text", "body": body},
+		"room_id": room,
+	})
+	ciphertext, err := s.Encrypt(payload)
+	if err != nil {
+		t.Fatalf("megolm encrypt: %v", err)
+	}
+	return map[string]any{"type": "m.room.encrypted", "event_id": id, "sender": p.user, "content": map[string]any{
+		"algorithm":  algMegolm,
+		"sender_key": p.acct.Curve25519(),
+		"session_id": s.ID(),
+		"ciphertext": ciphertext,
+		"device_id":  p.device,
+	}}
+}
+
+func encryptedBatch(next, room string, timeline, toDevice []map[string]any) string {
+	b, _ := json.Marshal(map[string]any{
+		"next_batch": next,
+		"rooms":      map[string]any{"join": map[string]any{room: map[string]any{"timeline": map[string]any{"events": timeline}}}},
+		"to_device":  map[string]any{"events": toDevice},
+	})
+	return string(b)
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// botKeys returns the bot's uploaded identity keys and claims one of its one-time keys,
+// checking the signatures the way another client would.
+func botKeys(t *testing.T, f *fakeHomeserver) (curve, ed, otk string) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	raw := f.deviceKeys["@bot:hs"]["BOTDEV"]
+	d, err := parseDevice("@bot:hs", "BOTDEV", raw)
+	if err != nil {
+		t.Fatalf("bot device keys: %v", err)
+	}
+	keys := f.oneTimeKeys["@bot:hs|BOTDEV"]
+	if len(keys) != oneTimeKeyBatch {
+		t.Fatalf("expected %d one-time keys, got %d", oneTimeKeyBatch, len(keys))
+	}
+	for id, k := range keys {
+		if err := verifySigned(k, "@bot:hs", "BOTDEV", d.Ed25519); err != nil {
+			t.Fatalf("one-time key signature: %v", err)
+		}
+		var signed struct {
+			Key string `json:"key"`
+		}
+		_ = json.Unmarshal(k, &signed)
+		delete(keys, id)
+		return d.Curve25519, d.Ed25519, signed.Key
+	}
+	return "", "", ""
+}
+
+func TestEncryptedDirectMessageRoundTrip(t *testing.T) {
+	fake := &fakeHomeserver{
+		deviceID:  "BOTDEV",
+		members:   map[string][]string{"!dm:hs": {"@bot:hs", "@joel:hs"}},
+		encrypted: map[string]bool{"!dm:hs": true},
+		batches:   []string{`{"next_batch":"b1"}`},
+	}
+	phone := newPeer(t, fake, "@joel:hs", "PHONE")
+	laptop := newPeer(t, fake, "@joel:hs", "LAPTOP")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	st := newTestStore(t)
+	tr := newTestTransport(t, srv.URL, st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inbound := make(chan core.InboundMessage, 8)
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, inbound) }()
+
+	waitUntil(t, "key upload", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.keyUploads) > 0
+	})
+	botCurve, botEd, botOTK := botKeys(t, fake)
+
+	// The phone starts an Olm session with a claimed key and shares its room key along
+	// with the first message; the second message's key only arrives a batch later.
+	olmOut, err := phone.acct.NewOutboundSession(botCurve, botOTK)
+	if err != nil {
+		t.Fatalf("olm session: %v", err)
+	}
+	group, _ := olm.NewOutboundGroupSession()
+	late, _ := olm.NewOutboundGroupSession()
+	keyEvent := phone.toDeviceEvent(t, olmOut, botCurve, botEd, "m.room_key", roomKeyContent("!dm:hs", group))
+	lateKey := phone.toDeviceEvent(t, olmOut, botCurve, botEd, "m.room_key", roomKeyContent("!dm:hs", late))
+	fake.mu.Lock()
+	fake.batches = append(fake.batches,
+		encryptedBatch("b2", "!dm:hs", []map[string]any{phone.encryptedEvent(t, "$1", "!dm:hs", group, "hello secret")}, []map[string]any{keyEvent}),
+		encryptedBatch("b3", "!dm:hs", []map[string]any{phone.encryptedEvent(t, "$2", "!dm:hs", late, "key came later")}, nil),
+		encryptedBatch("b4", "!dm:hs", nil, []map[string]any{lateKey}),
+	)
+	fake.mu.Unlock()
+
+	for _, want := range []string{"hello secret", "key came later"} {
+		msg := receive(t, inbound)
+		if msg.Text != want || msg.ThreadID != "!dm:hs" || msg.Sender != "@joel:hs" {
+			t.Fatalf("unexpected inbound %+v, want %q", msg, want)
+		}
+	}
+
+	// The reply is encrypted; the room key goes to both of Joel's devices, starting a
+	// session with the laptop from its claimed one-time key.
+	for _, text := range []string{"done", "again"} {
+		if err := tr.Send(context.Background(), core.OutboundMessage{ThreadID: "!dm:hs", Text: text}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	cancel()
+	<-done
+
+	fake.mu.Lock()
+	sends := append([]sentEvent(nil), fake.sends...)
+	toDevice := append([]map[string]map[string]json.RawMessage(nil), fake.toDevice...)
+	fake.mu.Unlock()
+	if len(sends) != 2 || sends[0].Type != "m.room.encrypted" || sends[0].Content["body"] != nil {
+		t.Fatalf("expected two encrypted sends, got %+v", sends)
+	}
+	if len(toDevice) != 1 {
+		t.Fatalf("room key should be shared once, got %d to-device sends", len(toDevice))
+	}
+
+	var sessionKey string
+	for _, p := range []*peer{phone, laptop} {
+		var content struct {
+			SenderKey  string `json:"sender_key"`
+			Ciphertext map[string]struct {
+				Type int    `json:"type"`
+				Body string `json:"body"`
+			} `json:"ciphertext"`
+		}
+		if err := json.Unmarshal(toDevice[0]["@joel:hs"][p.device], &content); err != nil {
+			t.Fatalf("to-device for %s: %v", p.device, err)
+		}
+		ct := content.Ciphertext[p.acct.Curve25519()]
+		var plaintext []byte
+		if p == phone {
+			if ct.Type != olm.MsgTypeMessage {
+				t.Fatalf("phone should get a normal message on its session, got type %d", ct.Type)
+			}
+			plaintext, err = olmOut.Decrypt(ct.Type, ct.Body)
+		} else {
+			_, plaintext, err = p.acct.NewInboundSession(content.SenderKey, ct.Body)
+		}
+		if err != nil {
+			t.Fatalf("decrypt room key for %s: %v", p.device, err)
+		}
+		var payload struct {
+			Type    string `json:"type"`
+			Sender  string `json:"sender"`
+			Content struct {
+				RoomID     string `json:"room_id"`
+				SessionKey string `json:"session_key"`
+			} `json:"content"`
+			Keys struct {
+				Ed25519 string `json:"ed25519"`
+			} `json:"keys"`
+		}
+		_ = json.Unmarshal(plaintext, &payload)
+		if payload.Type != "m.room_key" || payload.Sender != "@bot:hs" || payload.Content.RoomID != "!dm:hs" || payload.Keys.Ed25519 != botEd {
+			t.Fatalf("unexpected room key payload %s", plaintext)
+		}
+		sessionKey = payload.Content.SessionKey
+	}
+
+	in, err := olm.NewInboundGroupSession(sessionKey)
+	if err != nil {
+		t.Fatalf("import room key: %v", err)
+	}
+	for i, want := range []string{"done", "again"} {
+		if sends[i].Content["session_id"] != in.ID() {
+			t.Fatalf("send %d used another session", i)
+		}
+		plaintext, _, err := in.Decrypt(sends[i].Content["ciphertext"].(string))
+		if err != nil {
+			t.Fatalf("decrypt reply: %v", err)
+		}
+		var ev struct {
+			Type    string `json:"type"`
+			RoomID  string `json:"room_id"`
+			Content struct {
+				Body string `json:"body"`
+			} `json:"content"`
+		}
+		_ = json.Unmarshal(plaintext, &ev)
+		if ev.Type != "m.room.message" || ev.RoomID != "!dm:hs" || ev.Content.Body != want {
+			t.Fatalf("unexpected reply %s, want %q", plaintext, want)
+		}
+	}
+
+	// A restart reuses the saved device keys instead of uploading new ones.
+	tr2 := newTestTransport(t, srv.URL, st)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done2 := make(chan error, 1)
+	go func() { done2 <- tr2.Start(ctx2, make(chan core.InboundMessage, 1)) }()
+	waitUntil(t, "sync after restart", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.since) > 0 && fake.since[len(fake.since)-1] == "b4"
+	})
+	cancel2()
+	<-done2
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, up := range fake.keyUploads[1:] {
+		if _, ok := up["device_keys"]; ok {
+			t.Fatalf("device keys uploaded again after restart")
+		}
+	}
+}
+
+func TestEncryptedRoomsRefusedWithoutEncryption(t *testing.T) {
+	fake := &fakeHomeserver{encrypted: map[string]bool{"!team:hs": true}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	// A configured encrypted room is a startup error when keys cannot be kept.
+	tr := newTestTransport(t, srv.URL, nil)
+	err := tr.Start(context.Background(), make(chan core.InboundMessage, 1))
+	if err == nil || !strings.Contains(err.Error(), "!team:hs is end-to-end encrypted") {
+		t.Fatalf("expected an encrypted room error, got %v", err)
+	}
+
+	// An invite to an encrypted room is not accepted.
+	fake.mu.Lock()
+	fake.encrypted = nil
+	invite, _ := json.Marshal(map[string]any{"next_batch": "b1", "rooms": map[string]any{"invite": map[string]any{
+		"!secret:hs": map[string]any{"invite_state": map[string]any{"events": []any{
+			map[string]any{"type": "m.room.encryption", "sender": "@joel:hs", "state_key": "", "content": map[string]any{"algorithm": algMegolm}},
+			map[string]any{"type": "m.room.member", "sender": "@joel:hs", "state_key": "@bot:hs", "content": map[string]any{"membership": "invite"}},
+		}}},
+	}}})
+	fake.batches = []string{string(invite)}
+	fake.mu.Unlock()
+	tr = newTestTransport(t, srv.URL, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, make(chan core.InboundMessage, 1)) }()
+	waitUntil(t, "invite batch", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.since) > 1
+	})
+	cancel()
+	<-done
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.joins) != 0 {
+		t.Fatalf("encrypted room should not be joined, got %v", fake.joins)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	got, err := canonicalJSON(json.RawMessage(`{"b": 1, "a": "<x>", "signatures": {"u": {}}, "unsigned": {}, "c": {"z": [1, 2.5], "y": null}}`))
+	if err != nil {
+		t.Fatalf("canonical json: %v", err)
+	}
+	if want := `{"a":"<x>","b":1,"c":{"y":null,"z":[1,2.5]}}`; string(got) != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
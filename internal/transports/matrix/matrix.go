@@ -0,0 +1,264 @@
+// Package matrix implements a Matrix client-server API transport: it long-polls
+// /sync with an access token and replies in the room (and thread) a message came from.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/store"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// maxBodyChars keeps events well under the homeserver's 64 KiB event limit.
+const maxBodyChars = 16000
+
+// threadSep separates the room ID from the thread root event ID in ThreadID.
+// Room IDs already contain ':' so the usual separator cannot be used.
+const threadSep = "|"
+
+// Config for the Matrix transport.
+type Config struct {
+	ID              string   `json:"id"`
+	Homeserver      string   `json:"homeserver"`   // e.g. https://matrix.example.org
+	AccessToken     string   `json:"access_token"` // bot account token
+	UserID          string   `json:"user_id"`      // bot MXID; looked up via whoami when empty
+	AllowedUsers    []string `json:"allowed_users"`
+	Rooms           []string `json:"rooms"` // room IDs to listen in; DMs with allowed users are always accepted
+	SyncTimeoutSecs int      `json:"sync_timeout_seconds"`
+}
+
+// Transport implements core.Transport (and core.EditableTransport) for Matrix.
+type Transport struct {
+	cfg     Config
+	store   store.StoreAPI
+	log     *slog.Logger
+	client  *http.Client
+	allowed map[string]struct{}
+	rooms   map[string]struct{}
+	txn     atomic.Int64
+
+	// crypto does the end-to-end encryption work; it stays nil when encryption is
+	// unavailable, with noCrypto saying why. Both are set once by Start.
+	crypto   atomic.Pointer[olmMachine]
+	noCrypto string
+
+	mu        sync.Mutex
+	userID    string
+	deviceID  string
+	dms       map[string]dmCheck // room ID -> cached direct-chat check
+	lastSeen  map[string]string  // ThreadID -> latest inbound event ID, used as the reply target
+	encrypted map[string]bool    // room ID -> whether end-to-end encryption is on
+}
+
+// dmCheck caches whether a room is a two-person chat with an allowed user.
+type dmCheck struct {
+	direct  bool
+	checked time.Time
+}
+
+// New builds the transport. st persists the sync token across restarts and, when it can,
+// the keys for end-to-end encrypted rooms; it may be nil.
+func New(cfg Config, st store.StoreAPI, logger *slog.Logger) (*Transport, error) {
+	if cfg.ID == "" {
+		cfg.ID = "matrix"
+	}
+	if cfg.Homeserver == "" || cfg.AccessToken == "" {
+		return nil, errors.New("matrix: homeserver and access_token required")
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		return nil, errors.New("matrix: allowed_users required")
+	}
+	if cfg.SyncTimeoutSecs <= 0 {
+		cfg.SyncTimeoutSecs = 30
+	}
+	cfg.Homeserver = strings.TrimRight(cfg.Homeserver, "/")
+	if logger == nil {
+		logger = slog.Default()
+	}
+	t := &Transport{
+		cfg:       cfg,
+		store:     st,
+		log:       logger.With("transport", "matrix"),
+		client:    http.DefaultClient,
+		allowed:   toSet(cfg.AllowedUsers),
+		rooms:     toSet(cfg.Rooms),
+		userID:    cfg.UserID,
+		dms:       make(map[string]dmCheck),
+		lastSeen:  make(map[string]string),
+		encrypted: make(map[string]bool),
+	}
+	return t, nil
+}
+
+func (t *Transport) ID() string { return t.cfg.ID }
+
+// MaxMessageChars reports the text size the runner should split replies to.
+func (t *Transport) MaxMessageChars() int { return maxBodyChars }
+
+// Send posts msg to the room (and thread) encoded in ThreadID.
+func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
+	_, err := t.SendEditable(ctx, msg)
+	return err
+}
+
+// SendEditable posts msg and returns a "room|event_id" handle for Edit.
+func (t *Transport) SendEditable(ctx context.Context, msg core.OutboundMessage) (string, error) {
+	room, root, _ := strings.Cut(msg.ThreadID, threadSep)
+	if room == "" {
+		return "", errors.New("matrix: thread id (room) required")
+	}
+	content := map[string]any{"msgtype": "m.text", "body": msg.Text}
+	if root != "" {
+		replyTo := root
+		t.mu.Lock()
+		if id, ok := t.lastSeen[msg.ThreadID]; ok {
+			replyTo = id
+		}
+		t.mu.Unlock()
+		content["m.relates_to"] = map[string]any{
+			"rel_type":        "m.thread",
+			"event_id":        root,
+			"is_falling_back": true,
+			"m.in_reply_to":   map[string]string{"event_id": replyTo},
+		}
+	}
+	eventID, err := t.sendEvent(ctx, room, content)
+	if err != nil {
+		return "", err
+	}
+	return room + threadSep + eventID, nil
+}
+
+// Edit replaces a message sent with SendEditable using an m.replace relation.
+func (t *Transport) Edit(ctx context.Context, handle string, msg core.OutboundMessage) error {
+	room, eventID, _ := strings.Cut(handle, threadSep)
+	if room == "" || eventID == "" {
+		return fmt.Errorf("matrix: invalid message handle %q", handle)
+	}
+	_, err := t.sendEvent(ctx, room, map[string]any{
+		"msgtype":       "m.text",
+		"body":          "* " + msg.Text,
+		"m.new_content": map[string]any{"msgtype": "m.text", "body": msg.Text},
+		"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": eventID},
+	})
+	return err
+}
+
+// sendEvent posts an m.room.message, encrypted when the room has encryption turned on.
+func (t *Transport) sendEvent(ctx context.Context, room string, content map[string]any) (string, error) {
+	evType := "m.room.message"
+	if m := t.crypto.Load(); m != nil {
+		encrypted, err := t.roomEncrypted(ctx, room)
+		if err != nil {
+			return "", err
+		}
+		if encrypted {
+			if content, err = m.encrypt(ctx, room, evType, content); err != nil {
+				return "", fmt.Errorf("matrix: encrypt for %s: %w", room, err)
+			}
+			evType = "m.room.encrypted"
+		}
+	}
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(room) + "/send/" + evType + "/" + url.PathEscape(t.nextTxnID())
+	var out struct {
+		EventID string `json:"event_id"`
+	}
+	if err := t.do(ctx, http.MethodPut, path, nil, content, &out); err != nil {
+		return "", err
+	}
+	return out.EventID, nil
+}
+
+func (t *Transport) nextTxnID() string {
+	return fmt.Sprintf("buddy-%d-%d", time.Now().UnixNano(), t.txn.Add(1))
+}
+
+// apiError is a non-2xx client-server API response.
+type apiError struct {
+	Method, Path, Status string
+	Code                 int
+	ErrCode, Message     string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("matrix %s %s failed: %s: %s %s", e.Method, e.Path, e.Status, e.ErrCode, e.Message)
+}
+
+// do performs an authenticated client-server API request and decodes the JSON response into out.
+func (t *Transport) do(ctx context.Context, method, path string, query url.Values, body any, out any) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	u := t.cfg.Homeserver + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.cfg.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var merr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		_ = json.Unmarshal(data, &merr)
+		return &apiError{Method: method, Path: path, Status: resp.Status, Code: resp.StatusCode, ErrCode: merr.ErrCode, Message: merr.Error}
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func toSet(list []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		out[v] = struct{}{}
+	}
+	return out
+}
+
+func init() {
+	transport.MustRegister("matrix", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("matrix: invalid config type %T", cfg)
+		}
+		return New(c, nil, nil)
+	})
+}
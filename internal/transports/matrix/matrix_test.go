@@ -0,0 +1,331 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+	"github.com/joelklabo/buddy/internal/store"
+)
+
+// fakeHomeserver serves whoami, a scripted series of /sync batches, joins, and sends.
+// With a deviceID it also serves the key endpoints used in encrypted rooms.
+type fakeHomeserver struct {
+	mu        sync.Mutex
+	deviceID  string
+	batches   []string // raw sync responses served in order; afterwards an empty batch
+	since     []string // since param of each /sync call
+	joins     []string
+	sends     []sentEvent
+	members   map[string][]string
+	encrypted map[string]bool // rooms with an m.room.encryption state event
+
+	deviceKeys  map[string]map[string]json.RawMessage // user -> device -> signed device keys
+	oneTimeKeys map[string]map[string]json.RawMessage // "user|device" -> key ID -> signed key
+	keyUploads  []map[string]json.RawMessage
+	toDevice    []map[string]map[string]json.RawMessage // messages of each sendToDevice call
+}
+
+type sentEvent struct {
+	Room    string
+	Type    string
+	Content map[string]any
+}
+
+func (f *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"bad token"}`))
+		return
+	}
+	path := r.URL.EscapedPath()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case path == "/_matrix/client/v3/account/whoami":
+		_, _ = fmt.Fprintf(w, `{"user_id":"@bot:hs","device_id":%q}`, f.deviceID)
+	case path == "/_matrix/client/v3/sync":
+		f.since = append(f.since, r.URL.Query().Get("since"))
+		if len(f.batches) == 0 {
+			f.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			f.mu.Lock()
+			_, _ = fmt.Fprintf(w, `{"next_batch":%q}`, r.URL.Query().Get("since"))
+			return
+		}
+		_, _ = w.Write([]byte(f.batches[0]))
+		f.batches = f.batches[1:]
+	case strings.HasPrefix(path, "/_matrix/client/v3/join/"):
+		room := unescape(strings.TrimPrefix(path, "/_matrix/client/v3/join/"))
+		f.joins = append(f.joins, room)
+		_, _ = fmt.Fprintf(w, `{"room_id":%q}`, room)
+	case strings.HasSuffix(path, "/joined_members"):
+		room := unescape(strings.TrimSuffix(strings.TrimPrefix(path, "/_matrix/client/v3/rooms/"), "/joined_members"))
+		joined := map[string]any{}
+		for _, m := range f.members[room] {
+			joined[m] = map[string]any{}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"joined": joined})
+	case strings.HasSuffix(path, "/state/m.room.encryption"):
+		room := unescape(strings.TrimSuffix(strings.TrimPrefix(path, "/_matrix/client/v3/rooms/"), "/state/m.room.encryption"))
+		if !f.encrypted[room] {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"no encryption state"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"algorithm":"m.megolm.v1.aes-sha2"}`))
+	case strings.Contains(path, "/send/") && r.Method == http.MethodPut:
+		parts := strings.Split(path, "/send/")
+		room := unescape(strings.TrimPrefix(parts[0], "/_matrix/client/v3/rooms/"))
+		var content map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&content)
+		f.sends = append(f.sends, sentEvent{Room: room, Type: strings.Split(parts[1], "/")[0], Content: content})
+		_, _ = fmt.Fprintf(w, `{"event_id":"$sent%d"}`, len(f.sends))
+	case f.deviceID != "" && f.serveKeys(w, r, path):
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func unescape(s string) string {
+	s = strings.ReplaceAll(s, "%21", "!")
+	return strings.ReplaceAll(s, "%3A", ":")
+}
+
+func textEvent(id, sender, body string, relates map[string]any) map[string]any {
+	content := map[string]any{"msgtype": "m.text", "body": body}
+	if relates != nil {
+		content["m.relates_to"] = relates
+	}
+	return map[string]any{"type": "m.room.message", "event_id": id, "sender": sender, "content": content}
+}
+
+func batch(next string, join map[string][]map[string]any, invite map[string]string) string {
+	rooms := map[string]any{}
+	j := map[string]any{}
+	for room, events := range join {
+		j[room] = map[string]any{"timeline": map[string]any{"events": events}}
+	}
+	rooms["join"] = j
+	inv := map[string]any{}
+	for room, inviter := range invite {
+		inv[room] = map[string]any{"invite_state": map[string]any{"events": []any{
+			map[string]any{"type": "m.room.member", "sender": inviter, "state_key": "@bot:hs", "content": map[string]any{"membership": "invite"}},
+		}}}
+	}
+	rooms["invite"] = inv
+	b, _ := json.Marshal(map[string]any{"next_batch": next, "rooms": rooms})
+	return string(b)
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.New(t.TempDir() + "/state.db")
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+func newTestTransport(t *testing.T, hs string, st store.StoreAPI) *Transport {
+	t.Helper()
+	tr, err := New(Config{
+		Homeserver:   hs,
+		AccessToken:  "tok",
+		AllowedUsers: []string{"@joel:hs"},
+		Rooms:        []string{"!team:hs"},
+	}, st, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return tr
+}
+
+func receive(t *testing.T, inbound <-chan core.InboundMessage) core.InboundMessage {
+	t.Helper()
+	select {
+	case msg := <-inbound:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for inbound message")
+		return core.InboundMessage{}
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	cases := []Config{
+		{},
+		{Homeserver: "https://hs", AccessToken: "tok"},
+	}
+	for _, c := range cases {
+		if _, err := New(c, nil, nil); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestSyncDeliversAllowedMessagesAndPersistsToken(t *testing.T) {
+	fake := &fakeHomeserver{
+		members: map[string][]string{
+			"!dm:hs":    {"@bot:hs", "@joel:hs"},
+			"!crowd:hs": {"@bot:hs", "@joel:hs", "@eve:hs"},
+		},
+		batches: []string{
+			// Initial sync: the backlog is skipped, invites from allowed users are accepted.
+			batch("b1", map[string][]map[string]any{
+				"!team:hs": {textEvent("$old", "@joel:hs", "old backlog", nil)},
+			}, map[string]string{"!dm:hs": "@joel:hs", "!spam:hs": "@eve:hs"}),
+			batch("b2", map[string][]map[string]any{
+				"!dm:hs": {
+					textEvent("$1", "@joel:hs", "hello in dm", nil),
+					textEvent("$2", "@eve:hs", "not allowed", nil),
+					textEvent("$3", "@bot:hs", "own echo", nil),
+				},
+				"!crowd:hs": {textEvent("$4", "@joel:hs", "unconfigured room", nil)},
+				"!team:hs": {textEvent("$5", "@joel:hs", "> <@bot:hs> earlier\n\nin thread", map[string]any{
+					"rel_type":      "m.thread",
+					"event_id":      "$root",
+					"m.in_reply_to": map[string]any{"event_id": "$prev"},
+				})},
+			}, nil),
+		},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	st := newTestStore(t)
+	tr := newTestTransport(t, srv.URL, st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inbound := make(chan core.InboundMessage, 8)
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, inbound) }()
+
+	got := map[string]core.InboundMessage{}
+	for i := 0; i < 2; i++ {
+		msg := receive(t, inbound)
+		got[msg.Text] = msg
+	}
+	if dm, ok := got["hello in dm"]; !ok || dm.ThreadID != "!dm:hs" || dm.Sender != "@joel:hs" || dm.Transport != "matrix" {
+		t.Fatalf("unexpected dm mapping %+v", got)
+	}
+	if th, ok := got["in thread"]; !ok || th.ThreadID != "!team:hs|$root" {
+		t.Fatalf("unexpected thread mapping %+v", got)
+	}
+
+	// Reply in the thread, quoting the latest message.
+	if err := tr.Send(context.Background(), core.OutboundMessage{ThreadID: "!team:hs|$root", Text: "done"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if tok, _ := st.SyncToken("matrix:matrix"); tok == "b2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sync token not persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	select {
+	case msg := <-inbound:
+		t.Fatalf("unexpected message %+v", msg)
+	default:
+	}
+
+	fake.mu.Lock()
+	joins := append([]string(nil), fake.joins...)
+	sends := append([]sentEvent(nil), fake.sends...)
+	since := append([]string(nil), fake.since...)
+	fake.mu.Unlock()
+	if len(joins) != 1 || joins[0] != "!dm:hs" {
+		t.Fatalf("expected to join only the allowed invite, got %v", joins)
+	}
+	if since[0] != "" || since[1] != "b1" {
+		t.Fatalf("unexpected since sequence %v", since)
+	}
+	if len(sends) != 1 || sends[0].Room != "!team:hs" {
+		t.Fatalf("unexpected sends %+v", sends)
+	}
+	rel, _ := sends[0].Content["m.relates_to"].(map[string]any)
+	reply, _ := rel["m.in_reply_to"].(map[string]any)
+	if rel["rel_type"] != "m.thread" || rel["event_id"] != "$root" || reply["event_id"] != "$5" {
+		t.Fatalf("unexpected relation %+v", rel)
+	}
+
+	// A restart resumes from the saved token instead of replaying.
+	tr2 := newTestTransport(t, srv.URL, st)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done2 := make(chan error, 1)
+	go func() { done2 <- tr2.Start(ctx2, make(chan core.InboundMessage, 1)) }()
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		n := len(fake.since)
+		last := fake.since[n-1]
+		fake.mu.Unlock()
+		if n > len(since) {
+			if last != "b2" {
+				t.Fatalf("restart should resume from b2, got %q", last)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no sync after restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel2()
+	<-done2
+}
+
+func TestEditUsesReplaceRelation(t *testing.T) {
+	fake := &fakeHomeserver{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := newTestTransport(t, srv.URL, nil)
+
+	handle, err := tr.SendEditable(context.Background(), core.OutboundMessage{ThreadID: "!dm:hs", Text: "working"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if handle != "!dm:hs|$sent1" {
+		t.Fatalf("unexpected handle %q", handle)
+	}
+	if err := tr.Edit(context.Background(), handle, core.OutboundMessage{Text: "done"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, threaded := fake.sends[0].Content["m.relates_to"]; threaded {
+		t.Fatalf("room-level reply should not carry a relation: %+v", fake.sends[0].Content)
+	}
+	rel, _ := fake.sends[1].Content["m.relates_to"].(map[string]any)
+	newContent, _ := fake.sends[1].Content["m.new_content"].(map[string]any)
+	if rel["rel_type"] != "m.replace" || rel["event_id"] != "$sent1" || newContent["body"] != "done" {
+		t.Fatalf("unexpected edit %+v", fake.sends[1].Content)
+	}
+	if err := tr.Send(context.Background(), core.OutboundMessage{Recipient: "@joel:hs"}); err == nil {
+		t.Fatalf("expected error without a room")
+	}
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	if got := stripReplyFallback("> <@a:hs> hi\n> there\n\nanswer"); got != "answer" {
+		t.Fatalf("unexpected %q", got)
+	}
+	if got := stripReplyFallback("plain"); got != "plain" {
+		t.Fatalf("unexpected %q", got)
+	}
+}
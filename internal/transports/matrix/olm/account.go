@@ -0,0 +1,226 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// maxOneTimeKeys bounds the one-time keys an account keeps; the oldest are dropped first.
+const maxOneTimeKeys = 100
+
+// Account holds a device's long-term identity keys and its one-time keys.
+// It is plain data so callers can persist it as JSON.
+type Account struct {
+	IdentityKey KeyPair      `json:"identity_key"` // Curve25519
+	SigningSeed []byte       `json:"signing_seed"` // Ed25519 seed
+	OneTimeKeys []OneTimeKey `json:"one_time_keys"`
+	NextKeyID   uint32       `json:"next_key_id"`
+}
+
+// OneTimeKey is a Curve25519 key another device claims to start an Olm session.
+type OneTimeKey struct {
+	ID        uint32  `json:"id"`
+	Key       KeyPair `json:"key"`
+	Published bool    `json:"published"`
+}
+
+// NewAccount creates an account with fresh identity keys and no one-time keys.
+func NewAccount() (*Account, error) {
+	identity, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return &Account{IdentityKey: identity, SigningSeed: seed}, nil
+}
+
+// Curve25519 returns the encoded identity key.
+func (a *Account) Curve25519() string { return Encode(a.IdentityKey.Public) }
+
+// Ed25519 returns the encoded fingerprint (signing) key.
+func (a *Account) Ed25519() string {
+	return Encode(ed25519.NewKeyFromSeed(a.SigningSeed).Public().(ed25519.PublicKey))
+}
+
+// Sign returns the encoded Ed25519 signature of message.
+func (a *Account) Sign(message []byte) string {
+	return Encode(ed25519.Sign(ed25519.NewKeyFromSeed(a.SigningSeed), message))
+}
+
+// GenerateOneTimeKeys adds n unpublished one-time keys.
+func (a *Account) GenerateOneTimeKeys(n int) error {
+	for i := 0; i < n; i++ {
+		kp, err := newKeyPair()
+		if err != nil {
+			return err
+		}
+		a.NextKeyID++
+		a.OneTimeKeys = append(a.OneTimeKeys, OneTimeKey{ID: a.NextKeyID, Key: kp})
+	}
+	if extra := len(a.OneTimeKeys) - maxOneTimeKeys; extra > 0 {
+		a.OneTimeKeys = append([]OneTimeKey(nil), a.OneTimeKeys[extra:]...)
+	}
+	return nil
+}
+
+// UnpublishedOneTimeKeys returns the encoded public one-time keys not yet marked published, by key ID.
+func (a *Account) UnpublishedOneTimeKeys() map[string]string {
+	out := make(map[string]string)
+	for _, k := range a.OneTimeKeys {
+		if !k.Published {
+			out[keyID(k.ID)] = Encode(k.Key.Public)
+		}
+	}
+	return out
+}
+
+// MarkKeysAsPublished records that every current one-time key has been uploaded.
+func (a *Account) MarkKeysAsPublished() {
+	for i := range a.OneTimeKeys {
+		a.OneTimeKeys[i].Published = true
+	}
+}
+
+// keyID encodes a one-time key counter the way libolm does.
+func keyID(id uint32) string {
+	return Encode(binary.BigEndian.AppendUint32(nil, id))
+}
+
+// NewOutboundSession starts a session to a device from its identity key and a claimed one-time key.
+// Messages stay pre-key messages until the other device answers.
+func (a *Account) NewOutboundSession(theirIdentityKey, theirOneTimeKey string) (*Session, error) {
+	identity, err := decodeKey(theirIdentityKey)
+	if err != nil {
+		return nil, err
+	}
+	otk, err := decodeKey(theirOneTimeKey)
+	if err != nil {
+		return nil, err
+	}
+	base, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	ratchet, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := tripleDH(
+		[2][]byte{a.IdentityKey.Private, otk},
+		[2][]byte{base.Private, identity},
+		[2][]byte{base.Private, otk},
+	)
+	if err != nil {
+		return nil, err
+	}
+	root, chain, err := rootKeys(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		TheirIdentityKey: identity,
+		OurIdentityKey:   a.IdentityKey.Public,
+		OurBaseKey:       base.Public,
+		TheirOneTimeKey:  otk,
+		RootKey:          root,
+		Sender:           &senderChain{Ratchet: ratchet, ChainKey: chain},
+	}, nil
+}
+
+// NewInboundSession creates a session from a pre-key message sent by theirIdentityKey and
+// returns it with the decrypted plaintext. The one-time key it used is removed only when
+// the message decrypts.
+func (a *Account) NewInboundSession(theirIdentityKey, body string) (*Session, []byte, error) {
+	raw, err := Decode(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk, err := decodePreKey(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if theirIdentityKey != "" && theirIdentityKey != Encode(pk.identityKey) {
+		return nil, nil, errors.New("olm: pre-key message from a different identity key")
+	}
+	idx := -1
+	for i, k := range a.OneTimeKeys {
+		if bytes.Equal(k.Key.Public, pk.oneTimeKey) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, nil, errors.New("olm: unknown one-time key")
+	}
+	otk := a.OneTimeKeys[idx].Key
+	secret, err := tripleDH(
+		[2][]byte{otk.Private, pk.identityKey},
+		[2][]byte{a.IdentityKey.Private, pk.baseKey},
+		[2][]byte{otk.Private, pk.baseKey},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, chain, err := rootKeys(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	inner, _, _, err := decodeMessage(pk.message)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &Session{
+		TheirIdentityKey: pk.identityKey,
+		TheirBaseKey:     pk.baseKey,
+		OurOneTimeKey:    otk.Public,
+		RootKey:          root,
+		Receivers:        []receiverChain{{RatchetKey: inner.ratchetKey, ChainKey: chain}},
+	}
+	plaintext, err := s.decryptMessage(pk.message)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.OneTimeKeys = append(a.OneTimeKeys[:idx:idx], a.OneTimeKeys[idx+1:]...)
+	return s, plaintext, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	b, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, errors.New("olm: key must be 32 bytes")
+	}
+	return b, nil
+}
+
+// tripleDH concatenates the three Diffie-Hellman results that seed a new session.
+func tripleDH(pairs ...[2][]byte) ([]byte, error) {
+	var out []byte
+	for _, p := range pairs {
+		s, err := sharedSecret(p[0], p[1])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s...)
+	}
+	return out, nil
+}
+
+// rootKeys derives the initial root and chain keys from the triple Diffie-Hellman secret.
+func rootKeys(secret []byte) (root, chain []byte, err error) {
+	out, err := hkdf.Key(sha256.New, secret, nil, "OLM_ROOT", 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:32], out[32:], nil
+}
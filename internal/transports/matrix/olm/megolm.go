@@ -0,0 +1,215 @@
+package olm
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	megolmParts      = 4
+	megolmPartLength = 32
+	megolmLength     = megolmParts * megolmPartLength
+
+	// sessionKeyVersion is the version byte of the session keys shared in m.room_key events.
+	sessionKeyVersion = 2
+)
+
+// megolmRatchet is the four-part hash ratchet behind a group session; R(0) changes
+// every 2^24 messages and R(3) every message.
+type megolmRatchet struct {
+	Data    []byte `json:"data"`
+	Counter uint32 `json:"counter"`
+}
+
+func (r *megolmRatchet) part(i int) []byte {
+	return r.Data[i*megolmPartLength : (i+1)*megolmPartLength]
+}
+
+// rehash replaces R(to) with HMAC(R(from), to).
+func (r *megolmRatchet) rehash(from, to int) {
+	copy(r.part(to), hmacSHA256(r.part(from), []byte{byte(to)}))
+}
+
+func (r *megolmRatchet) advance() {
+	mask := uint32(0x00FFFFFF)
+	h := 0
+	r.Counter++
+	for h < megolmParts {
+		if r.Counter&mask == 0 {
+			break
+		}
+		h++
+		mask >>= 8
+	}
+	for i := megolmParts - 1; i >= h; i-- {
+		r.rehash(h, i)
+	}
+}
+
+// advanceTo moves the ratchet forward to index without stepping through every message.
+func (r *megolmRatchet) advanceTo(index uint32) {
+	for j := 0; j < megolmParts; j++ {
+		shift := uint((megolmParts - j - 1) * 8)
+		mask := ^uint32(0) << shift
+		steps := ((index >> shift) - (r.Counter >> shift)) & 0xff
+		if steps == 0 {
+			if index < r.Counter {
+				steps = 0x100
+			} else {
+				continue
+			}
+		}
+		for ; steps > 1; steps-- {
+			r.rehash(j, j)
+		}
+		for k := megolmParts - 1; k >= j; k-- {
+			r.rehash(j, k)
+		}
+		r.Counter = index & mask
+	}
+}
+
+func (r megolmRatchet) clone() megolmRatchet {
+	return megolmRatchet{Data: append([]byte(nil), r.Data...), Counter: r.Counter}
+}
+
+// OutboundGroupSession encrypts the messages one device sends to a room.
+type OutboundGroupSession struct {
+	Ratchet     megolmRatchet `json:"ratchet"`
+	SigningSeed []byte        `json:"signing_seed"`
+}
+
+// NewOutboundGroupSession creates a session with a random ratchet and signing key.
+func NewOutboundGroupSession() (*OutboundGroupSession, error) {
+	data := make([]byte, megolmLength)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return &OutboundGroupSession{Ratchet: megolmRatchet{Data: data}, SigningSeed: seed}, nil
+}
+
+func (s *OutboundGroupSession) key() ed25519.PrivateKey { return ed25519.NewKeyFromSeed(s.SigningSeed) }
+
+// ID is the encoded public signing key, the session_id used in events.
+func (s *OutboundGroupSession) ID() string {
+	return Encode(s.key().Public().(ed25519.PublicKey))
+}
+
+// MessageIndex is the index the next message will be encrypted at.
+func (s *OutboundGroupSession) MessageIndex() uint32 { return s.Ratchet.Counter }
+
+// SessionKey returns the signed key to share in m.room_key; it lets the receiver
+// decrypt messages from the current index onwards.
+func (s *OutboundGroupSession) SessionKey() string {
+	b := []byte{sessionKeyVersion}
+	b = binary.BigEndian.AppendUint32(b, s.Ratchet.Counter)
+	b = append(b, s.Ratchet.Data...)
+	b = append(b, s.key().Public().(ed25519.PublicKey)...)
+	b = append(b, ed25519.Sign(s.key(), b)...)
+	return Encode(b)
+}
+
+// Encrypt returns the encoded, signed Megolm message for plaintext and advances the ratchet.
+func (s *OutboundGroupSession) Encrypt(plaintext []byte) (string, error) {
+	keys, err := deriveKeys(s.Ratchet.Data, "MEGOLM_KEYS")
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := keys.encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	var b []byte
+	b = append(b, messageVersion)
+	b = appendVarint(b, 0x08, uint64(s.Ratchet.Counter))
+	b = appendBytes(b, 0x12, ciphertext)
+	b = append(b, keys.tag(b)...)
+	b = append(b, ed25519.Sign(s.key(), b)...)
+	s.Ratchet.advance()
+	return Encode(b), nil
+}
+
+// InboundGroupSession decrypts the messages another device sends to a room.
+type InboundGroupSession struct {
+	Ratchet    megolmRatchet `json:"ratchet"` // at the first index this session can decrypt
+	SigningKey []byte        `json:"signing_key"`
+}
+
+// NewInboundGroupSession imports a session key received in m.room_key.
+func NewInboundGroupSession(sessionKey string) (*InboundGroupSession, error) {
+	raw, err := Decode(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	const size = 1 + 4 + megolmLength + ed25519.PublicKeySize
+	if len(raw) != size+ed25519.SignatureSize || raw[0] != sessionKeyVersion {
+		return nil, errors.New("olm: unsupported session key")
+	}
+	pub := ed25519.PublicKey(raw[size-ed25519.PublicKeySize : size])
+	if !ed25519.Verify(pub, raw[:size], raw[size:]) {
+		return nil, errors.New("olm: bad session key signature")
+	}
+	return &InboundGroupSession{
+		Ratchet: megolmRatchet{
+			Data:    append([]byte(nil), raw[5:5+megolmLength]...),
+			Counter: binary.BigEndian.Uint32(raw[1:5]),
+		},
+		SigningKey: append([]byte(nil), pub...),
+	}, nil
+}
+
+// ID is the encoded public signing key, the session_id used in events.
+func (s *InboundGroupSession) ID() string { return Encode(s.SigningKey) }
+
+// FirstKnownIndex is the earliest message index this session can decrypt.
+func (s *InboundGroupSession) FirstKnownIndex() uint32 { return s.Ratchet.Counter }
+
+// Decrypt verifies and decrypts a Megolm message, returning the plaintext and its index.
+func (s *InboundGroupSession) Decrypt(body string) ([]byte, uint32, error) {
+	raw, err := Decode(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(raw) < 1+macLength+ed25519.SignatureSize || raw[0] != messageVersion {
+		return nil, 0, errors.New("olm: unsupported group message")
+	}
+	signed, sig := raw[:len(raw)-ed25519.SignatureSize], raw[len(raw)-ed25519.SignatureSize:]
+	if !ed25519.Verify(ed25519.PublicKey(s.SigningKey), signed, sig) {
+		return nil, 0, errors.New("olm: bad group message signature")
+	}
+	content, mac := signed[:len(signed)-macLength], signed[len(signed)-macLength:]
+	fields, err := parseFields(content[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	index := uint32(fields[0x08].num)
+	ciphertext := fields[0x12].bytes
+	if ciphertext == nil {
+		return nil, 0, errors.New("olm: incomplete group message")
+	}
+	if index < s.Ratchet.Counter {
+		return nil, 0, fmt.Errorf("olm: message index %d is before the first known index %d", index, s.Ratchet.Counter)
+	}
+	r := s.Ratchet.clone()
+	r.advanceTo(index)
+	keys, err := deriveKeys(r.Data, "MEGOLM_KEYS")
+	if err != nil {
+		return nil, 0, err
+	}
+	if !hmac.Equal(keys.tag(content), mac) {
+		return nil, 0, ErrBadMAC
+	}
+	plaintext, err := keys.decrypt(ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plaintext, index, nil
+}
@@ -0,0 +1,165 @@
+// Package olm implements the Olm and Megolm ratchets Matrix uses for end-to-end
+// encrypted rooms: Olm sessions carry room keys between two devices, Megolm group
+// sessions encrypt the room messages themselves. The wire formats follow the
+// Matrix specification and libolm, so sessions interoperate with other clients.
+package olm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// messageVersion is the version byte of Olm and Megolm messages.
+const messageVersion = 3
+
+// macLength is the length of the truncated HMAC-SHA-256 appended to messages.
+const macLength = 8
+
+// ErrBadMAC is returned when a message fails authentication.
+var ErrBadMAC = errors.New("olm: bad message mac")
+
+// Encode returns b as unpadded standard base64, the encoding Matrix uses for keys and ciphertexts.
+func Encode(b []byte) string { return base64.RawStdEncoding.EncodeToString(b) }
+
+// Decode parses unpadded (or padded) standard base64.
+func Decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// KeyPair is a Curve25519 key pair.
+type KeyPair struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+func newKeyPair() (KeyPair, error) {
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Private: k.Bytes(), Public: k.PublicKey().Bytes()}, nil
+}
+
+// sharedSecret performs Curve25519 Diffie-Hellman between a private and a public key.
+func sharedSecret(private, public []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(pub)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// cipherKeys are the AES-256-CBC and HMAC-SHA-256 keys derived for one message.
+type cipherKeys struct {
+	aes, mac, iv []byte
+}
+
+func deriveKeys(secret []byte, info string) (cipherKeys, error) {
+	out, err := hkdf.Key(sha256.New, secret, nil, info, 80)
+	if err != nil {
+		return cipherKeys{}, err
+	}
+	return cipherKeys{aes: out[:32], mac: out[32:64], iv: out[64:80]}, nil
+}
+
+func (k cipherKeys) encrypt(plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k.aes)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	out := append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, k.iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+func (k cipherKeys) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("olm: ciphertext is not a whole number of blocks")
+	}
+	block, err := aes.NewCipher(k.aes)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, k.iv).CryptBlocks(out, ciphertext)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(out) {
+		return nil, errors.New("olm: bad padding")
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("olm: bad padding")
+		}
+	}
+	return out[:len(out)-pad], nil
+}
+
+// tag truncates the message HMAC to macLength bytes.
+func (k cipherKeys) tag(message []byte) []byte {
+	return hmacSHA256(k.mac, message)[:macLength]
+}
+
+// appendBytes and appendVarint write the protobuf-style fields of Olm and Megolm messages.
+func appendBytes(b []byte, tag byte, v []byte) []byte {
+	b = append(b, tag)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendVarint(b []byte, tag byte, v uint64) []byte {
+	return binary.AppendUvarint(append(b, tag), v)
+}
+
+// field is one decoded message field: bytes for length-delimited tags, num for varints.
+type field struct {
+	bytes []byte
+	num   uint64
+}
+
+// parseFields decodes the fields after a message's version byte, keyed by tag.
+func parseFields(b []byte) (map[byte]field, error) {
+	out := make(map[byte]field)
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("olm: truncated message")
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			out[tag] = field{num: v}
+		case 2:
+			if v > uint64(len(b)) {
+				return nil, errors.New("olm: truncated message")
+			}
+			out[tag] = field{bytes: b[:v]}
+			b = b[v:]
+		default:
+			return nil, errors.New("olm: unsupported field type")
+		}
+	}
+	return out, nil
+}
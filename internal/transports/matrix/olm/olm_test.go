@@ -0,0 +1,231 @@
+package olm
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func newAccount(t *testing.T, keys int) *Account {
+	t.Helper()
+	a, err := NewAccount()
+	if err != nil {
+		t.Fatalf("account: %v", err)
+	}
+	if err := a.GenerateOneTimeKeys(keys); err != nil {
+		t.Fatalf("one-time keys: %v", err)
+	}
+	return a
+}
+
+func anyKey(m map[string]string) string {
+	for _, v := range m {
+		return v
+	}
+	return ""
+}
+
+func encrypt(t *testing.T, s *Session, text string) (int, string) {
+	t.Helper()
+	typ, body, err := s.Encrypt([]byte(text))
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	return typ, body
+}
+
+func decrypt(t *testing.T, s *Session, typ int, body, want string) {
+	t.Helper()
+	got, err := s.Decrypt(typ, body)
+	if err != nil {
+		t.Fatalf("decrypt %q: %v", want, err)
+	}
+	if string(got) != want {
+		t.Fatalf("decrypted %q, want %q", got, want)
+	}
+}
+
+func TestOlmSessionRoundTrip(t *testing.T) {
+	alice := newAccount(t, 0)
+	bob := newAccount(t, 2)
+	otk := anyKey(bob.UnpublishedOneTimeKeys())
+
+	out, err := alice.NewOutboundSession(bob.Curve25519(), otk)
+	if err != nil {
+		t.Fatalf("outbound: %v", err)
+	}
+	typ, first := encrypt(t, out, "hello bob")
+	if typ != MsgTypePreKey {
+		t.Fatalf("first message should be a pre-key message, got type %d", typ)
+	}
+	typ2, second := encrypt(t, out, "still there?")
+
+	in, plaintext, err := bob.NewInboundSession(alice.Curve25519(), first)
+	if err != nil {
+		t.Fatalf("inbound: %v", err)
+	}
+	if string(plaintext) != "hello bob" {
+		t.Fatalf("unexpected plaintext %q", plaintext)
+	}
+	if len(bob.OneTimeKeys) != 1 {
+		t.Fatalf("used one-time key should be removed, have %d", len(bob.OneTimeKeys))
+	}
+	if !in.MatchesInbound(alice.Curve25519(), second) || in.ID() != out.ID() {
+		t.Fatalf("second pre-key message should match the inbound session")
+	}
+	if _, _, err := bob.NewInboundSession(alice.Curve25519(), second); err == nil {
+		t.Fatalf("one-time key must not be usable twice")
+	}
+	decrypt(t, in, typ2, second, "still there?")
+	if _, err := in.Decrypt(typ2, second); err == nil {
+		t.Fatalf("replayed message should not decrypt")
+	}
+
+	// Bob answers, which ends Alice's pre-key phase and turns the ratchet both ways.
+	typ, reply := encrypt(t, in, "hi alice")
+	if typ != MsgTypeMessage {
+		t.Fatalf("reply should be a normal message, got type %d", typ)
+	}
+	decrypt(t, out, typ, reply, "hi alice")
+	typ, next := encrypt(t, out, "new ratchet")
+	if typ != MsgTypeMessage {
+		t.Fatalf("message after a reply should be normal, got type %d", typ)
+	}
+	decrypt(t, in, typ, next, "new ratchet")
+
+	// Out of order delivery within a chain uses the skipped keys.
+	typA, a := encrypt(t, in, "one")
+	typB, b := encrypt(t, in, "two")
+	decrypt(t, out, typB, b, "two")
+	decrypt(t, out, typA, a, "one")
+}
+
+func TestOlmDecryptFailureLeavesSessionUsable(t *testing.T) {
+	alice := newAccount(t, 0)
+	bob := newAccount(t, 1)
+	out, err := alice.NewOutboundSession(bob.Curve25519(), anyKey(bob.UnpublishedOneTimeKeys()))
+	if err != nil {
+		t.Fatalf("outbound: %v", err)
+	}
+	_, first := encrypt(t, out, "hello")
+	in, _, err := bob.NewInboundSession("", first)
+	if err != nil {
+		t.Fatalf("inbound: %v", err)
+	}
+	typ, reply := encrypt(t, in, "reply")
+	raw, _ := Decode(reply)
+	raw[len(raw)-1] ^= 1
+	if _, err := out.Decrypt(typ, Encode(raw)); err != ErrBadMAC {
+		t.Fatalf("expected a mac error, got %v", err)
+	}
+	decrypt(t, out, typ, reply, "reply")
+}
+
+func TestSessionSurvivesJSON(t *testing.T) {
+	alice := newAccount(t, 0)
+	bob := newAccount(t, 1)
+	out, err := alice.NewOutboundSession(bob.Curve25519(), anyKey(bob.UnpublishedOneTimeKeys()))
+	if err != nil {
+		t.Fatalf("outbound: %v", err)
+	}
+	_, first := encrypt(t, out, "hello")
+	raw, err := json.Marshal(bob)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var restored Account
+	if err := json.Unmarshal(raw, &restored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if restored.Ed25519() != bob.Ed25519() || restored.Curve25519() != bob.Curve25519() {
+		t.Fatalf("identity keys changed across JSON")
+	}
+	in, _, err := restored.NewInboundSession("", first)
+	if err != nil {
+		t.Fatalf("inbound from restored account: %v", err)
+	}
+	raw, _ = json.Marshal(in)
+	var again Session
+	_ = json.Unmarshal(raw, &again)
+	typ, reply := encrypt(t, &again, "from restored session")
+	decrypt(t, out, typ, reply, "from restored session")
+}
+
+func TestOneTimeKeysAreCapped(t *testing.T) {
+	a := newAccount(t, maxOneTimeKeys+5)
+	if len(a.OneTimeKeys) != maxOneTimeKeys || a.OneTimeKeys[0].ID != 6 {
+		t.Fatalf("expected the oldest keys dropped, have %d starting at %d", len(a.OneTimeKeys), a.OneTimeKeys[0].ID)
+	}
+	a.MarkKeysAsPublished()
+	if len(a.UnpublishedOneTimeKeys()) != 0 {
+		t.Fatalf("published keys should not be listed")
+	}
+	if keyID(1) != "AAAAAQ" {
+		t.Fatalf("unexpected key id %q", keyID(1))
+	}
+}
+
+func TestMegolmAdvanceToMatchesStepping(t *testing.T) {
+	start := make([]byte, megolmLength)
+	for i := range start {
+		start[i] = byte(i)
+	}
+	stepped := megolmRatchet{Data: append([]byte(nil), start...)}
+	for _, target := range []uint32{1, 255, 256, 257, 1000, 0x10000, 0x10203} {
+		for stepped.Counter < target {
+			stepped.advance()
+		}
+		jumped := megolmRatchet{Data: append([]byte(nil), start...)}
+		jumped.advanceTo(target)
+		if jumped.Counter != target || !bytes.Equal(jumped.Data, stepped.Data) {
+			t.Fatalf("advanceTo(%d) differs from stepping", target)
+		}
+	}
+}
+
+func TestMegolmRoundTrip(t *testing.T) {
+	out, err := NewOutboundGroupSession()
+	if err != nil {
+		t.Fatalf("outbound: %v", err)
+	}
+	early, _ := out.Encrypt([]byte("before the key was shared"))
+	in, err := NewInboundGroupSession(out.SessionKey())
+	if err != nil {
+		t.Fatalf("inbound: %v", err)
+	}
+	if in.ID() != out.ID() || in.FirstKnownIndex() != 1 {
+		t.Fatalf("unexpected inbound session %s at %d", in.ID(), in.FirstKnownIndex())
+	}
+	if _, _, err := in.Decrypt(early); err == nil {
+		t.Fatalf("messages before the shared index must not decrypt")
+	}
+	var bodies []string
+	for _, text := range []string{"one", "two", "three"} {
+		body, err := out.Encrypt([]byte(text))
+		if err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+		bodies = append(bodies, body)
+	}
+	for i := len(bodies) - 1; i >= 0; i-- {
+		got, index, err := in.Decrypt(bodies[i])
+		if err != nil {
+			t.Fatalf("decrypt %d: %v", i, err)
+		}
+		if want := []string{"one", "two", "three"}[i]; string(got) != want || index != uint32(i+1) {
+			t.Fatalf("got %q at %d, want %q at %d", got, index, want, i+1)
+		}
+	}
+
+	raw, _ := Decode(bodies[0])
+	raw[3] ^= 1
+	if _, _, err := in.Decrypt(Encode(raw)); err == nil {
+		t.Fatalf("tampered message should fail")
+	}
+	forged, _ := NewOutboundGroupSession()
+	forged.Ratchet = out.Ratchet.clone()
+	body, _ := forged.Encrypt([]byte("forged"))
+	if _, _, err := in.Decrypt(body); err == nil {
+		t.Fatalf("message signed by another key should fail")
+	}
+}
@@ -0,0 +1,333 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Olm message types as they appear in m.olm.v1.curve25519-aes-sha2 ciphertexts.
+const (
+	MsgTypePreKey  = 0
+	MsgTypeMessage = 1
+)
+
+// Limits taken from libolm.
+const (
+	maxReceiverChains = 5
+	maxSkippedKeys    = 40
+	maxMessageGap     = 2000
+)
+
+// Session is one Olm double-ratchet session with another device. It is plain data
+// so callers can persist it as JSON.
+type Session struct {
+	TheirIdentityKey []byte `json:"their_identity_key"`
+
+	// Inbound sessions remember the pre-key fields that created them so repeated
+	// pre-key messages from the same sender find the session again.
+	TheirBaseKey  []byte `json:"their_base_key,omitempty"`
+	OurOneTimeKey []byte `json:"our_one_time_key,omitempty"`
+
+	// Outbound sessions send these in pre-key messages until the other side answers.
+	OurIdentityKey  []byte `json:"our_identity_key,omitempty"`
+	OurBaseKey      []byte `json:"our_base_key,omitempty"`
+	TheirOneTimeKey []byte `json:"their_one_time_key,omitempty"`
+	ReceivedMessage bool   `json:"received_message"`
+
+	RootKey   []byte          `json:"root_key"`
+	Sender    *senderChain    `json:"sender,omitempty"`
+	Receivers []receiverChain `json:"receivers,omitempty"` // newest last
+	Skipped   []skippedKey    `json:"skipped,omitempty"`
+}
+
+type senderChain struct {
+	Ratchet  KeyPair `json:"ratchet"`
+	ChainKey []byte  `json:"chain_key"`
+	Index    uint32  `json:"index"`
+}
+
+type receiverChain struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	ChainKey   []byte `json:"chain_key"`
+	Index      uint32 `json:"index"`
+}
+
+// skippedKey keeps the message key of a message that has not arrived yet.
+type skippedKey struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	Index      uint32 `json:"index"`
+	MessageKey []byte `json:"message_key"`
+}
+
+// ID identifies the session the way libolm does: a hash of the keys that created it.
+func (s *Session) ID() string {
+	var in []byte
+	if s.OurBaseKey != nil {
+		in = append(append(append(in, s.OurIdentityKey...), s.OurBaseKey...), s.TheirOneTimeKey...)
+	} else {
+		in = append(append(append(in, s.TheirIdentityKey...), s.TheirBaseKey...), s.OurOneTimeKey...)
+	}
+	sum := sha256.Sum256(in)
+	return Encode(sum[:])
+}
+
+// MatchesInbound reports whether a pre-key message belongs to this inbound session.
+func (s *Session) MatchesInbound(theirIdentityKey, body string) bool {
+	if s.TheirBaseKey == nil {
+		return false
+	}
+	raw, err := Decode(body)
+	if err != nil {
+		return false
+	}
+	pk, err := decodePreKey(raw)
+	if err != nil {
+		return false
+	}
+	if theirIdentityKey != "" && theirIdentityKey != Encode(s.TheirIdentityKey) {
+		return false
+	}
+	return bytes.Equal(pk.identityKey, s.TheirIdentityKey) &&
+		bytes.Equal(pk.baseKey, s.TheirBaseKey) &&
+		bytes.Equal(pk.oneTimeKey, s.OurOneTimeKey)
+}
+
+// Encrypt returns the message type and encoded body for plaintext.
+func (s *Session) Encrypt(plaintext []byte) (int, string, error) {
+	if s.Sender == nil {
+		if len(s.Receivers) == 0 {
+			return 0, "", errors.New("olm: session has no chain to ratchet from")
+		}
+		ratchet, err := newKeyPair()
+		if err != nil {
+			return 0, "", err
+		}
+		root, chain, err := ratchetStep(s.RootKey, ratchet.Private, s.Receivers[len(s.Receivers)-1].RatchetKey)
+		if err != nil {
+			return 0, "", err
+		}
+		s.RootKey = root
+		s.Sender = &senderChain{Ratchet: ratchet, ChainKey: chain}
+	}
+	keys, err := deriveKeys(messageKey(s.Sender.ChainKey), "OLM_KEYS")
+	if err != nil {
+		return 0, "", err
+	}
+	ciphertext, err := keys.encrypt(plaintext)
+	if err != nil {
+		return 0, "", err
+	}
+	raw := encodeMessage(s.Sender.Ratchet.Public, s.Sender.Index, ciphertext, keys)
+	s.Sender.ChainKey = nextChainKey(s.Sender.ChainKey)
+	s.Sender.Index++
+	if !s.ReceivedMessage && s.OurBaseKey != nil {
+		var b []byte
+		b = append(b, messageVersion)
+		b = appendBytes(b, 0x0A, s.TheirOneTimeKey)
+		b = appendBytes(b, 0x12, s.OurBaseKey)
+		b = appendBytes(b, 0x1A, s.OurIdentityKey)
+		b = appendBytes(b, 0x22, raw)
+		return MsgTypePreKey, Encode(b), nil
+	}
+	return MsgTypeMessage, Encode(raw), nil
+}
+
+// Decrypt authenticates and decrypts a message of the given type. The session is only
+// changed when the message decrypts.
+func (s *Session) Decrypt(msgType int, body string) ([]byte, error) {
+	raw, err := Decode(body)
+	if err != nil {
+		return nil, err
+	}
+	switch msgType {
+	case MsgTypePreKey:
+		pk, err := decodePreKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		return s.decryptMessage(pk.message)
+	case MsgTypeMessage:
+		return s.decryptMessage(raw)
+	default:
+		return nil, fmt.Errorf("olm: unknown message type %d", msgType)
+	}
+}
+
+func (s *Session) decryptMessage(raw []byte) ([]byte, error) {
+	msg, signed, mac, err := decodeMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	for i := range s.Receivers {
+		chain := &s.Receivers[i]
+		if !bytes.Equal(chain.RatchetKey, msg.ratchetKey) {
+			continue
+		}
+		if msg.index < chain.Index {
+			return s.decryptSkipped(msg, signed, mac)
+		}
+		plaintext, next, skipped, err := decryptFromChain(*chain, msg, signed, mac)
+		if err != nil {
+			return nil, err
+		}
+		*chain = next
+		s.addSkipped(skipped)
+		s.ReceivedMessage = true
+		return plaintext, nil
+	}
+
+	// The other side has started a new ratchet key; that only happens after it read
+	// one of our messages, so we must have a sender chain to derive the new chain from.
+	if s.Sender == nil {
+		return nil, errors.New("olm: message for an unknown chain")
+	}
+	root, chainKey, err := ratchetStep(s.RootKey, s.Sender.Ratchet.Private, msg.ratchetKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, next, skipped, err := decryptFromChain(receiverChain{RatchetKey: msg.ratchetKey, ChainKey: chainKey}, msg, signed, mac)
+	if err != nil {
+		return nil, err
+	}
+	s.RootKey = root
+	s.Sender = nil
+	s.Receivers = append(s.Receivers, next)
+	if len(s.Receivers) > maxReceiverChains {
+		s.Receivers = append([]receiverChain(nil), s.Receivers[len(s.Receivers)-maxReceiverChains:]...)
+	}
+	s.addSkipped(skipped)
+	s.ReceivedMessage = true
+	return plaintext, nil
+}
+
+// decryptFromChain advances a copy of chain to the message index and decrypts; the caller
+// commits the returned chain and skipped keys only on success.
+func decryptFromChain(chain receiverChain, msg message, signed, mac []byte) ([]byte, receiverChain, []skippedKey, error) {
+	if msg.index-chain.Index > maxMessageGap {
+		return nil, chain, nil, errors.New("olm: message too far ahead")
+	}
+	var skipped []skippedKey
+	chainKey := chain.ChainKey
+	for i := chain.Index; i < msg.index; i++ {
+		skipped = append(skipped, skippedKey{RatchetKey: chain.RatchetKey, Index: i, MessageKey: messageKey(chainKey)})
+		chainKey = nextChainKey(chainKey)
+	}
+	plaintext, err := openMessage(messageKey(chainKey), msg, signed, mac)
+	if err != nil {
+		return nil, chain, nil, err
+	}
+	return plaintext, receiverChain{RatchetKey: chain.RatchetKey, ChainKey: nextChainKey(chainKey), Index: msg.index + 1}, skipped, nil
+}
+
+func (s *Session) decryptSkipped(msg message, signed, mac []byte) ([]byte, error) {
+	for i, k := range s.Skipped {
+		if k.Index != msg.index || !bytes.Equal(k.RatchetKey, msg.ratchetKey) {
+			continue
+		}
+		plaintext, err := openMessage(k.MessageKey, msg, signed, mac)
+		if err != nil {
+			return nil, err
+		}
+		s.Skipped = append(s.Skipped[:i:i], s.Skipped[i+1:]...)
+		return plaintext, nil
+	}
+	return nil, errors.New("olm: message key already used")
+}
+
+func (s *Session) addSkipped(keys []skippedKey) {
+	s.Skipped = append(s.Skipped, keys...)
+	if len(s.Skipped) > maxSkippedKeys {
+		s.Skipped = append([]skippedKey(nil), s.Skipped[len(s.Skipped)-maxSkippedKeys:]...)
+	}
+}
+
+func openMessage(key []byte, msg message, signed, mac []byte) ([]byte, error) {
+	keys, err := deriveKeys(key, "OLM_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keys.tag(signed), mac) {
+		return nil, ErrBadMAC
+	}
+	return keys.decrypt(msg.ciphertext)
+}
+
+// ratchetStep derives the next root and chain keys from a new ratchet key exchange.
+func ratchetStep(root, ourPrivate, theirPublic []byte) ([]byte, []byte, error) {
+	secret, err := sharedSecret(ourPrivate, theirPublic)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := hkdf.Key(sha256.New, secret, root, "OLM_RATCHET", 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:32], out[32:], nil
+}
+
+func messageKey(chainKey []byte) []byte   { return hmacSHA256(chainKey, []byte{0x01}) }
+func nextChainKey(chainKey []byte) []byte { return hmacSHA256(chainKey, []byte{0x02}) }
+
+type message struct {
+	ratchetKey []byte
+	index      uint32
+	ciphertext []byte
+}
+
+func encodeMessage(ratchetKey []byte, index uint32, ciphertext []byte, keys cipherKeys) []byte {
+	var b []byte
+	b = append(b, messageVersion)
+	b = appendBytes(b, 0x0A, ratchetKey)
+	b = appendVarint(b, 0x10, uint64(index))
+	b = appendBytes(b, 0x22, ciphertext)
+	return append(b, keys.tag(b)...)
+}
+
+// decodeMessage splits an Olm message into its fields, the authenticated bytes and the MAC.
+func decodeMessage(raw []byte) (message, []byte, []byte, error) {
+	if len(raw) < 1+macLength || raw[0] != messageVersion {
+		return message{}, nil, nil, errors.New("olm: unsupported message")
+	}
+	signed, mac := raw[:len(raw)-macLength], raw[len(raw)-macLength:]
+	fields, err := parseFields(signed[1:])
+	if err != nil {
+		return message{}, nil, nil, err
+	}
+	m := message{
+		ratchetKey: fields[0x0A].bytes,
+		index:      uint32(fields[0x10].num),
+		ciphertext: fields[0x22].bytes,
+	}
+	if len(m.ratchetKey) != 32 || m.ciphertext == nil {
+		return message{}, nil, nil, errors.New("olm: incomplete message")
+	}
+	return m, signed, mac, nil
+}
+
+type preKeyMessage struct {
+	oneTimeKey, baseKey, identityKey, message []byte
+}
+
+func decodePreKey(raw []byte) (preKeyMessage, error) {
+	if len(raw) < 1 || raw[0] != messageVersion {
+		return preKeyMessage{}, errors.New("olm: unsupported pre-key message")
+	}
+	fields, err := parseFields(raw[1:])
+	if err != nil {
+		return preKeyMessage{}, err
+	}
+	pk := preKeyMessage{
+		oneTimeKey:  fields[0x0A].bytes,
+		baseKey:     fields[0x12].bytes,
+		identityKey: fields[0x1A].bytes,
+		message:     fields[0x22].bytes,
+	}
+	if len(pk.oneTimeKey) != 32 || len(pk.baseKey) != 32 || len(pk.identityKey) != 32 || pk.message == nil {
+		return preKeyMessage{}, errors.New("olm: incomplete pre-key message")
+	}
+	return pk, nil
+}
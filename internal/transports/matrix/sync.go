@@ -0,0 +1,405 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// dmCacheTTL bounds how long a room's direct-chat membership check is trusted.
+const dmCacheTTL = 5 * time.Minute
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			State struct {
+				Events []roomEvent `json:"events"`
+			} `json:"state"`
+			Timeline struct {
+				Events []roomEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []roomEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+	ToDevice struct {
+		Events []roomEvent `json:"events"`
+	} `json:"to_device"`
+	DeviceLists struct {
+		Changed []string `json:"changed"`
+		Left    []string `json:"left"`
+	} `json:"device_lists"`
+	OneTimeKeyCounts map[string]int `json:"device_one_time_keys_count"`
+}
+
+type roomEvent struct {
+	Type     string          `json:"type"`
+	Sender   string          `json:"sender"`
+	EventID  string          `json:"event_id"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type messageContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	RelatesTo *struct {
+		RelType   string `json:"rel_type"`
+		EventID   string `json:"event_id"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+}
+
+// Start long-polls /sync until ctx is done. The sync token is saved after every batch so
+// a restart resumes where it stopped; without a saved token the backlog is skipped.
+func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	if err := t.resolveUserID(ctx); err != nil {
+		return err
+	}
+	if err := t.setupEncryption(ctx); err != nil {
+		return err
+	}
+	since := ""
+	if t.store != nil {
+		tok, err := t.store.SyncToken(t.syncKey())
+		if err != nil {
+			t.log.Warn("load sync token failed", "err", err)
+		}
+		since = tok
+	}
+
+	backoff := time.Second
+	for {
+		res, err := t.sync(ctx, since)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			t.log.Warn("sync failed", "err", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		t.acceptInvites(ctx, res)
+		t.noteEncryption(res)
+		if m := t.crypto.Load(); m != nil {
+			m.handleSync(ctx, res)
+		}
+		if since != "" {
+			t.dispatch(ctx, res, inbound)
+		}
+		since = res.NextBatch
+		if t.store != nil && since != "" {
+			if err := t.store.SaveSyncToken(t.syncKey(), since); err != nil {
+				t.log.Warn("save sync token failed", "err", err)
+			}
+		}
+	}
+}
+
+func (t *Transport) syncKey() string { return "matrix:" + t.cfg.ID }
+
+// resolveUserID looks up the bot's MXID (unless configured) and the device its access token belongs to.
+func (t *Transport) resolveUserID(ctx context.Context) error {
+	var who struct {
+		UserID   string `json:"user_id"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := t.do(ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, nil, &who); err != nil {
+		if t.self() == "" {
+			return err
+		}
+		t.log.Warn("whoami failed; continuing without a device ID", "err", err)
+		return nil
+	}
+	t.mu.Lock()
+	if t.userID == "" {
+		t.userID = who.UserID
+	}
+	t.deviceID = who.DeviceID
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Transport) self() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.userID
+}
+
+func (t *Transport) sync(ctx context.Context, since string) (syncResponse, error) {
+	filter := `{"presence":{"types":[]},"room":{"ephemeral":{"types":[]}}}`
+	if since == "" {
+		// First run: fetch only the position and pending invites, not the room backlog.
+		filter = `{"presence":{"types":[]},"room":{"ephemeral":{"types":[]},"timeline":{"limit":0}}}`
+	}
+	q := url.Values{}
+	q.Set("filter", filter)
+	q.Set("timeout", strconv.Itoa(t.cfg.SyncTimeoutSecs*1000))
+	if since != "" {
+		q.Set("since", since)
+	}
+	var res syncResponse
+	err := t.do(ctx, http.MethodGet, "/_matrix/client/v3/sync", q, nil, &res)
+	return res, err
+}
+
+// acceptInvites joins rooms the bot was invited to by an allowed user or that are configured.
+// Encrypted rooms are refused when encryption is unavailable.
+func (t *Transport) acceptInvites(ctx context.Context, res syncResponse) {
+	me := t.self()
+	for room, inv := range res.Rooms.Invite {
+		inviter := ""
+		encrypted := false
+		for _, ev := range inv.InviteState.Events {
+			if ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == me {
+				inviter = ev.Sender
+			}
+			if ev.Type == "m.room.encryption" {
+				encrypted = true
+			}
+		}
+		_, allowedUser := t.allowed[inviter]
+		_, configured := t.rooms[room]
+		if !allowedUser && !configured {
+			t.log.Warn("ignoring invite", "room", room, "inviter", inviter)
+			continue
+		}
+		if encrypted && t.crypto.Load() == nil {
+			t.log.Error("not joining end-to-end encrypted room: encryption is unavailable: "+t.noCrypto, "room", room, "inviter", inviter)
+			continue
+		}
+		if err := t.do(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(room), nil, map[string]any{}, nil); err != nil {
+			t.log.Warn("join failed", "room", room, "err", err)
+			continue
+		}
+		if encrypted {
+			t.setEncrypted(room, true)
+		}
+		t.log.Info("joined room", "room", room, "inviter", inviter)
+	}
+}
+
+// noteEncryption records rooms whose state turns on encryption. Without encryption
+// support such a room can no longer be used, which is reported once.
+func (t *Transport) noteEncryption(res syncResponse) {
+	for room, joined := range res.Rooms.Join {
+		for _, events := range [][]roomEvent{joined.State.Events, joined.Timeline.Events} {
+			for _, ev := range events {
+				if ev.Type != "m.room.encryption" || ev.StateKey == nil || *ev.StateKey != "" {
+					continue
+				}
+				if !t.setEncrypted(room, true) && t.crypto.Load() == nil {
+					t.log.Error("room is end-to-end encrypted; its messages are ignored because encryption is unavailable: "+t.noCrypto, "room", room)
+				}
+			}
+		}
+	}
+}
+
+// setEncrypted caches whether room is encrypted and reports whether it was already known to be.
+func (t *Transport) setEncrypted(room string, encrypted bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	was := t.encrypted[room]
+	t.encrypted[room] = encrypted
+	return was
+}
+
+// roomEncrypted reports whether room has encryption turned on, from the state seen in
+// sync or else the room's m.room.encryption state event.
+func (t *Transport) roomEncrypted(ctx context.Context, room string) (bool, error) {
+	t.mu.Lock()
+	encrypted, ok := t.encrypted[room]
+	t.mu.Unlock()
+	if ok {
+		return encrypted, nil
+	}
+	err := t.do(ctx, http.MethodGet, "/_matrix/client/v3/rooms/"+url.PathEscape(room)+"/state/m.room.encryption", nil, nil, nil)
+	var apiErr *apiError
+	switch {
+	case err == nil:
+		encrypted = true
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+		encrypted = false
+	default:
+		return false, err
+	}
+	t.setEncrypted(room, encrypted)
+	return encrypted, nil
+}
+
+// dispatch forwards accepted messages from the batch's room timelines, after encrypted
+// messages from earlier batches whose room key has now arrived.
+func (t *Transport) dispatch(ctx context.Context, res syncResponse, inbound chan<- core.InboundMessage) {
+	if m := t.crypto.Load(); m != nil {
+		for _, p := range m.readyPending() {
+			if !t.forward(ctx, p.room, p.ev, inbound) {
+				return
+			}
+		}
+	}
+	for room, joined := range res.Rooms.Join {
+		for _, ev := range joined.Timeline.Events {
+			if !t.forward(ctx, room, ev, inbound) {
+				return
+			}
+		}
+	}
+}
+
+// forward passes an accepted event on and reports false once ctx is done.
+func (t *Transport) forward(ctx context.Context, room string, ev roomEvent, inbound chan<- core.InboundMessage) bool {
+	msg, ok := t.toInbound(ctx, room, ev)
+	if !ok {
+		return true
+	}
+	select {
+	case inbound <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// toInbound maps a text message from an allowed user in a configured room or a direct chat.
+// Messages in a Matrix thread get ThreadID "room|root"; everything else is keyed by room.
+func (t *Transport) toInbound(ctx context.Context, room string, ev roomEvent) (core.InboundMessage, bool) {
+	if ev.Sender == t.self() {
+		return core.InboundMessage{}, false
+	}
+	if ev.Type == "m.room.encrypted" {
+		// Without encryption support, encrypted rooms were refused at startup or join
+		// time, or reported when their state turned encryption on.
+		m := t.crypto.Load()
+		if m == nil {
+			return core.InboundMessage{}, false
+		}
+		if _, ok := t.allowed[ev.Sender]; !ok {
+			t.log.Warn("rejecting sender not in allowlist", "sender", ev.Sender)
+			return core.InboundMessage{}, false
+		}
+		dec, err := m.decryptRoomEvent(room, ev)
+		if errors.Is(err, errNoRoomKey) {
+			m.deferEvent(room, ev)
+			return core.InboundMessage{}, false
+		}
+		if err != nil {
+			t.log.Warn("cannot decrypt message", "room", room, "event", ev.EventID, "sender", ev.Sender, "err", err)
+			return core.InboundMessage{}, false
+		}
+		ev = dec
+	}
+	if ev.Type != "m.room.message" {
+		return core.InboundMessage{}, false
+	}
+	var c messageContent
+	if err := json.Unmarshal(ev.Content, &c); err != nil || c.MsgType != "m.text" {
+		return core.InboundMessage{}, false
+	}
+	if c.RelatesTo != nil && c.RelatesTo.RelType == "m.replace" {
+		return core.InboundMessage{}, false
+	}
+	if _, ok := t.allowed[ev.Sender]; !ok {
+		t.log.Warn("rejecting sender not in allowlist", "sender", ev.Sender)
+		return core.InboundMessage{}, false
+	}
+	if _, ok := t.rooms[room]; !ok && !t.isDirect(ctx, room, ev.Sender) {
+		t.log.Warn("rejecting message from room not configured", "room", room)
+		return core.InboundMessage{}, false
+	}
+
+	threadID := room
+	if c.RelatesTo != nil && c.RelatesTo.RelType == "m.thread" && c.RelatesTo.EventID != "" {
+		threadID = room + threadSep + c.RelatesTo.EventID
+	}
+	text := c.Body
+	if c.RelatesTo != nil && c.RelatesTo.InReplyTo != nil {
+		text = stripReplyFallback(text)
+	}
+	t.mu.Lock()
+	if len(t.lastSeen) >= 4096 {
+		t.lastSeen = make(map[string]string)
+	}
+	t.lastSeen[threadID] = ev.EventID
+	t.mu.Unlock()
+
+	return core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    ev.Sender,
+		Text:      strings.TrimSpace(text),
+		ThreadID:  threadID,
+		Meta: map[string]any{
+			"room_id":  room,
+			"event_id": ev.EventID,
+		},
+	}, true
+}
+
+// isDirect reports whether room is a two-member chat between the bot and sender.
+func (t *Transport) isDirect(ctx context.Context, room, sender string) bool {
+	t.mu.Lock()
+	c, ok := t.dms[room]
+	t.mu.Unlock()
+	if ok && time.Since(c.checked) < dmCacheTTL {
+		return c.direct
+	}
+	members, err := t.joinedMembers(ctx, room)
+	if err != nil {
+		t.log.Warn("joined_members failed", "room", room, "err", err)
+		return false
+	}
+	_, hasSender := members[sender]
+	_, hasSelf := members[t.self()]
+	direct := len(members) == 2 && hasSender && hasSelf
+	t.mu.Lock()
+	t.dms[room] = dmCheck{direct: direct, checked: time.Now()}
+	t.mu.Unlock()
+	return direct
+}
+
+// joinedMembers returns the room's joined members keyed by MXID.
+func (t *Transport) joinedMembers(ctx context.Context, room string) (map[string]json.RawMessage, error) {
+	var members struct {
+		Joined map[string]json.RawMessage `json:"joined"`
+	}
+	err := t.do(ctx, http.MethodGet, "/_matrix/client/v3/rooms/"+url.PathEscape(room)+"/joined_members", nil, nil, &members)
+	return members.Joined, err
+}
+
+// stripReplyFallback drops the "> quoted" lines clients prepend to rich replies.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
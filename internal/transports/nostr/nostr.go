@@ -3,6 +3,9 @@ package nostr
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/joelklabo/buddy/internal/core"
 	client "github.com/joelklabo/buddy/internal/nostrclient"
@@ -13,57 +16,182 @@ import (
 
 // Config holds the parameters needed to run the Nostr transport.
 type Config struct {
-	Relays         []string
-	PrivateKey     string
-	AllowedPubkeys []string
+	Relays          []string
+	PrivateKey      string
+	Bunker          string // NIP-46 bunker:// URL; the remote signer holds the key instead of PrivateKey
+	BunkerClientKey string // hex key identifying this runner to the bunker
+	AllowedPubkeys  []string
+	RequireNIP17    bool           // accept only NIP-17 gift-wrapped DMs
+	Profile         client.Profile // kind 0 metadata published at start
+	DVMKinds        []int          // NIP-90 job kinds served; also announced via NIP-89
 }
 
-// Transport implements core.Transport for Nostr DMs.
+// jobTTL is how long a job's thread waits for the reply that is published as its result.
+const jobTTL = 2 * time.Hour
+
+// Transport implements core.Transport for Nostr DMs (NIP-04 and NIP-17) and, optionally,
+// NIP-90 job requests.
 type Transport struct {
 	cfg    Config
 	store  *store.Store
 	client nostrClient
 	id     string
+
+	jobMu sync.Mutex
+	jobs  map[string]job // thread ID (the request's event ID) -> job
+}
+
+type job struct {
+	req *nostr.Event
+	at  time.Time
 }
 
 type nostrClient interface {
 	Listen(ctx context.Context, handler func(context.Context, client.IncomingMessage)) error
 	SendReply(ctx context.Context, toPubKey string, message string) error
+	PublishProfile(ctx context.Context, p client.Profile) error
+	AnnounceDVM(ctx context.Context, p client.Profile) error
+	SendJobResult(ctx context.Context, job *nostr.Event, content string) error
+	SendJobFeedback(ctx context.Context, job *nostr.Event, status, info string) error
 }
 
-// New creates a Nostr transport.
+// New creates a Nostr transport. With a bunker URL it connects to the remote signer first.
 func New(cfg Config, st *store.Store) (*Transport, error) {
-	if cfg.PrivateKey == "" {
-		return nil, fmt.Errorf("nostr private key required")
-	}
-	pub, err := nostr.GetPublicKey(cfg.PrivateKey)
+	signer, err := newSigner(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("derive pubkey: %w", err)
+		return nil, err
+	}
+	var opts []client.Option
+	if cfg.RequireNIP17 {
+		opts = append(opts, client.RequireNIP17())
 	}
-	c := client.New(cfg.PrivateKey, pub, cfg.Relays, cfg.AllowedPubkeys, st)
+	if len(cfg.DVMKinds) > 0 {
+		opts = append(opts, client.WithDVM(cfg.DVMKinds...))
+	}
+	c := client.NewWithSigner(signer, cfg.Relays, cfg.AllowedPubkeys, st, nostr.NewSimplePool(context.Background()), opts...)
 	return &Transport{cfg: cfg, store: st, client: c, id: "nostr"}, nil
 }
 
+// maxDMChars keeps encrypted DMs well under common relay event size limits
+// (NIP-04 ciphertext is base64 and grows by about a third) and NIP-44's 64 KiB plaintext cap.
+const maxDMChars = 16000
+
+// MaxMessageChars reports the largest DM the transport sends in one event.
+func (t *Transport) MaxMessageChars() int { return maxDMChars }
+
 // ID returns transport identifier.
 func (t *Transport) ID() string { return t.id }
 
-// Start subscribes to Nostr DMs and pushes inbound messages.
+// Start publishes the runner's profile (and DVM announcement), subscribes to Nostr DMs and
+// job requests, and pushes inbound messages. Each job request is its own thread.
 func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	logger := slog.Default().With("transport", t.id)
+	go func() {
+		if err := t.client.PublishProfile(ctx, t.cfg.Profile); err != nil {
+			logger.Warn("publish profile failed", "err", err)
+		}
+		if err := t.client.AnnounceDVM(ctx, t.cfg.Profile); err != nil {
+			logger.Warn("publish DVM announcement failed", "err", err)
+		}
+	}()
+
 	handler := func(msgCtx context.Context, msg client.IncomingMessage) {
-		inbound <- core.InboundMessage{
+		in := core.InboundMessage{
 			Transport: t.id,
 			Sender:    msg.SenderPubKey,
 			Text:      msg.Plaintext,
 			ThreadID:  msg.SenderPubKey,
 		}
+		if msg.Job != nil {
+			t.rememberJob(msg.Job)
+			if err := t.client.SendJobFeedback(msgCtx, msg.Job, client.JobProcessing, ""); err != nil {
+				logger.Warn("job feedback failed", "job", msg.Job.ID, "err", err)
+			}
+			in.ThreadID = msg.Job.ID
+			in.Meta = map[string]any{"nip90_job": msg.Job.ID, "nip90_kind": msg.Job.Kind}
+		}
+		inbound <- in
 	}
 	return t.client.Listen(ctx, handler)
 }
 
-// Send delivers a DM reply back to sender.
+// AcceptsProgress reports that progress updates are wanted: they go out as DMs, or as
+// job feedback on a job's thread.
+func (t *Transport) AcceptsProgress() bool { return true }
+
+// WholeReplies reports that replies on a job's thread are taken whole: a job gets one result.
+func (t *Transport) WholeReplies(threadID string) bool {
+	_, ok := t.jobFor(threadID)
+	return ok
+}
+
+// Send delivers a DM reply back to sender. On a job's thread the runner's final message is
+// published as the job result, once; progress updates, approval prompts and other notices
+// go out as job feedback.
 func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
 	if msg.Recipient == "" {
 		return fmt.Errorf("nostr recipient missing")
 	}
+	if req, ok := t.jobFor(msg.ThreadID); ok {
+		if final, _ := msg.Meta[core.MetaFinal].(bool); !final {
+			return t.client.SendJobFeedback(ctx, req, client.JobProcessing, msg.Text)
+		}
+		if err := t.client.SendJobResult(ctx, req, msg.Text); err != nil {
+			return err
+		}
+		t.forgetJob(req.ID)
+		return nil
+	}
 	return t.client.SendReply(ctx, msg.Recipient, msg.Text)
 }
+
+// rememberJob records req so replies on its thread become results, dropping expired jobs.
+func (t *Transport) rememberJob(req *nostr.Event) {
+	t.jobMu.Lock()
+	defer t.jobMu.Unlock()
+	if t.jobs == nil {
+		t.jobs = make(map[string]job)
+	}
+	now := time.Now()
+	for id, j := range t.jobs {
+		if now.Sub(j.at) > jobTTL {
+			delete(t.jobs, id)
+		}
+	}
+	t.jobs[req.ID] = job{req: req, at: now}
+}
+
+// forgetJob drops an answered job; later messages on its thread go out as DMs.
+func (t *Transport) forgetJob(id string) {
+	t.jobMu.Lock()
+	defer t.jobMu.Unlock()
+	delete(t.jobs, id)
+}
+
+func (t *Transport) jobFor(threadID string) (*nostr.Event, bool) {
+	t.jobMu.Lock()
+	defer t.jobMu.Unlock()
+	j, ok := t.jobs[threadID]
+	if !ok || time.Since(j.at) > jobTTL {
+		return nil, false
+	}
+	return j.req, true
+}
+
+func newSigner(cfg Config) (client.Signer, error) {
+	if cfg.Bunker != "" {
+		logger := slog.Default().With("transport", "nostr")
+		signer, err := client.ConnectBunker(context.Background(), cfg.Bunker, cfg.BunkerClientKey, func(url string) {
+			logger.Warn("bunker requests authorization; open the URL to approve", "url", url)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("signing through bunker", "pubkey", signer.PublicKey())
+		return signer, nil
+	}
+	if cfg.PrivateKey == "" {
+		return nil, fmt.Errorf("nostr private key or bunker required")
+	}
+	return client.NewKeySigner(cfg.PrivateKey)
+}
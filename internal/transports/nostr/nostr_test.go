@@ -35,6 +35,9 @@ type stubClient struct {
 	listenErr error
 	sendErr   error
 	called    bool
+	replies   []string
+	results   []string
+	feedback  []string
 }
 
 func (s *stubClient) Listen(ctx context.Context, handler func(context.Context, client.IncomingMessage)) error {
@@ -43,9 +46,24 @@ func (s *stubClient) Listen(ctx context.Context, handler func(context.Context, c
 }
 
 func (s *stubClient) SendReply(ctx context.Context, toPubKey string, message string) error {
+	s.replies = append(s.replies, message)
 	return s.sendErr
 }
 
+func (s *stubClient) PublishProfile(ctx context.Context, p client.Profile) error { return nil }
+
+func (s *stubClient) AnnounceDVM(ctx context.Context, p client.Profile) error { return nil }
+
+func (s *stubClient) SendJobResult(ctx context.Context, job *nostr.Event, content string) error {
+	s.results = append(s.results, content)
+	return s.sendErr
+}
+
+func (s *stubClient) SendJobFeedback(ctx context.Context, job *nostr.Event, status, info string) error {
+	s.feedback = append(s.feedback, status+":"+info)
+	return nil
+}
+
 func TestSendMissingRecipient(t *testing.T) {
 	priv := nostr.GeneratePrivateKey()
 	st, _ := store.New(t.TempDir() + "/state.db")
@@ -84,3 +102,30 @@ func TestStartReturnsClientError(t *testing.T) {
 		t.Fatalf("unexpected err: %v", err)
 	}
 }
+
+func TestSendAnswersJobThreadsWithResults(t *testing.T) {
+	sc := &stubClient{}
+	tr := &Transport{client: sc, id: "nostr"}
+	req := &nostr.Event{ID: "job1", PubKey: "customer", Kind: 5050}
+	tr.rememberJob(req)
+
+	if !tr.WholeReplies("job1") || tr.WholeReplies("customer") {
+		t.Fatal("only job threads should take whole replies")
+	}
+
+	ctx := context.Background()
+	final := map[string]any{core.MetaFinal: true}
+	_ = tr.Send(ctx, core.OutboundMessage{Recipient: "customer", ThreadID: "job1", Text: "running: ls", Meta: map[string]any{"progress": true}})
+	_ = tr.Send(ctx, core.OutboundMessage{Recipient: "customer", ThreadID: "job1", Text: "Approval needed: shell"})
+	_ = tr.Send(ctx, core.OutboundMessage{Recipient: "customer", ThreadID: "job1", Text: "answer", Meta: final})
+	_ = tr.Send(ctx, core.OutboundMessage{Recipient: "customer", ThreadID: "customer", Text: "dm", Meta: final})
+	if len(sc.feedback) != 2 || sc.feedback[0] != "processing:running: ls" || sc.feedback[1] != "processing:Approval needed: shell" {
+		t.Fatalf("unexpected feedback %v", sc.feedback)
+	}
+	if len(sc.results) != 1 || sc.results[0] != "answer" || len(sc.replies) != 1 || sc.replies[0] != "dm" {
+		t.Fatalf("unexpected results %v replies %v", sc.results, sc.replies)
+	}
+	if tr.WholeReplies("job1") {
+		t.Fatal("answered job should be forgotten")
+	}
+}
@@ -0,0 +1,117 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// maxClockSkew rejects signed requests older than Slack's recommended replay window.
+const maxClockSkew = 5 * time.Minute
+
+// serveEvents runs the Events API webhook until ctx is done.
+func (t *Transport) serveEvents(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	mux := http.NewServeMux()
+	mux.Handle(t.cfg.Path, t.Handler(ctx, inbound))
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ln, err := net.Listen("tcp", t.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	t.addrMu.Lock()
+	t.addr = ln.Addr().String()
+	t.addrMu.Unlock()
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = srv.Shutdown(context.Background())
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Addr returns the webhook listen address (for tests).
+func (t *Transport) Addr() string {
+	t.addrMu.RLock()
+	defer t.addrMu.RUnlock()
+	return t.addr
+}
+
+// Handler verifies and decodes Events API requests: it answers url_verification
+// challenges and forwards message events to inbound.
+func (t *Transport) Handler(ctx context.Context, inbound chan<- core.InboundMessage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if !verifySignature(t.cfg.SigningSecret, r.Header, body, time.Now()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var env envelope
+		if err := json.Unmarshal(body, &env); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if env.Type == "url_verification" {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(env.Challenge))
+			return
+		}
+		// Slack expects an ack within 3s; hand the event off without waiting for the runner.
+		w.WriteHeader(http.StatusOK)
+		go t.handleEnvelope(ctx, env, inbound)
+	})
+}
+
+// verifySignature checks Slack's v0 request signature:
+// "v0=" + hex(HMAC-SHA256(secret, "v0:" + timestamp + ":" + body)).
+func verifySignature(secret string, h http.Header, body []byte, now time.Time) bool {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sig := h.Get("X-Slack-Signature")
+	if ts == "" || sig == "" {
+		return false
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(sign(secret, ts, body)))
+}
+
+func sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,37 +1,328 @@
-// Package slack is a placeholder Slack transport implementation.
+// Package slack implements a Slack transport: inbound events arrive through the
+// Events API (signed webhooks) or Socket Mode, replies go out via chat.postMessage.
 package slack
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
 	"github.com/joelklabo/buddy/internal/core"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// Receive modes.
+const (
+	ModeEvents = "events" // Events API webhook (default)
+	ModeSocket = "socket" // Socket Mode websocket; no public endpoint needed
 )
 
-// Config for Slack transport (stub).
+// maxTextChars keeps messages within Slack's recommended text size.
+const maxTextChars = 4000
+
+// Config for the Slack transport.
 type Config struct {
-	BotToken        string
-	SigningSecret   string
-	AllowedChannels []string
+	ID              string   `json:"id"`
+	BotToken        string   `json:"bot_token"`      // xoxb-...
+	SigningSecret   string   `json:"signing_secret"` // Events API request verification
+	AppToken        string   `json:"app_token"`      // xapp-...; required for socket mode
+	Mode            string   `json:"mode"`           // events|socket
+	Listen          string   `json:"listen"`         // ":8084"
+	Path            string   `json:"path"`           // "/slack/events"
+	AllowedChannels []string `json:"allowed_channels"`
+	AllowedUsers    []string `json:"allowed_users"`
+	APIBase         string   `json:"api_base"` // optional Slack API base override for tests
 }
 
-// Transport is a stub Slack transport implementing core.Transport.
+// Transport implements core.Transport (and core.EditableTransport) for Slack.
 type Transport struct {
-	id  string
-	cfg Config
+	cfg    Config
+	log    *slog.Logger
+	client *http.Client
+
+	addrMu sync.RWMutex
+	addr   string
+
+	seenMu sync.Mutex
+	seen   map[string]struct{}
 }
 
-func New(cfg Config) *Transport {
-	return &Transport{id: "slack", cfg: cfg}
+func New(cfg Config, logger *slog.Logger) (*Transport, error) {
+	if cfg.ID == "" {
+		cfg.ID = "slack"
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeEvents
+	}
+	if cfg.BotToken == "" {
+		return nil, errors.New("slack: bot_token required")
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		return nil, errors.New("slack: allowed_users required")
+	}
+	switch cfg.Mode {
+	case ModeEvents:
+		if cfg.SigningSecret == "" {
+			return nil, errors.New("slack: signing_secret required for events mode")
+		}
+	case ModeSocket:
+		if cfg.AppToken == "" {
+			return nil, errors.New("slack: app_token required for socket mode")
+		}
+	default:
+		return nil, fmt.Errorf("slack: unknown mode %q", cfg.Mode)
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8084"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/slack/events"
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = "https://slack.com/api"
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Transport{
+		cfg:    cfg,
+		log:    logger.With("transport", "slack"),
+		client: http.DefaultClient,
+		seen:   make(map[string]struct{}),
+	}, nil
 }
 
-func (t *Transport) ID() string { return t.id }
+func (t *Transport) ID() string { return t.cfg.ID }
 
+// Start receives events until ctx is done, via webhook or Socket Mode.
 func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
-	// Not implemented yet; placeholder for Events API/webhooks.
-	<-ctx.Done()
-	return ctx.Err()
+	if t.cfg.Mode == ModeSocket {
+		return t.runSocket(ctx, inbound)
+	}
+	return t.serveEvents(ctx, inbound)
 }
 
+// MaxMessageChars reports the text size the runner should split replies to.
+func (t *Transport) MaxMessageChars() int { return maxTextChars }
+
+// Send posts msg to the channel and thread encoded in ThreadID, or as a DM to the recipient.
 func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
-	return errors.New("slack transport not implemented")
+	_, err := t.SendEditable(ctx, msg)
+	return err
+}
+
+// SendEditable posts msg and returns a "channel:ts" handle for Edit.
+func (t *Transport) SendEditable(ctx context.Context, msg core.OutboundMessage) (string, error) {
+	channel, threadTS := splitThreadID(msg.ThreadID)
+	if channel == "" {
+		channel = msg.Recipient
+	}
+	body := map[string]string{"channel": channel, "text": msg.Text}
+	if threadTS != "" {
+		body["thread_ts"] = threadTS
+	}
+	var out struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := t.call(ctx, "chat.postMessage", t.cfg.BotToken, body, &out); err != nil {
+		return "", err
+	}
+	if out.Channel == "" {
+		out.Channel = channel
+	}
+	return out.Channel + ":" + out.TS, nil
+}
+
+// Edit replaces the text of a message sent with SendEditable (chat.update).
+func (t *Transport) Edit(ctx context.Context, handle string, msg core.OutboundMessage) error {
+	channel, ts := splitThreadID(handle)
+	if channel == "" || ts == "" {
+		return fmt.Errorf("slack: invalid message handle %q", handle)
+	}
+	return t.call(ctx, "chat.update", t.cfg.BotToken, map[string]string{"channel": channel, "ts": ts, "text": msg.Text}, nil)
+}
+
+// call invokes a Slack Web API method with a JSON body and checks the ok flag.
+func (t *Transport) call(ctx context.Context, method, token string, body any, out any) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(b)
+	}
+	url := strings.TrimRight(t.cfg.APIBase, "/") + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack %s failed: %s: %s", method, resp.Status, strings.TrimSpace(string(data)))
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("slack %s: decode response: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("slack %s failed: %s", method, status.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// event is the subset of a Slack message/app_mention event the transport uses.
+type event struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+	Team        string `json:"team"`
+}
+
+// envelope is an Events API callback (also the Socket Mode events_api payload).
+type envelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+var leadingMention = regexp.MustCompile(`^\s*<@[A-Z0-9]+>\s*`)
+
+// handleEnvelope maps an event callback to an inbound message and forwards it.
+func (t *Transport) handleEnvelope(ctx context.Context, env envelope, inbound chan<- core.InboundMessage) {
+	if env.Type != "event_callback" || len(env.Event) == 0 {
+		return
+	}
+	var ev event
+	if err := json.Unmarshal(env.Event, &ev); err != nil {
+		t.log.Warn("decode event failed", "err", err)
+		return
+	}
+	msg, ok := t.toInbound(ev)
+	if !ok {
+		return
+	}
+	select {
+	case inbound <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// toInbound filters bot/edited messages, enforces allowlists, and de-duplicates events
+// (a channel mention arrives both as message and app_mention, and Slack retries deliveries).
+func (t *Transport) toInbound(ev event) (core.InboundMessage, bool) {
+	if ev.Type != "message" && ev.Type != "app_mention" {
+		return core.InboundMessage{}, false
+	}
+	if ev.BotID != "" || ev.Subtype != "" || ev.User == "" {
+		return core.InboundMessage{}, false
+	}
+	isDM := ev.ChannelType == "im"
+	if !isDM && len(t.cfg.AllowedChannels) > 0 && !contains(t.cfg.AllowedChannels, ev.Channel) {
+		t.log.Warn("rejecting message from channel not in allowlist", "channel", ev.Channel)
+		return core.InboundMessage{}, false
+	}
+	if !contains(t.cfg.AllowedUsers, ev.User) {
+		t.log.Warn("rejecting sender not in allowlist", "user", ev.User)
+		return core.InboundMessage{}, false
+	}
+	if !t.markSeen(ev.Channel + ":" + ev.TS) {
+		return core.InboundMessage{}, false
+	}
+
+	// Channel messages are answered in a thread under the original; DMs stay flat unless threaded.
+	threadTS := ev.ThreadTS
+	if threadTS == "" && !isDM {
+		threadTS = ev.TS
+	}
+	threadID := ev.Channel
+	if threadTS != "" {
+		threadID += ":" + threadTS
+	}
+	return core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    ev.User,
+		Text:      leadingMention.ReplaceAllString(ev.Text, ""),
+		ThreadID:  threadID,
+		Meta: map[string]any{
+			"channel":      ev.Channel,
+			"channel_type": ev.ChannelType,
+			"ts":           ev.TS,
+			"team":         ev.Team,
+		},
+	}, true
+}
+
+// markSeen records key and reports whether it was new. The set is reset when it grows large.
+func (t *Transport) markSeen(key string) bool {
+	t.seenMu.Lock()
+	defer t.seenMu.Unlock()
+	if _, ok := t.seen[key]; ok {
+		return false
+	}
+	if len(t.seen) >= 4096 {
+		t.seen = make(map[string]struct{})
+	}
+	t.seen[key] = struct{}{}
+	return true
+}
+
+// splitThreadID splits "channel:thread_ts"; a bare channel has no thread.
+func splitThreadID(id string) (channel, ts string) {
+	channel, ts, _ = strings.Cut(id, ":")
+	return channel, ts
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	transport.MustRegister("slack", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("slack: invalid config type %T", cfg)
+		}
+		return New(c, nil)
+	})
 }
@@ -2,26 +2,311 @@ package slack
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
 
 	"github.com/joelklabo/buddy/internal/core"
 )
 
-func TestSlackIDAndSend(t *testing.T) {
-	tr := New(Config{})
+// fakeSlack records Web API calls and answers them like Slack does.
+type fakeSlack struct {
+	mu    sync.Mutex
+	calls []apiCall
+	wsURL string
+}
+
+type apiCall struct {
+	Method string
+	Auth   string
+	Body   map[string]string
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.calls = append(f.calls, apiCall{Method: method, Auth: r.Header.Get("Authorization"), Body: body})
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "chat.postMessage":
+		if body["channel"] == "" {
+			_, _ = w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "channel": body["channel"], "ts": "1700000000.000200"})
+	case "chat.update":
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	case "apps.connections.open":
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "url": f.wsURL})
+	default:
+		_, _ = w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+	}
+}
+
+func (f *fakeSlack) recorded() []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apiCall(nil), f.calls...)
+}
+
+func newTestTransport(t *testing.T, cfg Config) *Transport {
+	t.Helper()
+	if cfg.BotToken == "" {
+		cfg.BotToken = "xoxb-test"
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		cfg.AllowedUsers = []string{"U1", "U2"}
+	}
+	if cfg.SigningSecret == "" && cfg.Mode != ModeSocket {
+		cfg.SigningSecret = "secret"
+	}
+	tr, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return tr
+}
+
+func signedRequest(t *testing.T, secret, body string, ts time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", stamp)
+	req.Header.Set("X-Slack-Signature", sign(secret, stamp, []byte(body)))
+	return req
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	cases := []Config{
+		{},
+		{BotToken: "xoxb", AllowedUsers: []string{"U1"}},
+		{BotToken: "xoxb", SigningSecret: "s"},
+		{BotToken: "xoxb", AllowedUsers: []string{"U1"}, Mode: ModeSocket},
+		{BotToken: "xoxb", AllowedUsers: []string{"U1"}, SigningSecret: "s", Mode: "poll"},
+	}
+	for _, c := range cases {
+		if _, err := New(c, nil); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+	tr := newTestTransport(t, Config{})
 	if tr.ID() != "slack" {
 		t.Fatalf("id mismatch %s", tr.ID())
 	}
-	if err := tr.Send(context.Background(), core.OutboundMessage{}); err == nil {
-		t.Fatalf("expected not implemented error")
-	}
 }
 
 func TestSlackStartReturnsContextError(t *testing.T) {
-	tr := New(Config{})
+	tr := newTestTransport(t, Config{Listen: "127.0.0.1:0"})
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	if err := tr.Start(ctx, make(chan core.InboundMessage)); err == nil {
 		t.Fatalf("expected context error")
 	}
 }
+
+func TestHandlerRejectsBadSignature(t *testing.T) {
+	tr := newTestTransport(t, Config{})
+	h := tr.Handler(context.Background(), make(chan core.InboundMessage, 1))
+	body := `{"type":"url_verification","challenge":"abc"}`
+
+	req := signedRequest(t, "wrong", body, time.Now())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("bad signature: expected 403, got %d", rec.Code)
+	}
+
+	req = signedRequest(t, "secret", body, time.Now().Add(-10*time.Minute))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("stale timestamp: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestHandlerAnswersURLVerification(t *testing.T) {
+	tr := newTestTransport(t, Config{})
+	h := tr.Handler(context.Background(), make(chan core.InboundMessage, 1))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, signedRequest(t, "secret", `{"type":"url_verification","challenge":"abc123"}`, time.Now()))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc123" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandlerMapsMessageEvents(t *testing.T) {
+	tr := newTestTransport(t, Config{AllowedChannels: []string{"C1"}})
+	inbound := make(chan core.InboundMessage, 4)
+	h := tr.Handler(context.Background(), inbound)
+
+	post := func(ev string) {
+		t.Helper()
+		body := `{"type":"event_callback","team_id":"T1","event":` + ev + `}`
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, signedRequest(t, "secret", body, time.Now()))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+	}
+
+	post(`{"type":"app_mention","user":"U1","text":"<@UBOT> hello there","channel":"C1","channel_type":"channel","ts":"1.1","team":"T1"}`)
+	// Same message delivered as a plain message event: dropped as a duplicate.
+	post(`{"type":"message","user":"U1","text":"<@UBOT> hello there","channel":"C1","channel_type":"channel","ts":"1.1"}`)
+	// Bot echo, edits, and channels outside the allowlist are ignored.
+	post(`{"type":"message","bot_id":"B1","text":"reply","channel":"C1","ts":"1.2"}`)
+	post(`{"type":"message","subtype":"message_changed","channel":"C1","ts":"1.3"}`)
+	post(`{"type":"message","user":"U1","text":"hi","channel":"C9","channel_type":"channel","ts":"1.4"}`)
+	// Threaded reply and a DM.
+	post(`{"type":"message","user":"U1","text":"more","channel":"C1","channel_type":"channel","ts":"1.5","thread_ts":"1.1"}`)
+	post(`{"type":"message","user":"U2","text":"dm","channel":"D1","channel_type":"im","ts":"1.6"}`)
+
+	want := []core.InboundMessage{
+		{Sender: "U1", Text: "hello there", ThreadID: "C1:1.1"},
+		{Sender: "U1", Text: "more", ThreadID: "C1:1.1"},
+		{Sender: "U2", Text: "dm", ThreadID: "D1"},
+	}
+	got := make(map[string]core.InboundMessage)
+	for range want {
+		select {
+		case msg := <-inbound:
+			got[msg.Text] = msg
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for inbound; got %v", got)
+		}
+	}
+	select {
+	case msg := <-inbound:
+		t.Fatalf("unexpected extra message %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, w := range want {
+		msg, ok := got[w.Text]
+		if !ok {
+			t.Fatalf("missing message %q", w.Text)
+		}
+		if msg.Transport != "slack" || msg.Sender != w.Sender || msg.ThreadID != w.ThreadID {
+			t.Fatalf("unexpected mapping %+v, want %+v", msg, w)
+		}
+	}
+}
+
+func TestSendPostsToThread(t *testing.T) {
+	fake := &fakeSlack{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := newTestTransport(t, Config{APIBase: srv.URL + "/api"})
+
+	if err := tr.Send(context.Background(), core.OutboundMessage{Recipient: "U1", ThreadID: "C1:1.1", Text: "hi"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	handle, err := tr.SendEditable(context.Background(), core.OutboundMessage{Recipient: "U2", ThreadID: "D1", Text: "working"})
+	if err != nil {
+		t.Fatalf("send editable: %v", err)
+	}
+	if handle != "D1:1700000000.000200" {
+		t.Fatalf("unexpected handle %q", handle)
+	}
+	if err := tr.Edit(context.Background(), handle, core.OutboundMessage{Text: "done"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if err := tr.Send(context.Background(), core.OutboundMessage{}); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Fatalf("expected Slack error, got %v", err)
+	}
+
+	calls := fake.recorded()
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 calls, got %d", len(calls))
+	}
+	first := calls[0]
+	if first.Method != "chat.postMessage" || first.Auth != "Bearer xoxb-test" ||
+		first.Body["channel"] != "C1" || first.Body["thread_ts"] != "1.1" || first.Body["text"] != "hi" {
+		t.Fatalf("unexpected postMessage %+v", first)
+	}
+	if _, ok := calls[1].Body["thread_ts"]; ok || calls[1].Body["channel"] != "D1" {
+		t.Fatalf("DM reply should not be threaded: %+v", calls[1])
+	}
+	if calls[2].Method != "chat.update" || calls[2].Body["ts"] != "1700000000.000200" || calls[2].Body["text"] != "done" {
+		t.Fatalf("unexpected update %+v", calls[2])
+	}
+}
+
+func TestSocketModeReceivesAndAcks(t *testing.T) {
+	acks := make(chan string, 1)
+	ws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.CloseNow()
+		}()
+		ctx := r.Context()
+		_ = wsjson.Write(ctx, conn, map[string]any{"type": "hello"})
+		_ = wsjson.Write(ctx, conn, map[string]any{
+			"type":        "events_api",
+			"envelope_id": "env-1",
+			"payload": map[string]any{
+				"type": "event_callback",
+				"event": map[string]any{
+					"type": "message", "user": "U1", "text": "over socket",
+					"channel": "D1", "channel_type": "im", "ts": "2.1",
+				},
+			},
+		})
+		var ack map[string]string
+		if err := wsjson.Read(ctx, conn, &ack); err == nil {
+			acks <- ack["envelope_id"]
+		}
+		<-ctx.Done()
+	}))
+	defer ws.Close()
+
+	fake := &fakeSlack{wsURL: "ws" + strings.TrimPrefix(ws.URL, "http")}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+
+	tr := newTestTransport(t, Config{Mode: ModeSocket, AppToken: "xapp-test", APIBase: api.URL + "/api"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inbound := make(chan core.InboundMessage, 1)
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, inbound) }()
+
+	select {
+	case msg := <-inbound:
+		if msg.Text != "over socket" || msg.Sender != "U1" || msg.ThreadID != "D1" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for socket message")
+	}
+	select {
+	case id := <-acks:
+		if id != "env-1" {
+			t.Fatalf("unexpected ack %q", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("envelope not acknowledged")
+	}
+	if calls := fake.recorded(); len(calls) == 0 || calls[0].Auth != "Bearer xapp-test" {
+		t.Fatalf("connections.open should use the app token: %+v", calls)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("start did not return after cancel")
+	}
+}
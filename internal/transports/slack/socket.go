@@ -0,0 +1,92 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// socketEnvelope is a Socket Mode frame; events_api frames carry an Events API callback.
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// runSocket keeps a Socket Mode connection open until ctx is done, reconnecting with backoff.
+func (t *Transport) runSocket(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	backoff := time.Second
+	for {
+		err := t.socketSession(ctx, inbound)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			// Slack asked us to reconnect; do so right away.
+			backoff = time.Second
+			continue
+		}
+		t.log.Warn("socket mode disconnected", "err", err, "retry_in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// socketSession opens one Socket Mode connection and reads frames until it closes.
+// A nil error means Slack sent a disconnect frame and a fresh connection is expected.
+func (t *Transport) socketSession(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	var open struct {
+		URL string `json:"url"`
+	}
+	if err := t.call(ctx, "apps.connections.open", t.cfg.AppToken, nil, &open); err != nil {
+		return err
+	}
+	conn, _, err := websocket.Dial(ctx, open.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+	conn.SetReadLimit(1 << 20)
+
+	for {
+		var env socketEnvelope
+		if err := wsjson.Read(ctx, conn, &env); err != nil {
+			var ce websocket.CloseError
+			if errors.As(err, &ce) && ce.Code == websocket.StatusNormalClosure {
+				return nil
+			}
+			return err
+		}
+		if env.EnvelopeID != "" {
+			if err := wsjson.Write(ctx, conn, map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		switch env.Type {
+		case "events_api":
+			var cb envelope
+			if err := json.Unmarshal(env.Payload, &cb); err != nil {
+				t.log.Warn("decode socket payload failed", "err", err)
+				continue
+			}
+			t.handleEnvelope(ctx, cb, inbound)
+		case "disconnect":
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package stdio implements a terminal transport: each input line is a message from the
+// local user and replies are printed to the output. It backs `buddy chat`.
+package stdio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/joelklabo/buddy/internal/core"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// maxLineBytes allows long pasted prompts on a single line.
+const maxLineBytes = 1 << 20
+
+// Config for the stdio transport.
+type Config struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"` // sender ID for sessions and history (default "local")
+	Prompt string `json:"prompt"` // printed before each input line; empty when input is not a terminal
+}
+
+// Transport implements core.Transport over a reader and writer.
+type Transport struct {
+	cfg Config
+	in  io.Reader
+
+	outMu sync.Mutex
+	out   io.Writer
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func New(cfg Config, in io.Reader, out io.Writer) *Transport {
+	if cfg.ID == "" {
+		cfg.ID = "stdio"
+	}
+	if cfg.Sender == "" {
+		cfg.Sender = "local"
+	}
+	return &Transport{cfg: cfg, in: in, out: out, done: make(chan struct{})}
+}
+
+func (t *Transport) ID() string { return t.cfg.ID }
+
+// Done is closed once input ends and every line has been handed to the runner.
+func (t *Transport) Done() <-chan struct{} { return t.done }
+
+// Start forwards each non-empty input line until EOF or ctx is done. All lines share one
+// thread, so the terminal is a single conversation.
+func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	lines := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(t.in)
+		sc.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		errCh <- sc.Err()
+	}()
+
+	t.prompt()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				t.doneOnce.Do(func() { close(t.done) })
+				select {
+				case err := <-errCh:
+					return err
+				default:
+					return ctx.Err()
+				}
+			}
+			text := strings.TrimSpace(line)
+			if text == "" {
+				t.prompt()
+				continue
+			}
+			msg := core.InboundMessage{
+				Transport: t.ID(),
+				Sender:    t.cfg.Sender,
+				Text:      text,
+				ThreadID:  t.ID(),
+			}
+			select {
+			case inbound <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// AcceptsProgress reports that progress updates are printed.
+func (t *Transport) AcceptsProgress() bool { return true }
+
+// Send prints msg; progress updates are marked so they stand apart from replies.
+func (t *Transport) Send(_ context.Context, msg core.OutboundMessage) error {
+	t.outMu.Lock()
+	defer t.outMu.Unlock()
+	if progress, _ := msg.Meta["progress"].(bool); progress {
+		_, err := fmt.Fprintf(t.out, "… %s\n", msg.Text)
+		return err
+	}
+	if _, err := fmt.Fprintf(t.out, "%s\n", strings.TrimRight(msg.Text, "\n")); err != nil {
+		return err
+	}
+	if t.cfg.Prompt != "" {
+		_, err := io.WriteString(t.out, t.cfg.Prompt)
+		return err
+	}
+	return nil
+}
+
+func (t *Transport) prompt() {
+	if t.cfg.Prompt == "" {
+		return
+	}
+	t.outMu.Lock()
+	defer t.outMu.Unlock()
+	_, _ = io.WriteString(t.out, t.cfg.Prompt)
+}
+
+func init() {
+	transport.MustRegister("stdio", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("stdio: invalid config type %T", cfg)
+		}
+		return New(c, os.Stdin, os.Stdout), nil
+	})
+}
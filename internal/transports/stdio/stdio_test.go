@@ -0,0 +1,50 @@
+package stdio
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+func TestStartForwardsLinesUntilEOF(t *testing.T) {
+	var out bytes.Buffer
+	tr := New(Config{Prompt: "> "}, strings.NewReader("hello\n\n  /status  \n"), &out)
+	inbound := make(chan core.InboundMessage, 4)
+
+	if err := tr.Start(context.Background(), inbound); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	select {
+	case <-tr.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("done not closed at EOF")
+	}
+	close(inbound)
+	var got []core.InboundMessage
+	for msg := range inbound {
+		got = append(got, msg)
+	}
+	if len(got) != 2 || got[0].Text != "hello" || got[1].Text != "/status" {
+		t.Fatalf("unexpected messages %+v", got)
+	}
+	if got[0].Transport != "stdio" || got[0].Sender != "local" || got[0].ThreadID != got[1].ThreadID {
+		t.Fatalf("unexpected routing %+v", got[0])
+	}
+	if out.String() != "> > " {
+		t.Fatalf("expected a prompt at start and after the blank line, got %q", out.String())
+	}
+}
+
+func TestSendPrintsRepliesAndProgress(t *testing.T) {
+	var out bytes.Buffer
+	tr := New(Config{Prompt: "> "}, strings.NewReader(""), &out)
+	_ = tr.Send(context.Background(), core.OutboundMessage{Text: "running: ls", Meta: map[string]any{"progress": true}})
+	_ = tr.Send(context.Background(), core.OutboundMessage{Text: "done\n"})
+	if want := "… running: ls\ndone\n> "; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,254 @@
+// Package telegram implements a Telegram Bot API transport using getUpdates long
+// polling or a webhook; replies quote the message they answer.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joelklabo/buddy/internal/core"
+	transport "github.com/joelklabo/buddy/internal/transports"
+)
+
+// Receive modes.
+const (
+	ModePolling = "polling" // getUpdates long polling (default)
+	ModeWebhook = "webhook" // Telegram POSTs updates to Listen/Path
+)
+
+// maxTextChars is Telegram's limit for a single message text.
+const maxTextChars = 4096
+
+// Config for the Telegram transport.
+type Config struct {
+	ID              string  `json:"id"`
+	BotToken        string  `json:"bot_token"`
+	Mode            string  `json:"mode"` // polling|webhook
+	AllowedUsers    []int64 `json:"allowed_users"`
+	PollTimeoutSecs int     `json:"poll_timeout_seconds"` // long-poll wait, default 30
+	Listen          string  `json:"listen"`               // webhook mode, ":8085"
+	Path            string  `json:"path"`                 // webhook mode, "/telegram/webhook"
+	WebhookURL      string  `json:"webhook_url"`          // optional public URL registered via setWebhook
+	SecretToken     string  `json:"secret_token"`         // optional X-Telegram-Bot-Api-Secret-Token check
+	APIBase         string  `json:"api_base"`             // optional Bot API base override for tests
+}
+
+// Transport implements core.Transport (and core.EditableTransport) for Telegram.
+type Transport struct {
+	cfg     Config
+	log     *slog.Logger
+	client  *http.Client
+	allowed map[int64]struct{}
+
+	addrMu sync.RWMutex
+	addr   string
+
+	threadMu  sync.Mutex
+	roots     map[string]string // "chat:message_id" of a thread message -> thread root ("" for the flat chat)
+	lastInbox map[string]int64  // ThreadID -> latest inbound message id, quoted by the next reply
+}
+
+func New(cfg Config, logger *slog.Logger) (*Transport, error) {
+	if cfg.ID == "" {
+		cfg.ID = "telegram"
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModePolling
+	}
+	if cfg.BotToken == "" {
+		return nil, errors.New("telegram: bot_token required")
+	}
+	if len(cfg.AllowedUsers) == 0 {
+		return nil, errors.New("telegram: allowed_users required")
+	}
+	if cfg.Mode != ModePolling && cfg.Mode != ModeWebhook {
+		return nil, fmt.Errorf("telegram: unknown mode %q", cfg.Mode)
+	}
+	if cfg.PollTimeoutSecs <= 0 {
+		cfg.PollTimeoutSecs = 30
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8085"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/telegram/webhook"
+	}
+	if cfg.APIBase == "" {
+		cfg.APIBase = "https://api.telegram.org"
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	allowed := make(map[int64]struct{}, len(cfg.AllowedUsers))
+	for _, id := range cfg.AllowedUsers {
+		allowed[id] = struct{}{}
+	}
+	return &Transport{
+		cfg:       cfg,
+		log:       logger.With("transport", "telegram"),
+		client:    http.DefaultClient,
+		allowed:   allowed,
+		roots:     make(map[string]string),
+		lastInbox: make(map[string]int64),
+	}, nil
+}
+
+func (t *Transport) ID() string { return t.cfg.ID }
+
+// Start receives updates until ctx is done, by long polling or webhook.
+func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	if t.cfg.Mode == ModeWebhook {
+		return t.serveWebhook(ctx, inbound)
+	}
+	return t.poll(ctx, inbound)
+}
+
+// MaxMessageChars reports the text size the runner should split replies to.
+func (t *Transport) MaxMessageChars() int { return maxTextChars }
+
+// Send delivers msg to the chat in ThreadID (or the recipient's private chat), quoting the message it answers.
+func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
+	_, err := t.SendEditable(ctx, msg)
+	return err
+}
+
+// SendEditable sends msg and returns a "chat:message_id" handle for Edit.
+func (t *Transport) SendEditable(ctx context.Context, msg core.OutboundMessage) (string, error) {
+	threadID := msg.ThreadID
+	if threadID == "" {
+		threadID = msg.Recipient
+	}
+	chat, root, _ := strings.Cut(threadID, ":")
+	if chat == "" {
+		return "", errors.New("telegram: recipient chat required")
+	}
+	body := map[string]any{"chat_id": chat}
+	if replyTo := t.replyTarget(threadID, root); replyTo != 0 {
+		body["reply_parameters"] = map[string]any{"message_id": replyTo, "allow_sending_without_reply": true}
+	}
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := t.sendText(ctx, "sendMessage", body, msg.Text, &sent); err != nil {
+		return "", err
+	}
+	handle := chat + ":" + strconv.FormatInt(sent.MessageID, 10)
+	t.threadMu.Lock()
+	t.roots[handle] = root
+	t.threadMu.Unlock()
+	return handle, nil
+}
+
+// Edit replaces the text of a message sent with SendEditable (editMessageText).
+func (t *Transport) Edit(ctx context.Context, handle string, msg core.OutboundMessage) error {
+	chat, id, ok := strings.Cut(handle, ":")
+	messageID, err := strconv.ParseInt(id, 10, 64)
+	if !ok || chat == "" || err != nil {
+		return fmt.Errorf("telegram: invalid message handle %q", handle)
+	}
+	return t.sendText(ctx, "editMessageText", map[string]any{"chat_id": chat, "message_id": messageID}, msg.Text, nil)
+}
+
+// sendText sends text as Markdown, falling back to plain text when Telegram cannot parse the entities.
+func (t *Transport) sendText(ctx context.Context, method string, body map[string]any, text string, out any) error {
+	body["text"] = toMarkdown(text)
+	body["parse_mode"] = "Markdown"
+	err := t.call(ctx, method, body, out)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "can't parse entities") {
+		delete(body, "parse_mode")
+		body["text"] = text
+		err = t.call(ctx, method, body, out)
+	}
+	return err
+}
+
+// replyTarget picks the message a reply in threadID should quote: the latest inbound
+// message of the thread, else the thread root.
+func (t *Transport) replyTarget(threadID, root string) int64 {
+	t.threadMu.Lock()
+	defer t.threadMu.Unlock()
+	if id, ok := t.lastInbox[threadID]; ok {
+		return id
+	}
+	id, _ := strconv.ParseInt(root, 10, 64)
+	return id
+}
+
+// apiError is a Bot API response with ok=false.
+type apiError struct {
+	Method      string
+	Code        int
+	Description string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("telegram %s failed: %d %s", e.Method, e.Code, e.Description)
+}
+
+// call invokes a Bot API method with a JSON body and decodes the result into out.
+func (t *Transport) call(ctx context.Context, method string, body any, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(t.cfg.APIBase, "/") + "/bot" + t.cfg.BotToken + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The request URL embeds the bot token; keep it out of logs and errors.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	var res struct {
+		OK          bool            `json:"ok"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return fmt.Errorf("telegram %s: %s: decode response: %w", method, resp.Status, err)
+	}
+	if !res.OK {
+		return &apiError{Method: method, Code: res.ErrorCode, Description: res.Description}
+	}
+	if out != nil && len(res.Result) > 0 {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
+
+func init() {
+	transport.MustRegister("telegram", func(cfg any) (core.Transport, error) {
+		c, ok := cfg.(Config)
+		if !ok {
+			return nil, fmt.Errorf("telegram: invalid config type %T", cfg)
+		}
+		return New(c, nil)
+	})
+}
@@ -0,0 +1,287 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// fakeBotAPI records Bot API calls and serves queued updates to getUpdates.
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	calls   []botCall
+	updates []map[string]any
+	nextID  int64
+	// rejectMarkdown makes sendMessage fail when parse_mode is set, like Telegram does on bad entities.
+	rejectMarkdown bool
+}
+
+type botCall struct {
+	Method string
+	Body   map[string]any
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "bottoken" {
+		http.NotFound(w, r)
+		return
+	}
+	method := parts[1]
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, botCall{Method: method, Body: body})
+	var result any = true
+	switch method {
+	case "getUpdates":
+		result = f.updates
+		f.updates = nil
+	case "sendMessage", "editMessageText":
+		if _, markdown := body["parse_mode"]; markdown && f.rejectMarkdown {
+			f.mu.Unlock()
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: unclosed tag"}`))
+			return
+		}
+		f.nextID++
+		result = map[string]any{"message_id": 100 + f.nextID}
+	}
+	f.mu.Unlock()
+
+	if method == "getUpdates" && result == nil {
+		// Simulate a short long-poll wait instead of spinning.
+		time.Sleep(10 * time.Millisecond)
+		result = []any{}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func (f *fakeBotAPI) recorded(method string) []botCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []botCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func textUpdate(updateID, messageID, userID, chatID int64, chatType, text string, replyTo int64) map[string]any {
+	msg := map[string]any{
+		"message_id": messageID,
+		"from":       map[string]any{"id": userID, "is_bot": false, "username": "joel"},
+		"chat":       map[string]any{"id": chatID, "type": chatType},
+		"text":       text,
+	}
+	if replyTo != 0 {
+		msg["reply_to_message"] = map[string]any{"message_id": replyTo}
+	}
+	return map[string]any{"update_id": updateID, "message": msg}
+}
+
+func newTestTransport(t *testing.T, apiBase string, cfg Config) *Transport {
+	t.Helper()
+	cfg.BotToken = "token"
+	cfg.APIBase = apiBase
+	if len(cfg.AllowedUsers) == 0 {
+		cfg.AllowedUsers = []int64{42}
+	}
+	tr, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return tr
+}
+
+func receive(t *testing.T, inbound <-chan core.InboundMessage) core.InboundMessage {
+	t.Helper()
+	select {
+	case msg := <-inbound:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for inbound message")
+		return core.InboundMessage{}
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	cases := []Config{
+		{},
+		{BotToken: "t"},
+		{BotToken: "t", AllowedUsers: []int64{1}, Mode: "push"},
+	}
+	for _, c := range cases {
+		if _, err := New(c, nil); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestPollingDeliversAllowedMessages(t *testing.T) {
+	fake := &fakeBotAPI{updates: []map[string]any{
+		textUpdate(1, 10, 42, 42, "private", "hello", 0),
+		textUpdate(2, 11, 7, 7, "private", "intruder", 0),
+		textUpdate(3, 12, 42, -100, "group", "/status@buddy_bot", 0),
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := newTestTransport(t, srv.URL, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inbound := make(chan core.InboundMessage, 4)
+	done := make(chan error, 1)
+	go func() { done <- tr.Start(ctx, inbound) }()
+
+	first := receive(t, inbound)
+	if first.Transport != "telegram" || first.Sender != "42" || first.Text != "hello" || first.ThreadID != "42" {
+		t.Fatalf("unexpected private message %+v", first)
+	}
+	second := receive(t, inbound)
+	if second.Text != "/status" || second.ThreadID != "-100:12" {
+		t.Fatalf("unexpected group message %+v", second)
+	}
+
+	// The next poll acknowledges the delivered updates through offset.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		polls := fake.recorded("getUpdates")
+		if len(polls) >= 2 {
+			if off, _ := polls[1].Body["offset"].(float64); off != 4 {
+				t.Fatalf("expected offset 4, got %v", polls[1].Body["offset"])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no second poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(fake.recorded("deleteWebhook")) != 1 {
+		t.Fatalf("polling should clear any webhook first")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("start did not return after cancel")
+	}
+	select {
+	case msg := <-inbound:
+		t.Fatalf("unexpected message from non-allowlisted user %+v", msg)
+	default:
+	}
+}
+
+func TestRepliesQuoteAndFollowThreads(t *testing.T) {
+	fake := &fakeBotAPI{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := newTestTransport(t, srv.URL, Config{})
+
+	// A group message starts a thread; the reply quotes it.
+	in, ok := tr.toInbound(update{Message: decodeMessage(t, textUpdate(1, 50, 42, -100, "group", "run tests", 0))})
+	if !ok || in.ThreadID != "-100:50" {
+		t.Fatalf("unexpected thread %+v", in)
+	}
+	if err := tr.Send(context.Background(), core.OutboundMessage{Recipient: in.Sender, ThreadID: in.ThreadID, Text: "**done**"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	sent := fake.recorded("sendMessage")
+	if len(sent) != 1 {
+		t.Fatalf("expected one sendMessage, got %d", len(sent))
+	}
+	body := sent[0].Body
+	reply, _ := body["reply_parameters"].(map[string]any)
+	if body["chat_id"] != "-100" || reply["message_id"] != float64(50) || body["parse_mode"] != "Markdown" || body["text"] != "*done*" {
+		t.Fatalf("unexpected sendMessage body %+v", body)
+	}
+
+	// Replying to the bot's message (id 101) continues the same thread.
+	in2, ok := tr.toInbound(update{Message: decodeMessage(t, textUpdate(2, 52, 42, -100, "group", "again", 101))})
+	if !ok || in2.ThreadID != "-100:50" {
+		t.Fatalf("reply should join thread -100:50, got %+v", in2)
+	}
+}
+
+func TestSendFallsBackToPlainText(t *testing.T) {
+	fake := &fakeBotAPI{rejectMarkdown: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := newTestTransport(t, srv.URL, Config{})
+
+	handle, err := tr.SendEditable(context.Background(), core.OutboundMessage{Recipient: "42", Text: "a_b*c"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if handle != "42:101" {
+		t.Fatalf("unexpected handle %q", handle)
+	}
+	sent := fake.recorded("sendMessage")
+	if len(sent) != 2 {
+		t.Fatalf("expected markdown attempt and plain retry, got %d", len(sent))
+	}
+	if _, ok := sent[1].Body["parse_mode"]; ok || sent[1].Body["text"] != "a_b*c" {
+		t.Fatalf("retry should be plain text: %+v", sent[1].Body)
+	}
+	if err := tr.Edit(context.Background(), handle, core.OutboundMessage{Text: "done"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if edits := fake.recorded("editMessageText"); len(edits) == 0 || edits[0].Body["message_id"] != float64(101) {
+		t.Fatalf("unexpected edits %+v", edits)
+	}
+}
+
+func TestWebhookChecksSecretToken(t *testing.T) {
+	tr := newTestTransport(t, "http://127.0.0.1:1", Config{Mode: ModeWebhook, SecretToken: "s3cret"})
+	inbound := make(chan core.InboundMessage, 1)
+	h := tr.Handler(context.Background(), inbound)
+
+	payload, _ := json.Marshal(textUpdate(1, 10, 42, 42, "private", "via webhook", 0))
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(string(payload)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("missing secret: expected 403, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(string(payload)))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "s3cret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if msg := receive(t, inbound); msg.Text != "via webhook" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
+
+func TestToMarkdown(t *testing.T) {
+	in := "## Result\n**ok** and __fine__ with `**raw**`\n```go\nx := **y**\n```"
+	want := "*Result*\n*ok* and _fine_ with `**raw**`\n```go\nx := **y**\n```"
+	if got := toMarkdown(in); got != want {
+		t.Fatalf("toMarkdown:\n got %q\nwant %q", got, want)
+	}
+}
+
+func decodeMessage(t *testing.T, u map[string]any) *message {
+	t.Helper()
+	data, _ := json.Marshal(u["message"])
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return &m
+}
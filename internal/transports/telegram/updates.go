@@ -0,0 +1,252 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/core"
+)
+
+// update is the subset of a Bot API Update the transport uses.
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+type message struct {
+	MessageID int64 `json:"message_id"`
+	From      *struct {
+		ID       int64  `json:"id"`
+		IsBot    bool   `json:"is_bot"`
+		Username string `json:"username"`
+	} `json:"from"`
+	Chat struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"` // private, group, supergroup, channel
+	} `json:"chat"`
+	Text           string   `json:"text"`
+	ReplyToMessage *message `json:"reply_to_message"`
+}
+
+// poll long-polls getUpdates until ctx is done, backing off on errors.
+func (t *Transport) poll(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	// getUpdates is refused while a webhook is registered.
+	if err := t.call(ctx, "deleteWebhook", map[string]any{}, nil); err != nil && ctx.Err() == nil {
+		t.log.Warn("deleteWebhook failed", "err", err)
+	}
+	var offset int64
+	backoff := time.Second
+	for {
+		var updates []update
+		err := t.call(ctx, "getUpdates", map[string]any{
+			"offset":          offset,
+			"timeout":         t.cfg.PollTimeoutSecs,
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			t.log.Warn("getUpdates failed", "err", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			t.deliver(ctx, u, inbound)
+		}
+	}
+}
+
+// serveWebhook receives updates over HTTP until ctx is done, registering WebhookURL first when set.
+func (t *Transport) serveWebhook(ctx context.Context, inbound chan<- core.InboundMessage) error {
+	mux := http.NewServeMux()
+	mux.Handle(t.cfg.Path, t.Handler(ctx, inbound))
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ln, err := net.Listen("tcp", t.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	t.addrMu.Lock()
+	t.addr = ln.Addr().String()
+	t.addrMu.Unlock()
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	if t.cfg.WebhookURL != "" {
+		body := map[string]any{"url": t.cfg.WebhookURL, "allowed_updates": []string{"message"}}
+		if t.cfg.SecretToken != "" {
+			body["secret_token"] = t.cfg.SecretToken
+		}
+		if err := t.call(ctx, "setWebhook", body, nil); err != nil {
+			_ = srv.Shutdown(context.Background())
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = srv.Shutdown(context.Background())
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// Addr returns the webhook listen address (for tests).
+func (t *Transport) Addr() string {
+	t.addrMu.RLock()
+	defer t.addrMu.RUnlock()
+	return t.addr
+}
+
+// Handler decodes webhook updates, checking the secret token header when one is configured.
+func (t *Transport) Handler(ctx context.Context, inbound chan<- core.InboundMessage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if t.cfg.SecretToken != "" {
+			got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(t.cfg.SecretToken)) != 1 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		var u update
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&u); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		t.deliver(r.Context(), u, inbound)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// deliver forwards an update's message to inbound if it passes the filters.
+func (t *Transport) deliver(ctx context.Context, u update, inbound chan<- core.InboundMessage) {
+	msg, ok := t.toInbound(u)
+	if !ok {
+		return
+	}
+	select {
+	case inbound <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// botSuffix matches the "@botname" Telegram appends to commands in groups ("/status@buddy_bot").
+var botSuffix = regexp.MustCompile(`^(/\w+)@\w+`)
+
+// toInbound maps a text message from an allowlisted user. Threads follow reply chains:
+// a reply joins the thread of the message it answers, a new group message starts a
+// thread of its own, and a new private message continues the flat chat.
+func (t *Transport) toInbound(u update) (core.InboundMessage, bool) {
+	m := u.Message
+	if m == nil || m.From == nil || m.From.IsBot || strings.TrimSpace(m.Text) == "" {
+		return core.InboundMessage{}, false
+	}
+	if _, ok := t.allowed[m.From.ID]; !ok {
+		t.log.Warn("rejecting sender not in allowlist", "user", m.From.ID)
+		return core.InboundMessage{}, false
+	}
+
+	chat := strconv.FormatInt(m.Chat.ID, 10)
+	id := strconv.FormatInt(m.MessageID, 10)
+	t.threadMu.Lock()
+	var root string
+	switch {
+	case m.ReplyToMessage != nil:
+		parent := strconv.FormatInt(m.ReplyToMessage.MessageID, 10)
+		r, known := t.roots[chat+":"+parent]
+		if !known {
+			r = parent
+		}
+		root = r
+	case m.Chat.Type != "private":
+		root = id
+	}
+	threadID := chat
+	if root != "" {
+		threadID += ":" + root
+	}
+	if len(t.roots) >= 4096 {
+		t.roots = make(map[string]string)
+		t.lastInbox = make(map[string]int64)
+	}
+	t.roots[chat+":"+id] = root
+	t.lastInbox[threadID] = m.MessageID
+	t.threadMu.Unlock()
+
+	return core.InboundMessage{
+		Transport: t.ID(),
+		Sender:    strconv.FormatInt(m.From.ID, 10),
+		Text:      botSuffix.ReplaceAllString(strings.TrimSpace(m.Text), "$1"),
+		ThreadID:  threadID,
+		Meta: map[string]any{
+			"chat_id":    m.Chat.ID,
+			"chat_type":  m.Chat.Type,
+			"message_id": m.MessageID,
+			"username":   m.From.Username,
+		},
+	}, true
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+	mdBold    = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	mdItalic  = regexp.MustCompile(`__(.+?)__`)
+)
+
+// toMarkdown rewrites the CommonMark agents produce into Telegram's Markdown
+// parse mode: **bold** and headings become *bold*, __x__ becomes _x_. Code is left alone.
+func toMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			line = "**" + strings.Trim(m[1], "*") + "**"
+		}
+		// Odd segments between backticks are inline code.
+		segs := strings.Split(line, "`")
+		for j := 0; j < len(segs); j += 2 {
+			segs[j] = mdBold.ReplaceAllString(segs[j], "*$1*")
+			segs[j] = mdItalic.ReplaceAllString(segs[j], "_${1}_")
+		}
+		lines[i] = strings.Join(segs, "`")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,196 @@
+package whatsapp
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/transports/attach"
+)
+
+// mediaTimeout bounds downloading all media of one inbound message; Twilio gives up on a
+// webhook after 15s.
+const mediaTimeout = 10 * time.Second
+
+// downloadMedia saves MediaUrl0..n-1 of an inbound message under MediaDir/<sender>/ and
+// returns the paths, plus the content types of items that could not be fetched.
+func (t *Transport) downloadMedia(ctx context.Context, from, msgID string, form url.Values, n int) ([]string, []string) {
+	ctx, cancel := context.WithTimeout(ctx, mediaTimeout)
+	defer cancel()
+	dir := filepath.Join(t.cfg.MediaDir, safeSegment(from))
+	var files, failed []string
+	for i := 0; i < n; i++ {
+		mediaURL := form.Get("MediaUrl" + strconv.Itoa(i))
+		contentType := form.Get("MediaContentType" + strconv.Itoa(i))
+		if mediaURL == "" {
+			continue
+		}
+		name := safeSegment(msgID) + "-" + strconv.Itoa(i) + extensionFor(contentType)
+		path, err := t.fetchMedia(ctx, mediaURL, dir, name)
+		if err != nil {
+			t.log.Warn("media download failed", "message_sid", msgID, "index", i, "err", err)
+			failed = append(failed, cmp.Or(contentType, "file"))
+			continue
+		}
+		files = append(files, path)
+	}
+	return files, failed
+}
+
+// fetchMedia downloads one item with the account credentials (Twilio requires them when
+// media authentication is on) and writes it to dir/name.
+func (t *Transport) fetchMedia(ctx context.Context, mediaURL, dir, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(t.cfg.AccountSID, t.cfg.AuthToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("media fetch: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, t.cfg.MaxMediaBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > t.cfg.MaxMediaBytes {
+		return "", fmt.Errorf("media larger than %d bytes", t.cfg.MaxMediaBytes)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Media types WhatsApp commonly sends, with the extension their files get.
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"video/mp4":       ".mp4",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+func extensionFor(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := mediaExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// safeSegment keeps letters, digits, '+' and '-' so a phone number or SID is one path element.
+func safeSegment(s string) string {
+	out := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '+', r == '-':
+			return r
+		}
+		return -1
+	}, s)
+	if out == "" {
+		return "unknown"
+	}
+	return out
+}
+
+// outgoingMedia publishes a long reply and the files written while answering on the media
+// path and returns the body to send with their URLs. Files that cannot be sent are named
+// in the body instead.
+func (t *Transport) outgoingMedia(text string, files []string) (string, []string) {
+	text, out := attach.Outgoing(text, files, attach.Options{
+		AttachAfter: maxBodyChars,
+		ReplyName:   "reply.txt",
+		ReplyType:   "text/plain; charset=utf-8",
+		MaxBytes:    t.cfg.MaxMediaBytes,
+	})
+	urls := make([]string, 0, len(out))
+	for _, f := range out {
+		urls = append(urls, t.mediaURL(t.media.add(f.Filename, f.ContentType, f.Data)))
+	}
+	return text, urls
+}
+
+func (t *Transport) mediaURL(path string) string {
+	return t.cfg.PublicURL + t.cfg.MediaPath + path
+}
+
+// mediaTTL is how long outbound media stays fetchable; Twilio downloads it right after
+// the message is created, and again if the message is resent.
+const mediaTTL = time.Hour
+
+// mediaStore serves outbound media to Twilio under unguessable tokens.
+type mediaStore struct {
+	mu    sync.Mutex
+	items map[string]mediaItem
+}
+
+type mediaItem struct {
+	name        string
+	contentType string
+	data        []byte
+	expires     time.Time
+}
+
+// add stores data and returns its path under MediaPath: a random token, then the file name
+// so WhatsApp shows it.
+func (m *mediaStore) add(name, contentType string, data []byte) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	token := hex.EncodeToString(b[:])
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.items == nil {
+		m.items = make(map[string]mediaItem)
+	}
+	now := time.Now()
+	for k, it := range m.items {
+		if now.After(it.expires) {
+			delete(m.items, k)
+		}
+	}
+	m.items[token] = mediaItem{name: name, contentType: contentType, data: data, expires: now.Add(mediaTTL)}
+	return token + "/" + url.PathEscape(name)
+}
+
+func (m *mediaStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	token, _, _ := strings.Cut(r.URL.Path, "/")
+	it, ok := m.items[token]
+	m.mu.Unlock()
+	if !ok || time.Now().After(it.expires) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", it.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": it.name}))
+	_, _ = w.Write(it.data)
+}
@@ -0,0 +1,114 @@
+package whatsapp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/joelklabo/buddy/internal/metrics"
+)
+
+// sentTTL bounds how long a sent message is tracked waiting for a final status.
+const sentTTL = 24 * time.Hour
+
+// sentMessage is an outbound message awaiting delivery reports.
+type sentMessage struct {
+	form    url.Values
+	attempt int
+	status  string
+	at      time.Time
+	retried bool // a resend was scheduled; later failure reports are only recorded
+}
+
+// track records a created message so its status callbacks can be matched and, if it
+// fails, resent.
+func (t *Transport) track(sid string, form url.Values, attempt int) {
+	t.sentMu.Lock()
+	defer t.sentMu.Unlock()
+	now := time.Now()
+	for id, m := range t.sent {
+		if now.Sub(m.at) > sentTTL {
+			delete(t.sent, id)
+		}
+	}
+	t.sent[sid] = &sentMessage{form: form, attempt: attempt, status: "queued", at: now}
+}
+
+// status returns the last reported status of a sent message, "" if unknown.
+func (t *Transport) status(sid string) string {
+	t.sentMu.Lock()
+	defer t.sentMu.Unlock()
+	if m, ok := t.sent[sid]; ok {
+		return m.status
+	}
+	return ""
+}
+
+// statusHandler receives Twilio status callbacks. It records each status and resends
+// messages reported failed or undelivered, up to MaxRetries times.
+func (t *Transport) statusHandler(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if !t.verifySignature(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		sid := r.PostForm.Get("MessageSid")
+		status := r.PostForm.Get("MessageStatus")
+		w.WriteHeader(http.StatusNoContent)
+		if sid == "" || status == "" {
+			return
+		}
+		metrics.IncDeliveryStatus(t.ID(), status)
+
+		failed := status == "failed" || status == "undelivered"
+		t.sentMu.Lock()
+		m, ok := t.sent[sid]
+		retry := false
+		if ok {
+			m.status = status
+			retry = failed && !m.retried
+			m.retried = m.retried || retry
+		}
+		t.sentMu.Unlock()
+		if !ok {
+			return
+		}
+		switch {
+		case retry:
+			t.log.Warn("message not delivered", "message_sid", sid, "status", status,
+				"error_code", r.PostForm.Get("ErrorCode"), "attempt", m.attempt+1)
+			if m.attempt >= t.cfg.MaxRetries {
+				t.log.Error("giving up on message", "message_sid", sid, "attempts", m.attempt+1)
+				metrics.IncSendError()
+				return
+			}
+			go t.resend(ctx, sid, m.form, m.attempt+1)
+		case status == "delivered" || status == "read":
+			t.log.Debug("message delivered", "message_sid", sid, "status", status)
+		}
+	})
+}
+
+// resend posts form again after the retry delay for attempt.
+func (t *Transport) resend(ctx context.Context, sid string, form url.Values, attempt int) {
+	select {
+	case <-time.After(t.retryDelay(attempt)):
+	case <-ctx.Done():
+		return
+	}
+	if err := t.post(ctx, form, attempt); err != nil {
+		t.log.Error("resend failed", "message_sid", sid, "attempt", attempt+1, "err", err)
+		metrics.IncSendError()
+		return
+	}
+	t.log.Info("resent message", "message_sid", sid, "attempt", attempt+1)
+}
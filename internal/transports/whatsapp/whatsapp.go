@@ -6,6 +6,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -13,13 +14,17 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/joelklabo/buddy/internal/core"
 	transport "github.com/joelklabo/buddy/internal/transports"
+	"github.com/joelklabo/buddy/internal/transports/attach"
 )
 
 // Config for Twilio WhatsApp.
@@ -33,6 +38,15 @@ type Config struct {
 	AllowedNumbers []string `json:"allowed_numbers"`
 	SignatureKey   string   `json:"signature_key"` // optional; falls back to AuthToken
 	BaseURL        string   `json:"base_url"`      // optional Twilio API base override for tests
+
+	// PublicURL is where Twilio reaches Listen (e.g. "https://bot.example.com"). It enables
+	// status callbacks and sending long replies and written files as media.
+	PublicURL     string `json:"public_url"`
+	StatusPath    string `json:"status_path"`     // "/twilio/status"
+	MediaPath     string `json:"media_path"`      // "/twilio/media/"; serves outbound media
+	MediaDir      string `json:"media_dir"`       // inbound media; defaults to a temp dir
+	MaxMediaBytes int64  `json:"max_media_bytes"` // per item, in and out; WhatsApp caps media at 16 MB
+	MaxRetries    int    `json:"max_retries"`     // resends after a failed/undelivered status (2); negative disables them
 }
 
 type Transport struct {
@@ -41,6 +55,12 @@ type Transport struct {
 
 	addrMu sync.RWMutex
 	addr   string
+
+	media  mediaStore
+	sentMu sync.Mutex
+	sent   map[string]*sentMessage // by MessageSid, for status callbacks
+	// retryDelay is how long to wait before resending after attempt n failed.
+	retryDelay func(n int) time.Duration
 }
 
 func New(cfg Config, logger *slog.Logger) (*Transport, error) {
@@ -62,17 +82,77 @@ func New(cfg Config, logger *slog.Logger) (*Transport, error) {
 	if cfg.BaseURL == "" {
 		cfg.BaseURL = "https://api.twilio.com"
 	}
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	if cfg.StatusPath == "" {
+		cfg.StatusPath = "/twilio/status"
+	}
+	if cfg.MediaPath == "" {
+		cfg.MediaPath = "/twilio/media/"
+	}
+	if !strings.HasSuffix(cfg.MediaPath, "/") {
+		cfg.MediaPath += "/"
+	}
+	if cfg.MediaDir == "" {
+		cfg.MediaDir = filepath.Join(os.TempDir(), "buddy-whatsapp")
+	}
+	if cfg.MaxMediaBytes == 0 {
+		cfg.MaxMediaBytes = 16 << 20
+	}
+	switch {
+	case cfg.MaxRetries == 0:
+		cfg.MaxRetries = 2
+	case cfg.MaxRetries < 0:
+		cfg.MaxRetries = 0
+	}
 	if logger == nil {
 		logger = slog.Default()
 	}
-	return &Transport{cfg: cfg, log: logger.With("transport", "whatsapp")}, nil
+	return &Transport{
+		cfg:        cfg,
+		log:        logger.With("transport", "whatsapp"),
+		sent:       make(map[string]*sentMessage),
+		retryDelay: func(n int) time.Duration { return time.Duration(n) * 30 * time.Second },
+	}, nil
 }
 
 func (t *Transport) ID() string { return t.cfg.ID }
 
 func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessage) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc(t.cfg.Path, func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(t.cfg.Path, t.webhook(ctx, inbound))
+	mux.Handle(t.cfg.StatusPath, t.statusHandler(ctx))
+	mux.Handle(t.cfg.MediaPath, http.StripPrefix(t.cfg.MediaPath, &t.media))
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ln, err := net.Listen("tcp", t.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	t.addrMu.Lock()
+	t.addr = ln.Addr().String()
+	t.addrMu.Unlock()
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = srv.Shutdown(context.Background())
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// webhook handles incoming messages, downloading any media into MediaDir.
+func (t *Transport) webhook(ctx context.Context, inbound chan<- core.InboundMessage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -93,11 +173,20 @@ func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessag
 			w.WriteHeader(http.StatusOK)
 			return
 		}
+		// A WhatsApp chat is one conversation per number; MessageSid changes with every
+		// message, so it cannot key history or held-back reply parts.
 		im := core.InboundMessage{
 			Transport: t.ID(),
 			Sender:    from,
 			Text:      body,
-			ThreadID:  msgID,
+			ThreadID:  from,
+		}
+		if n, _ := strconv.Atoi(r.Form.Get("NumMedia")); n > 0 {
+			files, failed := t.downloadMedia(r.Context(), from, msgID, r.Form, n)
+			im.Text = attach.Note(body, files, "that could not be downloaded", failed)
+			if len(files) > 0 {
+				im.Meta = map[string]any{core.MetaAttachments: files}
+			}
 		}
 		select {
 		case inbound <- im:
@@ -106,32 +195,6 @@ func (t *Transport) Start(ctx context.Context, inbound chan<- core.InboundMessag
 		}
 		w.WriteHeader(http.StatusOK)
 	})
-
-	srv := &http.Server{
-		Handler:           mux,
-		ReadHeaderTimeout: 5 * time.Second,
-	}
-	ln, err := net.Listen("tcp", t.cfg.Listen)
-	if err != nil {
-		return err
-	}
-	t.addrMu.Lock()
-	t.addr = ln.Addr().String()
-	t.addrMu.Unlock()
-	errCh := make(chan error, 1)
-	go func() {
-		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			errCh <- err
-		}
-	}()
-
-	select {
-	case <-ctx.Done():
-		_ = srv.Shutdown(context.Background())
-		return nil
-	case err := <-errCh:
-		return err
-	}
 }
 
 // Addr returns the listen address (for tests).
@@ -141,11 +204,54 @@ func (t *Transport) Addr() string {
 	return t.addr
 }
 
+// maxBodyChars is Twilio's limit for a WhatsApp message body.
+const maxBodyChars = 1600
+
+// MaxMessageChars reports Twilio's body limit so the runner splits longer replies.
+func (t *Transport) MaxMessageChars() int { return maxBodyChars }
+
+// SendsAttachments reports whether replies can carry media, which Twilio fetches from
+// PublicURL. Without it the runner splits long replies instead.
+func (t *Transport) SendsAttachments() bool { return t.cfg.PublicURL != "" }
+
+// Send delivers msg. With PublicURL set, text over the body limit goes out as a reply.txt
+// document behind a preview and files written while answering are attached; WhatsApp takes
+// one media item per message, so each extra item is its own message.
 func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
+	text := msg.Text
+	var media []string
+	if t.SendsAttachments() {
+		files, _ := msg.Meta[core.MetaAttachments].([]string)
+		text, media = t.outgoingMedia(text, files)
+	}
 	form := url.Values{}
 	form.Set("To", "whatsapp:"+msg.Recipient)
 	form.Set("From", t.cfg.FromNumber)
-	form.Set("Body", msg.Text)
+	form.Set("Body", text)
+	if len(media) > 0 {
+		form.Set("MediaUrl", media[0])
+	}
+	if err := t.post(ctx, form, 0); err != nil {
+		return err
+	}
+	for _, m := range media[min(1, len(media)):] {
+		extra := url.Values{}
+		extra.Set("To", form.Get("To"))
+		extra.Set("From", t.cfg.FromNumber)
+		extra.Set("MediaUrl", m)
+		if err := t.post(ctx, extra, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post creates a message through the Twilio API and tracks it for status callbacks;
+// attempt counts earlier sends of the same form.
+func (t *Transport) post(ctx context.Context, form url.Values, attempt int) error {
+	if t.cfg.PublicURL != "" {
+		form.Set("StatusCallback", t.cfg.PublicURL+t.cfg.StatusPath)
+	}
 	api := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(t.cfg.BaseURL, "/"), t.cfg.AccountSID)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, strings.NewReader(form.Encode()))
 	if err != nil {
@@ -161,10 +267,16 @@ func (t *Transport) Send(ctx context.Context, msg core.OutboundMessage) error {
 	defer func() {
 		_ = resp.Body.Close()
 	}()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
 	if resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("twilio send failed: %s", strings.TrimSpace(string(b)))
 	}
+	var created struct {
+		SID string `json:"sid"`
+	}
+	if json.Unmarshal(b, &created) == nil && created.SID != "" && t.cfg.PublicURL != "" {
+		t.track(created.SID, form, attempt)
+	}
 	return nil
 }
 
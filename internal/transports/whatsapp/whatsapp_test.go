@@ -5,12 +5,16 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -191,3 +195,342 @@ func TestContainsHelper(t *testing.T) {
 		t.Fatalf("should not contain")
 	}
 }
+
+// postSigned posts form to srvURL+path with a valid Twilio signature.
+func postSigned(t *testing.T, srvURL, path string, form url.Values, key string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srvURL+path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", signFor(srvURL+path, form, key))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+func TestWebhookDownloadsMedia(t *testing.T) {
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "AC123" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png-bytes"))
+	}))
+	defer media.Close()
+
+	tr, err := New(Config{
+		AccountSID:     "AC123",
+		AuthToken:      "token",
+		FromNumber:     "whatsapp:+15550009999",
+		AllowedNumbers: []string{"+15555550100"},
+		MediaDir:       t.TempDir(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inbound := make(chan core.InboundMessage, 1)
+	srv := httptest.NewServer(tr.webhook(ctx, inbound))
+	defer srv.Close()
+
+	form := url.Values{}
+	form.Set("From", "whatsapp:+15555550100")
+	form.Set("Body", "what is this?")
+	form.Set("MessageSid", "MM1")
+	form.Set("NumMedia", "2")
+	form.Set("MediaUrl0", media.URL+"/shot")
+	form.Set("MediaContentType0", "image/png")
+	form.Set("MediaUrl1", media.URL+"/missing")
+	form.Set("MediaContentType1", "audio/ogg")
+	if resp := postSigned(t, srv.URL, "/twilio/webhook", form, "token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	m := <-inbound
+	want := filepath.Join(tr.cfg.MediaDir, "+15555550100", "MM1-0.png")
+	files, _ := m.Meta[core.MetaAttachments].([]string)
+	if len(files) != 1 || files[0] != want {
+		t.Fatalf("attachments = %v, want [%s]", files, want)
+	}
+	if b, err := os.ReadFile(want); err != nil || string(b) != "png-bytes" {
+		t.Fatalf("saved media = %q, %v", b, err)
+	}
+	if !strings.HasPrefix(m.Text, "what is this?") || !strings.Contains(m.Text, "- "+want) || !strings.Contains(m.Text, "not be downloaded: audio/ogg") {
+		t.Fatalf("unexpected text %q", m.Text)
+	}
+}
+
+func TestWebhookKeepsOneThreadPerSender(t *testing.T) {
+	tr, err := New(Config{
+		AccountSID:     "AC123",
+		AuthToken:      "token",
+		FromNumber:     "whatsapp:+15550009999",
+		AllowedNumbers: []string{"+15555550100"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inbound := make(chan core.InboundMessage, 2)
+	srv := httptest.NewServer(tr.webhook(ctx, inbound))
+	defer srv.Close()
+
+	var threads []string
+	for _, sid := range []string{"SM1", "SM2"} {
+		form := url.Values{}
+		form.Set("From", "whatsapp:+15555550100")
+		form.Set("Body", "message "+sid)
+		form.Set("MessageSid", sid)
+		if resp := postSigned(t, srv.URL, "/twilio/webhook", form, "token"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+		threads = append(threads, (<-inbound).ThreadID)
+	}
+	if threads[0] != "+15555550100" || threads[1] != threads[0] {
+		t.Fatalf("messages with different SIDs should share the sender's thread, got %q", threads)
+	}
+}
+
+// fakeTwilio records message-create forms and answers with sequential SIDs.
+type fakeTwilio struct {
+	mu    sync.Mutex
+	forms []url.Values
+}
+
+func (f *fakeTwilio) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.mu.Lock()
+	f.forms = append(f.forms, r.PostForm)
+	n := len(f.forms)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"sid":"SM%d"}`, n)
+}
+
+func (f *fakeTwilio) sent() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]url.Values(nil), f.forms...)
+}
+
+func TestSendLongReplyAndFilesAsMedia(t *testing.T) {
+	api := &fakeTwilio{}
+	apiSrv := httptest.NewServer(api)
+	defer apiSrv.Close()
+	tr, err := New(Config{
+		AccountSID: "AC123",
+		AuthToken:  "token",
+		FromNumber: "whatsapp:+15550001234",
+		BaseURL:    apiSrv.URL,
+		PublicURL:  "https://bot.example.com/",
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if !tr.SendsAttachments() {
+		t.Fatal("public_url should enable attachments")
+	}
+	mediaSrv := httptest.NewServer(http.StripPrefix(tr.cfg.MediaPath, &tr.media))
+	defer mediaSrv.Close()
+
+	report := filepath.Join(t.TempDir(), "report.csv")
+	_ = os.WriteFile(report, []byte("a,b\n"), 0o600)
+	long := strings.Repeat("word ", 500)
+	err = tr.Send(context.Background(), core.OutboundMessage{
+		Recipient: "+15550009999",
+		Text:      long,
+		Meta:      map[string]any{core.MetaAttachments: []string{report}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	forms := api.sent()
+	if len(forms) != 2 {
+		t.Fatalf("expected body+media and a second media message, got %d", len(forms))
+	}
+	if body := forms[0].Get("Body"); len(body) > maxBodyChars || !strings.Contains(body, "reply.txt") {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if cb := forms[0].Get("StatusCallback"); cb != "https://bot.example.com/twilio/status" {
+		t.Fatalf("status callback = %q", cb)
+	}
+	for i, want := range []string{long, "a,b\n"} {
+		mediaURL := forms[i].Get("MediaUrl")
+		path := strings.TrimPrefix(mediaURL, "https://bot.example.com")
+		if path == mediaURL {
+			t.Fatalf("media url %q not under public_url", mediaURL)
+		}
+		resp, err := http.Get(mediaSrv.URL + path)
+		if err != nil {
+			t.Fatalf("fetch media: %v", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(b) != want {
+			t.Fatalf("media %d = %q", i, b)
+		}
+	}
+}
+
+func TestStatusCallbackRetriesFailedSends(t *testing.T) {
+	api := &fakeTwilio{}
+	apiSrv := httptest.NewServer(api)
+	defer apiSrv.Close()
+	tr, err := New(Config{
+		AccountSID: "AC123",
+		AuthToken:  "token",
+		FromNumber: "whatsapp:+15550001234",
+		BaseURL:    apiSrv.URL,
+		PublicURL:  "https://bot.example.com",
+		MaxRetries: 1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	tr.retryDelay = func(int) time.Duration { return 0 }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := httptest.NewServer(tr.statusHandler(ctx))
+	defer status.Close()
+	report := func(sid, st string) {
+		form := url.Values{"MessageSid": {sid}, "MessageStatus": {st}, "ErrorCode": {"30003"}}
+		if resp := postSigned(t, status.URL, "/twilio/status", form, "token"); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("status callback: %d", resp.StatusCode)
+		}
+	}
+
+	if err := tr.Send(ctx, core.OutboundMessage{Recipient: "+15550009999", Text: "hi"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	report("SM1", "sent")
+	if got := tr.status("SM1"); got != "sent" {
+		t.Fatalf("status = %q", got)
+	}
+	report("SM1", "failed")
+	report("SM1", "undelivered") // a second failure report must not resend again
+	deadline := time.Now().Add(2 * time.Second)
+	for len(api.sent()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	forms := api.sent()
+	if len(forms) != 2 || forms[1].Get("Body") != "hi" {
+		t.Fatalf("expected one resend, got %v", forms)
+	}
+
+	report("SM2", "failed") // max_retries reached
+	time.Sleep(50 * time.Millisecond)
+	if n := len(api.sent()); n != 2 {
+		t.Fatalf("expected no further resends, got %d sends", n)
+	}
+	if got := tr.status("SM2"); got != "failed" {
+		t.Fatalf("resent status = %q", got)
+	}
+}
+
+func TestNegativeMaxRetriesDisablesResends(t *testing.T) {
+	api := &fakeTwilio{}
+	apiSrv := httptest.NewServer(api)
+	defer apiSrv.Close()
+	tr, err := New(Config{
+		AccountSID: "AC123",
+		AuthToken:  "token",
+		FromNumber: "whatsapp:+15550001234",
+		BaseURL:    apiSrv.URL,
+		PublicURL:  "https://bot.example.com",
+		MaxRetries: -1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	tr.retryDelay = func(int) time.Duration { return 0 }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	status := httptest.NewServer(tr.statusHandler(ctx))
+	defer status.Close()
+
+	if err := tr.Send(ctx, core.OutboundMessage{Recipient: "+15550009999", Text: "hi"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	form := url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"failed"}}
+	if resp := postSigned(t, status.URL, "/twilio/status", form, "token"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status callback: %d", resp.StatusCode)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(api.sent()); n != 1 {
+		t.Fatalf("expected no resend, got %d sends", n)
+	}
+}
+
+// longAgent answers every prompt with a reply longer than one WhatsApp message.
+type longAgent struct{}
+
+func (longAgent) Generate(ctx context.Context, req core.AgentRequest) (core.AgentResponse, error) {
+	return core.AgentResponse{Reply: strings.Repeat("word ", 400) + "tail"}, nil
+}
+
+func TestMoreReleasesPartsAcrossMessages(t *testing.T) {
+	api := &fakeTwilio{}
+	apiSrv := httptest.NewServer(api)
+	defer apiSrv.Close()
+	tr, err := New(Config{
+		AccountSID:     "AC123",
+		AuthToken:      "token",
+		FromNumber:     "whatsapp:+15550009999",
+		Listen:         "127.0.0.1:0",
+		AllowedNumbers: []string{"+15555550100"},
+		BaseURL:        apiSrv.URL,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	r := core.NewRunner([]core.Transport{tr}, longAgent{}, nil, nil, core.WithMaxReplyParts(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Start(ctx) }()
+
+	waitSent := func(n int) []url.Values {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(api.sent()) < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		forms := api.sent()
+		if len(forms) < n {
+			t.Fatalf("expected %d messages, got %d", n, len(forms))
+		}
+		return forms
+	}
+	for deadline := time.Now().Add(2 * time.Second); tr.Addr() == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	post := func(sid, body string) {
+		form := url.Values{}
+		form.Set("From", "whatsapp:+15555550100")
+		form.Set("Body", body)
+		form.Set("MessageSid", sid)
+		if resp := postSigned(t, "http://"+tr.Addr(), "/twilio/webhook", form, "token"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+	}
+
+	post("SM1", "write a lot")
+	forms := waitSent(2)
+	if !strings.Contains(forms[1].Get("Body"), "Send /more") {
+		t.Fatalf("expected a held-back notice, got %q", forms[1].Get("Body"))
+	}
+	post("SM2", "/more")
+	forms = waitSent(3)
+	if body := forms[2].Get("Body"); !strings.HasSuffix(body, "tail") {
+		t.Fatalf("/more from a new message should release the held-back part, got %q", body)
+	}
+}
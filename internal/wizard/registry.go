@@ -56,6 +56,18 @@ var defaultRegistry = Registry{
 	Transports: []TransportOption{
 		{Name: "nostr", Description: "Nostr DMs over relays"},
 		{Name: "mock", Description: "Offline mock transport"},
+		{Name: "telegram", Description: "Telegram bot (long polling or webhook)", Prompts: []PromptSpec{
+			{Kind: PromptPassword, Label: "Bot token (from @BotFather)", Required: true},
+			{Kind: PromptInput, Label: "Allowed Telegram user IDs (comma-separated)", Required: true},
+			{Kind: PromptSelect, Label: "Receive mode", Default: "polling", Options: []string{"polling", "webhook"}},
+		}},
+		{Name: "whatsapp", Description: "WhatsApp via Twilio webhook", Prompts: []PromptSpec{
+			{Kind: PromptInput, Label: "Twilio account SID", Required: true},
+			{Kind: PromptPassword, Label: "Twilio auth token", Required: true},
+			{Kind: PromptInput, Label: "WhatsApp sender number", Required: true, Description: "whatsapp:+15550001234"},
+			{Kind: PromptInput, Label: "Allowed phone numbers (comma-separated)", Required: true},
+			{Kind: PromptInput, Label: "Public URL of the webhook listener", Description: "enables delivery status callbacks and media replies"},
+		}},
 	},
 	Agents: []AgentOption{
 		{Name: "http", Description: "Claude/OpenAI-style HTTP"},
@@ -72,6 +84,7 @@ var defaultRegistry = Registry{
 		{Name: "copilot-shell", Description: "Nostr DM to Copilot CLI + shell action"},
 		{Name: "local-llm", Description: "Nostr DM to local HTTP LLM"},
 		{Name: "mock-echo", Description: "Mock transport + echo agent (offline)"},
+		{Name: "whatsapp-codex", Description: "WhatsApp (Twilio) to Codex CLI"},
 	},
 }
 
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/nbd-wtf/go-nostr"
 	"gopkg.in/yaml.v3"
 
 	"github.com/joelklabo/buddy/internal/check"
@@ -102,16 +103,10 @@ func Run(ctx context.Context, path string, p Prompter) (string, error) {
 			}
 			t.Relays = splitCSV(relays)
 		}
-		if t.PrivateKey == "" {
-			pk, err := p.AskPassword("Nostr private key (hex, not nsec)")
-			if err != nil {
+		if t.PrivateKey == "" && t.Bunker == "" {
+			if err := askNostrSigner(p, cfg, t); err != nil {
 				return "", err
 			}
-			if pk == "" {
-				return "", errors.New("private key is required")
-			}
-			t.PrivateKey = pk
-			cfg.Runner.PrivateKey = pk
 		}
 		if len(t.AllowedPubkeys) == 0 {
 			allowed, err := p.AskInput("Allowed pubkeys (comma-separated hex)", "")
@@ -127,6 +122,17 @@ func Run(ctx context.Context, path string, p Prompter) (string, error) {
 		}
 	}
 
+	// WhatsApp presets leave the Twilio account and allowlist blank.
+	for i := range cfg.Transports {
+		t := &cfg.Transports[i]
+		if t.Type != "whatsapp" {
+			continue
+		}
+		if err := askWhatsApp(p, t); err != nil {
+			return "", err
+		}
+	}
+
 	// Shell action prompt only if shell not already enabled.
 	hasShell := false
 	for _, a := range cfg.Actions {
@@ -146,6 +152,11 @@ func Run(ctx context.Context, path string, p Prompter) (string, error) {
 		}
 	}
 
+	// Presets leave secrets blank, so the config is only complete after the prompts.
+	if err := cfg.Validate(); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+
 	dryRun, err := p.AskConfirm("Dry-run only (preview config without writing)?", false)
 	if err != nil {
 		return "", err
@@ -175,6 +186,102 @@ func Run(ctx context.Context, path string, p Prompter) (string, error) {
 	return cfgPath, nil
 }
 
+// Signer choices offered for a nostr transport.
+const (
+	signerPrivateKey = "private key (stored in config)"
+	signerBunker     = "NIP-46 bunker (key stays in a remote signer)"
+)
+
+// askNostrSigner asks how the runner signs: with a private key in the config, or through a
+// bunker. For a bunker it generates the client key the bunker will approve.
+func askNostrSigner(p Prompter, cfg *config.Config, t *config.TransportConfig) error {
+	choice, err := p.AskSelect("Runner key", []string{signerPrivateKey, signerBunker}, signerPrivateKey)
+	if err != nil {
+		return err
+	}
+	if choice == signerBunker {
+		bunker, err := p.AskInput("Bunker URL (bunker://<pubkey>?relay=...&secret=...)", "")
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(bunker, "bunker://") {
+			return errors.New("bunker URL must start with bunker://")
+		}
+		t.Bunker, t.BunkerClientKey = bunker, nostr.GeneratePrivateKey()
+		cfg.Runner.Bunker, cfg.Runner.BunkerClientKey = t.Bunker, t.BunkerClientKey
+		cfg.Runner.PrivateKey = ""
+		return nil
+	}
+	pk, err := p.AskPassword("Nostr private key (hex, not nsec)")
+	if err != nil {
+		return err
+	}
+	if pk == "" {
+		return errors.New("private key is required")
+	}
+	t.PrivateKey = pk
+	cfg.Runner.PrivateKey = pk
+	return nil
+}
+
+// askWhatsApp fills the Twilio credentials, sender number, allowed numbers and optional
+// public URL a whatsapp transport still lacks.
+func askWhatsApp(p Prompter, t *config.TransportConfig) error {
+	if t.Config == nil {
+		t.Config = map[string]any{}
+	}
+	if v, _ := t.Config["account_sid"].(string); v == "" {
+		sid, err := p.AskInput("Twilio account SID", "")
+		if err != nil {
+			return err
+		}
+		t.Config["account_sid"] = strings.TrimSpace(sid)
+	}
+	if v, _ := t.Config["auth_token"].(string); v == "" {
+		token, err := p.AskPassword("Twilio auth token")
+		if err != nil {
+			return err
+		}
+		t.Config["auth_token"] = token
+	}
+	if v, _ := t.Config["from_number"].(string); v == "" {
+		from, err := p.AskInput("WhatsApp sender number (e.g. +15550001234)", "")
+		if err != nil {
+			return err
+		}
+		from = strings.TrimSpace(from)
+		if from != "" && !strings.HasPrefix(from, "whatsapp:") {
+			from = "whatsapp:" + from
+		}
+		t.Config["from_number"] = from
+	}
+	if v, _ := t.Config["allowed_numbers"].([]any); len(v) == 0 {
+		allowed, err := p.AskInput("Allowed phone numbers (comma-separated, as Twilio sends them, e.g. +15555550100)", "")
+		if err != nil {
+			return err
+		}
+		numbers := splitCSV(allowed)
+		if len(numbers) == 0 {
+			return errors.New("at least one allowed phone number required")
+		}
+		list := make([]any, len(numbers))
+		for i, n := range numbers {
+			list[i] = n
+		}
+		t.Config["allowed_numbers"] = list
+	}
+	if v, _ := t.Config["public_url"].(string); v == "" {
+		pub, err := p.AskInput("Public URL of the webhook listener (optional; enables delivery status and media replies)", "")
+		if err != nil {
+			return err
+		}
+		if pub = strings.TrimSpace(pub); pub != "" {
+			t.Config["public_url"] = pub
+		}
+	}
+	return nil
+}
+
 func resolveConfigPath(path string) (string, error) {
 	if path != "" {
 		return path, nil
@@ -419,9 +526,6 @@ func loadPresetConfig(data []byte) (*config.Config, error) {
 			cfg.Runner.AllowedPubkeys = []string{"mock"}
 		}
 	}
-	if err := cfg.Validate(); err != nil {
-		return nil, err
-	}
 	return &cfg, nil
 }
 
@@ -56,7 +56,7 @@ func TestRunFailsOnDepsMissingWhenUserSaysNo(t *testing.T) {
 		Selects:   []string{"copilot-shell"},
 		Inputs:    []string{"wss://relay.example", "npub1"}, // relays, allowed
 		Passwords: []string{"abcd1234"},                     // nostr priv
-		Confirms:  []bool{true, false, false},               // overwrite? dry-run? continue deps?
+		Confirms:  []bool{false, false},                     // dry-run? continue deps?
 	}
 	_, err := Run(context.Background(), path, p)
 	if err == nil {
@@ -64,6 +64,31 @@ func TestRunFailsOnDepsMissingWhenUserSaysNo(t *testing.T) {
 	}
 }
 
+func TestRunOffersBunkerSigner(t *testing.T) {
+	td := t.TempDir()
+	path := filepath.Join(td, "config.yaml")
+	signer := strings.Repeat("ab", 32)
+	p := &StubPrompter{
+		Selects:  []string{"claude-dm", signerBunker},
+		Inputs:   []string{"bunker://" + signer + "?relay=wss://relay.nsec.app&secret=s", signer}, // bunker, allowed
+		Confirms: []bool{false, false, true},                                                      // shell? dry-run? continue deps?
+	}
+	if _, err := Run(context.Background(), path, p); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "bunker: bunker://"+signer) || !strings.Contains(content, "bunker_client_key:") {
+		t.Fatalf("config missing bunker fields:\n%s", content)
+	}
+	if strings.Contains(content, "abcd1234") {
+		t.Fatalf("bunker config should not hold a private key:\n%s", content)
+	}
+}
+
 func TestSetRegistryOverridesOptions(t *testing.T) {
 	orig := GetRegistry()
 	defer SetRegistry(orig)
@@ -115,3 +140,27 @@ func TestRunDryRunDoesNotWriteFile(t *testing.T) {
 		t.Fatalf("config should not be written on dry-run")
 	}
 }
+
+func TestRunAsksForWhatsAppSettings(t *testing.T) {
+	td := t.TempDir()
+	path := filepath.Join(td, "config.yaml")
+	p := &StubPrompter{
+		Selects:   []string{"whatsapp-codex"},
+		Inputs:    []string{"AC123", "+15550001234", "+15555550100", "https://bot.example.com"}, // sid, from, allowed, public url
+		Passwords: []string{"twilio-token"},
+		Confirms:  []bool{false, false, true}, // shell? dry-run? continue deps?
+	}
+	if _, err := Run(context.Background(), path, p); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	content := string(data)
+	for _, want := range []string{"type: whatsapp", "account_sid: AC123", "auth_token: twilio-token", "from_number: whatsapp:+15550001234", `- "+15555550100"`, "public_url: https://bot.example.com"} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("config missing %q:\n%s", want, content)
+		}
+	}
+}